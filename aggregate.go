@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/dofusdude/alm-dates/almanax"
+)
+
+type offeringTotal struct {
+	ItemId         int      `json:"item_id"`
+	ItemCategoryId int      `json:"item_category_id"`
+	Item           string   `json:"item"`
+	Quantity       int      `json:"quantity"`
+	Dates          []string `json:"dates"`
+}
+
+// aggregateOfferings sums up the offering items needed for every mapped date in the range, grouped by item.
+func aggregateOfferings(days *almanax.Index, fromDate string, toDate string, lang string) []offeringTotal {
+	totals := make(map[int]*offeringTotal)
+	for _, day := range days.Range(fromDate, toDate) {
+		alm := day.Receiver
+
+		total, ok := totals[alm.Offering.ItemId]
+		if !ok {
+			total = &offeringTotal{
+				ItemId:         alm.Offering.ItemId,
+				ItemCategoryId: alm.Offering.ItemCategoryId,
+				Item:           alm.Offering.ItemName[lang],
+			}
+			totals[alm.Offering.ItemId] = total
+		}
+		total.Quantity += alm.Offering.Quantity
+		total.Dates = append(total.Dates, day.Date)
+	}
+
+	result := []offeringTotal{}
+	for _, total := range totals {
+		result = append(result, *total)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Quantity != result[j].Quantity {
+			return result[i].Quantity > result[j].Quantity
+		}
+		return result[i].Item < result[j].Item
+	})
+	return result
+}
+
+// maxQueryDays is the longest range a date range query may ask for.
+const maxQueryDays = 10 * 366
+
+// parseDateRangeQuery reads from and to in yyyy-mm-dd, defaulting to the next 30 days. Ranges over
+// maxQueryDays are refused.
+func parseDateRangeQuery(from string, to string) (string, string, error) {
+	today := time.Now()
+	if from == "" {
+		from = today.Format("2006-01-02")
+	}
+	if to == "" {
+		to = today.AddDate(0, 0, 30).Format("2006-01-02")
+	}
+
+	start, err := time.Parse("2006-01-02", from)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid date range, expected yyyy-mm-dd")
+	}
+	end, err := time.Parse("2006-01-02", to)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid date range, expected yyyy-mm-dd")
+	}
+	if start.After(end) {
+		return "", "", fmt.Errorf("from date is after to date")
+	}
+	if end.Sub(start) > maxQueryDays*24*time.Hour {
+		return "", "", fmt.Errorf("date range is longer than %d days", maxQueryDays)
+	}
+
+	return from, to, nil
+}
+
+func (s *almanaxStore) aggregateOfferings(fromDate string, toDate string, lang string) []offeringTotal {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return aggregateOfferings(s.days, fromDate, toDate, lang)
+}
+
+func (s *server) handleOfferings(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from, to, err := parseDateRangeQuery(query.Get("from"), query.Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lang := normalizeLang(query.Get("lang"))
+	totals := s.store.aggregateOfferings(from, to, lang)
+
+	switch format := query.Get("format"); format {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(totals)
+	case "markdown", "csv":
+		contentType := "text/markdown; charset=utf-8"
+		if format == "csv" {
+			contentType = "text/csv; charset=utf-8"
+		}
+		w.Header().Set("Content-Type", contentType)
+		err = writeShoppingList(w, format, totals, lang, from, to)
+		if err != nil {
+			log.Error("error writing shopping list", "error", err)
+		}
+	default:
+		http.Error(w, "unknown format, expected json, markdown or csv", http.StatusBadRequest)
+	}
+}
+
+func offeringsCommand(args []string) {
+	flags := flag.NewFlagSet("offerings", flag.ExitOnError)
+	from := flags.String("from", "", "first date (yyyy-mm-dd), defaults to today")
+	to := flags.String("to", "", "last date (yyyy-mm-dd), defaults to today + 30 days")
+	lang := flags.String("lang", "en", "language of the item names")
+	format := flags.String("format", "table", "output format: table, markdown or csv")
+	version := flags.String("version", "", "data repo version, defaults to the latest release")
+	file := flags.String("file", "", "read the mapped almanax from a local file instead of the release")
+	_ = flags.Parse(args)
+
+	fromDate, toDate, err := parseDateRangeQuery(*from, *to)
+	if err != nil {
+		log.Fatal("error parsing date range", "error", err)
+	}
+
+	ds, err := loadAlmanaxSource(*file, *version)
+	if err != nil {
+		log.Fatal("error loading almanax data", "error", err)
+	}
+
+	outLang := normalizeLang(*lang)
+	totals := aggregateOfferings(almanax.NewIndex(ds), fromDate, toDate, outLang)
+
+	err = writeShoppingList(os.Stdout, *format, totals, outLang, fromDate, toDate)
+	if err != nil {
+		log.Fatal("error writing shopping list", "error", err)
+	}
+}
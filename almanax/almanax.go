@@ -0,0 +1,193 @@
+// Package almanax holds the almanax domain types used by alm-dates and reads and writes them in the
+// layouts the mapped almanax asset is published in.
+package almanax
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Text is a translated text by language code.
+type Text map[string]string
+
+// Get returns the text in the language, falling back to english.
+func (t Text) Get(lang string) string {
+	if text, ok := t[lang]; ok && text != "" {
+		return text
+	}
+	return t["en"]
+}
+
+// Offering is the item a receiver asks for.
+type Offering struct {
+	ItemId         int
+	ItemCategoryId int
+	ItemName       Text
+	Quantity       int
+}
+
+// Bonus is the daily bonus that comes with a receiver.
+type Bonus struct {
+	Type        Text
+	Description Text
+}
+
+// Receiver is an almanax npc with its offering, rewards and the dates it is the receiver on.
+type Receiver struct {
+	// Name is the english name, the only one the data has.
+	Name            string
+	Days            []string
+	Offering        Offering
+	Bonus           Bonus
+	RewardKamas     int
+	ExperienceRatio float64
+	OptimalLevel    int
+	Duration        float64
+}
+
+// DayEntry is the receiver of one date.
+type DayEntry struct {
+	Date     string
+	Receiver *Receiver
+}
+
+// Metadata is the license and attribution that redistributors of the data have to keep.
+type Metadata struct {
+	License     string
+	Attribution string
+	Sources     []string
+}
+
+// IsZero reports whether no metadata is set.
+func (m Metadata) IsZero() bool {
+	return m.License == "" && m.Attribution == "" && len(m.Sources) == 0
+}
+
+// Dataset is the almanax of one game version.
+type Dataset struct {
+	Receivers []Receiver
+	// Tombstones are receivers that left the game data with the days they had before, so the history of
+	// the dataset stays complete. They are only kept by schemas that have a place for them.
+	Tombstones []Receiver
+	// Metadata is only kept by schemas that have a place for it.
+	Metadata Metadata
+	// Schema is the layout version of the asset the dataset was read from.
+	Schema int
+}
+
+// Mapped reports whether any receiver has dates.
+func (d *Dataset) Mapped() bool {
+	for _, receiver := range d.Receivers {
+		if len(receiver.Days) > 0 && receiver.Days[0] != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Days returns all mapped dates in order.
+func (d *Dataset) Days() []DayEntry {
+	var days []DayEntry
+	for i := range d.Receivers {
+		for _, date := range d.Receivers[i].Days {
+			if date != "" {
+				days = append(days, DayEntry{Date: date, Receiver: &d.Receivers[i]})
+			}
+		}
+	}
+	sort.Slice(days, func(i, j int) bool {
+		return days[i].Date < days[j].Date
+	})
+	return days
+}
+
+// Coverage returns the first and last mapped date, both empty if nothing is mapped.
+func (d *Dataset) Coverage() (string, string) {
+	days := d.Days()
+	if len(days) == 0 {
+		return "", ""
+	}
+	return days[0].Date, days[len(days)-1].Date
+}
+
+// Month returns a dataset with the receivers and tombstones of the dates in a month like 2025-03 and only
+// those dates, in the same schema and with the same metadata.
+func (d *Dataset) Month(month string) *Dataset {
+	return &Dataset{
+		Receivers:  receiversInMonth(d.Receivers, month),
+		Tombstones: receiversInMonth(d.Tombstones, month),
+		Metadata:   d.Metadata,
+		Schema:     d.Schema,
+	}
+}
+
+func receiversInMonth(receivers []Receiver, month string) []Receiver {
+	var result []Receiver
+	for _, receiver := range receivers {
+		var days []string
+		for _, date := range receiver.Days {
+			if strings.HasPrefix(date, month+"-") {
+				days = append(days, date)
+			}
+		}
+		if len(days) == 0 {
+			continue
+		}
+		receiver.Days = days
+		result = append(result, receiver)
+	}
+	return result
+}
+
+// Tombstone returns the removed receiver a date had.
+func (d *Dataset) Tombstone(date string) (*Receiver, bool) {
+	for i := range d.Tombstones {
+		if slices.Contains(d.Tombstones[i].Days, date) {
+			return &d.Tombstones[i], true
+		}
+	}
+	return nil, false
+}
+
+// NormalizeDays sorts the dates of every receiver and tombstone and drops repeated dates and values that are
+// not a YYYY-MM-DD date. The empty placeholder of a receiver without dates is kept, next to dates it is
+// dropped silently. It returns the other dropped values as "name: value".
+func (d *Dataset) NormalizeDays() []string {
+	var dropped []string
+	normalize := func(receivers []Receiver) {
+		for i := range receivers {
+			receiver := &receivers[i]
+			if len(receiver.Days) == 1 && receiver.Days[0] == "" {
+				continue
+			}
+			days := make([]string, 0, len(receiver.Days))
+			for _, date := range receiver.Days {
+				if date == "" {
+					continue
+				}
+				if _, err := time.Parse("2006-01-02", date); err != nil || slices.Contains(days, date) {
+					dropped = append(dropped, receiver.Name+": "+date)
+					continue
+				}
+				days = append(days, date)
+			}
+			sort.Strings(days)
+			receiver.Days = days
+		}
+	}
+	normalize(d.Receivers)
+	normalize(d.Tombstones)
+	return dropped
+}
+
+// SortDays sorts the dates of every receiver and tombstone.
+func (d *Dataset) SortDays() {
+	for i := range d.Receivers {
+		sort.Strings(d.Receivers[i].Days)
+	}
+	for i := range d.Tombstones {
+		sort.Strings(d.Tombstones[i].Days)
+	}
+}
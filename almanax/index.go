@@ -0,0 +1,65 @@
+package almanax
+
+import (
+	"sort"
+)
+
+// Index answers date lookups and range queries on a dataset without scanning the days of every
+// receiver. It is built once and must be rebuilt when the dataset changes.
+type Index struct {
+	byDate map[string]*Receiver
+	// days are sorted by date
+	days []DayEntry
+}
+
+func NewIndex(ds *Dataset) *Index {
+	days := ds.Days()
+	byDate := make(map[string]*Receiver, len(days))
+	for _, day := range days {
+		byDate[day.Date] = day.Receiver
+	}
+	return &Index{byDate: byDate, days: days}
+}
+
+// Day returns the receiver of a date.
+func (x *Index) Day(date string) (*Receiver, bool) {
+	receiver, ok := x.byDate[date]
+	return receiver, ok
+}
+
+// Days returns all mapped dates in order, the slice must not be modified.
+func (x *Index) Days() []DayEntry {
+	return x.days
+}
+
+// Range returns the mapped dates from from to to, both included, in order. An empty bound is open.
+func (x *Index) Range(from string, to string) []DayEntry {
+	start := 0
+	if from != "" {
+		start = sort.Search(len(x.days), func(i int) bool {
+			return x.days[i].Date >= from
+		})
+	}
+	end := len(x.days)
+	if to != "" {
+		end = sort.Search(len(x.days), func(i int) bool {
+			return x.days[i].Date > to
+		})
+	}
+	if start >= end {
+		return nil
+	}
+	return x.days[start:end]
+}
+
+// Coverage returns the first and last mapped date, both empty if nothing is mapped.
+func (x *Index) Coverage() (string, string) {
+	if len(x.days) == 0 {
+		return "", ""
+	}
+	return x.days[0].Date, x.days[len(x.days)-1].Date
+}
+
+func (x *Index) Len() int {
+	return len(x.days)
+}
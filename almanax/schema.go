@@ -0,0 +1,306 @@
+package almanax
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	mapping "github.com/dofusdude/dodumap"
+)
+
+// Schema versions of the mapped almanax asset.
+const (
+	// a plain list of mapping.MappedMultilangNPCAlmanaxUnity, what dodumap writes today
+	SchemaMappedList = 1
+	// the announced layout: an object with schema_version, metadata and snake_case receivers
+	SchemaReceivers = 2
+)
+
+// schemaAdapter reads and writes one layout of the mapped almanax asset.
+type schemaAdapter struct {
+	decode func(data []byte) (*Dataset, error)
+	encode func(ds *Dataset) ([]byte, error)
+}
+
+var schemaAdapters = map[int]schemaAdapter{
+	SchemaMappedList: {decode: decodeMappedList, encode: encodeMappedList},
+	SchemaReceivers:  {decode: decodeReceivers, encode: encodeReceivers},
+}
+
+// probeSchema finds the schema version of an asset: lists are the current dodumap output,
+// objects carry their version in schema_version.
+func probeSchema(data []byte) (int, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return 0, fmt.Errorf("empty almanax asset")
+	}
+
+	switch data[0] {
+	case '[':
+		return SchemaMappedList, nil
+	case '{':
+		var header struct {
+			SchemaVersion int `json:"schema_version"`
+		}
+		err := json.Unmarshal(data, &header)
+		if err != nil {
+			return 0, err
+		}
+		if header.SchemaVersion == 0 {
+			return 0, fmt.Errorf("almanax asset has no schema_version")
+		}
+		return header.SchemaVersion, nil
+	}
+	return 0, fmt.Errorf("unknown almanax asset layout")
+}
+
+// Decode reads an asset in any supported schema. The schema is kept on the dataset so it can be
+// written again in the layout it came in.
+func Decode(data []byte) (*Dataset, error) {
+	schema, err := probeSchema(data)
+	if err != nil {
+		return nil, err
+	}
+
+	adapter, ok := schemaAdapters[schema]
+	if !ok {
+		return nil, fmt.Errorf("unsupported almanax schema version %d, alm-dates needs an update", schema)
+	}
+
+	ds, err := adapter.decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("decoding almanax schema version %d: %w", schema, err)
+	}
+	ds.Schema = schema
+	return ds, nil
+}
+
+// Encode writes the dataset in its schema, new datasets use the dodumap list. The output only depends on
+// the dataset: fields and text languages are written in a fixed order, dates sorted and numbers in their
+// shortest form, so the same input always gives the same bytes.
+func (d *Dataset) Encode() ([]byte, error) {
+	schema := d.Schema
+	if schema == 0 {
+		schema = SchemaMappedList
+	}
+
+	adapter, ok := schemaAdapters[schema]
+	if !ok {
+		return nil, fmt.Errorf("unsupported almanax schema version %d", schema)
+	}
+	return adapter.encode(d)
+}
+
+// canonicalDays returns the dates sorted, never nil so they are written as a list.
+func canonicalDays(days []string) []string {
+	days = slices.Clone(days)
+	if days == nil {
+		return []string{}
+	}
+	slices.Sort(days)
+	return days
+}
+
+// canonicalFloat turns -0 into 0, which json writes differently.
+func canonicalFloat(f float64) float64 {
+	if f == 0 {
+		return 0
+	}
+	return f
+}
+
+func decodeMappedList(data []byte) (*Dataset, error) {
+	var almData []mapping.MappedMultilangNPCAlmanaxUnity
+	err := json.Unmarshal(data, &almData)
+	if err != nil {
+		return nil, err
+	}
+	return FromMapped(almData), nil
+}
+
+func encodeMappedList(ds *Dataset) ([]byte, error) {
+	return json.MarshalIndent(ds.ToMapped(), "", "  ")
+}
+
+// FromMapped converts the dodumap data into the domain model, the mapped data is not shared.
+func FromMapped(almData []mapping.MappedMultilangNPCAlmanaxUnity) *Dataset {
+	ds := &Dataset{Receivers: make([]Receiver, len(almData))}
+	for i, alm := range almData {
+		ds.Receivers[i] = Receiver{
+			Name: alm.OfferingReceiver,
+			Days: slices.Clone(alm.Days),
+			Offering: Offering{
+				ItemId:         alm.Offering.ItemId,
+				ItemCategoryId: alm.Offering.ItemCategoryId,
+				ItemName:       Text(alm.Offering.ItemName),
+				Quantity:       alm.Offering.Quantity,
+			},
+			Bonus: Bonus{
+				Type:        Text(alm.BonusType),
+				Description: Text(alm.Bonus),
+			},
+			RewardKamas:     alm.RewardKamas,
+			ExperienceRatio: alm.ExperienceRatio,
+			OptimalLevel:    alm.OptimalLevel,
+			Duration:        alm.Duration,
+		}
+	}
+	return ds
+}
+
+// ToMapped converts the domain model back into the dodumap layout for publishing.
+func (d *Dataset) ToMapped() []mapping.MappedMultilangNPCAlmanaxUnity {
+	almData := make([]mapping.MappedMultilangNPCAlmanaxUnity, len(d.Receivers))
+	for i, receiver := range d.Receivers {
+		alm := &almData[i]
+		alm.OfferingReceiver = receiver.Name
+		alm.Days = canonicalDays(receiver.Days)
+		alm.Offering.ItemId = receiver.Offering.ItemId
+		alm.Offering.ItemCategoryId = receiver.Offering.ItemCategoryId
+		alm.Offering.ItemName = receiver.Offering.ItemName
+		alm.Offering.Quantity = receiver.Offering.Quantity
+		alm.Bonus = receiver.Bonus.Description
+		alm.BonusType = receiver.Bonus.Type
+		alm.RewardKamas = receiver.RewardKamas
+		alm.ExperienceRatio = canonicalFloat(receiver.ExperienceRatio)
+		alm.OptimalLevel = receiver.OptimalLevel
+		alm.Duration = canonicalFloat(receiver.Duration)
+	}
+	return almData
+}
+
+type receiversAsset struct {
+	SchemaVersion int             `json:"schema_version"`
+	Metadata      *metadataEntry  `json:"metadata,omitempty"`
+	Receivers     []receiverEntry `json:"receivers"`
+	Tombstones    []receiverEntry `json:"tombstones,omitempty"`
+}
+
+type metadataEntry struct {
+	License     string   `json:"license,omitempty"`
+	Attribution string   `json:"attribution,omitempty"`
+	Sources     []string `json:"sources,omitempty"`
+}
+
+type receiverEntry struct {
+	Name     string   `json:"name"`
+	Days     []string `json:"days"`
+	Offering struct {
+		ItemId         int               `json:"item_id"`
+		ItemCategoryId int               `json:"item_category_id"`
+		ItemName       map[string]string `json:"item_name"`
+		Quantity       int               `json:"quantity"`
+	} `json:"offering"`
+	Bonus struct {
+		Type        map[string]string `json:"type"`
+		Description map[string]string `json:"description"`
+	} `json:"bonus"`
+	Rewards struct {
+		Kamas           int     `json:"kamas"`
+		ExperienceRatio float64 `json:"experience_ratio"`
+	} `json:"rewards"`
+	OptimalLevel int     `json:"optimal_level"`
+	Duration     float64 `json:"duration"`
+}
+
+func decodeReceivers(data []byte) (*Dataset, error) {
+	var asset receiversAsset
+	err := json.Unmarshal(data, &asset)
+	if err != nil {
+		return nil, err
+	}
+
+	ds := &Dataset{Receivers: make([]Receiver, len(asset.Receivers))}
+	if asset.Metadata != nil {
+		ds.Metadata = Metadata{
+			License:     asset.Metadata.License,
+			Attribution: asset.Metadata.Attribution,
+			Sources:     asset.Metadata.Sources,
+		}
+	}
+	for i, entry := range asset.Receivers {
+		ds.Receivers[i] = entry.receiver()
+	}
+	for _, entry := range asset.Tombstones {
+		ds.Tombstones = append(ds.Tombstones, entry.receiver())
+	}
+	return ds, nil
+}
+
+func encodeReceivers(ds *Dataset) ([]byte, error) {
+	asset := receiversAsset{SchemaVersion: SchemaReceivers, Receivers: make([]receiverEntry, len(ds.Receivers))}
+	if !ds.Metadata.IsZero() {
+		asset.Metadata = &metadataEntry{
+			License:     ds.Metadata.License,
+			Attribution: ds.Metadata.Attribution,
+			Sources:     ds.Metadata.Sources,
+		}
+	}
+	for i, receiver := range ds.Receivers {
+		asset.Receivers[i] = newReceiverEntry(receiver)
+	}
+	for _, receiver := range ds.Tombstones {
+		asset.Tombstones = append(asset.Tombstones, newReceiverEntry(receiver))
+	}
+	return json.MarshalIndent(asset, "", "  ")
+}
+
+// EmbedsMetadata reports whether the schema of the dataset writes the metadata into the asset. The others
+// publish it with EncodeMetadata next to the asset.
+func (d *Dataset) EmbedsMetadata() bool {
+	return d.Schema == SchemaReceivers
+}
+
+// EncodeMetadata writes the metadata on its own, in the layout of the metadata object of schema version 2.
+func EncodeMetadata(m Metadata) ([]byte, error) {
+	return json.MarshalIndent(metadataEntry{License: m.License, Attribution: m.Attribution, Sources: m.Sources}, "", "  ")
+}
+
+// DecodeMetadata reads metadata written by EncodeMetadata.
+func DecodeMetadata(data []byte) (Metadata, error) {
+	var entry metadataEntry
+	err := json.Unmarshal(data, &entry)
+	if err != nil {
+		return Metadata{}, err
+	}
+	return Metadata{License: entry.License, Attribution: entry.Attribution, Sources: entry.Sources}, nil
+}
+
+func (entry receiverEntry) receiver() Receiver {
+	return Receiver{
+		Name: entry.Name,
+		Days: entry.Days,
+		Offering: Offering{
+			ItemId:         entry.Offering.ItemId,
+			ItemCategoryId: entry.Offering.ItemCategoryId,
+			ItemName:       entry.Offering.ItemName,
+			Quantity:       entry.Offering.Quantity,
+		},
+		Bonus: Bonus{
+			Type:        entry.Bonus.Type,
+			Description: entry.Bonus.Description,
+		},
+		RewardKamas:     entry.Rewards.Kamas,
+		ExperienceRatio: entry.Rewards.ExperienceRatio,
+		OptimalLevel:    entry.OptimalLevel,
+		Duration:        entry.Duration,
+	}
+}
+
+func newReceiverEntry(receiver Receiver) receiverEntry {
+	var entry receiverEntry
+	entry.Name = receiver.Name
+	entry.Days = canonicalDays(receiver.Days)
+	entry.Offering.ItemId = receiver.Offering.ItemId
+	entry.Offering.ItemCategoryId = receiver.Offering.ItemCategoryId
+	entry.Offering.ItemName = receiver.Offering.ItemName
+	entry.Offering.Quantity = receiver.Offering.Quantity
+	entry.Bonus.Type = receiver.Bonus.Type
+	entry.Bonus.Description = receiver.Bonus.Description
+	entry.Rewards.Kamas = receiver.RewardKamas
+	entry.Rewards.ExperienceRatio = canonicalFloat(receiver.ExperienceRatio)
+	entry.OptimalLevel = receiver.OptimalLevel
+	entry.Duration = canonicalFloat(receiver.Duration)
+	return entry
+}
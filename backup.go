@@ -0,0 +1,238 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/log"
+)
+
+// backupWorkdir writes the state of the workdir and its layout marker, without the excluded files, as a
+// gzipped tarball. Caches, kept pages and anything else in the workdir are left out.
+func backupWorkdir(workdir string, out io.Writer, exclude ...string) error {
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+
+	err := backupPath(tw, workdir, filepath.Join(workdir, layoutFileName), exclude)
+	if err != nil {
+		return err
+	}
+
+	err = backupPath(tw, workdir, stateDir(workdir), exclude)
+	if err != nil {
+		return err
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// backupPath writes root and everything below it, named relative to the workdir. A fresh workdir has
+// neither the layout marker nor state/ yet, a missing root is skipped.
+func backupPath(tw *tar.Writer, workdir string, root string, exclude []string) error {
+	_, err := os.Stat(root)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		for _, excluded := range exclude {
+			if path == excluded {
+				return nil
+			}
+		}
+		if !(info.IsDir() || info.Mode().IsRegular()) {
+			return nil
+		}
+
+		name, err := filepath.Rel(workdir, path)
+		if err != nil {
+			return err
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		err = tw.WriteHeader(header)
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		_, err = io.Copy(tw, file)
+		return err
+	})
+}
+
+// restoreWorkdir extracts a backup into the workdir and migrates it to the current layout.
+func restoreWorkdir(workdir string, in io.Reader) error {
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(workdir, filepath.FromSlash(header.Name))
+		state := stateDir(workdir)
+		if target != state && target != filepath.Join(workdir, layoutFileName) && !strings.HasPrefix(target, state+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid path in backup: %s", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, os.ModePerm)
+		case tar.TypeReg:
+			err = extractFile(target, tr, os.FileMode(header.Mode).Perm())
+		default:
+			log.Warn("skipping unsupported backup entry", "name", header.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return migrateWorkdir(workdir)
+}
+
+func extractFile(target string, r io.Reader, perm os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(target), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, r)
+	return err
+}
+
+// hasState reports whether the state directory of the workdir contains anything.
+func hasState(workdir string) (bool, error) {
+	entries, err := os.ReadDir(stateDir(workdir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return len(entries) > 0, nil
+}
+
+func stateCommand(args []string) {
+	if len(args) == 0 {
+		log.Fatal("missing state subcommand", "available", "backup, restore")
+	}
+
+	switch args[0] {
+	case "backup":
+		stateBackupCommand(args[1:])
+	case "restore":
+		stateRestoreCommand(args[1:])
+	default:
+		log.Fatal("unknown state subcommand", "command", args[0])
+	}
+}
+
+func stateBackupCommand(args []string) {
+	flags := flag.NewFlagSet("state backup", flag.ExitOnError)
+	out := flags.String("out", "alm-dates-state.tar.gz", "backup file")
+	cfg, _, err := loadConfig(flags, args)
+	if err != nil {
+		log.Fatal("error loading config", "error", err)
+	}
+
+	workdir, err := parseWd(cfg.Workdir)
+	if err != nil {
+		log.Fatal("error parsing working directory", "error", err)
+	}
+
+	outPath, err := filepath.Abs(*out)
+	if err != nil {
+		log.Fatal("error parsing backup file path", "error", err)
+	}
+
+	file, err := os.Create(outPath)
+	if err != nil {
+		log.Fatal("error creating backup file", "error", err)
+	}
+	defer file.Close()
+
+	err = backupWorkdir(workdir, file, outPath)
+	if err != nil {
+		log.Fatal("error writing backup", "error", err)
+	}
+
+	log.Info("state backed up", "workdir", workdir, "out", *out)
+}
+
+func stateRestoreCommand(args []string) {
+	flags := flag.NewFlagSet("state restore", flag.ExitOnError)
+	in := flags.String("in", "alm-dates-state.tar.gz", "backup file")
+	force := flags.Bool("force", false, "overwrite existing state in the workdir")
+	cfg, _, err := loadConfig(flags, args)
+	if err != nil {
+		log.Fatal("error loading config", "error", err)
+	}
+
+	workdir, err := parseWd(cfg.Workdir)
+	if err != nil {
+		log.Fatal("error parsing working directory", "error", err)
+	}
+
+	existing, err := hasState(workdir)
+	if err != nil {
+		log.Fatal("error reading working directory", "error", err)
+	}
+	if existing && !*force {
+		log.Fatal("workdir already contains state, use --force to overwrite it", "workdir", workdir)
+	}
+
+	file, err := os.Open(*in)
+	if err != nil {
+		log.Fatal("error opening backup file", "error", err)
+	}
+	defer file.Close()
+
+	err = restoreWorkdir(workdir, file)
+	if err != nil {
+		log.Fatal("error restoring backup", "error", err)
+	}
+
+	log.Info("state restored", "workdir", workdir, "in", *in)
+}
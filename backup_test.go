@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, path string, data string) {
+	t.Helper()
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, []byte(data), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackupRestoreRoundTrip(t *testing.T) {
+	workdir := t.TempDir()
+	err := migrateWorkdir(workdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(stateDir(workdir), "version"), "1.0.0")
+	writeTestFile(t, overridesPath(workdir), `{"2025-01-01": {"skip": true}}`)
+	writeTestFile(t, filepath.Join(stateDir(workdir), "checkpoints", "1.0.0.jsonl"), "{}\n")
+	writeTestFile(t, pagePath(pagesDir(workdir), "en", "2025-01-01"), "<html></html>")
+	writeTestFile(t, filepath.Join(workdir, "stray.txt"), "not state")
+	out := filepath.Join(stateDir(workdir), "backup.tar.gz")
+	writeTestFile(t, out, "the backup being written")
+
+	var backup bytes.Buffer
+	err = backupWorkdir(workdir, &backup, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := t.TempDir()
+	err = restoreWorkdir(restored, &backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{layoutFileName, "state/version", "state/overrides.json", "state/checkpoints/1.0.0.jsonl"} {
+		want, err := os.ReadFile(filepath.Join(workdir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(filepath.Join(restored, filepath.FromSlash(name)))
+		if err != nil {
+			t.Errorf("%s not restored: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s restored as %q, want %q", name, got, want)
+		}
+	}
+	for _, name := range []string{"cache", "stray.txt", "state/backup.tar.gz"} {
+		if _, err := os.Stat(filepath.Join(restored, filepath.FromSlash(name))); err == nil {
+			t.Errorf("%s was backed up", name)
+		}
+	}
+}
+
+func TestBackupFreshWorkdir(t *testing.T) {
+	workdir := t.TempDir()
+	var backup bytes.Buffer
+	err := backupWorkdir(workdir, &backup)
+	if err != nil {
+		t.Fatalf("backing up a workdir without state: %v", err)
+	}
+
+	restored := t.TempDir()
+	err = restoreWorkdir(restored, &backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if existing, err := hasState(restored); err != nil || existing {
+		t.Errorf("restored empty backup has state %v, error %v", existing, err)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// parseVersions reads the versions of a map batch from a comma separated list and a file with one version per
+// line, where empty lines and lines starting with # are skipped. A version listed twice is mapped once.
+func parseVersions(list string, file string) ([]string, error) {
+	candidates := strings.Split(list, ",")
+	if file != "" {
+		in := os.Stdin
+		if file != "-" {
+			f, err := os.Open(file)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			in = f
+		}
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			candidates = append(candidates, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	var versions []string
+	seen := make(map[string]bool)
+	for _, version := range candidates {
+		version = strings.TrimSpace(version)
+		if version == "" || strings.HasPrefix(version, "#") || seen[version] {
+			continue
+		}
+		seen[version] = true
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// mapBatch maps the versions one after another with the same scraper, so its kept pages and budgets carry
+// over from one version to the next. It stops at the first failure unless keepGoing is set and returns the
+// versions that failed or were not mapped.
+func (p *pipeline) mapBatch(versions []string, force bool, keepGoing bool) []string {
+	var failed []string
+	for n, version := range versions {
+		j := job{Kind: jobMapVersion, Version: version, Force: force, Trigger: "map-command", Queued: time.Now()}
+		p.log.Info("mapping version", "version", version, "of", fmt.Sprintf("%d/%d", n+1, len(versions)))
+		p.scraper.limits.begin(p.repo.String())
+		start := time.Now()
+		err := p.execute(j)
+		p.recordRun(j, start, err)
+		if err == nil {
+			p.log.Info("version mapped", "version", version, "duration", FormatDuration(time.Since(start).Round(time.Second)))
+			continue
+		}
+
+		p.log.Error("error mapping version", "version", version, "error", err)
+		failed = append(failed, version)
+		if !keepGoing {
+			return append(failed, versions[n+1:]...)
+		}
+	}
+
+	// the batch may have pushed older releases out of keep_releases
+	if p.cfg.KeepReleases > 0 && len(failed) < len(versions) {
+		err := p.cleanupReleases()
+		if err != nil {
+			p.log.Error("error cleaning up releases", "error", err)
+		}
+	}
+	return failed
+}
+
+// commandPipeline sets up the pipeline of a tenant, the only one without tenants, and the process for a
+// command that runs it without the daemon.
+func commandPipeline(cfg *Config, tenant string) *pipeline {
+	pipelines, err := loadPipelines(cfg)
+	if err != nil {
+		log.Fatal("error setting up pipelines", "error", err)
+	}
+	var p *pipeline
+	for _, candidate := range pipelines {
+		if candidate.name == tenant {
+			p = candidate
+		}
+	}
+	if p == nil {
+		log.Fatal("unknown tenant, use --tenant", "tenant", tenant)
+	}
+
+	setupProcess(cfg, []*pipeline{p})
+	startReaper()
+	go handleSignals()
+	return p
+}
+
+// mapCommand maps explicit data repo versions without running the daemon, for releases that missed their
+// mapping. It exits with 1 if any version is not mapped.
+func mapCommand(args []string) {
+	flags := flag.NewFlagSet("map", flag.ExitOnError)
+	list := flags.String("versions", "", "comma separated data release versions to map, in this order")
+	file := flags.String("versions-file", "", "file with one version per line to map after --versions, - reads stdin")
+	tenant := flags.String("tenant", "", "tenant to map the versions for")
+	force := flags.Bool("force", false, "map every date of the window again, even if the release has it")
+	keepGoing := flags.Bool("keep-going", false, "map the remaining versions after one fails")
+	cfg, _, err := loadConfig(flags, args)
+	if err != nil {
+		log.Fatal("error loading config", "error", err)
+	}
+
+	if level, err := log.ParseLevel(cfg.LogLevel); err == nil {
+		log.SetLevel(level)
+	}
+
+	versions, err := parseVersions(*list, *file)
+	if err != nil {
+		log.Fatal("error reading versions", "error", err)
+	}
+	if len(versions) == 0 {
+		log.Fatal("no versions given, use --versions or --versions-file")
+	}
+
+	p := commandPipeline(&cfg, *tenant)
+
+	// an interrupted publish is completed by the daemon before anything else touches the release
+	journal, err := loadPublishJournal(p.workdir)
+	if err != nil {
+		log.Fatal("error reading publish journal", "error", err)
+	}
+	if journal != nil {
+		log.Fatal("a publish was interrupted, start the daemon to complete it first", "version", journal.Version)
+	}
+
+	err = p.duringMaintenance()
+	if err != nil {
+		log.Fatal("not mapping, try again later", "error", err)
+	}
+
+	failed := p.mapBatch(versions, *force, *keepGoing)
+	if len(failed) > 0 {
+		log.Error("versions not mapped", "mapped", len(versions)-len(failed), "not_mapped", strings.Join(failed, ","))
+		os.Exit(1)
+	}
+	log.Info("versions mapped", "mapped", len(versions))
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const bundleManifestName = "bundle.json"
+
+// bundleManifest describes a bundle, it is the first entry of the archive.
+type bundleManifest struct {
+	Version  string    `json:"version"`
+	Created  time.Time `json:"created"`
+	DataRepo string    `json:"data_repo"`
+	// Output is whether the bundle holds the asset the kept pages map to
+	Output bool     `json:"output"`
+	Files  []string `json:"files"`
+}
+
+// bundleOutputPath is where a bundle keeps the asset of its version, relative to the workdir.
+func bundleOutputPath(version string) string {
+	return filepath.Join(cacheDir(""), "bundles", version, MappedAlmanaxFileName)
+}
+
+// bundleFiles lists the files of the workdir a bundle of the version holds, relative to the workdir: the
+// replay record with the seed, the kept pages and their validators of its dates, the checkpoint of an
+// unfinished run, the aliases the playbook learned and the overrides.
+func bundleFiles(workdir string, record replayRecord) ([]string, error) {
+	candidates := []string{
+		replayRecordPath(workdir, record.Version),
+		checkpointPath(workdir, record.Version),
+		filepath.Join(stateDir(workdir), learnedAliasesFileName),
+		overridesPath(workdir),
+	}
+
+	pages := pagesDir(workdir)
+	langs, err := os.ReadDir(pages)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, lang := range langs {
+		if !lang.IsDir() {
+			continue
+		}
+		for _, date := range record.Dates {
+			candidates = append(candidates, pagePath(pages, lang.Name(), date), validatorsPath(pages, lang.Name(), date), monthCellPath(pages, lang.Name(), date))
+		}
+	}
+
+	var files []string
+	for _, path := range candidates {
+		info, err := os.Stat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		rel, err := filepath.Rel(workdir, path)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, filepath.ToSlash(rel))
+	}
+	return files, nil
+}
+
+// exportBundle writes everything needed to continue or reproduce the run of a version as a gzipped
+// tarball. The asset replayed from the kept pages is added when they cover all dates.
+func exportBundle(workdir string, version string, repo dataRepo, aliases map[string]string, out io.Writer) (bundleManifest, error) {
+	manifest := bundleManifest{Version: version, Created: time.Now().UTC(), DataRepo: repo.String()}
+	record, err := loadReplayRecord(workdir, version)
+	if err != nil {
+		return manifest, err
+	}
+	manifest.Files, err = bundleFiles(workdir, record)
+	if err != nil {
+		return manifest, err
+	}
+
+	output, err := replayAsset(workdir, record, aliases)
+	if err != nil {
+		log.Warn("bundle without output, the kept pages do not map every date", "version", version, "error", err)
+		output = nil
+	}
+	manifest.Output = output != nil
+
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+	err = writeTarBytes(tw, bundleManifestName, data)
+	if err != nil {
+		return manifest, err
+	}
+	if output != nil {
+		err = writeTarBytes(tw, filepath.ToSlash(bundleOutputPath(version)), output)
+		if err != nil {
+			return manifest, err
+		}
+	}
+	for _, name := range manifest.Files {
+		data, err := os.ReadFile(filepath.Join(workdir, filepath.FromSlash(name)))
+		if err != nil {
+			return manifest, err
+		}
+		err = writeTarBytes(tw, name, data)
+		if err != nil {
+			return manifest, err
+		}
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return manifest, err
+	}
+	return manifest, gz.Close()
+}
+
+func writeTarBytes(tw *tar.Writer, name string, data []byte) error {
+	err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: time.Now(), Typeflag: tar.TypeReg})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+// importBundle extracts a bundle into the workdir. Files that exist already are only replaced with force,
+// the manifest is checked before anything is written.
+func importBundle(workdir string, in io.Reader, force bool) (bundleManifest, error) {
+	var manifest bundleManifest
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return manifest, err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	header, err := tr.Next()
+	if err != nil {
+		return manifest, fmt.Errorf("reading bundle: %w", err)
+	}
+	if header.Name != bundleManifestName {
+		return manifest, fmt.Errorf("not a bundle, %s is missing", bundleManifestName)
+	}
+	err = json.NewDecoder(tr).Decode(&manifest)
+	if err != nil {
+		return manifest, fmt.Errorf("reading %s: %w", bundleManifestName, err)
+	}
+
+	if !force {
+		names := manifest.Files
+		if manifest.Output {
+			names = append(names, filepath.ToSlash(bundleOutputPath(manifest.Version)))
+		}
+		for _, name := range names {
+			if _, err := os.Stat(bundleTarget(workdir, name)); err == nil {
+				return manifest, fmt.Errorf("%s exists in the workdir, use --force to replace it", name)
+			}
+		}
+	}
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest, err
+		}
+
+		target := bundleTarget(workdir, header.Name)
+		if !strings.HasPrefix(target, filepath.Clean(workdir)+string(os.PathSeparator)) {
+			return manifest, fmt.Errorf("invalid path in bundle: %s", header.Name)
+		}
+		if header.Typeflag != tar.TypeReg {
+			log.Warn("skipping unsupported bundle entry", "name", header.Name)
+			continue
+		}
+		err = extractFile(target, tr, 0644)
+		if err != nil {
+			return manifest, err
+		}
+	}
+	return manifest, nil
+}
+
+// bundleTarget is where a file of a bundle goes in the workdir. Bundles from before the overrides moved
+// into state/ have them at the root.
+func bundleTarget(workdir string, name string) string {
+	if name == overridesFileName {
+		return overridesPath(workdir)
+	}
+	return filepath.Join(workdir, filepath.FromSlash(name))
+}
+
+func bundleCommand(args []string) {
+	if len(args) == 0 {
+		log.Fatal("missing bundle subcommand", "available", "export, import")
+	}
+
+	switch args[0] {
+	case "export":
+		bundleExportCommand(args[1:])
+	case "import":
+		bundleImportCommand(args[1:])
+	default:
+		log.Fatal("unknown bundle subcommand", "command", args[0])
+	}
+}
+
+func bundleExportCommand(args []string) {
+	flags := flag.NewFlagSet("bundle export", flag.ExitOnError)
+	version := flags.String("version", "", "game version to bundle, defaults to the last seen version")
+	out := flags.String("out", "", "bundle file, defaults to alm-dates-<version>.tar.gz")
+	cfg, _, err := loadConfig(flags, args)
+	if err != nil {
+		log.Fatal("error loading config", "error", err)
+	}
+
+	workdir, err := parseWd(cfg.Workdir)
+	if err != nil {
+		log.Fatal("error parsing working directory", "error", err)
+	}
+	err = migrateWorkdir(workdir)
+	if err != nil {
+		log.Fatal("error migrating working directory", "error", err)
+	}
+	if *version == "" {
+		*version, err = loadLocalVersion(workdir)
+		if err != nil || *version == "" {
+			log.Fatal("no version given and none seen yet", "error", err)
+		}
+	}
+	if *out == "" {
+		*out = fmt.Sprintf("alm-dates-%s.tar.gz", *version)
+	}
+
+	file, err := os.Create(*out)
+	if err != nil {
+		log.Fatal("error creating bundle file", "error", err)
+	}
+	defer file.Close()
+
+	nameTolerance = cfg.NameTolerance
+	learned, err := loadLearnedAliases(workdir)
+	if err != nil {
+		log.Fatal("error reading learned aliases", "error", err)
+	}
+	manifest, err := exportBundle(workdir, *version, cfg.dataRepo(), parseReceiverAliases(append(learned, cfg.ReceiverAliases...)), file)
+	if err != nil {
+		log.Fatal("error writing bundle", "version", *version, "error", err)
+	}
+
+	log.Info("bundle exported", "version", *version, "files", len(manifest.Files), "output", manifest.Output, "out", *out)
+}
+
+func bundleImportCommand(args []string) {
+	flags := flag.NewFlagSet("bundle import", flag.ExitOnError)
+	in := flags.String("in", "", "bundle file")
+	force := flags.Bool("force", false, "replace files of the workdir the bundle holds too")
+	cfg, _, err := loadConfig(flags, args)
+	if err != nil {
+		log.Fatal("error loading config", "error", err)
+	}
+	if *in == "" {
+		log.Fatal("missing --in bundle file")
+	}
+
+	workdir, err := parseWd(cfg.Workdir)
+	if err != nil {
+		log.Fatal("error parsing working directory", "error", err)
+	}
+	err = migrateWorkdir(workdir)
+	if err != nil {
+		log.Fatal("error migrating working directory", "error", err)
+	}
+
+	file, err := os.Open(*in)
+	if err != nil {
+		log.Fatal("error opening bundle file", "error", err)
+	}
+	defer file.Close()
+
+	manifest, err := importBundle(workdir, file, *force)
+	if err != nil {
+		log.Fatal("error importing bundle", "error", err)
+	}
+
+	log.Info("bundle imported", "version", manifest.Version, "repo", manifest.DataRepo, "files", len(manifest.Files), "workdir", workdir)
+	if manifest.Output {
+		log.Info("compare a replay with the bundled output", "command", fmt.Sprintf("alm-dates replay --version %s --expect %s", manifest.Version, filepath.Join(workdir, bundleOutputPath(manifest.Version))))
+	}
+}
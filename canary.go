@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/dofusdude/alm-dates/almanax"
+	"golang.org/x/exp/rand"
+)
+
+type canaryFailure struct {
+	Date      string
+	Url       string
+	Diagnosis string
+}
+
+// canaryScrape scrapes a few random dates of the range and checks that their receivers exist in the
+// mapped data, so a broken scraper is noticed before a mapping run of several hours.
+func canaryScrape(ds *almanax.Dataset, lang string, dateRange []string, count int, timeout time.Duration, aliases map[string]string) []canaryFailure {
+	var failures []canaryFailure
+	for _, i := range rand.Perm(len(dateRange))[:min(count, len(dateRange))] {
+		date := dateRange[i]
+		url := almanaxPageUrl(lang, date)
+
+		doc, status, err := fetchAlmanaxDocument(lang, date, timeout)
+		if err != nil {
+			failures = append(failures, canaryFailure{date, url, fmt.Sprintf("request failed: %s", err)})
+			continue
+		}
+		if status == 202 {
+			log.Info("canary date not yet available, skipping", "date", date)
+			continue
+		}
+		if status != 200 {
+			failures = append(failures, canaryFailure{date, url, fmt.Sprintf("unexpected status %d", status)})
+			continue
+		}
+
+		page := extractAlmanaxPage(doc, lang)
+		if page.Receiver == "" {
+			reason := "no offering receiver found on the page, the page layout probably changed"
+			if missing := missingBlocks(doc); len(missing) > 0 {
+				reason = "scraper schema drift: the page lacks the " + strings.Join(missing, ", ")
+			}
+			failures = append(failures, canaryFailure{date, url, reason})
+			continue
+		}
+		if matchAlmanaxPage(ds, lang, page, aliases) == -1 {
+			failures = append(failures, canaryFailure{date, url, fmt.Sprintf("receiver %q is not in the mapped data, the name format or the data changed", page.Receiver)})
+			continue
+		}
+
+		log.Debug("canary date ok", "date", date, "receiver", page.Receiver)
+	}
+	return failures
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+// cdpMessage is a command, answer or event of the chrome devtools protocol.
+type cdpMessage struct {
+	Id        int             `json:"id,omitempty"`
+	SessionId string          `json:"sessionId,omitempty"`
+	Method    string          `json:"method,omitempty"`
+	Params    any             `json:"params,omitempty"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	Error     *cdpError       `json:"error,omitempty"`
+}
+
+// cdpEvent is an event as read, with its params still encoded.
+type cdpEvent struct {
+	SessionId string
+	Method    string
+	Params    json.RawMessage
+}
+
+type cdpError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *cdpError) Error() string {
+	return fmt.Sprintf("devtools error %d: %s", e.Code, e.Message)
+}
+
+// cdpConn is a devtools protocol connection to the browser. Answers go to the waiting call, events to the
+// handler, which runs on the reading goroutine and may only send.
+type cdpConn struct {
+	ws      *websocket.Conn
+	mu      sync.Mutex
+	lastId  int
+	pending map[int]chan cdpMessage
+	handler func(*cdpConn, cdpEvent)
+	closed  chan struct{}
+	err     error
+}
+
+func dialCdp(url string, handler func(*cdpConn, cdpEvent)) (*cdpConn, error) {
+	ws, err := websocket.Dial(url, "", "http://localhost/")
+	if err != nil {
+		return nil, err
+	}
+	// the page documents come in one message and exceed the default limit of the codec
+	ws.MaxPayloadBytes = 64 << 20
+
+	c := &cdpConn{ws: ws, pending: make(map[int]chan cdpMessage), handler: handler, closed: make(chan struct{})}
+	go c.read()
+	return c, nil
+}
+
+func (c *cdpConn) read() {
+	for {
+		var raw struct {
+			cdpMessage
+			Params json.RawMessage `json:"params,omitempty"`
+		}
+		err := websocket.JSON.Receive(c.ws, &raw)
+		if err != nil {
+			c.mu.Lock()
+			c.err = err
+			c.mu.Unlock()
+			close(c.closed)
+			return
+		}
+
+		if raw.Id == 0 {
+			c.handler(c, cdpEvent{SessionId: raw.SessionId, Method: raw.Method, Params: raw.Params})
+			continue
+		}
+		c.mu.Lock()
+		answer, ok := c.pending[raw.Id]
+		delete(c.pending, raw.Id)
+		c.mu.Unlock()
+		if ok {
+			answer <- raw.cdpMessage
+		}
+	}
+}
+
+// send writes a command without waiting for its answer.
+func (c *cdpConn) send(session string, method string, params any) (chan cdpMessage, error) {
+	c.mu.Lock()
+	c.lastId++
+	id := c.lastId
+	answer := make(chan cdpMessage, 1)
+	c.pending[id] = answer
+	c.mu.Unlock()
+
+	err := websocket.JSON.Send(c.ws, cdpMessage{Id: id, SessionId: session, Method: method, Params: params})
+	if err != nil {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return nil, err
+	}
+	return answer, nil
+}
+
+// call sends a command and decodes its result into result, which may be nil.
+func (c *cdpConn) call(ctx context.Context, session string, method string, params any, result any) error {
+	answer, err := c.send(session, method, params)
+	if err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+
+	select {
+	case msg := <-answer:
+		if msg.Error != nil {
+			return fmt.Errorf("%s: %w", method, msg.Error)
+		}
+		if result == nil {
+			return nil
+		}
+		return json.Unmarshal(msg.Result, result)
+	case <-c.closed:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return fmt.Errorf("%s: connection closed: %w", method, c.err)
+	case <-ctx.Done():
+		return fmt.Errorf("%s: %w", method, ctx.Err())
+	}
+}
+
+func (c *cdpConn) close() error {
+	err := c.ws.Close()
+	<-c.closed
+	return err
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"github.com/dofusdude/alm-dates/almanax"
+)
+
+// checkpointEntry is a scraped date with the receiver it resolved to.
+type checkpointEntry struct {
+	Date     string `json:"date"`
+	Receiver string `json:"receiver"`
+	provenanceEntry
+}
+
+// checkpoint persists the scraped dates of a version while it is mapped, one json line per date, so a
+// restarted run continues where the last one stopped. It is removed once the version is published.
+type checkpoint struct {
+	mu      sync.Mutex
+	version string
+	file    *os.File
+	entries map[string]checkpointEntry
+}
+
+// openCheckpoints are the checkpoints of the running mappings of all tenants, flushed when draining.
+var (
+	openCheckpointsMu sync.Mutex
+	openCheckpoints   = make(map[*checkpoint]bool)
+)
+
+func checkpointDir(workdir string) string {
+	return filepath.Join(stateDir(workdir), "checkpoints")
+}
+
+func checkpointPath(workdir string, version string) string {
+	return filepath.Join(checkpointDir(workdir), version+".jsonl")
+}
+
+// openCheckpoint loads the dates an earlier run of the version scraped and appends new ones to them.
+func openCheckpoint(workdir string, version string) (*checkpoint, error) {
+	err := os.MkdirAll(checkpointDir(workdir), os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(checkpointPath(workdir, version), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	cp := &checkpoint{version: version, file: file, entries: make(map[string]checkpointEntry)}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var entry checkpointEntry
+		// the last line is cut off if the process died while writing it
+		if json.Unmarshal(line, &entry) != nil {
+			continue
+		}
+		cp.entries[entry.Date] = entry
+	}
+
+	// new lines must not continue a cut off one
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		_, err = file.Write([]byte("\n"))
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	openCheckpointsMu.Lock()
+	openCheckpoints[cp] = true
+	openCheckpointsMu.Unlock()
+	return cp, nil
+}
+
+// flushCheckpoints writes the open checkpoints to disk, so a stop right after loses no scraped date.
+func flushCheckpoints() {
+	openCheckpointsMu.Lock()
+	defer openCheckpointsMu.Unlock()
+	for cp := range openCheckpoints {
+		cp.mu.Lock()
+		err := cp.file.Sync()
+		dates := len(cp.entries)
+		cp.mu.Unlock()
+		if err != nil {
+			log.Error("error writing checkpoint", "version", cp.version, "error", err)
+			continue
+		}
+		log.Info("checkpoint written, the next start continues from it", "version", cp.version, "dates", dates)
+	}
+}
+
+func (c *checkpoint) lookup(date string) (checkpointEntry, bool) {
+	if c == nil {
+		return checkpointEntry{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[date]
+	return entry, ok
+}
+
+// record writes a scraped date through to disk. A failed write only costs the date being scraped again.
+func (c *checkpoint) record(date string, receiver string, source provenanceEntry) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := checkpointEntry{Date: date, Receiver: receiver, provenanceEntry: source}
+	line, err := json.Marshal(entry)
+	if err == nil {
+		_, err = c.file.Write(append(line, '\n'))
+	}
+	if err == nil {
+		err = c.file.Sync()
+	}
+	if err != nil {
+		log.Warn("error writing checkpoint", "date", date, "error", err)
+		return
+	}
+	c.entries[date] = entry
+}
+
+func (c *checkpoint) len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+func (c *checkpoint) close() error {
+	if c == nil {
+		return nil
+	}
+	openCheckpointsMu.Lock()
+	delete(openCheckpoints, c)
+	openCheckpointsMu.Unlock()
+	return c.file.Close()
+}
+
+func removeCheckpoint(workdir string, version string) error {
+	err := os.Remove(checkpointPath(workdir, version))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func hasCheckpoint(workdir string, version string) bool {
+	_, err := os.Stat(checkpointPath(workdir, version))
+	return err == nil
+}
+
+// unfinishedRuns lists the versions with a checkpoint, runs that stopped before they were published.
+func unfinishedRuns(workdir string) ([]string, error) {
+	entries, err := os.ReadDir(checkpointDir(workdir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []string
+	for _, entry := range entries {
+		if version, ok := strings.CutSuffix(entry.Name(), ".jsonl"); ok {
+			versions = append(versions, version)
+		}
+	}
+	return versions, nil
+}
+
+// restore adds the checkpointed dates to their receivers and sources and returns the dates still to scrape.
+// A date whose receiver is no longer in the dataset is scraped again.
+func (c *checkpoint) restore(ds *almanax.Dataset, dates []string, sources provenance) []string {
+	if c.len() == 0 {
+		return dates
+	}
+
+	receivers := make(map[string]int, len(ds.Receivers))
+	for i, receiver := range ds.Receivers {
+		receivers[receiver.Name] = i
+	}
+
+	var left []string
+	for _, date := range dates {
+		entry, ok := c.lookup(date)
+		i, found := receivers[entry.Receiver]
+		if !ok || !found {
+			left = append(left, date)
+			continue
+		}
+		ds.Receivers[i].Days = append(ds.Receivers[i].Days, date)
+		sources[date] = entry.provenanceEntry
+	}
+	log.Info("resuming from checkpoint", "restored", len(dates)-len(left), "left", len(left))
+	return left
+}
@@ -0,0 +1,200 @@
+// Package client downloads the mapped almanax published by alm-dates and answers the usual bot
+// questions about it, like today's offering or the next day with a given bonus.
+//
+//	c := client.New(client.DefaultUrl)
+//	day, err := c.Today(ctx, "fr")
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dofusdude/alm-dates/almanax"
+)
+
+// DefaultUrl is the mapped almanax of the latest dofus3 data release.
+const DefaultUrl = "https://github.com/dofusdude/dofus3-main/releases/latest/download/MAPPED_ALMANAX.json"
+
+// Day is one almanax date in a single language.
+type Day struct {
+	Date      string
+	Receiver  string
+	ItemId    int
+	Item      string
+	Quantity  int
+	BonusType string
+	Bonus     string
+	Kamas     int
+}
+
+// Client fetches the asset and keeps it in memory. It asks for it again at most every MaxAge, using
+// ETag and Last-Modified so an unchanged asset is not downloaded twice. A Client is safe for
+// concurrent use.
+type Client struct {
+	Url        string
+	HttpClient *http.Client
+	// MaxAge is how long a fetched asset is used before checking for a new one.
+	MaxAge time.Duration
+	// Location decides which date is today, defaults to Europe/Paris like the game.
+	Location *time.Location
+
+	mu           sync.Mutex
+	etag         string
+	lastModified string
+	checked      time.Time
+	dataset      *almanax.Dataset
+	days         *almanax.Index
+}
+
+func New(url string) *Client {
+	location, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		location = time.UTC
+	}
+	return &Client{
+		Url:        url,
+		HttpClient: &http.Client{Timeout: 30 * time.Second},
+		MaxAge:     time.Hour,
+		Location:   location,
+	}
+}
+
+// Dataset returns the almanax, fetching it if it is missing or older than MaxAge.
+func (c *Client) Dataset(ctx context.Context) (*almanax.Dataset, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.dataset != nil && time.Since(c.checked) < c.MaxAge {
+		return c.dataset, nil
+	}
+
+	err := c.fetch(ctx)
+	if err != nil {
+		if c.dataset != nil {
+			// keep answering from the old asset until the next check works
+			return c.dataset, nil
+		}
+		return nil, err
+	}
+	return c.dataset, nil
+}
+
+// Index returns the date index of the almanax, fetching it like Dataset.
+func (c *Client) Index(ctx context.Context) (*almanax.Index, error) {
+	_, err := c.Dataset(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.days, nil
+}
+
+// Refresh checks for a new asset now, regardless of MaxAge.
+func (c *Client) Refresh(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.fetch(ctx)
+}
+
+func (c *Client) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.Url, nil)
+	if err != nil {
+		return err
+	}
+	if c.dataset != nil {
+		if c.etag != "" {
+			req.Header.Set("If-None-Match", c.etag)
+		}
+		if c.lastModified != "" {
+			req.Header.Set("If-Modified-Since", c.lastModified)
+		}
+	}
+
+	res, err := c.HttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotModified && c.dataset != nil {
+		c.checked = time.Now()
+		return nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status fetching %s: %d", c.Url, res.StatusCode)
+	}
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	ds, err := almanax.Decode(data)
+	if err != nil {
+		return err
+	}
+
+	c.dataset = ds
+	c.days = almanax.NewIndex(ds)
+	c.etag = res.Header.Get("ETag")
+	c.lastModified = res.Header.Get("Last-Modified")
+	c.checked = time.Now()
+	return nil
+}
+
+func newDay(date string, receiver *almanax.Receiver, lang string) Day {
+	return Day{
+		Date:      date,
+		Receiver:  receiver.Name,
+		ItemId:    receiver.Offering.ItemId,
+		Item:      receiver.Offering.ItemName.Get(lang),
+		Quantity:  receiver.Offering.Quantity,
+		BonusType: receiver.Bonus.Type.Get(lang),
+		Bonus:     receiver.Bonus.Description.Get(lang),
+		Kamas:     receiver.RewardKamas,
+	}
+}
+
+// Day returns a date (2006-01-02) in the language.
+func (c *Client) Day(ctx context.Context, date string, lang string) (Day, error) {
+	days, err := c.Index(ctx)
+	if err != nil {
+		return Day{}, err
+	}
+
+	receiver, ok := days.Day(date)
+	if !ok {
+		return Day{}, fmt.Errorf("%s is not mapped", date)
+	}
+	return newDay(date, receiver, lang), nil
+}
+
+// Today returns the current almanax day in the language.
+func (c *Client) Today(ctx context.Context, lang string) (Day, error) {
+	return c.Day(ctx, time.Now().In(c.Location).Format("2006-01-02"), lang)
+}
+
+// NextBonus returns the first day from today on whose bonus type matches, case-insensitive in any
+// language. The day is in english.
+func (c *Client) NextBonus(ctx context.Context, bonusType string) (Day, error) {
+	days, err := c.Index(ctx)
+	if err != nil {
+		return Day{}, err
+	}
+
+	today := time.Now().In(c.Location).Format("2006-01-02")
+	for _, day := range days.Range(today, "") {
+		for _, text := range day.Receiver.Bonus.Type {
+			if strings.EqualFold(strings.TrimSpace(text), strings.TrimSpace(bonusType)) {
+				return newDay(day.Date, day.Receiver, "en"), nil
+			}
+		}
+	}
+	return Day{}, fmt.Errorf("no mapped day with bonus %q", bonusType)
+}
@@ -0,0 +1,1060 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/dofusdude/alm-dates/almanax"
+)
+
+// Config is the daemon configuration. Every field can be set in the json config file by its json key,
+// by the env variable ALM_<KEY> and, if it has a flag tag, by a command line flag. Later sources win.
+// Env variables in the alias tag are still read for older deployments. Hidden fields are left out of the
+// config dump unless they are set.
+type Config struct {
+	Workdir                  string        `json:"workdir" flag:"workdir" usage:"directory for local state, defaults to the current directory"`
+	Tenants                  []string      `json:"tenants" usage:"config files of independent pipelines, each applied on top of this config with the workdir defaulting to a subfolder named like the file"`
+	Game                     string        `json:"game" usage:"doduapi game notified about new data"`
+	DataRepo                 string        `json:"data_repo" usage:"github repository owner/name whose releases get the mapped almanax"`
+	GhAuthKey                string        `json:"gh_auth_key" alias:"GH_AUTH_KEY" secret:"true" required:"true" usage:"github token with write access to the data repo releases"`
+	GhAuthKeySecondary       string        `json:"gh_auth_key_secondary" alias:"GH_AUTH_KEY_SECONDARY" secret:"true" usage:"github token used once github rejects or rate limits gh_auth_key"`
+	TokenCheckInterval       time.Duration `json:"token_check_interval" flag:"token-check-interval" usage:"how often the github token expiry, scopes and repo access are checked, 0 disables the check"`
+	TokenExpiryWarning       time.Duration `json:"token_expiry_warning" flag:"token-expiry-warning" usage:"how long before the github token expires the check raises alerts"`
+	DoduapiUpdateToken       string        `json:"doduapi_update_token" alias:"DODUAPI_UPDATE_TOKEN" secret:"true" usage:"token to notify doduapi about new data"`
+	PollingInterval          time.Duration `json:"polling_interval" alias:"POLLING_INTERVAL" flag:"polling-interval" usage:"interval to check for new data repo releases"`
+	EndDuration              time.Duration `json:"end_duration" alias:"END_DURATION" flag:"end-duration" usage:"how far into the future dates are mapped"`
+	DoduapiPoll              bool          `json:"doduapi_poll" flag:"doduapi-poll" usage:"also queue a mapping when doduapi reports a new game version"`
+	WebhookAddr              string        `json:"webhook_addr" flag:"webhook-addr" usage:"listen address for POST /trigger, disabled if empty"`
+	WebhookSecret            string        `json:"webhook_secret" secret:"true" usage:"bearer token required by the trigger webhook"`
+	QueueLimit               int           `json:"queue_limit" flag:"queue-limit" usage:"queued jobs before queue_policy applies to new ones, 0 for no limit"`
+	QueuePolicy              string        `json:"queue_policy" flag:"queue-policy" usage:"what a full job queue does with a new job: coalesce merges it into a waiting one of the same kind, drop-oldest drops the job waiting longest, reject drops the new one"`
+	JobAttempts              int           `json:"job_attempts" flag:"job-attempts" usage:"starts of a job that did not finish before it is parked, 0 never parks"`
+	Cron                     string        `json:"cron" usage:"scheduled jobs like \"0 4 * * 1 validate\", separated by semicolons"`
+	ExtendBelow              time.Duration `json:"extend_below" flag:"extend-below" usage:"map the dates after the published horizon when less than this is left, 0 disables it"`
+	CanaryDates              int           `json:"canary_dates" flag:"canary-dates" usage:"random dates scraped and checked before a full mapping run, 0 disables the check"`
+	ValidatePercent          float64       `json:"validate_percent" flag:"validate-percent" usage:"percentage of mapped dates scraped again before publishing, 0 disables the validation pass"`
+	SanityGate               bool          `json:"sanity_gate" flag:"sanity-gate" usage:"block the upload of an asset with fewer upcoming dates, empty receivers, missing item name languages or a much different size than the published one"`
+	SanityMinDates           int           `json:"sanity_min_dates" flag:"sanity-min-dates" usage:"upcoming dates an asset needs at least to pass the sanity gate, on top of not having fewer than the published one"`
+	SanityMaxSizeChange      float64       `json:"sanity_max_size_change" flag:"sanity-max-size-change" usage:"percent the asset size may change against the published one to pass the sanity gate, 0 does not check the size"`
+	CoverageMode             string        `json:"coverage_mode" flag:"coverage-mode" usage:"strict fails a publish whose window has unmapped dates or dates mapped to several receivers, lenient only warns"`
+	ValidateWorkers          int           `json:"validate_workers" flag:"validate-workers" usage:"concurrent requests of the validation pass"`
+	Strategies               []string      `json:"strategies" flag:"strategies" usage:"comma separated mapping strategies tried in order, each maps the dates the ones before left: incremental, replay-from-cache, cycle-inference, full-scrape"`
+	ScrapeLanguages          []string      `json:"scrape_languages" flag:"scrape-languages" usage:"comma separated krosmoz page languages used for mapping, later ones are fallbacks"`
+	ScrapeWorkers            int           `json:"scrape_workers" flag:"scrape-workers" process:"true" usage:"dates scraped at the same time while mapping"`
+	ScrapeRate               float64       `json:"scrape_rate" flag:"scrape-rate" process:"true" usage:"krosmoz requests per second on average, shared by all workers and pipelines"`
+	ScrapeBudget             int           `json:"scrape_budget" flag:"scrape-budget" process:"true" usage:"krosmoz requests in any minute at most, shared by all workers and pipelines, 0 is unlimited"`
+	ScrapeJitterMin          time.Duration `json:"scrape_jitter_min" flag:"scrape-jitter-min" process:"true" usage:"shortest random delay before a krosmoz request"`
+	ScrapeJitterMax          time.Duration `json:"scrape_jitter_max" flag:"scrape-jitter-max" process:"true" usage:"longest random delay before a krosmoz request"`
+	UserAgents               []string      `json:"user_agents" flag:"user-agents" process:"true" usage:"comma separated user agents krosmoz requests rotate through, the built-in one if empty"`
+	UserAgentsFile           string        `json:"user_agents_file" flag:"user-agents-file" process:"true" usage:"file with more user agents to rotate through, one per line"`
+	ScrapeMode               string        `json:"scrape_mode" flag:"scrape-mode" usage:"day requests every date, month reads a month view per request and requests only the dates missing there"`
+	PageCacheTtl             time.Duration `json:"page_cache_ttl" flag:"page-cache-ttl" usage:"how long fetched krosmoz pages are reused from the workdir cache instead of requested again, 0 always requests them"`
+	FallbackAfter            int           `json:"fallback_after" flag:"fallback-after" usage:"unavailable answers for a date before the next scrape language is tried"`
+	CrossCheckLanguages      []string      `json:"cross_check_languages" flag:"cross-check-languages" usage:"comma separated krosmoz page languages every mapped date is scraped in again to check they agree on the receiver, empty disables it"`
+	LanguagePacks            string        `json:"language_packs" flag:"language-packs" usage:"directory with <lang>.json language packs that change the offering quest texts of a page language or add one"`
+	LocaleCheckInterval      time.Duration `json:"locale_check_interval" flag:"locale-check-interval" usage:"how often krosmoz is asked which locales it offers the almanax in, new and removed ones raise alerts, 0 disables it"`
+	LiveCheckInterval        time.Duration `json:"live_check_interval" flag:"live-check-interval" usage:"how often the published almanax is downloaded and checked for corruption and truncation, 0 disables the check"`
+	MaintenanceFeed          string        `json:"maintenance_feed" flag:"maintenance-feed" usage:"statuspage.io scheduled maintenances json or rss feed announcing krosmoz maintenance, jobs requesting krosmoz wait during it, disabled if empty"`
+	MaintenanceCheckInterval time.Duration `json:"maintenance_check_interval" flag:"maintenance-check-interval" usage:"how often maintenance_feed is read"`
+	MaintenanceDuration      time.Duration `json:"maintenance_duration" flag:"maintenance-duration" usage:"how long an announced maintenance without an end is assumed to last"`
+	CycleVerifyPercent       float64       `json:"cycle_verify_percent" flag:"cycle-verify-percent" usage:"percentage of the dates cycle-inference inferred that are scraped to check the cycle still holds, 0 trusts it"`
+	SecondarySource          string        `json:"secondary_source" flag:"secondary-source" usage:"url of a community almanax source like dofusdb with {date} for the YYYY-MM-DD date, sampled dates are compared with it after mapping, disabled if empty"`
+	SecondaryPercent         float64       `json:"secondary_percent" flag:"secondary-percent" usage:"percentage of the newly mapped dates compared with secondary_source"`
+	RunMaxRequests           int           `json:"run_max_requests" flag:"run-max-requests" usage:"krosmoz requests a single job may make before it checkpoints and continues after run_limit_pause, 0 does not cap them"`
+	RunMaxDuration           time.Duration `json:"run_max_duration" flag:"run-max-duration" usage:"how long a single job may scrape before it checkpoints and continues after run_limit_pause, 0 does not cap it"`
+	RunMaxGithubCalls        int           `json:"run_max_github_calls" flag:"run-max-github-calls" usage:"github requests a single job may make, a publish that would go over it waits for run_limit_pause, 0 does not cap them"`
+	RunLimitPause            time.Duration `json:"run_limit_pause" flag:"run-limit-pause" usage:"how long a job that hit a run limit waits before it continues"`
+	AutoEnableLocales        bool          `json:"auto_enable_locales" flag:"auto-enable-locales" usage:"cross check new krosmoz locales that have a language pack from when they are discovered"`
+	ReceiverAliases          []string      `json:"receiver_aliases" flag:"receiver-aliases" usage:"comma separated scraped=mapped receiver names for names that differ beyond case, accents and punctuation"`
+	NameTolerance            int           `json:"name_tolerance" flag:"name-tolerance" process:"true" usage:"typos a scraped receiver name of six letters or more may have and still match the only mapped name that close, 0 only matches exact names"`
+	PublishPartial           bool          `json:"publish_partial" flag:"publish-partial" usage:"publish the mapped dates when some receivers match nothing, those dates stay unmapped, otherwise the job fails"`
+	RenderFallback           bool          `json:"render_fallback" flag:"render-fallback" usage:"render krosmoz pages in a headless chromium when krosmoz answers with an anti-bot challenge or a page without the offering"`
+	RenderBrowser            string        `json:"render_browser" flag:"render-browser" usage:"chromium like browser for render_fallback, defaults to the first of chromium, chromium-browser, google-chrome and chrome in the PATH"`
+	ProxyUrl                 string        `json:"proxy_url" alias:"PROXY_URL" secret:"true" process:"true" usage:"proxy url krosmoz requests go through, used before scrape_proxies, it may hold credentials"`
+	ScrapeProxies            []string      `json:"scrape_proxies" secret:"true" process:"true" usage:"comma separated proxy urls krosmoz requests go through, see proxy_mode"`
+	ProxyMode                string        `json:"proxy_mode" flag:"proxy-mode" usage:"round-robin sends every krosmoz request through the next proxy, failover sends them directly until the switch-proxy remediation or failures move to the next proxy"`
+	ProxyMaxFailures         int           `json:"proxy_max_failures" flag:"proxy-max-failures" usage:"failed requests in a row before a proxy cools down, 0 never cools one down"`
+	ProxyCooldown            time.Duration `json:"proxy_cooldown" flag:"proxy-cooldown" usage:"how long a failing proxy is skipped"`
+	Playbook                 []string      `json:"playbook" flag:"playbook" usage:"comma separated failure=remedy remediations of failed jobs: krosmoz-blocked=switch-proxy|pause|none, github-quota=wait-reset|none, receiver-mismatch=apply-alias|none"`
+	PlaybookPause            time.Duration `json:"playbook_pause" flag:"playbook-pause" usage:"wait of the pause remediation, and of wait-reset when github does not tell the reset"`
+	PlaybookAttempts         int           `json:"playbook_attempts" flag:"playbook-attempts" usage:"remediations of a job in a row before it is given up"`
+	Languages                []string      `json:"languages" flag:"languages" usage:"comma separated krosmoz page languages checked by selfcheck"`
+	Timezone                 string        `json:"timezone" flag:"timezone" usage:"time zone name that decides the current day, defaults to the system time zone"`
+	LogLevel                 string        `json:"log_level" flag:"log-level" process:"true" usage:"debug, info, warn or error"`
+	HealthAddr               string        `json:"health_addr" flag:"health-addr" process:"true" usage:"listen address for /healthz, /readyz, /prestop and /metrics, disabled if empty"`
+	DashboardAddr            string        `json:"dashboard_addr" flag:"dashboard-addr" process:"true" usage:"listen address for the maintainer dashboard with run history, progress, the last diff and alerts, disabled if empty"`
+	AlertWindow              time.Duration `json:"alert_window" flag:"alert-window" usage:"alerts of the same failure class within this time are grouped into one"`
+	AlertEscalateAfter       int           `json:"alert_escalate_after" flag:"alert-escalate-after" usage:"occurrences of a grouped alert after which it is escalated, 0 never escalates"`
+	DashboardToken           string        `json:"dashboard_token" secret:"true" process:"true" usage:"bearer token for pausing, resuming and forcing a remap from the dashboard, the buttons are disabled without it"`
+	LeaseName                string        `json:"lease_name" flag:"lease-name" process:"true" usage:"kubernetes lease for leader election, disabled if empty"`
+	LeaseNamespace           string        `json:"lease_namespace" flag:"lease-namespace" process:"true" usage:"namespace of the lease, defaults to the pod namespace"`
+	LeaseDuration            time.Duration `json:"lease_duration" flag:"lease-duration" process:"true" usage:"how long a lease is valid without renewal"`
+	ShutdownGrace            time.Duration `json:"shutdown_grace" flag:"shutdown-grace" process:"true" usage:"time to finish a running publish and flush notifications after SIGTERM"`
+	HttpConnectTimeout       time.Duration `json:"http_connect_timeout" flag:"http-connect-timeout" process:"true" usage:"timeout of connecting to krosmoz, github and doduapi, tls handshake included"`
+	HttpReadTimeout          time.Duration `json:"http_read_timeout" flag:"http-read-timeout" process:"true" usage:"timeout of waiting for the response headers after a request is sent"`
+	HttpMaxIdleConns         int           `json:"http_max_idle_conns" flag:"http-max-idle-conns" process:"true" usage:"idle connections kept open per host for reuse"`
+	ScrapeTimeout            time.Duration `json:"scrape_timeout" flag:"scrape-timeout" usage:"timeout of a krosmoz page request"`
+	ThrottleMaxWait          time.Duration `json:"throttle_max_wait" flag:"throttle-max-wait" process:"true" usage:"backoff after krosmoz answers 429 or 503 before the job fails and the playbook pauses it"`
+	DownloadTimeout          time.Duration `json:"download_timeout" flag:"download-timeout" process:"true" usage:"timeout of the release asset download"`
+	UploadTimeout            time.Duration `json:"upload_timeout" flag:"upload-timeout" usage:"timeout of the release asset upload"`
+	NotifyTimeout            time.Duration `json:"notify_timeout" flag:"notify-timeout" usage:"timeout of the doduapi update notification"`
+	ServeSlo                 time.Duration `json:"serve_slo" flag:"serve-slo" usage:"longest time from detecting a game version until doduapi serves its mapping before an alert is raised, 0 disables the tracking"`
+	ServeCheckInterval       time.Duration `json:"serve_check_interval" flag:"serve-check-interval" usage:"how often doduapi is asked whether it serves a new mapping"`
+	History                  bool          `json:"history" flag:"history" usage:"also publish every almanax day ever mapped, across game versions, as an append-only asset"`
+	FlavorAsset              bool          `json:"flavor_asset" flag:"flavor-asset" usage:"also publish the protector, meridian and quote of every mapped day read from the kept krosmoz pages"`
+	DiffAsset                bool          `json:"diff_asset" flag:"diff-asset" usage:"also publish the date assignments added, removed and changed against the asset consumers had before"`
+	ContentAddressed         bool          `json:"content_addressed" flag:"content-addressed" usage:"also publish the asset named with its content hash and a pointer file to it"`
+	KeepReleases             int           `json:"keep_releases" flag:"keep-releases" usage:"newest releases that keep the patch and content addressed assets, older ones are cleaned up, 0 keeps all"`
+	ArchiveDir               string        `json:"archive_dir" flag:"archive-dir" usage:"directory the cleaned up assets are downloaded to first, relative to the workdir, disabled if empty"`
+	StagingUrl               string        `json:"staging_url" flag:"staging-url" usage:"doduapi staging endpoint the dataset is posted to before publishing, the publish stops if it is rejected"`
+	StagingToken             string        `json:"staging_token" secret:"true" usage:"bearer token for the staging endpoint"`
+	UploadRate               int           `json:"upload_rate" flag:"upload-rate" usage:"limit of the release asset upload in KiB/s, 0 does not limit it"`
+	GithubQuotaReserve       int           `json:"github_quota_reserve" flag:"github-quota-reserve" process:"true" usage:"github api requests of a token left for the end of its rate limit window, requests wait for the reset below it"`
+	GithubWriteInterval      time.Duration `json:"github_write_interval" flag:"github-write-interval" process:"true" usage:"shortest time between mutating github requests of a token, for the secondary rate limits"`
+	RetryInitial             time.Duration `json:"retry_initial" flag:"retry-initial" process:"true" usage:"wait before the first retry of a failed krosmoz, github or doduapi request"`
+	RetryMultiplier          float64       `json:"retry_multiplier" flag:"retry-multiplier" process:"true" usage:"factor the wait grows by with every retry"`
+	RetryMaxDelay            time.Duration `json:"retry_max_delay" flag:"retry-max-delay" process:"true" usage:"longest wait between retries"`
+	RetryAttempts            int           `json:"retry_attempts" flag:"retry-attempts" process:"true" usage:"tries of a request before giving up, 0 retries forever"`
+	RetryJitter              float64       `json:"retry_jitter" flag:"retry-jitter" process:"true" usage:"share of the wait that is randomized, between 0 and 1"`
+	License                  string        `json:"license" flag:"license" usage:"license notice embedded in the published metadata and serve mode responses"`
+	Attribution              string        `json:"attribution" flag:"attribution" usage:"attribution embedded in the published metadata and serve mode responses"`
+	SourceUrls               []string      `json:"source_urls" flag:"source-urls" usage:"comma separated urls the data comes from, defaults to the krosmoz almanax and the data repo"`
+	Faults                   string        `json:"faults" hidden:"true" process:"true" usage:"injected failures for integration tests and staging, like scrape_error=0.2,slow=0.1,slow_delay=10s,github_5xx=0.3,drop_notify=1"`
+}
+
+func defaultConfig() Config {
+	workdir := os.Getenv("PWD")
+	if workdir == "" {
+		workdir = "."
+	}
+
+	return Config{
+		Workdir:                  workdir,
+		Game:                     "dofus3",
+		DataRepo:                 DataRepoOwner + "/" + DataRepoName,
+		PollingInterval:          5 * time.Minute,
+		EndDuration:              365 * 24 * time.Hour,
+		Languages:                []string{"en"},
+		Strategies:               []string{strategyIncremental, strategyFullScrape},
+		ScrapeLanguages:          []string{"en"},
+		PageCacheTtl:             24 * time.Hour,
+		FallbackAfter:            3,
+		ScrapeMode:               "day",
+		SanityGate:               true,
+		SanityMaxSizeChange:      50,
+		CoverageMode:             "strict",
+		ScrapeWorkers:            2,
+		ScrapeRate:               1,
+		GithubQuotaReserve:       50,
+		GithubWriteInterval:      time.Second,
+		CrossCheckLanguages:      []string{"en", "fr", "de", "es", "pt"},
+		QueueLimit:               50,
+		QueuePolicy:              queueCoalesce,
+		JobAttempts:              5,
+		Playbook:                 []string{failureKrosmozBlocked + "=" + remedyPause, failureGithubQuota + "=" + remedyWaitReset, failureReceiverMismatch + "=" + remedyApplyAlias},
+		PlaybookPause:            30 * time.Minute,
+		ProxyMode:                proxyRoundRobin,
+		ProxyMaxFailures:         3,
+		ProxyCooldown:            10 * time.Minute,
+		PlaybookAttempts:         3,
+		ExtendBelow:              30 * 24 * time.Hour,
+		CanaryDates:              3,
+		ValidateWorkers:          2,
+		LogLevel:                 "info",
+		LeaseDuration:            15 * time.Second,
+		ShutdownGrace:            25 * time.Second,
+		HttpConnectTimeout:       10 * time.Second,
+		HttpReadTimeout:          30 * time.Second,
+		HttpMaxIdleConns:         10,
+		ScrapeTimeout:            30 * time.Second,
+		ThrottleMaxWait:          15 * time.Minute,
+		DownloadTimeout:          2 * time.Minute,
+		UploadTimeout:            5 * time.Minute,
+		NotifyTimeout:            30 * time.Second,
+		ServeSlo:                 2 * time.Hour,
+		ServeCheckInterval:       time.Minute,
+		TokenCheckInterval:       12 * time.Hour,
+		TokenExpiryWarning:       14 * 24 * time.Hour,
+		NameTolerance:            1,
+		LocaleCheckInterval:      24 * time.Hour,
+		AlertWindow:              time.Hour,
+		AlertEscalateAfter:       5,
+		LiveCheckInterval:        time.Hour,
+		MaintenanceCheckInterval: 15 * time.Minute,
+		MaintenanceDuration:      2 * time.Hour,
+		RunLimitPause:            time.Hour,
+		SecondaryPercent:         5,
+		CycleVerifyPercent:       2,
+		RetryInitial:             5 * time.Second,
+		RetryMultiplier:          2,
+		RetryMaxDelay:            5 * time.Minute,
+		RetryAttempts:            8,
+		RetryJitter:              0.2,
+		License:                  "Dofus and the almanax texts are the property of Ankama Games, this data is not affiliated with or endorsed by Ankama.",
+		Attribution:              "Almanax dates mapped by dofusdude (https://github.com/dofusdude/alm-dates)",
+	}
+}
+
+// EnvPrefix is prepended to the upper case config key to get its env variable.
+const EnvPrefix = "ALM_"
+
+type configField struct {
+	key      string
+	env      string
+	aliases  []string
+	flag     string
+	usage    string
+	secret   bool
+	required bool
+	hidden   bool
+	// process settings apply to the whole process, only the base config sets them
+	process bool
+	value   reflect.Value
+}
+
+func (c *Config) fields() []configField {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+
+	fields := make([]configField, t.NumField())
+	for i := range fields {
+		tag := t.Field(i).Tag
+		key := tag.Get("json")
+		var aliases []string
+		if alias := tag.Get("alias"); alias != "" {
+			aliases = strings.Split(alias, ",")
+		}
+		fields[i] = configField{
+			key:      key,
+			env:      EnvPrefix + strings.ToUpper(key),
+			aliases:  aliases,
+			flag:     tag.Get("flag"),
+			usage:    tag.Get("usage"),
+			secret:   tag.Get("secret") == "true",
+			required: tag.Get("required") == "true",
+			hidden:   tag.Get("hidden") == "true",
+			process:  tag.Get("process") == "true",
+			value:    v.Field(i),
+		}
+	}
+	return fields
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func (f configField) set(raw string) error {
+	v := f.value
+	if v.Type() == durationType {
+		dur, err := ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(dur))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int:
+		i, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(i))
+	case reflect.Float64:
+		fl, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(fl)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		var values []string
+		for _, s := range strings.Split(raw, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				values = append(values, s)
+			}
+		}
+		v.Set(reflect.ValueOf(values))
+	default:
+		return fmt.Errorf("unsupported config type %s", v.Type())
+	}
+	return nil
+}
+
+func (f configField) String() string {
+	v := f.value
+	if v.Type() == durationType {
+		return FormatDuration(time.Duration(v.Int()))
+	}
+	if v.Kind() == reflect.Slice {
+		return strings.Join(v.Interface().([]string), ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// lookupEnv returns the first set env variable of the field, the prefixed name wins over the aliases.
+func (f configField) lookupEnv() (string, string) {
+	for _, env := range append([]string{f.env}, f.aliases...) {
+		if raw := os.Getenv(env); raw != "" {
+			return env, raw
+		}
+	}
+	return "", ""
+}
+
+func (f configField) isZero() bool {
+	return f.value.IsZero()
+}
+
+// configSources remembers where each config key got its value from.
+type configSources map[string]string
+
+// loadConfigFile applies a json object of config keys. Strings are parsed like env values.
+func (c *Config) loadConfigFile(path string, sources configSources) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var values map[string]json.RawMessage
+	err = json.NewDecoder(file).Decode(&values)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	fields := make(map[string]configField)
+	for _, field := range c.fields() {
+		fields[field.key] = field
+	}
+
+	for key, raw := range values {
+		field, ok := fields[key]
+		if !ok {
+			return fmt.Errorf("%s: unknown config key %s", path, key)
+		}
+
+		var str string
+		var list []string
+		if json.Unmarshal(raw, &str) == nil {
+			err = field.set(str)
+		} else if json.Unmarshal(raw, &list) == nil {
+			err = field.set(strings.Join(list, ","))
+		} else {
+			err = field.set(string(raw))
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", path, key, err)
+		}
+		sources[key] = "file " + path
+	}
+
+	return nil
+}
+
+// loadConfig merges defaults, the config file, env variables and command line flags in that order.
+// The config flags are added to flags before parsing args, so commands can register their own flags first.
+func loadConfig(flags *flag.FlagSet, args []string) (Config, configSources, error) {
+	cfg := defaultConfig()
+	sources := make(configSources)
+	for _, field := range cfg.fields() {
+		sources[field.key] = "default"
+	}
+
+	configFileEnv := os.Getenv(EnvPrefix + "CONFIG_FILE")
+	if configFileEnv == "" {
+		configFileEnv = os.Getenv("CONFIG_FILE")
+	}
+	configFile := flags.String("config", configFileEnv, "json config file")
+	flagValues := make(map[string]*string)
+	for _, field := range cfg.fields() {
+		if field.flag != "" {
+			flagValues[field.flag] = flags.String(field.flag, "", field.usage)
+		}
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return cfg, sources, err
+	}
+
+	if *configFile != "" {
+		err = cfg.loadConfigFile(*configFile, sources)
+		if err != nil {
+			return cfg, sources, err
+		}
+	}
+
+	for _, field := range cfg.fields() {
+		env, raw := field.lookupEnv()
+		if raw == "" {
+			continue
+		}
+		err = field.set(raw)
+		if err != nil {
+			return cfg, sources, fmt.Errorf("%s: %w", env, err)
+		}
+		sources[field.key] = "env " + env
+		if env != field.env {
+			sources[field.key] += " (deprecated, use " + field.env + ")"
+		}
+	}
+
+	var flagErr error
+	flags.Visit(func(f *flag.Flag) {
+		for _, field := range cfg.fields() {
+			if field.flag != f.Name {
+				continue
+			}
+			if err := field.set(*flagValues[f.Name]); err != nil && flagErr == nil {
+				flagErr = fmt.Errorf("--%s: %w", f.Name, err)
+			}
+			sources[field.key] = "flag --" + f.Name
+		}
+	})
+	if flagErr != nil {
+		return cfg, sources, flagErr
+	}
+
+	if cfg.LanguagePacks != "" {
+		err = loadLanguagePacks(cfg.LanguagePacks)
+		if err != nil {
+			return cfg, sources, fmt.Errorf("language_packs: %w", err)
+		}
+	}
+
+	return cfg, sources, nil
+}
+
+func (c *Config) cronEntries() []string {
+	var entries []string
+	for _, entry := range strings.Split(c.Cron, ";") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func (c *Config) dataRepo() dataRepo {
+	owner, name, _ := strings.Cut(c.DataRepo, "/")
+	return dataRepo{owner: owner, name: name}
+}
+
+func (c *Config) doduapiUrl() string {
+	return DoduapiBaseUrl + "/" + c.Game + "/v1"
+}
+
+// proxyUrls are the proxies of proxy_url and scrape_proxies in order.
+func (c *Config) proxyUrls() []string {
+	var proxies []string
+	if c.ProxyUrl != "" {
+		proxies = append(proxies, c.ProxyUrl)
+	}
+	return append(proxies, c.ScrapeProxies...)
+}
+
+func proxyScheme(u *url.URL) bool {
+	return (u.Scheme == "http" || u.Scheme == "https" || u.Scheme == "socks5") && u.Host != ""
+}
+
+// renderBrowser is the browser of the render fallback, empty if it is disabled or there is none.
+func (c *Config) renderBrowser() string {
+	if !c.RenderFallback {
+		return ""
+	}
+	return findBrowser(c.RenderBrowser)
+}
+
+func (c *Config) retryPolicy() RetryPolicy {
+	return RetryPolicy{
+		Initial:    c.RetryInitial,
+		Multiplier: c.RetryMultiplier,
+		Max:        c.RetryMaxDelay,
+		Attempts:   c.RetryAttempts,
+		Jitter:     c.RetryJitter,
+	}
+}
+
+// metadata is the license and attribution published with the dataset.
+func (c *Config) metadata() almanax.Metadata {
+	sources := c.SourceUrls
+	if len(sources) == 0 {
+		sources = []string{AlmanaxUrl, "https://github.com/" + c.DataRepo}
+	}
+	return almanax.Metadata{License: c.License, Attribution: c.Attribution, Sources: sources}
+}
+
+func (c *Config) location() *time.Location {
+	if c.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+type configProblem struct {
+	key     string
+	message string
+	warning bool
+}
+
+var (
+	githubTokenRegex  = regexp.MustCompile(`^(gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,}|[0-9a-f]{40})$`)
+	doduapiTokenRegex = regexp.MustCompile(`^[^\s/?#]+$`)
+)
+
+// validate checks the config without network access.
+func (c *Config) validate() []configProblem {
+	var problems []configProblem
+	for _, field := range c.fields() {
+		if field.required && field.isZero() {
+			problems = append(problems, configProblem{key: field.key, message: "required but not set"})
+		}
+	}
+
+	if c.GhAuthKey != "" && !githubTokenRegex.MatchString(c.GhAuthKey) {
+		problems = append(problems, configProblem{key: "gh_auth_key", message: "does not look like a github token", warning: true})
+	}
+	if c.GhAuthKeySecondary != "" && !githubTokenRegex.MatchString(c.GhAuthKeySecondary) {
+		problems = append(problems, configProblem{key: "gh_auth_key_secondary", message: "does not look like a github token", warning: true})
+	}
+	if c.GhAuthKeySecondary != "" && c.GhAuthKeySecondary == c.GhAuthKey {
+		problems = append(problems, configProblem{key: "gh_auth_key_secondary", message: "is the same token as gh_auth_key", warning: true})
+	}
+	if c.DoduapiUpdateToken != "" && !doduapiTokenRegex.MatchString(c.DoduapiUpdateToken) {
+		problems = append(problems, configProblem{key: "doduapi_update_token", message: "must not contain whitespace, '/', '?' or '#'"})
+	}
+
+	if owner, name, ok := strings.Cut(c.DataRepo, "/"); !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+		problems = append(problems, configProblem{key: "data_repo", message: "expected owner/name"})
+	}
+	if c.Game == "" || !doduapiTokenRegex.MatchString(c.Game) {
+		problems = append(problems, configProblem{key: "game", message: "must be a doduapi game like dofus3"})
+	}
+
+	if c.PollingInterval <= 0 {
+		problems = append(problems, configProblem{key: "polling_interval", message: "must be positive"})
+	}
+	if c.EndDuration <= 0 {
+		problems = append(problems, configProblem{key: "end_duration", message: "must be positive"})
+	}
+
+	if c.DashboardAddr != "" && c.DashboardToken == "" {
+		problems = append(problems, configProblem{key: "dashboard_token", message: "not set, the dashboard is read only", warning: true})
+	}
+	if c.WebhookAddr != "" && c.WebhookSecret == "" {
+		problems = append(problems, configProblem{key: "webhook_secret", message: "required when webhook_addr is set"})
+	}
+	for _, entry := range c.cronEntries() {
+		if _, err := parseCronTrigger(entry, time.UTC); err != nil {
+			problems = append(problems, configProblem{key: "cron", message: err.Error()})
+		}
+	}
+
+	for key, timeout := range map[string]time.Duration{
+		"http_connect_timeout": c.HttpConnectTimeout,
+		"http_read_timeout":    c.HttpReadTimeout,
+		"scrape_timeout":       c.ScrapeTimeout,
+		"throttle_max_wait":    c.ThrottleMaxWait,
+		"download_timeout":     c.DownloadTimeout,
+		"upload_timeout":       c.UploadTimeout,
+		"notify_timeout":       c.NotifyTimeout,
+	} {
+		if timeout <= 0 {
+			problems = append(problems, configProblem{key: key, message: "must be positive"})
+		}
+	}
+
+	seenClasses := make(map[string]bool)
+	for _, pair := range c.Playbook {
+		class, remedy, ok := strings.Cut(pair, "=")
+		remedies, known := playbookRemedies[strings.TrimSpace(class)]
+		switch {
+		case !ok:
+			problems = append(problems, configProblem{key: "playbook", message: fmt.Sprintf("expected failure=remedy, got %q", pair)})
+		case !known:
+			problems = append(problems, configProblem{key: "playbook", message: fmt.Sprintf("unknown failure %s, expected one of %s", class, strings.Join(slices.Sorted(maps.Keys(playbookRemedies)), ", "))})
+		case !slices.Contains(remedies, strings.TrimSpace(remedy)):
+			problems = append(problems, configProblem{key: "playbook", message: fmt.Sprintf("unknown remedy %s for %s, expected one of %s", remedy, class, strings.Join(remedies, ", "))})
+		case seenClasses[strings.TrimSpace(class)]:
+			problems = append(problems, configProblem{key: "playbook", message: fmt.Sprintf("%s is configured twice", class)})
+		case strings.TrimSpace(remedy) == remedySwitchProxy && len(c.proxyUrls()) == 0:
+			problems = append(problems, configProblem{key: "playbook", message: "switch-proxy needs proxy_url or scrape_proxies"})
+		}
+		seenClasses[strings.TrimSpace(class)] = true
+	}
+	if c.RenderFallback && c.renderBrowser() == "" {
+		problems = append(problems, configProblem{key: "render_fallback", message: "no browser found, set render_browser or install chromium", warning: true})
+	}
+	if c.RenderBrowser != "" && !c.RenderFallback {
+		problems = append(problems, configProblem{key: "render_browser", message: "has no effect without render_fallback", warning: true})
+	}
+
+	// the urls may hold credentials, they are not repeated
+	if u, err := url.Parse(c.ProxyUrl); c.ProxyUrl != "" && (err != nil || !proxyScheme(u)) {
+		problems = append(problems, configProblem{key: "proxy_url", message: "expected an http(s) or socks5 url"})
+	}
+	for i, proxy := range c.ScrapeProxies {
+		if u, err := url.Parse(proxy); err != nil || !proxyScheme(u) {
+			problems = append(problems, configProblem{key: "scrape_proxies", message: fmt.Sprintf("entry %d is not an http(s) or socks5 url", i+1)})
+		}
+	}
+	if c.ProxyMode != proxyRoundRobin && c.ProxyMode != proxyFailover {
+		problems = append(problems, configProblem{key: "proxy_mode", message: fmt.Sprintf("expected %s or %s", proxyRoundRobin, proxyFailover)})
+	}
+	if c.ProxyMaxFailures < 0 {
+		problems = append(problems, configProblem{key: "proxy_max_failures", message: "must not be negative"})
+	}
+	if c.ProxyMaxFailures > 0 && c.ProxyCooldown <= 0 {
+		problems = append(problems, configProblem{key: "proxy_cooldown", message: "must be positive"})
+	}
+	if c.PlaybookPause <= 0 {
+		problems = append(problems, configProblem{key: "playbook_pause", message: "must be positive"})
+	}
+	if c.PlaybookAttempts < 0 {
+		problems = append(problems, configProblem{key: "playbook_attempts", message: "must not be negative"})
+	}
+
+	if c.QueueLimit < 0 {
+		problems = append(problems, configProblem{key: "queue_limit", message: "must not be negative"})
+	}
+	if c.JobAttempts < 0 {
+		problems = append(problems, configProblem{key: "job_attempts", message: "must not be negative"})
+	}
+	if !slices.Contains(queuePolicies, c.QueuePolicy) {
+		problems = append(problems, configProblem{key: "queue_policy", message: fmt.Sprintf("unknown policy %s, expected one of %s", c.QueuePolicy, strings.Join(queuePolicies, ", "))})
+	}
+
+	if c.HttpMaxIdleConns < 1 {
+		problems = append(problems, configProblem{key: "http_max_idle_conns", message: "must be at least 1"})
+	}
+	if c.PageCacheTtl < 0 {
+		problems = append(problems, configProblem{key: "page_cache_ttl", message: "must not be negative"})
+	}
+
+	if c.KeepReleases < 0 {
+		problems = append(problems, configProblem{key: "keep_releases", message: "must not be negative"})
+	}
+	if c.ArchiveDir != "" && c.KeepReleases == 0 {
+		problems = append(problems, configProblem{key: "archive_dir", message: "has no effect without keep_releases", warning: true})
+	}
+
+	if c.StagingUrl != "" {
+		if u, err := url.Parse(c.StagingUrl); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, configProblem{key: "staging_url", message: "expected an http(s) url"})
+		}
+	}
+
+	for _, source := range c.SourceUrls {
+		if u, err := url.Parse(source); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, configProblem{key: "source_urls", message: fmt.Sprintf("expected an http(s) url, got %q", source)})
+		}
+	}
+
+	if c.UploadRate < 0 {
+		problems = append(problems, configProblem{key: "upload_rate", message: "must not be negative"})
+	}
+
+	if c.RetryInitial <= 0 {
+		problems = append(problems, configProblem{key: "retry_initial", message: "must be positive"})
+	}
+	if c.RetryMultiplier < 1 {
+		problems = append(problems, configProblem{key: "retry_multiplier", message: "must be at least 1"})
+	}
+	if c.RetryMaxDelay < c.RetryInitial {
+		problems = append(problems, configProblem{key: "retry_max_delay", message: "must not be shorter than retry_initial"})
+	}
+	if c.RetryAttempts < 0 {
+		problems = append(problems, configProblem{key: "retry_attempts", message: "must not be negative"})
+	}
+	if c.RetryJitter < 0 || c.RetryJitter > 1 {
+		problems = append(problems, configProblem{key: "retry_jitter", message: "must be between 0 and 1"})
+	}
+
+	if c.Faults != "" {
+		if _, err := parseFaults(c.Faults); err != nil {
+			problems = append(problems, configProblem{key: "faults", message: err.Error()})
+		} else {
+			problems = append(problems, configProblem{key: "faults", message: "requests fail on purpose, never set this in production", warning: true})
+		}
+	}
+
+	if c.ExtendBelow < 0 {
+		problems = append(problems, configProblem{key: "extend_below", message: "must not be negative"})
+	} else if c.ExtendBelow >= c.EndDuration {
+		problems = append(problems, configProblem{key: "extend_below", message: "must be shorter than end_duration"})
+	}
+
+	if c.CanaryDates < 0 {
+		problems = append(problems, configProblem{key: "canary_dates", message: "must not be negative"})
+	}
+
+	if c.ValidatePercent < 0 || c.ValidatePercent > 100 {
+		problems = append(problems, configProblem{key: "validate_percent", message: "must be between 0 and 100"})
+	}
+	if c.ValidateWorkers < 1 {
+		problems = append(problems, configProblem{key: "validate_workers", message: "must be at least 1"})
+	}
+
+	for _, pair := range c.ReceiverAliases {
+		if scraped, mapped, ok := strings.Cut(pair, "="); !ok || normalizeName(scraped) == "" || normalizeName(mapped) == "" {
+			problems = append(problems, configProblem{key: "receiver_aliases", message: fmt.Sprintf("expected scraped=mapped, got %q", pair)})
+		}
+	}
+
+	if c.NameTolerance < 0 {
+		problems = append(problems, configProblem{key: "name_tolerance", message: "must not be negative"})
+	}
+
+	if len(c.ScrapeLanguages) == 0 {
+		problems = append(problems, configProblem{key: "scrape_languages", message: "at least one language is needed"})
+	}
+	for _, lang := range c.ScrapeLanguages {
+		if _, ok := almanaxPagePatterns[lang]; !ok {
+			problems = append(problems, configProblem{key: "scrape_languages", message: fmt.Sprintf("unsupported language %q", lang)})
+		}
+	}
+	for _, lang := range c.CrossCheckLanguages {
+		if _, ok := almanaxPagePatterns[lang]; !ok {
+			problems = append(problems, configProblem{key: "cross_check_languages", message: fmt.Sprintf("unsupported language %q", lang)})
+		}
+	}
+	if c.ScrapeWorkers < 1 {
+		problems = append(problems, configProblem{key: "scrape_workers", message: "must be at least 1"})
+	}
+	if c.ScrapeRate <= 0 {
+		problems = append(problems, configProblem{key: "scrape_rate", message: "must be positive"})
+	}
+	if c.ServeSlo < 0 {
+		problems = append(problems, configProblem{key: "serve_slo", message: "must not be negative"})
+	}
+	if c.ServeSlo > 0 && c.ServeCheckInterval <= 0 {
+		problems = append(problems, configProblem{key: "serve_check_interval", message: "must be positive"})
+	}
+	if c.AlertWindow < 0 {
+		problems = append(problems, configProblem{key: "alert_window", message: "must not be negative"})
+	}
+	if c.AlertEscalateAfter < 0 {
+		problems = append(problems, configProblem{key: "alert_escalate_after", message: "must not be negative"})
+	}
+	if c.MaintenanceFeed != "" {
+		if u, err := url.Parse(c.MaintenanceFeed); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, configProblem{key: "maintenance_feed", message: "must be an http or https url"})
+		}
+		if c.MaintenanceCheckInterval <= 0 {
+			problems = append(problems, configProblem{key: "maintenance_check_interval", message: "must be positive"})
+		}
+		if c.MaintenanceDuration <= 0 {
+			problems = append(problems, configProblem{key: "maintenance_duration", message: "must be positive"})
+		}
+	}
+	if c.SecondarySource != "" {
+		if u, err := url.Parse(secondaryUrl(c.SecondarySource, "2025-01-01")); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, configProblem{key: "secondary_source", message: "must be an http or https url"})
+		}
+		if !strings.Contains(c.SecondarySource, "{date}") {
+			problems = append(problems, configProblem{key: "secondary_source", message: "has no {date}, every date is compared with the same answer", warning: true})
+		}
+	}
+	if c.CycleVerifyPercent < 0 || c.CycleVerifyPercent > 100 {
+		problems = append(problems, configProblem{key: "cycle_verify_percent", message: "must be between 0 and 100"})
+	}
+	if c.SecondaryPercent < 0 || c.SecondaryPercent > 100 {
+		problems = append(problems, configProblem{key: "secondary_percent", message: "must be between 0 and 100"})
+	}
+	if c.RunMaxRequests < 0 {
+		problems = append(problems, configProblem{key: "run_max_requests", message: "must not be negative"})
+	}
+	if c.RunMaxDuration < 0 {
+		problems = append(problems, configProblem{key: "run_max_duration", message: "must not be negative"})
+	}
+	if c.RunMaxGithubCalls < 0 {
+		problems = append(problems, configProblem{key: "run_max_github_calls", message: "must not be negative"})
+	}
+	if (c.RunMaxRequests > 0 || c.RunMaxDuration > 0 || c.RunMaxGithubCalls > 0) && c.RunLimitPause <= 0 {
+		problems = append(problems, configProblem{key: "run_limit_pause", message: "must be positive when a run limit is set"})
+	}
+	if c.LiveCheckInterval < 0 {
+		problems = append(problems, configProblem{key: "live_check_interval", message: "must not be negative"})
+	}
+	if c.LocaleCheckInterval < 0 {
+		problems = append(problems, configProblem{key: "locale_check_interval", message: "must not be negative"})
+	}
+	if c.TokenCheckInterval < 0 {
+		problems = append(problems, configProblem{key: "token_check_interval", message: "must not be negative"})
+	}
+	if c.GithubQuotaReserve < 0 {
+		problems = append(problems, configProblem{key: "github_quota_reserve", message: "must not be negative"})
+	}
+	if c.GithubWriteInterval < 0 {
+		problems = append(problems, configProblem{key: "github_write_interval", message: "must not be negative"})
+	}
+	if c.UserAgentsFile != "" {
+		if _, err := os.Stat(c.UserAgentsFile); err != nil {
+			problems = append(problems, configProblem{key: "user_agents_file", message: err.Error()})
+		}
+	}
+	if c.ScrapeBudget < 0 {
+		problems = append(problems, configProblem{key: "scrape_budget", message: "must not be negative"})
+	}
+	if c.ScrapeJitterMin < 0 || c.ScrapeJitterMax < c.ScrapeJitterMin {
+		problems = append(problems, configProblem{key: "scrape_jitter_max", message: "must be at least scrape_jitter_min, which must not be negative"})
+	}
+	seen := make(map[string]bool)
+	for _, strategy := range c.Strategies {
+		if !slices.Contains(mappingStrategies, strategy) {
+			problems = append(problems, configProblem{key: "strategies", message: fmt.Sprintf("unknown strategy %s, expected one of %s", strategy, strings.Join(mappingStrategies, ", "))})
+		}
+		if seen[strategy] {
+			problems = append(problems, configProblem{key: "strategies", message: fmt.Sprintf("%s is listed twice", strategy)})
+		}
+		seen[strategy] = true
+	}
+	if !seen[strategyFullScrape] {
+		problems = append(problems, configProblem{key: "strategies", message: "without full-scrape the dates the other strategies can not map stay unmapped", warning: true})
+	}
+	if c.ScrapeMode != "day" && c.ScrapeMode != "month" {
+		problems = append(problems, configProblem{key: "scrape_mode", message: "must be day or month"})
+	}
+	if c.SanityMinDates < 0 {
+		problems = append(problems, configProblem{key: "sanity_min_dates", message: "must not be negative"})
+	}
+	if c.SanityMaxSizeChange < 0 {
+		problems = append(problems, configProblem{key: "sanity_max_size_change", message: "must not be negative"})
+	}
+	if c.CoverageMode != "strict" && c.CoverageMode != "lenient" {
+		problems = append(problems, configProblem{key: "coverage_mode", message: "must be strict or lenient"})
+	}
+	if c.FallbackAfter < 1 {
+		problems = append(problems, configProblem{key: "fallback_after", message: "must be at least 1"})
+	}
+
+	if len(c.Languages) == 0 {
+		problems = append(problems, configProblem{key: "languages", message: "at least one language is needed"})
+	}
+	for _, lang := range c.Languages {
+		if _, ok := almanaxPagePatterns[lang]; !ok {
+			problems = append(problems, configProblem{key: "languages", message: fmt.Sprintf("unsupported language %q", lang)})
+		}
+	}
+
+	if c.ShutdownGrace <= 0 {
+		problems = append(problems, configProblem{key: "shutdown_grace", message: "must be positive"})
+	}
+	if c.LeaseName != "" && c.LeaseDuration < 3*time.Second {
+		problems = append(problems, configProblem{key: "lease_duration", message: "must be at least 3s"})
+	}
+
+	if _, err := log.ParseLevel(c.LogLevel); err != nil {
+		problems = append(problems, configProblem{key: "log_level", message: err.Error()})
+	}
+
+	if c.Timezone != "" {
+		if _, err := time.LoadLocation(c.Timezone); err != nil {
+			problems = append(problems, configProblem{key: "timezone", message: err.Error()})
+		}
+	}
+
+	return problems
+}
+
+func checkReachable(url string) error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	req, err := http.NewRequest("HEAD", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", UserAgent)
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 500 {
+		return fmt.Errorf("status code error: %d %s", res.StatusCode, res.Status)
+	}
+	return nil
+}
+
+// checkConnectivity checks that the services used by the daemon can be reached.
+func (c *Config) checkConnectivity() []configProblem {
+	urls := [][2]string{
+		{"krosmoz", AlmanaxUrl},
+		{"doduapi", DoduapiUrl},
+		{"github", "https://api.github.com"},
+	}
+
+	var problems []configProblem
+	for _, url := range urls {
+		if err := checkReachable(url[1]); err != nil {
+			problems = append(problems, configProblem{key: url[0], message: fmt.Sprintf("%s not reachable: %s", url[1], err)})
+		}
+	}
+
+	if c.GhAuthKey != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), c.NotifyTimeout)
+		defer cancel()
+		health, err := checkGithubToken(ctx, c.GhAuthKey, c.dataRepo())
+		if err != nil {
+			problems = append(problems, configProblem{key: "gh_auth_key", message: err.Error()})
+		} else {
+			problems = append(problems, health.problems(c.TokenExpiryWarning)...)
+		}
+	}
+	return problems
+}
+
+// redactSecret keeps only the last characters of long secrets so operators can tell tokens apart.
+func redactSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	if len(secret) < 16 {
+		return "****"
+	}
+	return "****" + secret[len(secret)-4:]
+}
+
+// displayValue is the value of a config field as it may be printed or logged.
+func (f configField) displayValue() string {
+	if f.secret {
+		return redactSecret(f.String())
+	}
+	return f.String()
+}
+
+// writeConfigDump prints every config key with its value, the source of the value and its problems.
+func writeConfigDump(w io.Writer, cfg *Config, sources configSources, problems []configProblem) error {
+	byKey := make(map[string][]configProblem)
+	for _, problem := range problems {
+		byKey[problem.key] = append(byKey[problem.key], problem)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, field := range cfg.fields() {
+		if field.hidden && field.isZero() {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t= %s\t# %s\n", field.key, field.displayValue(), sources[field.key])
+		for _, problem := range byKey[field.key] {
+			fmt.Fprintf(tw, "  %s\t%s\t\n", problemLevel(problem), problem.message)
+		}
+		delete(byKey, field.key)
+	}
+	for _, problem := range problems {
+		if _, ok := byKey[problem.key]; ok {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", problem.key, problemLevel(problem), problem.message)
+		}
+	}
+	return tw.Flush()
+}
+
+// logConfig logs the effective config at debug level.
+func logConfig(cfg *Config, sources configSources) {
+	for _, field := range cfg.fields() {
+		if field.hidden && field.isZero() {
+			continue
+		}
+		log.Debug("config", "key", field.key, "value", field.displayValue(), "source", sources[field.key])
+	}
+}
+
+func problemLevel(problem configProblem) string {
+	if problem.warning {
+		return "WARN"
+	}
+	return "ERROR"
+}
+
+func configCommand(args []string) {
+	if len(args) == 0 {
+		log.Fatal("missing config subcommand", "available", "validate, show")
+	}
+
+	switch args[0] {
+	case "validate":
+		configValidateCommand(args[1:])
+	case "show":
+		configShowCommand(args[1:])
+	default:
+		log.Fatal("unknown config subcommand", "command", args[0])
+	}
+}
+
+func configValidateCommand(args []string) {
+	flags := flag.NewFlagSet("config validate", flag.ExitOnError)
+	offline := flags.Bool("offline", false, "skip the reachability checks")
+	cfg, sources, err := loadConfig(flags, args)
+	if err != nil {
+		log.Fatal("error loading config", "error", err)
+	}
+
+	problems := cfg.validate()
+	if len(cfg.Tenants) > 0 {
+		// the base config only provides defaults for the tenants, each tenant has to be complete
+		tenants, err := loadTenants(&cfg)
+		if err != nil {
+			log.Fatal("error loading tenants", "error", err)
+		}
+		problems = nil
+		for _, tenant := range tenants {
+			for _, problem := range tenant.cfg.validate() {
+				problem.key = tenant.name + "." + problem.key
+				problems = append(problems, problem)
+			}
+		}
+	}
+	if !*offline {
+		problems = append(problems, cfg.checkConnectivity()...)
+	}
+
+	err = writeConfigDump(os.Stdout, &cfg, sources, problems)
+	if err != nil {
+		log.Fatal("error writing config", "error", err)
+	}
+
+	for _, problem := range problems {
+		if !problem.warning {
+			fmt.Println("\nconfig is invalid")
+			os.Exit(1)
+		}
+	}
+	fmt.Println("\nconfig is valid")
+}
+
+func configShowCommand(args []string) {
+	cfg, sources, err := loadConfig(flag.NewFlagSet("config show", flag.ExitOnError), args)
+	if err != nil {
+		log.Fatal("error loading config", "error", err)
+	}
+
+	err = writeConfigDump(os.Stdout, &cfg, sources, nil)
+	if err != nil {
+		log.Fatal("error writing config", "error", err)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a standard five field cron expression: minute, hour, day of month, month, day of week.
+type cronSchedule struct {
+	fields [5]map[int]bool
+	// anyDay and anyWeekday are set for day fields starting with *, like in cron a date matches either day
+	// field when both are restricted
+	anyDay     bool
+	anyWeekday bool
+}
+
+// day of week goes to 7, which is sunday like 0
+var cronRanges = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+// parseCron supports "*", numbers, ranges "a-b", lists "a,b" and steps "*/n" or "a-b/n".
+func parseCron(spec string) (cronSchedule, error) {
+	var schedule cronSchedule
+	parts := strings.Fields(spec)
+	if len(parts) != 5 {
+		return schedule, fmt.Errorf("expected 5 fields, got %d", len(parts))
+	}
+
+	for i, part := range parts {
+		values := make(map[int]bool)
+		for _, item := range strings.Split(part, ",") {
+			lo, hi := cronRanges[i][0], cronRanges[i][1]
+			step := 1
+
+			rng, stepStr, hasStep := strings.Cut(item, "/")
+			if hasStep {
+				var err error
+				step, err = strconv.Atoi(stepStr)
+				if err != nil || step < 1 {
+					return schedule, fmt.Errorf("invalid step in %q", item)
+				}
+			}
+
+			if rng != "*" {
+				from, to, isRange := strings.Cut(rng, "-")
+				var err error
+				lo, err = strconv.Atoi(from)
+				if err != nil {
+					return schedule, fmt.Errorf("invalid value in %q", item)
+				}
+				hi = lo
+				if isRange {
+					hi, err = strconv.Atoi(to)
+					if err != nil {
+						return schedule, fmt.Errorf("invalid value in %q", item)
+					}
+				} else if hasStep {
+					hi = cronRanges[i][1]
+				}
+			}
+
+			if lo < cronRanges[i][0] || hi > cronRanges[i][1] || lo > hi {
+				return schedule, fmt.Errorf("%q out of range %d-%d", item, cronRanges[i][0], cronRanges[i][1])
+			}
+			for v := lo; v <= hi; v += step {
+				values[v] = true
+			}
+		}
+		if i == 4 && values[7] {
+			values[0] = true
+		}
+		schedule.fields[i] = values
+	}
+	schedule.anyDay = strings.HasPrefix(parts[2], "*")
+	schedule.anyWeekday = strings.HasPrefix(parts[4], "*")
+	return schedule, nil
+}
+
+func (c cronSchedule) matches(t time.Time) bool {
+	if !c.fields[0][t.Minute()] || !c.fields[1][t.Hour()] || !c.fields[3][int(t.Month())] {
+		return false
+	}
+	day, weekday := c.fields[2][t.Day()], c.fields[4][int(t.Weekday())]
+	if !c.anyDay && !c.anyWeekday {
+		return day || weekday
+	}
+	return day && weekday
+}
+
+// next returns the first matching minute after t, or the zero time if none matches within a year.
+func (c cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	for end := t.AddDate(1, 0, 0); t.Before(end); t = t.Add(time.Minute) {
+		if c.matches(t) {
+			return t
+		}
+	}
+	return time.Time{}
+}
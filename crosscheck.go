@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/charmbracelet/log"
+	"github.com/dofusdude/alm-dates/almanax"
+)
+
+// languageMismatch is a date whose page in another language resolves to a different receiver.
+type languageMismatch struct {
+	Date string
+	// Lang is the cross checked language, Mapped the receiver resolved from the mapping language.
+	Lang   string
+	Mapped string
+	// Scraped is the receiver name on the page, Resolved the entry it matches, empty if none.
+	Scraped  string
+	Resolved string
+}
+
+// crossCheck scrapes a date in the cross check languages and returns those whose page does not resolve to
+// receiver i, which was resolved from the page in lang. The bonus of the pages that do is compared with the
+// data. Unavailable pages are skipped.
+func (s *scraper) crossCheck(ds *almanax.Dataset, date string, lang string, i int, aliases map[string]string) []languageMismatch {
+	var mismatches []languageMismatch
+	for _, other := range s.checkLanguages() {
+		if other == lang {
+			continue
+		}
+
+		html, _ := s.cachedPage(other, date)
+		if html == nil {
+			var status int
+			err := s.retry.do(context.Background(), "cross check scrape", func() error {
+				var err error
+				s.limits.request()
+				html, status, err = fetchAlmanaxHtml(other, date, s.timeout)
+				return err
+			})
+			if err != nil {
+				log.Warn("could not cross check date", "date", date, "lang", other, "error", err)
+				continue
+			}
+			if status != 200 {
+				log.Debug("cross check page unavailable", "date", date, "lang", other, "status", status)
+				continue
+			}
+			s.keepPage(other, date, html, pageValidators{})
+		}
+
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(html))
+		if err != nil {
+			log.Warn("could not cross check date", "date", date, "lang", other, "error", err)
+			continue
+		}
+		page := extractAlmanaxPage(doc, other)
+		if mismatch, ok := compareLanguage(ds, date, other, page, i, aliases); !ok {
+			mismatches = append(mismatches, mismatch)
+			continue
+		}
+		s.bonus.check(ds, date, other, page, i)
+	}
+	return mismatches
+}
+
+// compareLanguage checks that the page of a date in lang resolves to receiver i.
+func compareLanguage(ds *almanax.Dataset, date string, lang string, page almanaxPage, i int, aliases map[string]string) (languageMismatch, bool) {
+	j := matchAlmanaxPage(ds, lang, page, aliases)
+	if j == i {
+		return languageMismatch{}, true
+	}
+
+	mismatch := languageMismatch{Date: date, Lang: lang, Mapped: ds.Receivers[i].Name, Scraped: page.Receiver}
+	if j != -1 {
+		mismatch.Resolved = ds.Receivers[j].Name
+	}
+	return mismatch, false
+}
+
+// bonusDivergence is a date whose page shows a different bonus than the data has for the receiver it
+// resolved to, the data repo may have mapped the bonus to the wrong receiver.
+type bonusDivergence struct {
+	Date     string `json:"date"`
+	Lang     string `json:"lang"`
+	Receiver string `json:"receiver"`
+	Scraped  string `json:"scraped"`
+	Mapped   string `json:"mapped"`
+}
+
+// compareBonus checks that the bonus of the page of a date in lang contains the bonus receiver i has in that
+// language. A page or receiver without a bonus text agrees.
+func compareBonus(ds *almanax.Dataset, date string, lang string, page almanaxPage, i int) (bonusDivergence, bool) {
+	mapped := ds.Receivers[i].Bonus.Description[lang]
+	scraped := normalizeName(page.Bonus)
+	if scraped == "" || normalizeName(mapped) == "" || strings.Contains(scraped, normalizeName(mapped)) {
+		return bonusDivergence{}, true
+	}
+	return bonusDivergence{Date: date, Lang: lang, Receiver: ds.Receivers[i].Name, Scraped: page.Bonus, Mapped: mapped}, false
+}
+
+// bonusReport collects the bonus divergences of a job for its run record.
+type bonusReport struct {
+	mu          sync.Mutex
+	divergences []bonusDivergence
+}
+
+func (r *bonusReport) add(divergence bonusDivergence) {
+	log.Warn("bonus differs from the mapped data", "date", divergence.Date, "lang", divergence.Lang, "receiver", divergence.Receiver, "scraped", divergence.Scraped, "mapped", divergence.Mapped)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.divergences = append(r.divergences, divergence)
+}
+
+// check compares the bonus of a page and keeps a divergence.
+func (r *bonusReport) check(ds *almanax.Dataset, date string, lang string, page almanaxPage, i int) {
+	if divergence, ok := compareBonus(ds, date, lang, page, i); !ok {
+		r.add(divergence)
+	}
+}
+
+// take returns the collected divergences sorted by date and starts over.
+func (r *bonusReport) take() []bonusDivergence {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	divergences := r.divergences
+	r.divergences = nil
+	sort.Slice(divergences, func(a, b int) bool {
+		if divergences[a].Date != divergences[b].Date {
+			return divergences[a].Date < divergences[b].Date
+		}
+		return divergences[a].Lang < divergences[b].Lang
+	})
+	return divergences
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"crypto/subtle"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+const pausedFileName = "paused"
+
+// jobProgress is how far the running job of a pipeline got. Scraped dates are counted by the checkpoint.
+type jobProgress struct {
+	mu      sync.Mutex
+	job     *job
+	started time.Time
+	total   int
+	cp      *checkpoint
+}
+
+type progressStatus struct {
+	Job     *job      `json:"job"`
+	Started time.Time `json:"started"`
+	Done    int       `json:"done"`
+	Total   int       `json:"total"`
+}
+
+func (pr *jobProgress) begin(j job) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.job, pr.started, pr.total, pr.cp = &j, time.Now().UTC(), 0, nil
+}
+
+// track counts the dates of the checkpoint against the dates the job scrapes.
+func (pr *jobProgress) track(cp *checkpoint, total int) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.cp, pr.total = cp, total
+}
+
+func (pr *jobProgress) end() {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.job, pr.cp, pr.total = nil, nil, 0
+}
+
+func (pr *jobProgress) status() progressStatus {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	status := progressStatus{Job: pr.job, Started: pr.started, Total: pr.total}
+	if pr.job == nil {
+		status.Started = time.Time{}
+	}
+	status.Done = min(pr.cp.len(), pr.total)
+	return status
+}
+
+// alert is a failure maintainers should look at. Alerts of the same class within alert_window are grouped
+// into one, Count says how often it happened and Last when it happened last.
+type alert struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+	Error   string    `json:"error,omitempty"`
+	Class   string    `json:"class"`
+	Count   int       `json:"count"`
+	Last    time.Time `json:"last"`
+	// Escalated is set once the class happened alert_escalate_after times in a row
+	Escalated bool `json:"escalated,omitempty"`
+	// Resolved is when the failure recovered, resolution notices have it set from the start
+	Resolved *time.Time `json:"resolved,omitempty"`
+}
+
+// alertLog keeps the recent alerts of a pipeline in memory for the dashboard.
+type alertLog struct {
+	mu            sync.Mutex
+	alerts        []alert
+	window        time.Duration
+	escalateAfter int
+}
+
+const recentAlerts = 50
+
+// alertClass groups the alerts of a failure: the playbook failure class of the error if it has one,
+// otherwise the message.
+func alertClass(message string, err error) string {
+	if class := classifyFailure(err); class != "" {
+		return class
+	}
+	return message
+}
+
+func (a *alertLog) add(message string, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now().UTC()
+	class := alertClass(message, err)
+
+	// a repeated failure updates its open alert and moves it to the top instead of adding another
+	for n := len(a.alerts) - 1; n >= 0; n-- {
+		open := a.alerts[n]
+		if open.Class != class || open.Resolved != nil || now.Sub(open.Last) > a.window {
+			continue
+		}
+		open.Count++
+		open.Last = now
+		if err != nil {
+			open.Error = err.Error()
+		}
+		if a.escalateAfter > 0 && open.Count >= a.escalateAfter && !open.Escalated {
+			open.Escalated = true
+			log.Error("alert escalated, it keeps happening", "alert", open.Message, "class", class, "count", open.Count, "since", open.Time.Format(time.RFC3339))
+		}
+		a.alerts = append(slices.Delete(a.alerts, n, n+1), open)
+		return
+	}
+
+	entry := alert{Time: now, Message: message, Class: class, Count: 1, Last: now}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	a.append(entry)
+}
+
+// resolve closes the open alerts of the classes and adds a resolution notice for each.
+func (a *alertLog) resolve(classes ...string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now().UTC()
+	var notices []alert
+	for n, open := range a.alerts {
+		if open.Resolved != nil || !slices.Contains(classes, open.Class) {
+			continue
+		}
+		a.alerts[n].Resolved = &now
+		message := fmt.Sprintf("resolved: %s", open.Message)
+		if open.Count > 1 {
+			message += fmt.Sprintf(" (happened %d times)", open.Count)
+		}
+		notices = append(notices, alert{Time: now, Message: message, Class: open.Class, Count: 1, Last: now, Resolved: &now})
+	}
+	for _, notice := range notices {
+		a.append(notice)
+	}
+}
+
+func (a *alertLog) append(entry alert) {
+	a.alerts = append(a.alerts, entry)
+	if len(a.alerts) > recentAlerts {
+		a.alerts = a.alerts[len(a.alerts)-recentAlerts:]
+	}
+}
+
+// recent returns the alerts newest first.
+func (a *alertLog) recent() []alert {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	alerts := make([]alert, len(a.alerts))
+	for i, entry := range a.alerts {
+		alerts[len(alerts)-1-i] = entry
+	}
+	return alerts
+}
+
+// paused reports whether a maintainer paused the pipeline, it starts no job until resumed. The pause is
+// kept in the workdir state, so it survives restarts.
+func (p *pipeline) paused() bool {
+	_, err := os.Stat(filepath.Join(stateDir(p.workdir), pausedFileName))
+	return err == nil
+}
+
+func (p *pipeline) setPaused(paused bool) error {
+	path := filepath.Join(stateDir(p.workdir), pausedFileName)
+	if !paused {
+		err := os.Remove(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	err := os.MkdirAll(stateDir(p.workdir), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(time.Now().UTC().Format(time.RFC3339)), 0644)
+}
+
+// tenantStatus is what the dashboard shows of a pipeline.
+type tenantStatus struct {
+	Tenant   string         `json:"tenant"`
+	Repo     string         `json:"repo"`
+	Paused   bool           `json:"paused"`
+	Degraded string         `json:"degraded,omitempty"`
+	Progress progressStatus `json:"progress"`
+	Queue    []job          `json:"queue"`
+	Runs     []runRecord    `json:"runs"`
+	Diff     *diffReport    `json:"diff"`
+	Alerts   []alert        `json:"alerts"`
+}
+
+// dashboard serves the maintainer ui and its api. Reading is open like the health endpoints, pausing and
+// remapping need the dashboard token.
+type dashboard struct {
+	token     string
+	pipelines []*pipeline
+}
+
+const dashboardRuns = 20
+
+func (d *dashboard) routes() *http.ServeMux {
+	files, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		panic(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /", http.FileServerFS(files))
+	mux.HandleFunc("GET /api/status", d.handleStatus)
+	mux.HandleFunc("POST /api/pause", d.authorized(d.handlePause(true)))
+	mux.HandleFunc("POST /api/resume", d.authorized(d.handlePause(false)))
+	mux.HandleFunc("POST /api/remap", d.authorized(d.handleRemap))
+	return mux
+}
+
+func (d *dashboard) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if d.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(d.token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// tenant returns the pipeline of the tenant query parameter, the only one may be picked without it.
+func (d *dashboard) tenant(w http.ResponseWriter, r *http.Request) *pipeline {
+	name := r.URL.Query().Get("tenant")
+	for _, p := range d.pipelines {
+		if p.name == name || (name == "" && len(d.pipelines) == 1) {
+			if p.queue == nil {
+				http.Error(w, "pipeline is starting", http.StatusServiceUnavailable)
+				return nil
+			}
+			return p
+		}
+	}
+	http.Error(w, "unknown tenant", http.StatusNotFound)
+	return nil
+}
+
+func (d *dashboard) handleStatus(w http.ResponseWriter, r *http.Request) {
+	statuses := make([]tenantStatus, 0, len(d.pipelines))
+	for _, p := range d.pipelines {
+		status := tenantStatus{
+			Tenant:   p.name,
+			Repo:     p.repo.String(),
+			Paused:   p.paused(),
+			Degraded: health.degradedReason(p.name),
+			Progress: p.progress.status(),
+			Alerts:   p.alerts.recent(),
+		}
+		if p.queue != nil {
+			status.Queue = p.queue.list()
+		}
+
+		var err error
+		status.Runs, err = loadRuns(p.workdir, dashboardRuns)
+		if err != nil {
+			log.Warn("error reading run history", "tenant", p.name, "error", err)
+		}
+		status.Diff, err = loadDiffReport(p.workdir)
+		if err != nil {
+			log.Warn("error reading diff report", "tenant", p.name, "error", err)
+		}
+		statuses = append(statuses, status)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(statuses)
+}
+
+func (d *dashboard) handlePause(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := d.tenant(w, r)
+		if p == nil {
+			return
+		}
+		err := p.setPaused(paused)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		p.log.Warn("pipeline paused from the dashboard", "paused", paused)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleRemap queues a map-version job that maps all dates of the window again.
+func (d *dashboard) handleRemap(w http.ResponseWriter, r *http.Request) {
+	p := d.tenant(w, r)
+	if p == nil {
+		return
+	}
+	added, err := p.queue.push(job{Kind: jobMapVersion, Force: true, Trigger: "dashboard"})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !added {
+		http.Error(w, "a forced remap is already queued", http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func serveDashboard(addr string, token string, pipelines []*pipeline) {
+	d := &dashboard{token: token, pipelines: pipelines}
+	log.Info("dashboard listening", "addr", addr)
+	err := http.ListenAndServe(addr, d.routes())
+	if err != nil {
+		log.Error("dashboard stopped, the pipelines keep running", "addr", addr, "error", err)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/dofusdude/alm-dates/almanax"
+)
+
+func loadDataset(repo dataRepo, version string) (*almanax.Dataset, error) {
+	ds, _, err := loadDatasetRelease(repo, version)
+	return ds, err
+}
+
+// loadDatasetRelease downloads the mapped almanax of a release in whatever schema it has and returns it
+// with the time the asset was uploaded.
+func loadDatasetRelease(repo dataRepo, version string) (*almanax.Dataset, time.Time, error) {
+	data, uploadedAt, err := downloadAlmanaxAsset(repo, version)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	ds, err := almanax.Decode(data)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return ds, uploadedAt, nil
+}
+
+// assetPointer names the content addressed copy of the mapped almanax.
+type assetPointer struct {
+	Name   string `json:"name"`
+	Sha256 string `json:"sha256"`
+	Size   int    `json:"size"`
+}
+
+// contentAddressedAssets returns the asset named with its short content hash, which never changes and can
+// be cached forever, and the pointer file to it.
+func contentAddressedAssets(data []byte) []releaseAsset {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	name := fmt.Sprintf("%s-%s.json", strings.TrimSuffix(MappedAlmanaxFileName, ".json"), hash[:6])
+
+	// marshaling a struct of strings and ints does not fail
+	pointer, _ := json.MarshalIndent(assetPointer{Name: name, Sha256: hash, Size: len(data)}, "", "  ")
+	return []releaseAsset{
+		{name: name, label: name, data: data},
+		{name: MappedAlmanaxPointerFileName, label: MappedAlmanaxPointerFileName, data: pointer},
+	}
+}
+
+// publishDataset replaces the release asset, written in the schema the dataset was read from, and the
+// provenance of its dates with the sources of the newly mapped ones added. pages holds the kept krosmoz
+// pages the flavor asset is read from. It returns the changes against the asset consumers had before, nil
+// if that could not be read.
+func publishDataset(journal *publishJournal, ds *almanax.Dataset, version string, cfg *Config, sources provenance, pages string) (*diffReport, error) {
+	// runs over partly mapped data can leave repeated or out of order dates behind
+	if dropped := ds.NormalizeDays(); len(dropped) > 0 {
+		log.Warn("dropped repeated or malformed dates before publishing", "version", version, "dropped", dropped)
+	}
+
+	data, err := ds.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	base, baseVersion, err := previousAsset(cfg.dataRepo(), version)
+	if err != nil {
+		log.Warn("could not load the asset consumers have now", "version", version, "error", err)
+		base = nil
+	}
+	if cfg.SanityGate {
+		err = sanityGate(ds, data, base, cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = stageDataset(data, version, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var report *diffReport
+	if base != nil {
+		previous, err := almanax.Decode(base)
+		if err == nil {
+			changes := diffAssignments(version, assignments(previous), assignments(ds))
+			changes.From = baseVersion
+			report = &changes
+			log.Info("changes against the published asset", "version", version, "from", baseVersion, "added", len(changes.Added), "removed", len(changes.Removed), "changed", len(changes.Changed))
+		}
+	}
+
+	// the patch is a convenience for consumers, the full asset is published without it
+	var extra []releaseAsset
+	patch, err := patchAsset(data, base, baseVersion, version)
+	if err != nil {
+		log.Warn("could not build the patch against the previous version", "version", version, "error", err)
+	} else if patch != nil {
+		extra = append(extra, *patch)
+	}
+
+	dates := mergeProvenance(ds, version, cfg, sources)
+	provenance, err := buildProvenanceAsset(version, dates)
+	if err != nil {
+		return nil, err
+	}
+	enriched, err := buildEnrichedAsset(ds, version, dates)
+	if err != nil {
+		return nil, err
+	}
+	extra = append(extra, *provenance, *enriched)
+
+	// the dodumap list has no place for the license and attribution, they go next to it
+	if !ds.EmbedsMetadata() && !ds.Metadata.IsZero() {
+		metadata, err := almanax.EncodeMetadata(ds.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		extra = append(extra, releaseAsset{name: MappedAlmanaxMetadataFileName, label: "license and attribution of the mapped almanax", data: metadata})
+	}
+
+	// the flavor is a courtesy to bots, a publish does not fail on it
+	if cfg.FlavorAsset {
+		flavor, err := buildFlavorAsset(ds, version, cfg, pages, dates)
+		if err != nil {
+			log.Warn("could not build the flavor asset", "version", version, "error", err)
+		} else {
+			extra = append(extra, *flavor)
+		}
+	}
+
+	// without the published history the new one would lose the earlier days, so it is not replaced then
+	if cfg.History {
+		history, err := buildHistoryAsset(ds, version, cfg)
+		if err != nil {
+			log.Error("could not load the published history, not updating it", "version", version, "error", err)
+		} else {
+			extra = append(extra, *history)
+		}
+	}
+
+	if cfg.DiffAsset && report != nil {
+		diff, err := diffAsset(*report)
+		if err != nil {
+			return nil, err
+		}
+		extra = append(extra, *diff)
+	}
+
+	if cfg.ContentAddressed {
+		extra = append(extra, contentAddressedAssets(data)...)
+	}
+
+	return report, updateAlmanaxRelease(journal, data, version, cfg, extra...)
+}
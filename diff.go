@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/dofusdude/alm-dates/almanax"
+)
+
+const diffFileName = "last_diff.json"
+
+// dateChange is a date whose receiver changed, Before or After is empty for added and removed dates.
+type dateChange struct {
+	Date   string `json:"date"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// diffReport is what a publish changed in the date assignments of a release. From is the release whose
+// asset consumers had before, empty if the diff is against the assignments the run started from.
+type diffReport struct {
+	Version   string       `json:"version"`
+	From      string       `json:"from,omitempty"`
+	Published time.Time    `json:"published"`
+	Added     []dateChange `json:"added"`
+	Removed   []dateChange `json:"removed"`
+	Changed   []dateChange `json:"changed"`
+}
+
+// assignments maps every date of the dataset to its receiver name.
+func assignments(ds *almanax.Dataset) map[string]string {
+	dates := make(map[string]string)
+	for _, day := range ds.Days() {
+		dates[day.Date] = day.Receiver.Name
+	}
+	return dates
+}
+
+// diffAssignments compares the date assignments before and after a run, sorted by date.
+func diffAssignments(version string, before map[string]string, after map[string]string) diffReport {
+	report := diffReport{Version: version, Published: time.Now().UTC()}
+	for date, receiver := range after {
+		previous, ok := before[date]
+		switch {
+		case !ok:
+			report.Added = append(report.Added, dateChange{Date: date, After: receiver})
+		case previous != receiver:
+			report.Changed = append(report.Changed, dateChange{Date: date, Before: previous, After: receiver})
+		}
+	}
+	for date, receiver := range before {
+		if _, ok := after[date]; !ok {
+			report.Removed = append(report.Removed, dateChange{Date: date, Before: receiver})
+		}
+	}
+	for _, changes := range [][]dateChange{report.Added, report.Removed, report.Changed} {
+		sort.Slice(changes, func(a, b int) bool {
+			return changes[a].Date < changes[b].Date
+		})
+	}
+	return report
+}
+
+func saveDiffReport(workdir string, report diffReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(stateDir(workdir), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(stateDir(workdir), diffFileName), data, 0644)
+}
+
+// loadDiffReport returns the report of the last publish, nil if there was none.
+func loadDiffReport(workdir string) (*diffReport, error) {
+	data, err := os.ReadFile(filepath.Join(stateDir(workdir), diffFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var report diffReport
+	err = json.Unmarshal(data, &report)
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// diffAsset builds the diff release asset of a report.
+func diffAsset(report diffReport) (*releaseAsset, error) {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return &releaseAsset{name: MappedAlmanaxDiffFileName, label: fmt.Sprintf("date assignments changed from %s", report.From), data: data}, nil
+}
+
+// publish publishes the dataset and keeps what changed against the asset it replaced, or against the
+// assignments the run started from if that could not be read.
+func (p *pipeline) publish(ds *almanax.Dataset, version string, sources provenance, before map[string]string) error {
+	defer p.timePhase(phasePublish)()
+	err := p.scraper.limits.githubLeft(planGithubCalls(&p.cfg))
+	if err != nil {
+		return err
+	}
+	report, err := publishDataset(newPublishJournal(p.workdir), ds, version, &p.cfg, sources, pagesDir(p.workdir))
+	if err != nil {
+		return err
+	}
+	if report == nil {
+		changes := diffAssignments(version, before, assignments(ds))
+		report = &changes
+	}
+	p.log.Info("published", "version", version, "added", len(report.Added), "removed", len(report.Removed), "changed", len(report.Changed))
+	err = saveDiffReport(p.workdir, *report)
+	if err != nil {
+		p.log.Warn("error keeping diff report", "error", err)
+	}
+	return nil
+}
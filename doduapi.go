@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// doduapi item categories by dofus item category id
+var doduapiItemCategories = map[int]string{
+	0: "equipment",
+	1: "consumables",
+	2: "resources",
+	3: "quest_items",
+	5: "cosmetics",
+}
+
+type doduapiImageUrls struct {
+	Icon string `json:"icon"`
+	SD   string `json:"sd"`
+	HQ   string `json:"hq"`
+	HD   string `json:"hd"`
+}
+
+type doduapiItem struct {
+	AnkamaId  int              `json:"ankama_id"`
+	Name      string           `json:"name"`
+	ImageUrls doduapiImageUrls `json:"image_urls"`
+}
+
+const (
+	// doduapiTimeout bounds an item lookup, serve mode waits for it before answering a date page
+	doduapiTimeout = 5 * time.Second
+	// doduapiFailureTtl is how long a failed lookup is answered from the cache before doduapi is asked again
+	doduapiFailureTtl = time.Minute
+)
+
+// doduapiCacheEntry is a looked up item, or the error of a failed lookup until it expires.
+type doduapiCacheEntry struct {
+	item    doduapiItem
+	err     error
+	expires time.Time
+}
+
+var (
+	doduapiItemCache   = make(map[string]doduapiCacheEntry)
+	doduapiItemCacheMu sync.Mutex
+)
+
+func doduapiItemUrl(lang string, itemId int, categoryId int) (string, error) {
+	category, ok := doduapiItemCategories[categoryId]
+	if !ok {
+		return "", fmt.Errorf("unknown item category %d", categoryId)
+	}
+
+	return fmt.Sprintf("%s/%s/items/%s/%d", DoduapiUrl, lang, category, itemId), nil
+}
+
+// getDoduapiItem looks up an item on doduapi. Items are cached for the lifetime of the process, failures
+// for doduapiFailureTtl, so a doduapi that is down or slow is not asked on every call.
+func getDoduapiItem(lang string, itemId int, categoryId int) (doduapiItem, error) {
+	url, err := doduapiItemUrl(lang, itemId, categoryId)
+	if err != nil {
+		return doduapiItem{}, err
+	}
+
+	doduapiItemCacheMu.Lock()
+	entry, ok := doduapiItemCache[url]
+	doduapiItemCacheMu.Unlock()
+	if ok && (entry.err == nil || time.Now().Before(entry.expires)) {
+		return entry.item, entry.err
+	}
+
+	item, err := fetchDoduapiItem(url)
+	entry = doduapiCacheEntry{item: item, err: err}
+	if err != nil {
+		entry.expires = time.Now().Add(doduapiFailureTtl)
+	}
+	doduapiItemCacheMu.Lock()
+	doduapiItemCache[url] = entry
+	doduapiItemCacheMu.Unlock()
+
+	return item, err
+}
+
+func fetchDoduapiItem(url string) (doduapiItem, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), doduapiTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return doduapiItem{}, err
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return doduapiItem{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return doduapiItem{}, fmt.Errorf("doduapi status code error: %d %s", res.StatusCode, res.Status)
+	}
+
+	var item doduapiItem
+	err = json.NewDecoder(res.Body).Decode(&item)
+	return item, err
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// calendarEvent is an external game event like a double xp weekend. Events without servers apply to all servers.
+type calendarEvent struct {
+	Name    string   `json:"name"`
+	Start   string   `json:"start"` // yyyy-mm-dd
+	End     string   `json:"end"`   // yyyy-mm-dd, inclusive
+	Servers []string `json:"servers,omitempty"`
+}
+
+func (e calendarEvent) on(date string, server string) bool {
+	if date < e.Start || date > e.End {
+		return false
+	}
+	if server == "" || len(e.Servers) == 0 {
+		return true
+	}
+	for _, s := range e.Servers {
+		if strings.EqualFold(s, server) {
+			return true
+		}
+	}
+	return false
+}
+
+func eventsOn(events []calendarEvent, date string, server string) []calendarEvent {
+	var result []calendarEvent
+	for _, event := range events {
+		if event.on(date, server) {
+			result = append(result, event)
+		}
+	}
+	return result
+}
+
+// loadEventCalendar reads events from a local file or http(s) url, either as ics or as a json list of calendarEvent.
+func loadEventCalendar(source string) ([]calendarEvent, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		var res *http.Response
+		res, err = httpClient.Get(source)
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("status code error: %d %s", res.StatusCode, res.Status)
+		}
+		data, err = io.ReadAll(res.Body)
+	} else {
+		data, err = os.ReadFile(source)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var events []calendarEvent
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("BEGIN:VCALENDAR")) {
+		events, err = parseICS(data)
+	} else {
+		err = json.Unmarshal(data, &events)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i, event := range events {
+		if event.End == "" {
+			events[i].End = event.Start
+		}
+		if !isDate(events[i].Start) || !isDate(events[i].End) {
+			return nil, fmt.Errorf("event %q has an invalid date range", event.Name)
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Start < events[j].Start
+	})
+	return events, nil
+}
+
+// parseICSDate reads DATE and DATE-TIME values, only the day is kept.
+func parseICSDate(value string) (time.Time, error) {
+	if len(value) < 8 {
+		return time.Time{}, fmt.Errorf("invalid ics date %s", value)
+	}
+	return time.Parse("20060102", value[:8])
+}
+
+// parseICS extracts the VEVENTs of an ics calendar. CATEGORIES are used as server names.
+func parseICS(data []byte) ([]calendarEvent, error) {
+	// unfold continuation lines
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var events []calendarEvent
+	var current *calendarEvent
+	var exclusiveEnd bool
+	for _, line := range lines {
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		name, params, _ := strings.Cut(name, ";")
+
+		switch name {
+		case "BEGIN":
+			if value == "VEVENT" {
+				current = &calendarEvent{}
+				exclusiveEnd = false
+			}
+		case "END":
+			if value == "VEVENT" && current != nil {
+				if exclusiveEnd && current.End > current.Start {
+					// all-day events end on the following day
+					end, _ := time.Parse("2006-01-02", current.End)
+					current.End = end.AddDate(0, 0, -1).Format("2006-01-02")
+				}
+				events = append(events, *current)
+				current = nil
+			}
+		case "SUMMARY":
+			if current != nil {
+				current.Name = value
+			}
+		case "CATEGORIES":
+			if current != nil {
+				for _, server := range strings.Split(value, ",") {
+					current.Servers = append(current.Servers, strings.TrimSpace(server))
+				}
+			}
+		case "DTSTART", "DTEND":
+			if current == nil {
+				continue
+			}
+			date, err := parseICSDate(value)
+			if err != nil {
+				return nil, err
+			}
+			if name == "DTSTART" {
+				current.Start = date.Format("2006-01-02")
+			} else {
+				current.End = date.Format("2006-01-02")
+				exclusiveEnd = strings.Contains(params, "VALUE=DATE") && !strings.Contains(params, "VALUE=DATE-TIME")
+			}
+		}
+	}
+
+	return events, nil
+}
+
+type eventDay struct {
+	Date      string          `json:"date"`
+	BonusType string          `json:"bonus_type"`
+	Bonus     string          `json:"bonus"`
+	Events    []calendarEvent `json:"events"`
+}
+
+// eventDays lists the mapped dates in the range that coincide with at least one event.
+func (s *almanaxStore) eventDays(fromDate string, toDate string, server string, lang string) ([]eventDay, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	dates, err := createDateRange(fromDate, toDate)
+	if err != nil {
+		return nil, err
+	}
+	days := []eventDay{}
+	for _, date := range dates {
+		events := eventsOn(s.events, date, server)
+		if len(events) == 0 {
+			continue
+		}
+
+		day := eventDay{Date: date, Events: events}
+		if alm, ok := s.days.Day(date); ok {
+			day.BonusType = alm.Bonus.Type[lang]
+			day.Bonus = alm.Bonus.Description[lang]
+		}
+		days = append(days, day)
+	}
+	return days, nil
+}
+
+func (s *server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from, to, err := parseDateRangeQuery(query.Get("from"), query.Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	days, err := s.store.eventDays(from, to, query.Get("server"), normalizeLang(query.Get("lang")))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(days)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"golang.org/x/exp/rand"
+)
+
+// faultConfig is the parsed faults setting. Probabilities are between 0 and 1.
+type faultConfig struct {
+	// scrape_error fails a krosmoz request with a network error
+	ScrapeError float64
+	// slow delays any request by slow_delay
+	Slow      float64
+	SlowDelay time.Duration
+	// github_5xx answers a github api or upload request with 503
+	Github5xx float64
+	// drop_notify loses the doduapi update notification
+	DropNotify float64
+}
+
+// parseFaults reads key=value pairs separated by commas, like "scrape_error=0.2,slow=0.1,slow_delay=10s".
+func parseFaults(raw string) (faultConfig, error) {
+	faults := faultConfig{SlowDelay: 5 * time.Second}
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return faults, fmt.Errorf("expected key=value, got %q", pair)
+		}
+
+		if key == "slow_delay" {
+			delay, err := ParseDuration(value)
+			if err != nil {
+				return faults, fmt.Errorf("%s: %w", key, err)
+			}
+			faults.SlowDelay = delay
+			continue
+		}
+
+		var target *float64
+		switch key {
+		case "scrape_error":
+			target = &faults.ScrapeError
+		case "slow":
+			target = &faults.Slow
+		case "github_5xx":
+			target = &faults.Github5xx
+		case "drop_notify":
+			target = &faults.DropNotify
+		default:
+			return faults, fmt.Errorf("unknown fault %q", key)
+		}
+		p, err := strconv.ParseFloat(value, 64)
+		if err != nil || p < 0 || p > 1 {
+			return faults, fmt.Errorf("%s: expected a probability between 0 and 1, got %q", key, value)
+		}
+		*target = p
+	}
+	return faults, nil
+}
+
+var errInjected = errors.New("injected fault")
+
+// faultTransport fails requests on purpose, so retries, fallbacks and alerts can be seen working in
+// integration tests and staging.
+type faultTransport struct {
+	faults faultConfig
+	next   http.RoundTripper
+}
+
+func (t *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if rand.Float64() < t.faults.Slow {
+		log.Debug("injecting slow response", "url", redactUrl(req.URL), "delay", FormatDuration(t.faults.SlowDelay))
+		select {
+		case <-time.After(t.faults.SlowDelay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+
+	host := req.URL.Hostname()
+	switch {
+	case "https://"+host == KrosmozUrl && rand.Float64() < t.faults.ScrapeError:
+		log.Debug("injecting scrape error", "url", redactUrl(req.URL))
+		return nil, fmt.Errorf("%w: scrape of %s failed", errInjected, req.URL.Path)
+	case (host == "api.github.com" || host == "uploads.github.com") && rand.Float64() < t.faults.Github5xx:
+		log.Debug("injecting github 503", "url", redactUrl(req.URL))
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return &http.Response{
+			Status:     "503 Service Unavailable",
+			StatusCode: http.StatusServiceUnavailable,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{"Content-Type": {"text/plain"}},
+			Body:       io.NopCloser(bytes.NewReader([]byte("injected fault"))),
+			Request:    req,
+		}, nil
+	case "https://"+host == DoduapiBaseUrl && req.Method == http.MethodPost && strings.Contains(req.URL.Path, "/update/") && rand.Float64() < t.faults.DropNotify:
+		log.Debug("injecting dropped notification", "url", redactUrl(req.URL))
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, fmt.Errorf("%w: notification dropped", errInjected)
+	}
+
+	return t.next.RoundTrip(req)
+}
+
+// redactUrl drops the path after /update/, which carries the doduapi token.
+func redactUrl(u *url.URL) string {
+	if before, _, ok := strings.Cut(u.Path, "/update/"); ok {
+		return u.Scheme + "://" + u.Host + before + "/update/****"
+	}
+	return u.String()
+}
+
+// installFaults wraps the transport of the shared client, which every krosmoz, github and doduapi request
+// goes through.
+func installFaults(cfg *Config) error {
+	if cfg.Faults == "" {
+		return nil
+	}
+	faults, err := parseFaults(cfg.Faults)
+	if err != nil {
+		return err
+	}
+	log.Warn("fault injection is enabled, requests fail on purpose", "faults", cfg.Faults)
+	wrapHttpTransport(func(next http.RoundTripper) http.RoundTripper {
+		return &faultTransport{faults: faults, next: next}
+	})
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/charmbracelet/log"
+	"github.com/dofusdude/alm-dates/almanax"
+)
+
+// The blocks of the protector of the month, the meridian of the day and its quote on a krosmoz almanax page.
+const (
+	protectorSelector = "#almanax_boss_desc .title"
+	meridianSelector  = "#almanax_meryde .title"
+	quoteSelector     = "#almanax_meryde .description"
+)
+
+// dayFlavor is the text of an almanax page around the offering, empty fields were not on the page.
+type dayFlavor struct {
+	Lang      string `json:"lang"`
+	Protector string `json:"protector,omitempty"`
+	Meridian  string `json:"meridian,omitempty"`
+	Quote     string `json:"quote,omitempty"`
+}
+
+func (f dayFlavor) empty() bool {
+	return f.Protector == "" && f.Meridian == "" && f.Quote == ""
+}
+
+// flavorAsset is published next to the mapped almanax with flavor_asset.
+type flavorAsset struct {
+	Version string               `json:"version"`
+	Dates   map[string]dayFlavor `json:"dates"`
+}
+
+// extractDayFlavor reads the protector, meridian and quote of an almanax page.
+func extractDayFlavor(doc *goquery.Document, lang string) dayFlavor {
+	return dayFlavor{
+		Lang:      lang,
+		Protector: withoutLabel(blockText(doc.Selection, protectorSelector)),
+		Meridian:  withoutLabel(blockText(doc.Selection, meridianSelector)),
+		Quote:     strings.Trim(blockText(doc.Selection, quoteSelector), `"“”«» `),
+	}
+}
+
+// withoutLabel drops a leading label like "Protector of the month:" from a title.
+func withoutLabel(text string) string {
+	if label, value, ok := strings.Cut(text, ":"); ok && len(label) < 40 {
+		return strings.TrimSpace(value)
+	}
+	return text
+}
+
+// previousFlavor returns the flavor already published with a release, empty if there is none.
+func previousFlavor(repo dataRepo, version string) (map[string]dayFlavor, error) {
+	data, _, err := downloadReleaseAsset(repo, version, MappedAlmanaxFlavorFileName)
+	if errors.Is(err, errAssetNotFound) {
+		return map[string]dayFlavor{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var asset flavorAsset
+	err = json.Unmarshal(data, &asset)
+	if err != nil {
+		return nil, err
+	}
+	if asset.Dates == nil {
+		asset.Dates = map[string]dayFlavor{}
+	}
+	return asset.Dates, nil
+}
+
+// buildFlavorAsset reads the flavor of the mapped dates from their kept pages, in the language they were
+// mapped from, on top of the published one. Dates without a kept day page, like month view or inferred
+// dates, keep their published flavor or are left out.
+func buildFlavorAsset(ds *almanax.Dataset, version string, cfg *Config, pages string, dates provenance) (*releaseAsset, error) {
+	previous, err := previousFlavor(cfg.dataRepo(), version)
+	if err != nil {
+		log.Warn("could not load the published flavor, only the kept pages are read", "version", version, "error", err)
+		previous = map[string]dayFlavor{}
+	}
+
+	flavor := make(map[string]dayFlavor)
+	for _, day := range ds.Days() {
+		if entry, ok := dates[day.Date]; ok && entry.Lang != "" && pages != "" {
+			html, err := loadPage(pages, entry.Lang, day.Date)
+			if err != nil {
+				return nil, err
+			}
+			if html != nil {
+				doc, err := goquery.NewDocumentFromReader(bytes.NewReader(html))
+				if err != nil {
+					return nil, err
+				}
+				if f := extractDayFlavor(doc, entry.Lang); !f.empty() {
+					flavor[day.Date] = f
+					continue
+				}
+			}
+		}
+		if f, ok := previous[day.Date]; ok {
+			flavor[day.Date] = f
+		}
+	}
+
+	data, err := json.MarshalIndent(flavorAsset{Version: version, Dates: flavor}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return &releaseAsset{name: MappedAlmanaxFlavorFileName, label: "protector, meridian and quote of the almanax days", data: data}, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// daysRemaining counts the mapped days after today until the last mapped date.
+func daysRemaining(today time.Time, to string) int {
+	last, err := time.Parse("2006-01-02", to)
+	if err != nil {
+		return 0
+	}
+
+	start := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+	days := int(last.Sub(start).Hours() / 24)
+	return max(days, 0)
+}
+
+type freshness struct {
+	Version       string    `json:"version"`
+	GeneratedAt   time.Time `json:"generated_at"`
+	From          string    `json:"from"`
+	To            string    `json:"to"`
+	DaysRemaining int       `json:"days_remaining"`
+	// Remaining is days_remaining as a duration like "1M2w".
+	Remaining string           `json:"remaining"`
+	Metadata  metadataResponse `json:"metadata"`
+	// Stale is set while the latest release can not be looked up, Checked is when it last could
+	Stale   bool      `json:"stale"`
+	Checked time.Time `json:"checked"`
+}
+
+type metadataResponse struct {
+	License     string   `json:"license"`
+	Attribution string   `json:"attribution"`
+	Sources     []string `json:"sources"`
+}
+
+func (s *almanaxStore) freshness(today time.Time) freshness {
+	metadata := s.metadata()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	from, to := s.days.Coverage()
+	remaining := daysRemaining(today, to)
+	return freshness{
+		Version:       s.version,
+		GeneratedAt:   s.generatedAt,
+		From:          from,
+		To:            to,
+		DaysRemaining: remaining,
+		Remaining:     FormatDuration(time.Duration(remaining) * 24 * time.Hour),
+		Metadata: metadataResponse{
+			License:     metadata.License,
+			Attribution: metadata.Attribution,
+			Sources:     metadata.Sources,
+		},
+		Stale:   s.stale,
+		Checked: s.checked,
+	}
+}
+
+// handleFreshness lets monitoring alert before the mapped horizon runs out.
+func (s *server) handleFreshness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.store.freshness(time.Now()))
+}
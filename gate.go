@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dofusdude/alm-dates/almanax"
+)
+
+// sanityError is an asset the sanity gate kept from being uploaded, with everything that looked wrong.
+type sanityError struct {
+	Problems []string
+}
+
+func (e sanityError) Error() string {
+	return "sanity gate blocked the upload: " + strings.Join(e.Problems, "; ")
+}
+
+// sanityGate checks the asset about to be uploaded against the one consumers have now, base, which is nil
+// if there is none. It blocks assets with fewer upcoming dates than sanity_min_dates or nine tenths of what
+// base has, receivers without name or offering, receivers missing an item name language base has and a size that changed more
+// than sanity_max_size_change percent.
+func sanityGate(ds *almanax.Dataset, data []byte, base []byte, cfg *Config) error {
+	var problems []string
+	today := time.Now().In(cfg.location()).Format("2006-01-02")
+
+	var previous *almanax.Dataset
+	if base != nil {
+		var err error
+		previous, err = almanax.Decode(base)
+		if err != nil {
+			// a broken base is what the new asset replaces, it is no measure
+			previous = nil
+		}
+	}
+
+	upcoming := len(almanax.NewIndex(ds).Range(today, ""))
+	expected := cfg.SanityMinDates
+	if previous != nil {
+		// a few dates may be skipped by an override or left for review
+		expected = max(expected, len(almanax.NewIndex(previous).Range(today, ""))*9/10)
+	}
+	if upcoming < expected {
+		problems = append(problems, fmt.Sprintf("%d dates from today on, expected at least %d", upcoming, expected))
+	}
+
+	languages := []string{"en"}
+	if previous != nil {
+		languages = itemNameLanguages(previous)
+	}
+	var empty, untranslated []string
+	for i, receiver := range ds.Receivers {
+		if receiver.Name == "" || receiver.Offering.ItemId == 0 {
+			empty = append(empty, fmt.Sprintf("#%d %q", i, receiver.Name))
+			continue
+		}
+		for _, lang := range languages {
+			if receiver.Offering.ItemName[lang] == "" {
+				untranslated = append(untranslated, fmt.Sprintf("%s (%s)", receiver.Name, lang))
+			}
+		}
+	}
+	if len(empty) > 0 {
+		problems = append(problems, fmt.Sprintf("%d receivers without name or offering: %s", len(empty), shortList(empty)))
+	}
+	if len(untranslated) > 0 {
+		problems = append(problems, fmt.Sprintf("%d item names missing a language: %s", len(untranslated), shortList(untranslated)))
+	}
+
+	if base != nil && cfg.SanityMaxSizeChange > 0 {
+		change := math.Abs(float64(len(data)-len(base))) / float64(len(base)) * 100
+		if change > cfg.SanityMaxSizeChange {
+			problems = append(problems, fmt.Sprintf("size changed by %.0f%% from %d to %d bytes", change, len(base), len(data)))
+		}
+	}
+
+	if len(problems) > 0 {
+		return sanityError{Problems: problems}
+	}
+	return nil
+}
+
+// itemNameLanguages returns the languages every receiver of a dataset has an item name in.
+func itemNameLanguages(ds *almanax.Dataset) []string {
+	var languages []string
+	for i, receiver := range ds.Receivers {
+		var have []string
+		for lang, name := range receiver.Offering.ItemName {
+			if name != "" && (i == 0 || slices.Contains(languages, lang)) {
+				have = append(have, lang)
+			}
+		}
+		languages = have
+	}
+	slices.Sort(languages)
+	return languages
+}
+
+// shortList joins the first ten values and says how many more there are.
+func shortList(values []string) string {
+	if len(values) <= 10 {
+		return strings.Join(values, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(values[:10], ", "), len(values)-10)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/google/go-github/v67/github"
+)
+
+// githubCredentials are the github tokens of a pipeline. The secondary token takes over once github
+// rejects the primary one, until the process restarts, or while the primary one is rate limited.
+type githubCredentials struct {
+	mu        sync.Mutex
+	primary   string
+	secondary string
+	// failedOver is set while the secondary token is used
+	failedOver bool
+	// back is when a rate limited primary token is used again, zero after it was rejected
+	back time.Time
+	// alert is told about a failover, it is set by the pipeline for its dashboard
+	alert func(message string, err error)
+}
+
+var (
+	githubCredentialsMu sync.Mutex
+	// githubCredentialsByToken shares the failover state between the clients of a config
+	githubCredentialsByToken = make(map[string]*githubCredentials)
+)
+
+// credentialsFor returns the credentials of a config, the same for every call with the same tokens.
+func credentialsFor(cfg *Config) *githubCredentials {
+	githubCredentialsMu.Lock()
+	defer githubCredentialsMu.Unlock()
+	key := cfg.GhAuthKey + "\n" + cfg.GhAuthKeySecondary
+	creds, ok := githubCredentialsByToken[key]
+	if !ok {
+		creds = &githubCredentials{primary: cfg.GhAuthKey, secondary: cfg.GhAuthKeySecondary}
+		githubCredentialsByToken[key] = creds
+	}
+	return creds
+}
+
+// onFailover sets the alert raised when the secondary token takes over.
+func (c *githubCredentials) onFailover(alert func(message string, err error)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.alert = alert
+}
+
+// token returns the token requests are sent with.
+func (c *githubCredentials) token() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failedOver && !c.back.IsZero() && time.Now().After(c.back) {
+		c.failedOver = false
+		c.back = time.Time{}
+		log.Info("github rate limit of the primary token reset, using it again")
+	}
+	if c.failedOver {
+		return c.secondary
+	}
+	return c.primary
+}
+
+// failover switches to the secondary token after github rejected token, and reports whether a request
+// should be sent again with the secondary one.
+func (c *githubCredentials) failover(token string, reason string, back time.Time) bool {
+	c.mu.Lock()
+	if c.secondary == "" || token != c.primary {
+		c.mu.Unlock()
+		return false
+	}
+	switched := !c.failedOver
+	c.failedOver = true
+	c.back = back
+	alert := c.alert
+	c.mu.Unlock()
+
+	if switched {
+		log.Warn("github "+reason+" the primary token, failing over to the secondary token", "until", back)
+		if alert != nil {
+			alert("github "+reason+" the primary token, publishing with the secondary token", nil)
+		}
+	}
+	return true
+}
+
+// githubAuthTransport authenticates github requests with the current token of the credentials.
+type githubAuthTransport struct {
+	creds *githubCredentials
+	next  http.RoundTripper
+}
+
+func (t *githubAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token := t.creds.token()
+	res, err := t.send(req, token)
+	if err != nil {
+		return nil, err
+	}
+
+	reason, back := githubRejection(res)
+	if reason == "" || !t.creds.failover(token, reason, back) {
+		return res, nil
+	}
+	// a body that can not be read again fails this time, the next try uses the secondary token
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return res, nil
+	}
+	res.Body.Close()
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		retry.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t.send(retry, t.creds.token())
+}
+
+func (t *githubAuthTransport) send(req *http.Request, token string) (*http.Response, error) {
+	authed := req.Clone(req.Context())
+	if token != "" {
+		authed.Header.Set("Authorization", "Bearer "+token)
+	}
+	return t.next.RoundTrip(authed)
+}
+
+// githubRejection tells why github did not accept the token of an answer, empty if it did. A rate limited
+// token can be used again at the returned time.
+func githubRejection(res *http.Response) (string, time.Time) {
+	switch {
+	case res.StatusCode == http.StatusUnauthorized:
+		return "rejected", time.Time{}
+	case (res.StatusCode == http.StatusForbidden || res.StatusCode == http.StatusTooManyRequests) && res.Header.Get("X-RateLimit-Remaining") == "0":
+		reset, err := strconv.ParseInt(res.Header.Get("X-RateLimit-Reset"), 10, 64)
+		if err != nil {
+			return "rate limited", time.Now().Add(time.Hour)
+		}
+		return "rate limited", time.Unix(reset, 0)
+	}
+	return "", time.Time{}
+}
+
+// githubClient returns an authenticated github client of the config on top of an http client.
+func githubClient(hc *http.Client, cfg *Config) *github.Client {
+	return github.NewClient(&http.Client{Transport: &githubAuthTransport{creds: credentialsFor(cfg), next: hc.Transport}})
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// githubTokenQuota is the rate limit state of one github token, shared by all lanes that use it. A lane is
+// the data repo a request is for, so tenants publishing to different repos with the same token are
+// separate lanes.
+type githubTokenQuota struct {
+	limit     int
+	remaining int
+	reset     time.Time
+	// used are the requests of each lane since the last reset
+	used map[string]int
+	// lanes are the lanes configured for the token, more show up with their first request
+	lanes map[string]bool
+	// nextWrite is when the secondary limits allow the next mutating request
+	nextWrite time.Time
+}
+
+// githubQuotaBudget schedules github api requests per token so they stay within the primary rate limit,
+// keeping reserve requests for the reset window, and the secondary limits by spacing mutating requests.
+// Once the quota runs low, a lane that used more than its share of the window waits for the reset, so one
+// busy lane can not starve the others sharing the token.
+type githubQuotaBudget struct {
+	mu            sync.Mutex
+	reserve       int
+	writeInterval time.Duration
+	tokens        map[string]*githubTokenQuota
+}
+
+func newGithubQuotaBudget(reserve int, writeInterval time.Duration) *githubQuotaBudget {
+	return &githubQuotaBudget{reserve: reserve, writeInterval: writeInterval, tokens: make(map[string]*githubTokenQuota)}
+}
+
+// githubQuota is shared by all github requests across pipelines, it is set from the config on startup.
+var githubQuota = newGithubQuotaBudget(50, time.Second)
+
+// tokenKey identifies a token without keeping it, unauthenticated requests share the empty key.
+func tokenKey(authorization string) string {
+	if authorization == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(authorization))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (b *githubQuotaBudget) token(key string) *githubTokenQuota {
+	q, ok := b.tokens[key]
+	if !ok {
+		q = &githubTokenQuota{used: make(map[string]int), lanes: make(map[string]bool)}
+		b.tokens[key] = q
+	}
+	return q
+}
+
+// register announces a lane of a token before its first request, so its share is kept from the start.
+func (b *githubQuotaBudget) register(token string, lane string) {
+	if token == "" {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.token(tokenKey("Bearer " + token)).lanes[lane] = true
+}
+
+// delay returns how long a request of the lane has to wait and takes its place in the budget if it does not.
+func (b *githubQuotaBudget) delay(key string, lane string, write bool) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q := b.token(key)
+	now := time.Now()
+	if !q.reset.IsZero() && now.After(q.reset) {
+		// a new window, the quota is full again until github says otherwise
+		q.remaining = q.limit
+		q.reset = time.Time{}
+		clear(q.used)
+	}
+	q.lanes[lane] = true
+
+	if q.limit > 0 && !q.reset.IsZero() {
+		if q.remaining <= b.reserve {
+			return time.Until(q.reset)
+		}
+		// the share of a lane is the quota of the window above the reserve split between the lanes
+		share := (q.limit - b.reserve) / len(q.lanes)
+		low := q.remaining-b.reserve < q.limit/4
+		if len(q.lanes) > 1 && low && q.used[lane] >= share {
+			return time.Until(q.reset)
+		}
+	}
+	if write {
+		if wait := time.Until(q.nextWrite); wait > 0 {
+			return wait
+		}
+		q.nextWrite = now.Add(b.writeInterval)
+	}
+
+	q.used[lane]++
+	if q.remaining > 0 {
+		q.remaining--
+	}
+	return 0
+}
+
+// update takes the quota github reports with every answer.
+func (b *githubQuotaBudget) update(key string, header http.Header) {
+	limit, err := strconv.Atoi(header.Get("X-RateLimit-Limit"))
+	if err != nil {
+		return
+	}
+	remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q := b.token(key)
+	q.limit = limit
+	q.remaining = remaining
+	q.reset = time.Unix(reset, 0)
+}
+
+// githubLane is the owner/name of the repo of a github api request, empty for requests not about a repo.
+func githubLane(req *http.Request) string {
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/"), "/")
+	if len(parts) < 3 || parts[0] != "repos" {
+		return ""
+	}
+	return parts[1] + "/" + parts[2]
+}
+
+// githubQuotaTransport waits for the github quota budget before api and upload requests.
+type githubQuotaTransport struct {
+	next http.RoundTripper
+}
+
+func (t *githubQuotaTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != "api.github.com" && req.URL.Host != "uploads.github.com" {
+		return t.next.RoundTrip(req)
+	}
+
+	key := tokenKey(req.Header.Get("Authorization"))
+	lane := githubLane(req)
+	write := req.Method != http.MethodGet && req.Method != http.MethodHead
+	for {
+		wait := githubQuota.delay(key, lane, write)
+		if wait <= 0 {
+			break
+		}
+		if wait > time.Second {
+			log.Info("waiting for the github quota", "lane", lane, "for", FormatDuration(wait.Round(time.Second)))
+		}
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+
+	githubCalls.add(lane)
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	githubQuota.update(key, res.Header)
+	return res, nil
+}
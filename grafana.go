@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/log"
+)
+
+// grafanaDatasource is the input grafana asks for when the dashboard is imported.
+const grafanaDatasource = "${DS_PROMETHEUS}"
+
+// grafanaPanelsPerRow is how many panels fit side by side, each panel is 8 of the 24 grid columns wide.
+const grafanaPanelsPerRow = 3
+
+func dashboardCommand(args []string) {
+	if len(args) == 0 {
+		log.Fatal("missing dashboard subcommand", "available", "export")
+	}
+
+	switch args[0] {
+	case "export":
+		dashboardExportCommand(args[1:])
+	default:
+		log.Fatal("unknown dashboard subcommand", "command", args[0])
+	}
+}
+
+// dashboardExportCommand writes a grafana dashboard for the metrics of GET /metrics, ready to import.
+func dashboardExportCommand(args []string) {
+	flags := flag.NewFlagSet("dashboard export", flag.ExitOnError)
+	title := flags.String("title", "alm-dates", "dashboard title")
+	datasource := flags.String("datasource", "", "uid of the prometheus datasource, asked for on import when empty")
+	out := flags.String("out", "-", "dashboard file, - for stdout")
+	err := flags.Parse(args)
+	if err != nil {
+		log.Fatal("error parsing flags", "error", err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		file, err := os.Create(*out)
+		if err != nil {
+			log.Fatal("error creating dashboard file", "error", err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(grafanaDashboard(*title, *datasource))
+	if err != nil {
+		log.Fatal("error writing dashboard", "error", err)
+	}
+	if *out != "-" {
+		log.Info("dashboard exported", "out", *out)
+	}
+}
+
+// grafanaDashboard builds a dashboard with one panel per metric of metricDefs. The game and tenant variables
+// filter every metric that has those labels.
+func grafanaDashboard(title string, datasource string) map[string]any {
+	uid := datasource
+	if uid == "" {
+		uid = grafanaDatasource
+	}
+	ds := map[string]any{"type": "prometheus", "uid": uid}
+
+	panels := make([]any, 0, len(metricDefs))
+	for i, def := range metricDefs {
+		panels = append(panels, map[string]any{
+			"id":          i + 1,
+			"type":        "timeseries",
+			"title":       def.name,
+			"description": def.help,
+			"datasource":  ds,
+			"gridPos":     map[string]any{"x": (i % grafanaPanelsPerRow) * 8, "y": (i / grafanaPanelsPerRow) * 8, "w": 8, "h": 8},
+			"fieldConfig": map[string]any{"defaults": map[string]any{"unit": grafanaUnit(def)}, "overrides": []any{}},
+			"targets": []any{map[string]any{
+				"refId":        "A",
+				"datasource":   ds,
+				"expr":         grafanaExpr(def),
+				"legendFormat": grafanaLegend(def),
+			}},
+		})
+	}
+
+	variable := func(name string, metric string) map[string]any {
+		return map[string]any{
+			"name":       name,
+			"type":       "query",
+			"datasource": ds,
+			"query":      fmt.Sprintf("label_values(%s, %s)", metric, name),
+			"refresh":    2,
+			"multi":      true,
+			"includeAll": true,
+			"current":    map[string]any{"text": "All", "value": "$__all"},
+		}
+	}
+
+	dashboard := map[string]any{
+		"title":         title,
+		"uid":           "alm-dates",
+		"tags":          []string{"alm-dates"},
+		"timezone":      "utc",
+		"schemaVersion": 39,
+		"refresh":       "1m",
+		"time":          map[string]any{"from": "now-7d", "to": "now"},
+		"panels":        panels,
+		"templating": map[string]any{"list": []any{
+			variable("game", metricJobs.name),
+			variable("tenant", metricJobs.name),
+		}},
+	}
+	if datasource == "" {
+		dashboard["__inputs"] = []any{map[string]any{
+			"name":     "DS_PROMETHEUS",
+			"label":    "Prometheus",
+			"type":     "datasource",
+			"pluginId": "prometheus",
+		}}
+	}
+	return dashboard
+}
+
+// grafanaExpr is the query of a metric panel: the rate of counters, the average of summaries and gauges
+// as they are, summed by the labels that tell the series apart.
+func grafanaExpr(def metricDef) string {
+	var filters []string
+	for _, label := range []string{"game", "tenant"} {
+		if slices.Contains(def.labels, label) {
+			filters = append(filters, fmt.Sprintf("%s=~\"$%s\"", label, label))
+		}
+	}
+	selector := "{" + strings.Join(filters, ",") + "}"
+	by := strings.Join(def.labels, ", ")
+
+	switch def.kind {
+	case metricCounter:
+		return fmt.Sprintf("sum by (%s) (rate(%s%s[$__rate_interval]))", by, def.name, selector)
+	case metricSummary:
+		return fmt.Sprintf("sum by (%s) (rate(%s_sum%s[$__rate_interval])) / sum by (%s) (rate(%s_count%s[$__rate_interval]))",
+			by, def.name, selector, by, def.name, selector)
+	default:
+		return fmt.Sprintf("sum by (%s) (%s%s)", by, def.name, selector)
+	}
+}
+
+func grafanaLegend(def metricDef) string {
+	parts := make([]string, len(def.labels))
+	for i, label := range def.labels {
+		parts[i] = "{{" + label + "}}"
+	}
+	return strings.Join(parts, " ")
+}
+
+func grafanaUnit(def metricDef) string {
+	switch {
+	case strings.HasSuffix(def.name, "_timestamp_seconds"):
+		return "dateTimeAsIso"
+	case strings.HasSuffix(def.name, "_seconds"):
+		return "s"
+	case def.kind == metricCounter:
+		return "ops"
+	default:
+		return "short"
+	}
+}
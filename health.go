@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/charmbracelet/log"
+)
+
+// daemonHealth is the state the kubernetes probes and hooks see of the daemon.
+type daemonHealth struct {
+	// publishMu is held from deleting the old release asset until the new one is uploaded.
+	publishMu  sync.Mutex
+	publishing atomic.Bool
+	draining   atomic.Bool
+	lease      *leaseElector
+	// degraded are the tenants that stopped working, by tenant name with the reason. The other tenants
+	// of the process keep running.
+	degradedMu sync.Mutex
+	degraded   map[string]string
+}
+
+var health = &daemonHealth{}
+
+// errLeaseLost stops a write to a release by a replica that no longer holds the lease.
+var errLeaseLost = errors.New("lease lost, another replica publishes")
+
+// fence re-reads the lease right before a write to a release. A replica that lost it without noticing yet,
+// like after a long pause, must not delete or upload assets the leader is writing.
+func (h *daemonHealth) fence() error {
+	if h.lease == nil {
+		return nil
+	}
+	held, err := h.lease.holds()
+	if err != nil {
+		return fmt.Errorf("checking the lease before writing the release: %w", err)
+	}
+	if !held {
+		return errLeaseLost
+	}
+	return nil
+}
+
+func (h *daemonHealth) beginPublish() {
+	h.publishMu.Lock()
+	h.publishing.Store(true)
+}
+
+func (h *daemonHealth) endPublish() {
+	h.publishing.Store(false)
+	h.publishMu.Unlock()
+}
+
+// canStartUpdate is false while shutting down or while another replica holds the lease.
+func (h *daemonHealth) canStartUpdate() bool {
+	if h.draining.Load() {
+		return false
+	}
+	return h.lease == nil || h.lease.isLeader()
+}
+
+// drain stops new updates, waits until a running publish left the swap window and writes the checkpoints
+// of running mappings, so the next replica continues them.
+func (h *daemonHealth) drain() {
+	if h.draining.Swap(true) {
+		return
+	}
+
+	log.Info("draining, waiting for running publish")
+	h.publishMu.Lock()
+	defer h.publishMu.Unlock()
+	flushCheckpoints()
+
+	if h.lease != nil {
+		h.lease.release()
+	}
+}
+
+// degrade marks a tenant as not working, the empty name is the only pipeline without tenants.
+func (h *daemonHealth) degrade(tenant string, reason string) {
+	h.degradedMu.Lock()
+	defer h.degradedMu.Unlock()
+	if h.degraded == nil {
+		h.degraded = make(map[string]string)
+	}
+	h.degraded[tenant] = reason
+}
+
+func (h *daemonHealth) recover(tenant string) {
+	h.degradedMu.Lock()
+	defer h.degradedMu.Unlock()
+	delete(h.degraded, tenant)
+}
+
+// degradedReason is why a tenant is degraded, empty if it works.
+func (h *daemonHealth) degradedReason(tenant string) string {
+	h.degradedMu.Lock()
+	defer h.degradedMu.Unlock()
+	return h.degraded[tenant]
+}
+
+type healthResponse struct {
+	Ready      bool `json:"ready"`
+	Publishing bool `json:"publishing"`
+	Draining   bool `json:"draining"`
+	Leader     bool `json:"leader"`
+	// Degraded are the tenants that stopped working with the reason, the daemon stays up for the others
+	Degraded map[string]string `json:"degraded,omitempty"`
+}
+
+func (h *daemonHealth) response() healthResponse {
+	res := healthResponse{
+		Publishing: h.publishing.Load(),
+		Draining:   h.draining.Load(),
+		Leader:     h.lease == nil || h.lease.isLeader(),
+	}
+	h.degradedMu.Lock()
+	if len(h.degraded) > 0 {
+		res.Degraded = maps.Clone(h.degraded)
+	}
+	h.degradedMu.Unlock()
+	res.Ready = !res.Publishing && !res.Draining
+	return res
+}
+
+func (h *daemonHealth) handleLive(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(h.response())
+}
+
+// handleReady fails while a publish is in the swap window so rolling updates wait for it.
+func (h *daemonHealth) handleReady(w http.ResponseWriter, r *http.Request) {
+	res := h.response()
+	w.Header().Set("Content-Type", "application/json")
+	if !res.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// handlePreStop is meant for the kubernetes preStop hook and returns once the daemon can be stopped safely.
+// Draining can not be undone, so only requests from inside the pod are accepted.
+func (h *daemonHealth) handlePreStop(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+		http.Error(w, "prestop is only accepted from localhost", http.StatusForbidden)
+		return
+	}
+
+	h.drain()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(h.response())
+}
+
+func (h *daemonHealth) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", h.handleLive)
+	mux.HandleFunc("GET /readyz", h.handleReady)
+	mux.HandleFunc("POST /prestop", h.handlePreStop)
+	mux.HandleFunc("GET /metrics", metrics.handleMetrics)
+	return mux
+}
+
+// serveHealth runs the health endpoints. If they stop the pipelines keep running, only the probes fail.
+func serveHealth(addr string) {
+	log.Info("health endpoints listening", "addr", addr)
+	err := http.ListenAndServe(addr, health.routes())
+	if err != nil {
+		log.Error("health server stopped, the pipelines keep running", "addr", addr, "error", err)
+	}
+}
+
+// prestopCommand drains the daemon running in the same pod, for an exec preStop hook. It returns once the
+// daemon can be stopped safely.
+func prestopCommand(args []string) {
+	flags := flag.NewFlagSet("prestop", flag.ExitOnError)
+	cfg, _, err := loadConfig(flags, args)
+	if err != nil {
+		log.Fatal("error loading config", "error", err)
+	}
+	if cfg.HealthAddr == "" {
+		log.Fatal("prestop needs health_addr")
+	}
+
+	host, port, err := net.SplitHostPort(cfg.HealthAddr)
+	if err != nil {
+		log.Fatal("error parsing health_addr", "error", err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		host = "127.0.0.1"
+	}
+
+	// no timeout, a running publish is waited for and kubernetes ends the hook after the grace period
+	res, err := http.Post("http://"+net.JoinHostPort(host, port)+"/prestop", "", nil)
+	if err != nil {
+		log.Fatal("error draining the daemon", "error", err)
+	}
+	defer res.Body.Close()
+
+	_, _ = io.Copy(os.Stdout, res.Body)
+	if res.StatusCode != http.StatusOK {
+		log.Fatal("daemon did not drain", "status", res.StatusCode)
+	}
+}
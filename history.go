@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/charmbracelet/log"
+	"github.com/dofusdude/alm-dates/almanax"
+	"github.com/google/go-github/v67/github"
+)
+
+// historyDay is a mapped almanax day in the cumulative history, with the game version it was mapped for.
+type historyDay struct {
+	Date      string            `json:"date"`
+	Version   string            `json:"version"`
+	Receiver  string            `json:"receiver"`
+	ItemId    int               `json:"item_id"`
+	ItemName  map[string]string `json:"item_name"`
+	Quantity  int               `json:"quantity"`
+	BonusType map[string]string `json:"bonus_type"`
+	Bonus     map[string]string `json:"bonus"`
+	// the receiver metadata of the seed, days appended before it was kept do not have it
+	ItemCategoryId  int     `json:"item_category_id,omitempty"`
+	RewardKamas     int     `json:"reward_kamas,omitempty"`
+	ExperienceRatio float64 `json:"experience_ratio,omitempty"`
+	OptimalLevel    int     `json:"optimal_level,omitempty"`
+	Duration        float64 `json:"duration,omitempty"`
+}
+
+// historyAsset holds every day ever mapped across game versions, sorted by date.
+type historyAsset struct {
+	Days []historyDay `json:"days"`
+}
+
+// publishedHistory returns the history of the newest release up to version that has one, and false if none has.
+func publishedHistory(repo dataRepo, version string) ([]historyDay, bool, error) {
+	ctx := context.Background()
+	releases, err := listReleases(ctx, github.NewClient(httpClient), repo, retryPolicy)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// releases are listed newest first, the ones after version are skipped
+	found := false
+	for _, release := range releases {
+		if release.GetTagName() == version {
+			found = true
+		}
+		if !found {
+			continue
+		}
+
+		data, _, err := downloadReleaseAsset(repo, release.GetTagName(), AlmanaxHistoryFileName)
+		if errors.Is(err, errAssetNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, false, err
+		}
+
+		var asset historyAsset
+		err = json.Unmarshal(data, &asset)
+		if err != nil {
+			return nil, false, fmt.Errorf("%s of %s: %w", AlmanaxHistoryFileName, release.GetTagName(), err)
+		}
+		return asset.Days, true, nil
+	}
+
+	if !found {
+		return nil, false, fmt.Errorf("release %s not found", version)
+	}
+	return nil, false, nil
+}
+
+// appendHistory adds the mapped days of the dataset that the history does not have yet. Days already in the
+// history are never changed, a different receiver for them is only logged.
+func appendHistory(history []historyDay, ds *almanax.Dataset, version string) ([]historyDay, int) {
+	known := make(map[string]string, len(history))
+	for _, day := range history {
+		known[day.Date] = day.Receiver
+	}
+
+	added := 0
+	for _, day := range ds.Days() {
+		if receiver, ok := known[day.Date]; ok {
+			if receiver != day.Receiver.Name {
+				log.Warn("history keeps the receiver it has for a date", "date", day.Date, "history", receiver, "mapped", day.Receiver.Name, "version", version)
+			}
+			continue
+		}
+
+		history = append(history, historyDay{
+			Date:      day.Date,
+			Version:   version,
+			Receiver:  day.Receiver.Name,
+			ItemId:    day.Receiver.Offering.ItemId,
+			ItemName:  day.Receiver.Offering.ItemName,
+			Quantity:  day.Receiver.Offering.Quantity,
+			BonusType: day.Receiver.Bonus.Type,
+			Bonus:     day.Receiver.Bonus.Description,
+
+			ItemCategoryId:  day.Receiver.Offering.ItemCategoryId,
+			RewardKamas:     day.Receiver.RewardKamas,
+			ExperienceRatio: day.Receiver.ExperienceRatio,
+			OptimalLevel:    day.Receiver.OptimalLevel,
+			Duration:        day.Receiver.Duration,
+		})
+		known[day.Date] = day.Receiver.Name
+		added++
+	}
+
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].Date < history[j].Date
+	})
+	return history, added
+}
+
+// buildHistoryAsset carries the history of the previous releases forward with the new days appended.
+func buildHistoryAsset(ds *almanax.Dataset, version string, cfg *Config) (*releaseAsset, error) {
+	history, published, err := publishedHistory(cfg.dataRepo(), version)
+	if err != nil {
+		return nil, err
+	}
+	if !published {
+		// expected once, when the history is turned on. Later it means the history was removed from every
+		// release and the new one only has the days of this release
+		log.Warn("no release has a history, starting a new one from this release", "version", version)
+	}
+
+	logYearOverYear(history, ds)
+	history, added := appendHistory(history, ds, version)
+	data, err := json.MarshalIndent(historyAsset{Days: history}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("publishing history", "days", len(history), "added", added)
+	return &releaseAsset{name: AlmanaxHistoryFileName, label: "every almanax day mapped so far", data: data}, nil
+}
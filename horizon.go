@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const horizonFileName = "horizon"
+
+// loadHorizon returns the last mapped date of the published release, empty if unknown.
+func loadHorizon(workdir string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(stateDir(workdir), horizonFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func saveHorizon(workdir string, to string) error {
+	err := os.MkdirAll(stateDir(workdir), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(stateDir(workdir), horizonFileName), []byte(to), 0644)
+}
+
+// extendHorizon maps the dates after the published horizon when fewer than extend_below are left,
+// so the data does not run out while the game version stays the same.
+func extendHorizon(p *pipeline) error {
+	cfg := &p.cfg
+	version, err := loadLocalVersion(p.workdir)
+	if err != nil || version == "" {
+		return err
+	}
+
+	to, err := loadHorizon(p.workdir)
+	if err != nil {
+		return err
+	}
+
+	today := time.Now().In(cfg.location())
+	if to != "" && time.Duration(daysRemaining(today, to))*24*time.Hour >= cfg.ExtendBelow {
+		return nil
+	}
+
+	ds, err := loadDataset(p.repo, version)
+	if err != nil {
+		return err
+	}
+
+	before := assignments(ds)
+
+	// the horizon file is missing for releases published before it existed
+	_, to = ds.Coverage()
+	if to == "" {
+		return nil
+	}
+	if time.Duration(daysRemaining(today, to))*24*time.Hour >= cfg.ExtendBelow {
+		return saveHorizon(p.workdir, to)
+	}
+
+	last, err := time.Parse("2006-01-02", to)
+	if err != nil {
+		return err
+	}
+	fromDate := last.AddDate(0, 0, 1).Format("2006-01-02")
+	toDate := today.Add(cfg.EndDuration).Format("2006-01-02")
+	if toDate < fromDate {
+		return fmt.Errorf("end_duration %s does not reach past the horizon %s", FormatDuration(cfg.EndDuration), to)
+	}
+
+	p.log.Info("horizon running out, extending", "version", version, "horizon", to, "to", toDate)
+	dateRange, err := createDateRange(fromDate, toDate)
+	if err != nil {
+		return err
+	}
+	ds.Metadata = cfg.metadata()
+	p.keepReplayRecord(version, dateRange, ds)
+	start := time.Now()
+	sources, err := p.mapDates(ds, version, dateRange)
+	if err != nil {
+		return err
+	}
+	p.log.Info("extension done", "duration", FormatDuration(time.Since(start).Round(time.Second)))
+
+	err = p.checkCoverage(ds, dateRange)
+	if err != nil {
+		return err
+	}
+
+	err = p.publish(ds, version, sources, before)
+	if err != nil {
+		return err
+	}
+	p.discardCheckpoint(version)
+	return saveHorizon(p.workdir, toDate)
+}
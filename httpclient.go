@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// httpClient sends every krosmoz, github and doduapi request. It has no overall timeout, the calls bound
+// themselves with their own timeouts like scrape_timeout, since uploads and downloads take long. It is set
+// from the config on startup like retryPolicy.
+var httpClient = newHttpClient(defaultConfig())
+
+// newHttpClient bounds connecting and waiting for the response headers, so a hung connection fails the
+// request instead of stalling the pipeline.
+func newHttpClient(cfg Config) *http.Client {
+	dialer := &net.Dialer{Timeout: cfg.HttpConnectTimeout, KeepAlive: 30 * time.Second}
+	return &http.Client{
+		Transport: &githubQuotaTransport{next: &proxyTransport{next: &http.Transport{
+			Proxy:                 proxyFromContext,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   cfg.HttpConnectTimeout,
+			ResponseHeaderTimeout: cfg.HttpReadTimeout,
+			ExpectContinueTimeout: time.Second,
+			IdleConnTimeout:       90 * time.Second,
+			MaxIdleConns:          cfg.HttpMaxIdleConns,
+			MaxIdleConnsPerHost:   cfg.HttpMaxIdleConns,
+			ForceAttemptHTTP2:     true,
+		}}},
+	}
+}
+
+// wrapHttpTransport puts a custom transport in front of the shared client, it gets every request first.
+func wrapHttpTransport(wrap func(next http.RoundTripper) http.RoundTripper) {
+	httpClient.Transport = wrap(httpClient.Transport)
+}
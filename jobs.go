@@ -0,0 +1,422 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+type jobKind string
+
+const (
+	jobMapVersion    jobKind = "map-version"
+	jobBackfill      jobKind = "backfill"
+	jobExtendHorizon jobKind = "extend-horizon"
+	jobValidate      jobKind = "validate"
+	jobCleanup       jobKind = "cleanup-releases"
+)
+
+// jobPriorities decides which queued job runs first, higher runs earlier.
+var jobPriorities = map[jobKind]int{
+	jobMapVersion:    30,
+	jobBackfill:      20,
+	jobExtendHorizon: 10,
+	jobValidate:      0,
+	jobCleanup:       -10,
+}
+
+// job is a unit of work for a pipeline. Version is empty for jobs on the last seen version,
+// From and To limit backfill jobs.
+type job struct {
+	Kind    jobKind   `json:"kind"`
+	Version string    `json:"version,omitempty"`
+	From    string    `json:"from,omitempty"`
+	To      string    `json:"to,omitempty"`
+	Queued  time.Time `json:"queued"`
+	Trigger string    `json:"trigger,omitempty"`
+	// Force maps the dates of a map-version job again even if the release has them
+	Force bool `json:"force,omitempty"`
+	// NotBefore holds the job back until then, set for jobs that continue a run that hit its limits
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	// Attempts counts the starts of the job, saved before it runs so a job that crashes the process is
+	// counted too
+	Attempts int `json:"attempts,omitempty"`
+	// Parked is set once the job started job_attempts times without finishing, it does not run again
+	// until an equal job is queued
+	Parked *time.Time `json:"parked,omitempty"`
+}
+
+// versionPattern is what a data release tag may look like. Versions name files in the workdir, so they
+// must not hold path separators or start with a dot.
+var versionPattern = regexp.MustCompile(`^[0-9A-Za-z][0-9A-Za-z._+-]{0,63}$`)
+
+// validate checks a job from outside the process, the webhook or the trigger inbox, before it is queued.
+func (j job) validate() error {
+	if _, ok := jobPriorities[j.Kind]; !ok {
+		return fmt.Errorf("unknown job kind %q", j.Kind)
+	}
+	if j.Version != "" && !versionPattern.MatchString(j.Version) {
+		return fmt.Errorf("invalid version %q", j.Version)
+	}
+	if j.Kind == jobBackfill {
+		if !isDate(j.From) || !isDate(j.To) {
+			return fmt.Errorf("backfill needs from and to as existing yyyy-mm-dd dates")
+		}
+		if j.To < j.From {
+			return fmt.Errorf("backfill from %s is after to %s", j.From, j.To)
+		}
+	}
+	return nil
+}
+
+// mapping reports whether the job scrapes krosmoz and publishes, only one of those runs at a time.
+func (j job) mapping() bool {
+	return j.Kind == jobMapVersion || j.Kind == jobBackfill || j.Kind == jobExtendHorizon
+}
+
+// scrapes reports whether the job requests krosmoz, those wait during an announced maintenance.
+func (j job) scrapes() bool {
+	return j.mapping() || j.Kind == jobValidate
+}
+
+// mappingMu is held while a mapping job runs, across the pipelines of all tenants.
+var mappingMu sync.Mutex
+
+// The queue policies, what a full queue does with a new job.
+const (
+	queueCoalesce   = "coalesce"
+	queueDropOldest = "drop-oldest"
+	queueReject     = "reject"
+)
+
+var queuePolicies = []string{queueCoalesce, queueDropOldest, queueReject}
+
+// key identifies equal jobs, a job is not queued again while an equal one waits.
+func (j job) key() string {
+	key := string(j.Kind) + "|" + j.Version + "|" + j.From + "|" + j.To
+	if j.Force {
+		key += "|force"
+	}
+	return key
+}
+
+// jobQueue is a priority queue persisted in the workdir state, so queued and interrupted jobs run again
+// after a restart. Jobs stay in the queue until they are done.
+type jobQueue struct {
+	mu     sync.Mutex
+	path   string
+	jobs   []job
+	notify chan struct{}
+	// limit is the number of queued jobs before policy applies to new ones, 0 for no limit
+	limit  int
+	policy string
+	// running is the key of the job being executed, it is never dropped or coalesced into
+	running string
+	// attempts is the number of starts after which a job is parked, 0 never parks
+	attempts int
+	stats    queueStats
+}
+
+// queueStats are counted since the start for the metrics.
+type queueStats struct {
+	waitSeconds map[jobKind]float64
+	started     map[jobKind]int
+	shed        map[string]int
+}
+
+func openJobQueue(workdir string, limit int, policy string, attempts int) (*jobQueue, error) {
+	q := &jobQueue{
+		path:     filepath.Join(stateDir(workdir), "jobs.json"),
+		notify:   make(chan struct{}, 1),
+		limit:    limit,
+		policy:   policy,
+		attempts: attempts,
+		stats: queueStats{
+			waitSeconds: make(map[jobKind]float64),
+			started:     make(map[jobKind]int),
+			shed:        make(map[string]int),
+		},
+	}
+
+	data, err := os.ReadFile(q.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &q.jobs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(q.jobs) > 0 {
+		q.signal()
+	}
+	return q, nil
+}
+
+func (q *jobQueue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// save writes the queue to a temporary file first so a crash never leaves a partial queue.
+func (q *jobQueue) save() error {
+	data, err := json.MarshalIndent(q.jobs, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(q.path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(q.path+".tmp", data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(q.path+".tmp", q.path)
+}
+
+// push queues a job unless an equal one is already queued and reports whether it was added. An equal job
+// that is parked is queued again with its attempts reset. On a full queue the policy decides: coalesce
+// merges the job into a waiting one of the same kind and version or drops it if there is none, drop-oldest
+// makes room by dropping the job waiting longest and reject drops the new job.
+func (q *jobQueue) push(j job) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, queued := range q.jobs {
+		if queued.key() != j.key() {
+			continue
+		}
+		if queued.Parked == nil {
+			return false, nil
+		}
+		queued.Parked, queued.Attempts, queued.Queued, queued.Trigger = nil, 0, time.Now(), j.Trigger
+		q.jobs[i] = queued
+		err := q.save()
+		if err != nil {
+			return false, err
+		}
+		q.signal()
+		return true, nil
+	}
+
+	if j.Queued.IsZero() {
+		j.Queued = time.Now()
+	}
+
+	if q.limit > 0 && len(q.jobs) >= q.limit {
+		switch q.policy {
+		case queueCoalesce:
+			i := q.coalesceInto(j)
+			if i == -1 {
+				log.Warn("job queue full, dropping new job", "kind", j.Kind, "trigger", j.Trigger, "queued", len(q.jobs))
+				q.stats.shed[queueReject]++
+				return false, nil
+			}
+			log.Info("job queue full, coalescing new job", "kind", j.Kind, "version", j.Version, "trigger", j.Trigger)
+			q.stats.shed[queueCoalesce]++
+			return false, q.save()
+		case queueDropOldest:
+			oldest := -1
+			for i, queued := range q.jobs {
+				if queued.key() != q.running && (oldest == -1 || queued.Queued.Before(q.jobs[oldest].Queued)) {
+					oldest = i
+				}
+			}
+			if oldest == -1 {
+				return false, nil
+			}
+			dropped := q.jobs[oldest]
+			log.Warn("job queue full, dropping oldest job", "kind", dropped.Kind, "version", dropped.Version, "queued", dropped.Queued)
+			q.jobs = append(q.jobs[:oldest], q.jobs[oldest+1:]...)
+			q.stats.shed[queueDropOldest]++
+		default:
+			log.Warn("job queue full, rejecting new job", "kind", j.Kind, "trigger", j.Trigger, "queued", len(q.jobs))
+			q.stats.shed[queueReject]++
+			return false, nil
+		}
+	}
+
+	q.jobs = append(q.jobs, j)
+	sort.SliceStable(q.jobs, func(a, b int) bool {
+		return jobPriorities[q.jobs[a].Kind] > jobPriorities[q.jobs[b].Kind]
+	})
+
+	err := q.save()
+	if err != nil {
+		return false, err
+	}
+	q.signal()
+	return true, nil
+}
+
+// coalesceInto merges a job into a waiting one of the same kind and version, a backfill widens to cover
+// both ranges. It returns the index of the waiting job or -1 if there is none.
+func (q *jobQueue) coalesceInto(j job) int {
+	for i, queued := range q.jobs {
+		if queued.Kind != j.Kind || queued.Version != j.Version || queued.key() == q.running {
+			continue
+		}
+		if j.Kind == jobBackfill {
+			if !isDate(j.From) || !isDate(j.To) {
+				return -1
+			}
+			if j.From < queued.From {
+				queued.From = j.From
+			}
+			if j.To > queued.To {
+				queued.To = j.To
+			}
+			q.jobs[i] = queued
+		}
+		return i
+	}
+	return -1
+}
+
+// next waits for the job with the highest priority that is not held back or parked without removing it.
+func (q *jobQueue) next(ctx context.Context) (job, bool) {
+	for {
+		q.mu.Lock()
+		var wake time.Time
+		for _, j := range q.jobs {
+			if j.Parked != nil {
+				continue
+			}
+			if j.NotBefore != nil && time.Now().Before(*j.NotBefore) {
+				if wake.IsZero() || j.NotBefore.Before(wake) {
+					wake = *j.NotBefore
+				}
+				continue
+			}
+			q.mu.Unlock()
+			return j, true
+		}
+		q.mu.Unlock()
+
+		var held <-chan time.Time
+		if !wake.IsZero() {
+			held = time.After(time.Until(wake))
+		}
+		select {
+		case <-ctx.Done():
+			return job{}, false
+		case <-q.notify:
+		case <-held:
+		}
+	}
+}
+
+// start marks a job as running, counts how long it waited and saves its attempt before it runs. It returns
+// the job with the attempt counted.
+func (q *jobQueue) start(j job) (job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.running = j.key()
+	q.stats.waitSeconds[j.Kind] += time.Since(j.Queued).Seconds()
+	q.stats.started[j.Kind]++
+	for i, queued := range q.jobs {
+		if queued.key() == j.key() {
+			q.jobs[i].Attempts++
+			j = q.jobs[i]
+			break
+		}
+	}
+	return j, q.save()
+}
+
+// exhausted reports whether the job started job_attempts times without finishing.
+func (q *jobQueue) exhausted(j job) bool {
+	return q.attempts > 0 && j.Attempts >= q.attempts
+}
+
+// park keeps a job that keeps failing in the queue without running it, so a maintainer can look at it.
+func (q *jobQueue) park(j job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now().UTC()
+	for i, queued := range q.jobs {
+		if queued.key() == j.key() {
+			q.jobs[i].Parked = &now
+			break
+		}
+	}
+	return q.save()
+}
+
+// done removes a finished job.
+func (q *jobQueue) done(j job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.running = ""
+	for i, queued := range q.jobs {
+		if queued.key() == j.key() {
+			q.jobs = append(q.jobs[:i], q.jobs[i+1:]...)
+			break
+		}
+	}
+	return q.save()
+}
+
+// queued reports whether a waiting job works on the version, jobs without one work on the local version.
+func (q *jobQueue) queued(version string, local string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, j := range q.jobs {
+		if j.Version == version || (j.Version == "" && local == version) {
+			return true
+		}
+	}
+	return false
+}
+
+// list returns the queued jobs in the order they run.
+func (q *jobQueue) list() []job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return slices.Clone(q.jobs)
+}
+
+// queueSnapshot is the state of a queue for the metrics.
+type queueSnapshot struct {
+	depth      int
+	oldestWait time.Duration
+	stats      queueStats
+}
+
+func (q *jobQueue) snapshot() queueSnapshot {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	snap := queueSnapshot{stats: queueStats{
+		waitSeconds: maps.Clone(q.stats.waitSeconds),
+		started:     maps.Clone(q.stats.started),
+		shed:        maps.Clone(q.stats.shed),
+	}}
+	for _, j := range q.jobs {
+		if j.key() == q.running || j.Parked != nil {
+			continue
+		}
+		snap.depth++
+		snap.oldestWait = max(snap.oldestWait, time.Since(j.Queued))
+	}
+	return snap
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func openTestQueue(t *testing.T, workdir string, limit int, policy string, attempts int) *jobQueue {
+	t.Helper()
+	q, err := openJobQueue(workdir, limit, policy, attempts)
+	if err != nil {
+		t.Fatalf("opening queue: %v", err)
+	}
+	return q
+}
+
+func pushJob(t *testing.T, q *jobQueue, j job) bool {
+	t.Helper()
+	added, err := q.push(j)
+	if err != nil {
+		t.Fatalf("pushing %s: %v", j.Kind, err)
+	}
+	return added
+}
+
+func queuedKeys(q *jobQueue) []string {
+	var keys []string
+	for _, j := range q.list() {
+		keys = append(keys, j.key())
+	}
+	return keys
+}
+
+func TestJobQueuePriority(t *testing.T) {
+	q := openTestQueue(t, t.TempDir(), 0, queueCoalesce, 0)
+	for _, j := range []job{
+		{Kind: jobCleanup},
+		{Kind: jobValidate},
+		{Kind: jobExtendHorizon},
+		{Kind: jobBackfill, From: "2025-01-01", To: "2025-01-31"},
+		{Kind: jobMapVersion, Version: "1.0.0"},
+	} {
+		if !pushJob(t, q, j) {
+			t.Fatalf("%s was not queued", j.Kind)
+		}
+	}
+
+	var kinds []jobKind
+	for _, j := range q.list() {
+		kinds = append(kinds, j.Kind)
+	}
+	want := []jobKind{jobMapVersion, jobBackfill, jobExtendHorizon, jobValidate, jobCleanup}
+	if !slices.Equal(kinds, want) {
+		t.Fatalf("order is %v, want %v", kinds, want)
+	}
+
+	j, ok := q.next(context.Background())
+	if !ok || j.Kind != jobMapVersion {
+		t.Fatalf("next is %s, want %s", j.Kind, jobMapVersion)
+	}
+}
+
+func TestJobQueueSkipsEqualJobs(t *testing.T) {
+	q := openTestQueue(t, t.TempDir(), 0, queueCoalesce, 0)
+	tests := []struct {
+		job  job
+		want bool
+	}{
+		{job{Kind: jobMapVersion, Version: "1.0.0"}, true},
+		{job{Kind: jobMapVersion, Version: "1.0.0", Trigger: "webhook"}, false},
+		{job{Kind: jobMapVersion, Version: "1.0.0", Force: true}, true},
+		{job{Kind: jobMapVersion, Version: "1.0.1"}, true},
+	}
+	for _, test := range tests {
+		if added := pushJob(t, q, test.job); added != test.want {
+			t.Errorf("push %s force=%v trigger=%s: added %v, want %v", test.job.Version, test.job.Force, test.job.Trigger, added, test.want)
+		}
+	}
+}
+
+func TestJobQueueFullPolicies(t *testing.T) {
+	old := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name   string
+		policy string
+		queued []job
+		// running is started before the new job is pushed
+		running *job
+		push    job
+		added   bool
+		want    []string
+	}{
+		{
+			name:   "coalesce widens a backfill",
+			policy: queueCoalesce,
+			queued: []job{{Kind: jobBackfill, From: "2025-01-10", To: "2025-01-20"}},
+			push:   job{Kind: jobBackfill, From: "2025-01-01", To: "2025-01-15"},
+			want:   []string{"backfill||2025-01-01|2025-01-20"},
+		},
+		{
+			name:   "coalesce drops a job without a match",
+			policy: queueCoalesce,
+			queued: []job{{Kind: jobValidate}},
+			push:   job{Kind: jobMapVersion, Version: "1.0.0"},
+			want:   []string{"validate|||"},
+		},
+		{
+			name:    "coalesce skips the running job",
+			policy:  queueCoalesce,
+			queued:  []job{{Kind: jobBackfill, From: "2025-01-10", To: "2025-01-20"}},
+			running: &job{Kind: jobBackfill, From: "2025-01-10", To: "2025-01-20"},
+			push:    job{Kind: jobBackfill, From: "2025-01-01", To: "2025-01-15"},
+			want:    []string{"backfill||2025-01-10|2025-01-20"},
+		},
+		{
+			name:   "drop-oldest makes room",
+			policy: queueDropOldest,
+			queued: []job{{Kind: jobValidate, Queued: old}},
+			push:   job{Kind: jobCleanup},
+			added:  true,
+			want:   []string{"cleanup-releases|||"},
+		},
+		{
+			name:    "drop-oldest keeps the running job",
+			policy:  queueDropOldest,
+			queued:  []job{{Kind: jobValidate, Queued: old}},
+			running: &job{Kind: jobValidate},
+			push:    job{Kind: jobCleanup},
+			want:    []string{"validate|||"},
+		},
+		{
+			name:   "reject drops the new job",
+			policy: queueReject,
+			queued: []job{{Kind: jobValidate}},
+			push:   job{Kind: jobMapVersion, Version: "1.0.0"},
+			want:   []string{"validate|||"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q := openTestQueue(t, t.TempDir(), len(test.queued), test.policy, 0)
+			for _, j := range test.queued {
+				pushJob(t, q, j)
+			}
+			if test.running != nil {
+				if _, err := q.start(*test.running); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if added := pushJob(t, q, test.push); added != test.added {
+				t.Errorf("added %v, want %v", added, test.added)
+			}
+			if keys := queuedKeys(q); !slices.Equal(keys, test.want) {
+				t.Errorf("queue is %v, want %v", keys, test.want)
+			}
+		})
+	}
+}
+
+func TestJobQueueNotBefore(t *testing.T) {
+	q := openTestQueue(t, t.TempDir(), 0, queueCoalesce, 0)
+	later := time.Now().Add(100 * time.Millisecond)
+	pushJob(t, q, job{Kind: jobMapVersion, Version: "1.0.0", NotBefore: &later})
+	pushJob(t, q, job{Kind: jobValidate})
+
+	j, ok := q.next(context.Background())
+	if !ok || j.Kind != jobValidate {
+		t.Fatalf("next is %s, want the job that is not held back", j.Kind)
+	}
+	if err := q.done(j); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if j, ok := q.next(ctx); ok {
+		t.Fatalf("next returned %s before its time", j.Kind)
+	}
+
+	j, ok = q.next(context.Background())
+	if !ok || j.Kind != jobMapVersion {
+		t.Fatalf("next is %s, want the held back job once its time came", j.Kind)
+	}
+	if time.Now().Before(later) {
+		t.Fatal("held back job returned early")
+	}
+}
+
+func TestJobQueuePersistence(t *testing.T) {
+	workdir := t.TempDir()
+	q := openTestQueue(t, workdir, 0, queueCoalesce, 0)
+	pushJob(t, q, job{Kind: jobValidate})
+	pushJob(t, q, job{Kind: jobMapVersion, Version: "1.0.0"})
+	j, _ := q.next(context.Background())
+	if _, err := q.start(j); err != nil {
+		t.Fatal(err)
+	}
+
+	// the process died while the job ran
+	reopened := openTestQueue(t, workdir, 0, queueCoalesce, 0)
+	if keys, want := queuedKeys(reopened), queuedKeys(q); !slices.Equal(keys, want) {
+		t.Fatalf("reopened queue is %v, want %v", keys, want)
+	}
+	if attempts := reopened.list()[0].Attempts; attempts != 1 {
+		t.Errorf("interrupted job has %d attempts, want 1", attempts)
+	}
+
+	j, ok := reopened.next(context.Background())
+	if !ok || j.Kind != jobMapVersion {
+		t.Fatalf("next after reopening is %s, want the interrupted job", j.Kind)
+	}
+	if err := reopened.done(j); err != nil {
+		t.Fatal(err)
+	}
+	if keys := queuedKeys(openTestQueue(t, workdir, 0, queueCoalesce, 0)); !slices.Equal(keys, []string{"validate|||"}) {
+		t.Errorf("queue after done is %v", keys)
+	}
+}
+
+func TestJobQueueParksExhaustedJobs(t *testing.T) {
+	q := openTestQueue(t, t.TempDir(), 0, queueCoalesce, 2)
+	pushJob(t, q, job{Kind: jobMapVersion, Version: "1.0.0"})
+
+	var j job
+	for attempt := 1; attempt <= 2; attempt++ {
+		next, _ := q.next(context.Background())
+		if q.exhausted(next) {
+			t.Fatalf("exhausted after %d attempts", attempt-1)
+		}
+		var err error
+		j, err = q.start(next)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.Attempts != attempt {
+			t.Fatalf("attempt %d counted as %d", attempt, j.Attempts)
+		}
+	}
+	if !q.exhausted(j) {
+		t.Fatal("not exhausted after 2 attempts")
+	}
+	if err := q.park(j); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if j, ok := q.next(ctx); ok {
+		t.Fatalf("parked %s job was returned", j.Kind)
+	}
+	if depth := q.snapshot().depth; depth != 0 {
+		t.Errorf("parked job counts as waiting, depth %d", depth)
+	}
+
+	if !pushJob(t, q, job{Kind: jobMapVersion, Version: "1.0.0", Trigger: "webhook"}) {
+		t.Fatal("equal job did not queue the parked one again")
+	}
+	j, ok := q.next(context.Background())
+	if !ok || j.Parked != nil || j.Attempts != 0 || j.Trigger != "webhook" {
+		t.Fatalf("job queued again is %+v, want it unparked with no attempts", j)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/go-github/v67/github"
+)
+
+const journalFileName = "journal.json"
+
+// runRecoverPublish is the run history kind of completing a publish an earlier process did not finish. It
+// is not a job, it runs on startup before any job.
+const runRecoverPublish jobKind = "recover-publish"
+
+// journalAsset is an asset of a publish, its data is kept next to the journal until the publish is done.
+type journalAsset struct {
+	Name     string `json:"name"`
+	Label    string `json:"label"`
+	Uploaded bool   `json:"uploaded,omitempty"`
+}
+
+// publishJournal records a publish while it replaces the assets of a release one after another and
+// notifies doduapi. A process that dies in between leaves it behind, the next one completes the publish.
+type publishJournal struct {
+	dir     string
+	Version string         `json:"version"`
+	Started time.Time      `json:"started"`
+	Assets  []journalAsset `json:"assets"`
+	// Notify is whether doduapi still has to be told about the new data
+	Notify bool `json:"notify,omitempty"`
+}
+
+func journalDir(workdir string) string {
+	return filepath.Join(stateDir(workdir), "publish")
+}
+
+// newPublishJournal returns the journal of the publishes of a workdir, it is written once a publish begins.
+func newPublishJournal(workdir string) *publishJournal {
+	return &publishJournal{dir: journalDir(workdir)}
+}
+
+// loadPublishJournal returns the journal of a publish that did not finish, nil if there is none.
+func loadPublishJournal(workdir string) (*publishJournal, error) {
+	data, err := os.ReadFile(filepath.Join(journalDir(workdir), journalFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	journal := newPublishJournal(workdir)
+	err = json.Unmarshal(data, journal)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", journalFileName, err)
+	}
+	return journal, nil
+}
+
+// begin keeps the assets of a publish before the first one is deleted from the release.
+func (j *publishJournal) begin(version string, assets []releaseAsset, notify bool) error {
+	if j == nil {
+		return nil
+	}
+	err := os.RemoveAll(j.dir)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(j.dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	j.Version = version
+	j.Started = time.Now().UTC()
+	j.Assets = nil
+	j.Notify = notify
+	for _, asset := range assets {
+		err = os.WriteFile(filepath.Join(j.dir, asset.name), asset.data, 0644)
+		if err != nil {
+			return err
+		}
+		j.Assets = append(j.Assets, journalAsset{Name: asset.name, Label: asset.label})
+	}
+	return j.save()
+}
+
+// save writes the journal to a temporary file first so a crash never leaves a partial journal.
+func (j *publishJournal) save() error {
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(j.dir, journalFileName)
+	err = os.WriteFile(path+".tmp", data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (j *publishJournal) uploaded(name string) error {
+	if j == nil {
+		return nil
+	}
+	for n := range j.Assets {
+		if j.Assets[n].Name == name {
+			j.Assets[n].Uploaded = true
+		}
+	}
+	return j.save()
+}
+
+// finish removes the journal and the kept assets of a publish that is done.
+func (j *publishJournal) finish() error {
+	if j == nil {
+		return nil
+	}
+	return os.RemoveAll(j.dir)
+}
+
+// pending returns the assets the interrupted publish did not upload yet, with their kept data.
+func (j *publishJournal) pending() ([]releaseAsset, error) {
+	var assets []releaseAsset
+	for _, asset := range j.Assets {
+		if asset.Uploaded {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(j.dir, asset.Name))
+		if err != nil {
+			return nil, err
+		}
+		assets = append(assets, releaseAsset{name: asset.Name, label: asset.Label, data: data})
+	}
+	return assets, nil
+}
+
+// completePublish uploads the assets an interrupted publish did not upload and notifies doduapi if it did
+// not get to it.
+func completePublish(journal *publishJournal, assets []releaseAsset, cfg *Config) error {
+	ctx := context.Background()
+	client := githubClient(uploadHttpClient(cfg), cfg)
+	repo := cfg.dataRepo()
+
+	if len(assets) > 0 {
+		var repRel *github.RepositoryRelease
+		err := cfg.retryPolicy().do(ctx, "get release", func() error {
+			var err error
+			repRel, _, err = client.Repositories.GetReleaseByTag(ctx, repo.owner, repo.name, journal.Version)
+			return githubRetryable(err)
+		})
+		if err != nil {
+			return err
+		}
+		err = uploadReleaseAssets(ctx, client, repRel, assets, cfg, journal)
+		if err != nil {
+			return err
+		}
+	}
+
+	if journal.Notify && cfg.DoduapiUpdateToken != "" {
+		err := notifyDoduapi(ctx, journal.Version, cfg)
+		if err != nil {
+			return err
+		}
+	}
+	return journal.finish()
+}
+
+// recoverPublish completes a publish an earlier process died in, between replacing the assets of the release
+// or before notifying doduapi, so the release is not left half replaced. A journal whose kept assets are
+// gone can not be completed, it is rolled back by mapping the version again from scratch.
+func (p *pipeline) recoverPublish() error {
+	journal, err := loadPublishJournal(p.workdir)
+	if err != nil || journal == nil {
+		return err
+	}
+
+	started := time.Now()
+	p.log.Warn("publish was interrupted, completing it", "version", journal.Version, "started", journal.Started.Format(time.RFC3339))
+	run := runRecord{Kind: runRecoverPublish, Version: journal.Version, Started: started.UTC()}
+
+	assets, err := journal.pending()
+	if err != nil {
+		p.log.Error("kept assets of the interrupted publish are unreadable, mapping the version again", "version", journal.Version, "error", err)
+		p.alerts.add(fmt.Sprintf("publish of %s was interrupted and can not be completed, mapping it again", journal.Version), err)
+		run.Error = err.Error()
+		err = journal.finish()
+		if err == nil {
+			_, err = p.queue.push(job{Kind: jobMapVersion, Version: journal.Version, Force: true, Trigger: string(runRecoverPublish)})
+		}
+	} else {
+		err = completePublish(journal, assets, &p.cfg)
+		if err != nil {
+			run.Error = err.Error()
+		} else {
+			p.log.Info("interrupted publish completed", "version", journal.Version, "assets", len(assets))
+			p.alerts.add(fmt.Sprintf("completed the interrupted publish of %s", journal.Version), nil)
+		}
+	}
+
+	run.Finished = time.Now().UTC()
+	if recordErr := appendRun(p.workdir, run); recordErr != nil {
+		p.log.Warn("error recording run", "error", recordErr)
+	}
+	return err
+}
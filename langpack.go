@@ -0,0 +1,123 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+//go:embed langpacks
+var builtinLanguagePacks embed.FS
+
+// languagePack holds the texts krosmoz uses for the offering quest in a page language. {receiver} stands
+// for the name of the receiver, {quantity} and {item} for the offering.
+type languagePack struct {
+	Receiver string `json:"receiver"`
+	Offering string `json:"offering"`
+}
+
+// pagePatterns are the compiled texts of a language pack.
+type pagePatterns struct {
+	receiver *regexp.Regexp
+	item     *regexp.Regexp
+}
+
+// almanaxPagePatterns holds the patterns of every known page language, the built-in packs with the ones
+// of language_packs on top.
+var almanaxPagePatterns = mustLoadBuiltinLanguagePacks()
+
+func mustLoadBuiltinLanguagePacks() map[string]pagePatterns {
+	patterns := make(map[string]pagePatterns)
+	entries, err := builtinLanguagePacks.ReadDir("langpacks")
+	if err != nil {
+		panic(err)
+	}
+	for _, entry := range entries {
+		data, err := builtinLanguagePacks.ReadFile("langpacks/" + entry.Name())
+		if err != nil {
+			panic(err)
+		}
+		lang := strings.TrimSuffix(entry.Name(), ".json")
+		patterns[lang], err = parseLanguagePack(data, languagePack{})
+		if err != nil {
+			panic(fmt.Sprintf("language pack %s: %s", lang, err))
+		}
+	}
+	return patterns
+}
+
+// loadLanguagePacks reads the <lang>.json packs of a directory. A pack for a built-in language replaces the
+// texts it sets, a pack for another language adds it.
+func loadLanguagePacks(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		lang := strings.TrimSuffix(entry.Name(), ".json")
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+
+		var base languagePack
+		if builtin, err := builtinLanguagePacks.ReadFile("langpacks/" + entry.Name()); err == nil {
+			err = json.Unmarshal(builtin, &base)
+			if err != nil {
+				return err
+			}
+		}
+		patterns, err := parseLanguagePack(data, base)
+		if err != nil {
+			return fmt.Errorf("language pack %s: %w", entry.Name(), err)
+		}
+		almanaxPagePatterns[lang] = patterns
+	}
+	return nil
+}
+
+// parseLanguagePack compiles a pack, texts it leaves out are taken from base.
+func parseLanguagePack(data []byte, base languagePack) (pagePatterns, error) {
+	var patterns pagePatterns
+	pack := base
+	err := json.Unmarshal(data, &pack)
+	if err != nil {
+		return patterns, err
+	}
+
+	patterns.receiver, err = compilePackText(pack.Receiver, map[string]string{"receiver": receiverNamePattern})
+	if err != nil {
+		return patterns, fmt.Errorf("receiver: %w", err)
+	}
+	patterns.item, err = compilePackText(pack.Offering, map[string]string{"quantity": `(?P<quantity>\d+)`, "item": `(?P<item>.+?)`})
+	if err != nil {
+		return patterns, fmt.Errorf("offering: %w", err)
+	}
+	return patterns, nil
+}
+
+// compilePackText turns a text of a pack into a pattern, every placeholder has to be used once. Spaces are
+// collapsed like collapseSpaces does with the page text.
+func compilePackText(text string, placeholders map[string]string) (*regexp.Regexp, error) {
+	text = strings.Join(strings.Fields(text), " ")
+	if text == "" {
+		return nil, fmt.Errorf("missing")
+	}
+
+	pattern := regexp.QuoteMeta(text)
+	for name, sub := range placeholders {
+		placeholder := regexp.QuoteMeta("{" + name + "}")
+		if strings.Count(pattern, placeholder) != 1 {
+			return nil, fmt.Errorf("needs {%s} once in %q", name, text)
+		}
+		pattern = strings.Replace(pattern, placeholder, sub, 1)
+	}
+	return regexp.Compile(pattern)
+}
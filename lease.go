@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	leaseTimeFormat   = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+type leaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int    `json:"leaseTransitions"`
+}
+
+type leaseMetadata struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type lease struct {
+	ApiVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   leaseMetadata `json:"metadata"`
+	Spec       leaseSpec     `json:"spec"`
+}
+
+// leaseElector implements leader election with a coordination.k8s.io Lease through the in-cluster api.
+type leaseElector struct {
+	name      string
+	namespace string
+	identity  string
+	duration  time.Duration
+	apiUrl    string
+	client    *http.Client
+	leader    atomic.Bool
+	stopped   atomic.Bool
+}
+
+func newLeaseElector(name string, namespace string, duration time.Duration) (*leaseElector, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running inside kubernetes")
+	}
+
+	if namespace == "" {
+		data, err := os.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, err
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+
+	caCert, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(caCert)
+
+	identity := os.Getenv("POD_NAME")
+	if identity == "" {
+		identity, err = os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &leaseElector{
+		name:      name,
+		namespace: namespace,
+		identity:  identity,
+		duration:  duration,
+		apiUrl:    "https://" + net.JoinHostPort(host, port),
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+	}, nil
+}
+
+func (e *leaseElector) isLeader() bool {
+	return e.leader.Load()
+}
+
+func (e *leaseElector) leaseUrl(named bool) string {
+	url := fmt.Sprintf("%s/apis/coordination.k8s.io/v1/namespaces/%s/leases", e.apiUrl, e.namespace)
+	if named {
+		url += "/" + e.name
+	}
+	return url
+}
+
+// request sends a lease request, the service account token is read every time because it is rotated.
+func (e *leaseElector) request(method string, url string, body *lease) (*lease, int, error) {
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, 0, err
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	token, err := os.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := e.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return nil, res.StatusCode, nil
+	}
+
+	var result lease
+	err = json.NewDecoder(res.Body).Decode(&result)
+	if err != nil {
+		return nil, res.StatusCode, err
+	}
+	return &result, res.StatusCode, nil
+}
+
+// tryAcquire creates, renews or takes over an expired lease and reports whether this replica holds it.
+func (e *leaseElector) tryAcquire() (bool, error) {
+	now := time.Now()
+	nowStr := now.UTC().Format(leaseTimeFormat)
+
+	current, status, err := e.request("GET", e.leaseUrl(true), nil)
+	if err != nil {
+		return false, err
+	}
+
+	if status == http.StatusNotFound {
+		created := &lease{
+			ApiVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata:   leaseMetadata{Name: e.name, Namespace: e.namespace},
+			Spec: leaseSpec{
+				HolderIdentity:       e.identity,
+				LeaseDurationSeconds: int(e.duration.Seconds()),
+				AcquireTime:          nowStr,
+				RenewTime:            nowStr,
+			},
+		}
+		_, status, err = e.request("POST", e.leaseUrl(false), created)
+		return err == nil && status < 300, err
+	}
+	if current == nil {
+		return false, fmt.Errorf("unexpected status reading lease: %d", status)
+	}
+
+	spec := current.Spec
+	if spec.HolderIdentity != e.identity && spec.HolderIdentity != "" {
+		renewed, err := time.Parse(leaseTimeFormat, spec.RenewTime)
+		expired := err != nil || now.After(renewed.Add(time.Duration(spec.LeaseDurationSeconds)*time.Second))
+		if !expired {
+			return false, nil
+		}
+	}
+
+	if spec.HolderIdentity != e.identity {
+		current.Spec.HolderIdentity = e.identity
+		current.Spec.AcquireTime = nowStr
+		current.Spec.LeaseTransitions++
+	}
+	current.Spec.LeaseDurationSeconds = int(e.duration.Seconds())
+	current.Spec.RenewTime = nowStr
+
+	// the resource version makes concurrent takeovers fail with a conflict
+	_, status, err = e.request("PUT", e.leaseUrl(true), current)
+	return err == nil && status < 300, err
+}
+
+// run keeps acquiring or renewing the lease until the context is done.
+func (e *leaseElector) run(ctx context.Context) {
+	ticker := time.NewTicker(e.duration / 3)
+	defer ticker.Stop()
+
+	for {
+		if e.stopped.Load() {
+			return
+		}
+
+		leader, err := e.tryAcquire()
+		if err != nil {
+			log.Error("error updating lease", "lease", e.name, "error", err)
+		}
+		if leader != e.leader.Swap(leader) {
+			log.Info("leadership changed", "lease", e.name, "identity", e.identity, "leader", leader)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// holds re-reads the lease and reports whether this replica holds it and it did not expire. A lease it lost
+// also ends the leadership, without waiting for the next renewal.
+func (e *leaseElector) holds() (bool, error) {
+	if !e.isLeader() {
+		return false, nil
+	}
+	current, status, err := e.request("GET", e.leaseUrl(true), nil)
+	if err != nil {
+		return false, err
+	}
+	if current == nil {
+		return false, fmt.Errorf("unexpected status reading lease: %d", status)
+	}
+	renewed, err := time.Parse(leaseTimeFormat, current.Spec.RenewTime)
+	held := err == nil && current.Spec.HolderIdentity == e.identity && time.Now().Before(renewed.Add(time.Duration(current.Spec.LeaseDurationSeconds)*time.Second))
+	if !held && e.leader.Swap(false) {
+		log.Warn("lease lost before writing the release", "lease", e.name, "holder", current.Spec.HolderIdentity)
+	}
+	return held, nil
+}
+
+// release gives up the lease so another replica can take over without waiting for it to expire.
+func (e *leaseElector) release() {
+	e.stopped.Store(true)
+	if !e.leader.Swap(false) {
+		return
+	}
+
+	current, _, err := e.request("GET", e.leaseUrl(true), nil)
+	if err != nil || current == nil || current.Spec.HolderIdentity != e.identity {
+		return
+	}
+
+	current.Spec.HolderIdentity = ""
+	_, _, err = e.request("PUT", e.leaseUrl(true), current)
+	if err != nil {
+		log.Error("error releasing lease", "lease", e.name, "error", err)
+	}
+}
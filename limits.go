@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// runLimitError is a run that hit one of its caps. The scraped dates are checkpointed, the job is queued
+// again for after run_limit_pause to map the rest.
+type runLimitError struct {
+	Limit string
+	Value string
+}
+
+func (e runLimitError) Error() string {
+	return fmt.Sprintf("run hit %s of %s", e.Limit, e.Value)
+}
+
+// runLimits caps the krosmoz requests, the duration and the github requests of a single job.
+type runLimits struct {
+	maxRequests int
+	maxDuration time.Duration
+	maxGithub   int
+
+	mu       sync.Mutex
+	started  time.Time
+	requests int
+	lane     string
+	// github is the count of github requests of the lane when the job started
+	github int
+}
+
+func newRunLimits(cfg *Config) *runLimits {
+	return &runLimits{maxRequests: cfg.RunMaxRequests, maxDuration: cfg.RunMaxDuration, maxGithub: cfg.RunMaxGithubCalls}
+}
+
+// begin starts counting for a job of the data repo lane.
+func (l *runLimits) begin(lane string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.started = time.Now()
+	l.requests = 0
+	l.lane = lane
+	l.github = githubCalls.count(lane)
+}
+
+// request counts a krosmoz request.
+func (l *runLimits) request() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.requests++
+}
+
+// exceeded returns the krosmoz request or duration cap the job hit, nil if it may go on. Requests already
+// under way finish, so a run can end a few requests over its cap.
+func (l *runLimits) exceeded() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.started.IsZero() {
+		return nil
+	}
+	if l.maxRequests > 0 && l.requests >= l.maxRequests {
+		return runLimitError{Limit: "run_max_requests", Value: fmt.Sprint(l.maxRequests)}
+	}
+	if l.maxDuration > 0 && time.Since(l.started) >= l.maxDuration {
+		return runLimitError{Limit: "run_max_duration", Value: FormatDuration(l.maxDuration)}
+	}
+	return nil
+}
+
+// githubLeft returns the github cap as an error if calls more github requests would take the job over it.
+func (l *runLimits) githubLeft(calls int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxGithub <= 0 || l.started.IsZero() {
+		return nil
+	}
+	if githubCalls.count(l.lane)-l.github+calls > l.maxGithub {
+		return runLimitError{Limit: "run_max_github_calls", Value: fmt.Sprint(l.maxGithub)}
+	}
+	return nil
+}
+
+// githubCallCounter counts the github requests by lane since startup.
+type githubCallCounter struct {
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (c *githubCallCounter) add(lane string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.calls == nil {
+		c.calls = make(map[string]int)
+	}
+	c.calls[lane]++
+}
+
+func (c *githubCallCounter) count(lane string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls[lane]
+}
+
+// githubCalls counts every github request that goes through the quota transport.
+var githubCalls githubCallCounter
+
+// rescheduleRun takes a job that hit a run limit out of the queue and queues it again for after
+// run_limit_pause, its checkpoint lets it continue where it stopped.
+func (p *pipeline) rescheduleRun(j job, limit runLimitError) error {
+	err := p.queue.done(j)
+	if err != nil {
+		return err
+	}
+	next := j
+	next.Queued = time.Time{}
+	notBefore := time.Now().Add(p.cfg.RunLimitPause)
+	next.NotBefore = &notBefore
+	next.Trigger = "run-limit"
+	// hitting a limit is not a failure, the continuation starts counting again
+	next.Attempts = 0
+	_, err = p.queue.push(next)
+	if err != nil {
+		return err
+	}
+	p.log.Warn("run hit its limit, continuing later", "kind", j.Kind, "version", j.Version, "limit", limit.Limit, "value", limit.Value, "at", next.NotBefore.Format(time.RFC3339))
+	return nil
+}
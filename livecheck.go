@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dofusdude/alm-dates/almanax"
+)
+
+const liveCorruptAlert = "the published almanax is corrupt or truncated"
+
+// watchLive checks the published asset every live_check_interval, whoever uploaded it. Every failed check
+// raises an alert, they are grouped, and the alert is resolved once the asset is whole again.
+func (p *pipeline) watchLive(ctx context.Context) {
+	for {
+		checked, err := p.checkLive()
+		switch {
+		case !checked:
+			if err != nil {
+				p.log.Warn("could not check the published almanax", "error", err)
+			}
+		case err != nil:
+			p.log.Error("published almanax is corrupt", "error", err)
+			metrics.set(metricLiveCorrupt, 1, p.cfg.Game, p.name)
+			p.alerts.add(liveCorruptAlert, err)
+		default:
+			metrics.set(metricLiveCorrupt, 0, p.cfg.Game, p.name)
+			p.alerts.resolve(liveCorruptAlert)
+		}
+
+		if !sleepCtx(ctx, p.cfg.LiveCheckInterval) {
+			return
+		}
+	}
+}
+
+// checkLive downloads the published almanax of the last seen version and checks it decodes, matches the
+// content addressed pointer if there is one, reaches the horizon and covers every date until it exactly
+// once. checked is false if there was nothing to check yet, or a publish of this process is under way.
+func (p *pipeline) checkLive() (checked bool, err error) {
+	version, err := loadLocalVersion(p.workdir)
+	if err != nil || version == "" {
+		return false, nil
+	}
+	journal, err := loadPublishJournal(p.workdir)
+	if err != nil || journal != nil {
+		return false, nil
+	}
+
+	data, _, err := downloadAlmanaxAsset(p.repo, version)
+	if errors.Is(err, errAssetNotFound) {
+		return true, fmt.Errorf("%s of %s is missing", MappedAlmanaxFileName, version)
+	}
+	if err != nil {
+		p.log.Warn("could not download the published almanax to check it", "version", version, "error", err)
+		return false, nil
+	}
+
+	ds, err := almanax.Decode(data)
+	if err != nil {
+		return true, fmt.Errorf("%s of %s does not decode (%d bytes): %w", MappedAlmanaxFileName, version, len(data), err)
+	}
+	// a new version is not mapped until its first publish
+	if !ds.Mapped() {
+		return false, nil
+	}
+
+	if p.cfg.ContentAddressed {
+		err = checkPointer(p.repo, version, data)
+		if err != nil {
+			return true, err
+		}
+	}
+
+	horizon, err := loadHorizon(p.workdir)
+	if err != nil || horizon == "" {
+		return false, err
+	}
+	_, to := ds.Coverage()
+	if to < horizon {
+		return true, fmt.Errorf("%s of %s ends on %s, before the horizon %s", MappedAlmanaxFileName, version, to, horizon)
+	}
+
+	exempt := make(map[string]bool)
+	overrides, err := loadOverrides(p.workdir)
+	if err != nil {
+		return false, err
+	}
+	for date, override := range overrides {
+		exempt[date] = override.Skip
+	}
+	today := time.Now().In(p.cfg.location()).Format("2006-01-02")
+	dates, err := createDateRange(today, horizon)
+	if err != nil {
+		return false, err
+	}
+	return true, checkCoverage(ds, dates, exempt)
+}
+
+// checkPointer compares the published almanax with the size and hash its pointer asset announces. A pointer
+// that can not be downloaded is not checked.
+func checkPointer(repo dataRepo, version string, data []byte) error {
+	raw, _, err := downloadReleaseAsset(repo, version, MappedAlmanaxPointerFileName)
+	if err != nil {
+		return nil
+	}
+	var pointer assetPointer
+	err = json.Unmarshal(raw, &pointer)
+	if err != nil {
+		return fmt.Errorf("%s of %s does not decode: %w", MappedAlmanaxPointerFileName, version, err)
+	}
+	sum := sha256.Sum256(data)
+	if pointer.Size != len(data) || pointer.Sha256 != hex.EncodeToString(sum[:]) {
+		return fmt.Errorf("%s of %s has %d bytes, its pointer announces %d with another hash", MappedAlmanaxFileName, version, len(data), pointer.Size)
+	}
+	return nil
+}
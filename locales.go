@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const localesFileName = "locales.json"
+
+// localeLinkRegex matches the links to the almanax of a locale, relative or absolute.
+var localeLinkRegex = regexp.MustCompile(`^(?:https?://[^/]+)?/([a-z]{2})/almanax(?:[/?]|$)`)
+
+// localeState is what the locale discovery saw last and the locales it enabled for the cross check.
+type localeState struct {
+	Known   []string `json:"known"`
+	Enabled []string `json:"enabled,omitempty"`
+}
+
+func loadLocaleState(workdir string) (localeState, error) {
+	var state localeState
+	data, err := os.ReadFile(filepath.Join(stateDir(workdir), localesFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return state, err
+	}
+	err = json.Unmarshal(data, &state)
+	return state, err
+}
+
+func saveLocaleState(workdir string, state localeState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(stateDir(workdir), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(stateDir(workdir), localesFileName), data, 0644)
+}
+
+// extractLocales returns the locales an almanax page links to, from its alternate links and its language
+// switcher, sorted.
+func extractLocales(doc *goquery.Document) []string {
+	var locales []string
+	add := func(locale string) {
+		if locale != "" && !slices.Contains(locales, locale) {
+			locales = append(locales, locale)
+		}
+	}
+	doc.Find("link[rel=alternate][hreflang]").Each(func(_ int, link *goquery.Selection) {
+		hreflang, _ := link.Attr("hreflang")
+		locale, _, _ := strings.Cut(strings.ToLower(hreflang), "-")
+		if len(locale) == 2 {
+			add(locale)
+		}
+	})
+	doc.Find("a[href]").Each(func(_ int, link *goquery.Selection) {
+		href, _ := link.Attr("href")
+		if matches := localeLinkRegex.FindStringSubmatch(href); len(matches) > 1 {
+			add(matches[1])
+		}
+	})
+	slices.Sort(locales)
+	return locales
+}
+
+// discoverLocales requests the almanax of today in lang and returns the locales krosmoz offers it in.
+func discoverLocales(lang string, date string, timeout time.Duration) ([]string, error) {
+	html, status, err := fetchAlmanaxHtml(lang, date, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if status != 200 {
+		return nil, fmt.Errorf("krosmoz answered %d", status)
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(html))
+	if err != nil {
+		return nil, err
+	}
+	locales := extractLocales(doc)
+	if len(locales) == 0 {
+		return nil, fmt.Errorf("no locale links on the almanax page")
+	}
+	return locales, nil
+}
+
+// watchLocales looks for locales krosmoz added or removed every locale_check_interval and raises an alert
+// for each. With auto_enable_locales a new locale with a language pack is cross checked from then on.
+func (p *pipeline) watchLocales(ctx context.Context) {
+	state, err := loadLocaleState(p.workdir)
+	if err != nil {
+		p.log.Error("error reading discovered locales", "error", err)
+	}
+	for _, lang := range state.Enabled {
+		p.scraper.enableCrossCheck(lang)
+	}
+
+	for {
+		state = p.checkLocales(state)
+		if !sleepCtx(ctx, p.cfg.LocaleCheckInterval) {
+			return
+		}
+	}
+}
+
+// checkLocales compares the locales krosmoz offers now with the ones of the last check and saves them.
+func (p *pipeline) checkLocales(state localeState) localeState {
+	date := time.Now().In(p.cfg.location()).Format("2006-01-02")
+	locales, err := discoverLocales(p.cfg.ScrapeLanguages[0], date, p.cfg.ScrapeTimeout)
+	if err != nil {
+		p.log.Warn("could not discover krosmoz locales", "error", err)
+		return state
+	}
+
+	// the first check only learns what is there
+	if state.Known != nil {
+		for _, locale := range locales {
+			if slices.Contains(state.Known, locale) {
+				continue
+			}
+			_, supported := almanaxPagePatterns[locale]
+			p.log.Info("krosmoz offers a new almanax locale", "locale", locale, "language_pack", supported)
+			switch {
+			case p.scrapes(locale):
+				p.alerts.add(fmt.Sprintf("krosmoz offers the almanax in %s, it is scraped already", locale), nil)
+			case !supported:
+				p.alerts.add(fmt.Sprintf("krosmoz offers the almanax in %s, add a language pack to scrape it", locale), nil)
+			case p.cfg.AutoEnableLocales:
+				p.scraper.enableCrossCheck(locale)
+				if !slices.Contains(state.Enabled, locale) {
+					state.Enabled = append(state.Enabled, locale)
+				}
+				p.alerts.add(fmt.Sprintf("krosmoz offers the almanax in %s, cross checking it from now on", locale), nil)
+			default:
+				p.alerts.add(fmt.Sprintf("krosmoz offers the almanax in %s, add it to cross_check_languages to scrape it", locale), nil)
+			}
+		}
+		for _, locale := range state.Known {
+			if slices.Contains(locales, locale) {
+				continue
+			}
+			p.log.Warn("krosmoz no longer offers an almanax locale", "locale", locale)
+			if p.scrapes(locale) {
+				p.alerts.add(fmt.Sprintf("krosmoz no longer offers the almanax in %s, which is still scraped", locale), nil)
+			} else {
+				p.alerts.add(fmt.Sprintf("krosmoz no longer offers the almanax in %s", locale), nil)
+			}
+		}
+	}
+
+	state.Known = locales
+	err = saveLocaleState(p.workdir, state)
+	if err != nil {
+		p.log.Error("error saving discovered locales", "error", err)
+	}
+	return state
+}
+
+// scrapes reports whether a locale is a scrape or cross check language.
+func (p *pipeline) scrapes(locale string) bool {
+	return slices.Contains(p.cfg.ScrapeLanguages, locale) || slices.Contains(p.scraper.checkLanguages(), locale)
+}
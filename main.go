@@ -2,57 +2,65 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/PuerkitoBio/goquery"
 	"github.com/charmbracelet/log"
-	mapping "github.com/dofusdude/dodumap"
+	"github.com/dofusdude/alm-dates/almanax"
 	"github.com/google/go-github/v67/github"
-	"golang.org/x/exp/rand"
 )
 
-func isDate(date string) bool {
-	if len(date) != 10 {
-		return false
-	}
-	if date[4] != '-' || date[7] != '-' {
-		return false
-	}
-
-	// check if date is valid
-	var month, day, year int
-	_, err := fmt.Sscanf(date, "%d-%d-%d", &year, &month, &day)
-	if err != nil {
-		return false
-	}
-	if month < 1 || month > 12 {
-		return false
-	}
-	if day < 1 || day > 31 {
-		return false
-	}
+// isMonth checks a month like 2025-03.
+func isMonth(month string) bool {
+	return isDate(month + "-01")
+}
 
-	return true
+// isDate checks a date like 2025-03-01 that exists in the calendar, 2025-02-31 does not.
+func isDate(date string) bool {
+	_, err := time.Parse("2006-01-02", date)
+	return err == nil
 }
 
 const (
-	AlmanaxUrl               = "https://www.krosmoz.com/en/almanax"
-	DoduapiUpdateEndpointUrl = "https://api.dofusdu.de/dofus3/v1/update"
-	UserAgent                = "Mozilla/5.0 (Windows NT 6.1; rv:2.0b7) Gecko/20100101 Firefox/4.0b7"
-	DataRepoOwner            = "dofusdude"
-	DataRepoName             = "dofus3-main"
-	MappedAlmanaxFileName    = "MAPPED_ALMANAX.json"
+	AlmanaxUrl            = KrosmozUrl + "/en/almanax"
+	DoduapiBaseUrl        = "https://api.dofusdu.de"
+	DoduapiUrl            = DoduapiBaseUrl + "/dofus3/v1"
+	UserAgent             = "Mozilla/5.0 (Windows NT 6.1; rv:2.0b7) Gecko/20100101 Firefox/4.0b7"
+	DataRepoOwner         = "dofusdude"
+	DataRepoName          = "dofus3-main"
+	MappedAlmanaxFileName = "MAPPED_ALMANAX.json"
+
+	MappedAlmanaxPatchFileName      = "MAPPED_ALMANAX.patch.json"
+	MappedAlmanaxPointerFileName    = "MAPPED_ALMANAX.pointer.json"
+	MappedAlmanaxProvenanceFileName = "MAPPED_ALMANAX.provenance.json"
+	MappedAlmanaxEnrichedFileName   = "MAPPED_ALMANAX.enriched.json"
+	MappedAlmanaxFlavorFileName     = "MAPPED_ALMANAX.flavor.json"
+	MappedAlmanaxDiffFileName       = "MAPPED_ALMANAX.diff.json"
+	MappedAlmanaxMetadataFileName   = "MAPPED_ALMANAX.metadata.json"
+	AlmanaxHistoryFileName          = "ALMANAX_HISTORY.json"
 )
 
-var DoduapiUpdateToken string
+// dataRepo is a github repository whose releases carry the mapped almanax asset.
+type dataRepo struct {
+	owner string
+	name  string
+}
+
+var defaultDataRepo = dataRepo{owner: DataRepoOwner, name: DataRepoName}
+
+func (r dataRepo) String() string {
+	return r.owner + "/" + r.name
+}
 
 // ParseDuration parses a duration string.
 // examples: "10d", "-1.5w" or "3Y4M5d".
@@ -101,141 +109,310 @@ func ParseDuration(s string) (time.Duration, error) {
 	return sumDur, nil
 }
 
-func loadAlmanaxData(version string) ([]mapping.MappedMultilangNPCAlmanaxUnity, error) {
-	client := github.NewClient(nil)
+// FormatDuration renders a duration in the units of ParseDuration, largest first.
+// examples: "1y2w3d", "1M12h" or "90ms". ParseDuration reads the result back to the same duration.
+func FormatDuration(d time.Duration) string {
+	if d == 0 {
+		return "0s"
+	}
+
+	var sb strings.Builder
+	if d < 0 {
+		sb.WriteString("-")
+		d = -d
+	}
+
+	day := 24 * time.Hour
+	units := []struct {
+		unit string
+		size time.Duration
+	}{
+		{"y", 365 * day},
+		{"M", 30 * day},
+		{"w", 7 * day},
+		{"d", day},
+	}
+	for _, u := range units {
+		if n := d / u.size; n > 0 {
+			fmt.Fprintf(&sb, "%d%s", n, u.unit)
+			d -= n * u.size
+		}
+	}
 
-	repRel, _, err := client.Repositories.GetReleaseByTag(context.Background(), DataRepoOwner, DataRepoName, version)
+	// the rest is below a day, without the zero parts time.Duration prints
+	switch {
+	case d == 0:
+	case d%time.Hour == 0:
+		fmt.Fprintf(&sb, "%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		sb.WriteString(strings.TrimSuffix(d.String(), "0s"))
+	default:
+		sb.WriteString(d.String())
+	}
+	return sb.String()
+}
+
+// errAssetNotFound is returned for releases without the requested asset.
+var errAssetNotFound = errors.New("asset not found")
+
+// downloadAlmanaxAsset downloads the raw mapped almanax asset of a release.
+func downloadAlmanaxAsset(repo dataRepo, version string) ([]byte, time.Time, error) {
+	return downloadReleaseAsset(repo, version, MappedAlmanaxFileName)
+}
+
+// downloadReleaseAsset downloads a release asset by name with the time it was uploaded.
+func downloadReleaseAsset(repo dataRepo, version string, name string) ([]byte, time.Time, error) {
+	ctx := context.Background()
+	client := github.NewClient(httpClient)
+
+	var repRel *github.RepositoryRelease
+	err := retryPolicy.do(ctx, "get release", func() error {
+		var err error
+		repRel, _, err = client.Repositories.GetReleaseByTag(ctx, repo.owner, repo.name, version)
+		return githubRetryable(err)
+	})
 	if err != nil {
-		return nil, err
+		return nil, time.Time{}, err
 	}
 
-	// get the mapped almanax data
 	var assetId int64
+	var uploadedAt time.Time
 	assetId = -1
 	for _, asset := range repRel.Assets {
-		if asset.GetName() == MappedAlmanaxFileName {
+		if asset.GetName() == name {
 			assetId = asset.GetID()
+			uploadedAt = asset.GetUpdatedAt().Time
 			break
 		}
 	}
 
 	if assetId == -1 {
-		return nil, fmt.Errorf("could not find asset with name %s", MappedAlmanaxFileName)
+		return nil, time.Time{}, fmt.Errorf("%w: %s in %s", errAssetNotFound, name, version)
 	}
 
-	log.Info("downloading asset", "assetId", assetId)
-	httpClient := &http.Client{
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			// Automatically follow all redirects
-			return nil
-		},
+	log.Info("downloading asset", "name", name, "assetId", assetId)
+	var data []byte
+	err = retryPolicy.do(ctx, "download asset", func() error {
+		ctx, cancel := context.WithTimeout(ctx, downloadTimeout)
+		defer cancel()
+
+		asset, redirectUrl, err := client.Repositories.DownloadReleaseAsset(ctx, repo.owner, repo.name, assetId, httpClient)
+		if err != nil {
+			return githubRetryable(err)
+		}
+
+		if asset == nil {
+			return permanent(fmt.Errorf("asset is nil, redirect url: %s", redirectUrl))
+		}
+
+		defer asset.Close()
+
+		data, err = io.ReadAll(asset)
+		return err
+	})
+	if err != nil {
+		return nil, time.Time{}, err
 	}
-	asset, redirectUrl, err := client.Repositories.DownloadReleaseAsset(context.Background(), DataRepoOwner, DataRepoName, assetId, httpClient)
+
+	return data, uploadedAt, nil
+}
+
+func loadAlmanaxFile(path string) (*almanax.Dataset, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
+	return almanax.Decode(data)
+}
+
+// loadAlmanaxSource reads the mapped almanax from a local file if given, otherwise from the release
+// of the given version. An empty version resolves to the latest release.
+func loadAlmanaxSource(file string, version string) (*almanax.Dataset, error) {
+	if file != "" {
+		return loadAlmanaxFile(file)
+	}
 
-	if asset == nil {
-		return nil, fmt.Errorf("asset is nil, redirect url: %s", redirectUrl)
+	if version == "" {
+		var err error
+		version, err = getLatestVersion(defaultDataRepo)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	defer asset.Close()
+	return loadDataset(defaultDataRepo, version)
+}
 
-	var almData []mapping.MappedMultilangNPCAlmanaxUnity
-	dec := json.NewDecoder(asset)
-	err = dec.Decode(&almData)
+func getLatestVersion(repo dataRepo) (string, error) {
+	ghclient := github.NewClient(httpClient)
+	var repRel *github.RepositoryRelease
+	err := retryPolicy.do(context.Background(), "get latest release", func() error {
+		var err error
+		repRel, _, err = ghclient.Repositories.GetLatestRelease(context.Background(), repo.owner, repo.name)
+		return githubRetryable(err)
+	})
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	return almData, nil
+	return repRel.GetTagName(), nil
 }
 
-func updateAlmanaxRelease(almData []mapping.MappedMultilangNPCAlmanaxUnity, version string, ghToken string) error {
-	client := github.NewClient(nil).WithAuthToken(ghToken)
+// releaseAsset is a file attached to a data repo release next to the mapped almanax.
+type releaseAsset struct {
+	name  string
+	label string
+	data  []byte
+}
 
-	repRel, _, err := client.Repositories.GetReleaseByTag(context.Background(), DataRepoOwner, DataRepoName, version)
-	if err != nil {
-		return err
-	}
+// replaceReleaseAsset deletes the asset with the same name from the release and uploads the new one.
+func replaceReleaseAsset(ctx context.Context, client *github.Client, repRel *github.RepositoryRelease, asset releaseAsset, cfg *Config) error {
+	repo := cfg.dataRepo()
+	retry := cfg.retryPolicy()
 
-	// delete the old asset
-	for _, asset := range repRel.Assets {
-		if asset.GetName() == MappedAlmanaxFileName {
-			_, err = client.Repositories.DeleteReleaseAsset(context.Background(), "dofusdude", "dofus3-main", asset.GetID())
+	for _, old := range repRel.Assets {
+		if old.GetName() == asset.name {
+			err := health.fence()
+			if err != nil {
+				return err
+			}
+			err = retry.do(ctx, "delete asset", func() error {
+				_, err := client.Repositories.DeleteReleaseAsset(ctx, repo.owner, repo.name, old.GetID())
+				return githubRetryable(err)
+			})
 			if err != nil {
 				return err
 			}
 		}
 	}
 
-	// create the new asset
-	assetName := MappedAlmanaxFileName
-	assetLabel := MappedAlmanaxFileName
-	assetContentType := "application/json"
-	assetDataBytes, err := json.MarshalIndent(almData, "", "  ")
+	// the github client uploads from a file
+	assetFile, err := os.CreateTemp("", "alm-dates-*.json")
 	if err != nil {
 		return err
 	}
+	defer func() {
+		assetFile.Close()
+		_ = os.Remove(assetFile.Name())
+	}()
 
-	// write to file
-	assetFile, err := os.Create("tmp.json")
+	_, err = assetFile.Write(asset.data)
 	if err != nil {
 		return err
 	}
-	defer assetFile.Close()
 
-	_, err = assetFile.Write(assetDataBytes)
+	err = health.fence()
 	if err != nil {
 		return err
 	}
+	return retry.do(ctx, "upload asset", func() error {
+		ctx, cancel := context.WithTimeout(ctx, uploadTimeout(cfg, len(asset.data)))
+		defer cancel()
+
+		_, err := assetFile.Seek(0, io.SeekStart)
+		if err != nil {
+			return permanent(err)
+		}
+		_, _, err = client.Repositories.UploadReleaseAsset(ctx, repo.owner, repo.name, repRel.GetID(), &github.UploadOptions{
+			Name:      asset.name,
+			Label:     asset.label,
+			MediaType: "application/json",
+		}, assetFile)
+		return githubRetryable(err)
+	})
+}
 
-	assetFile, err = os.Open("tmp.json")
+// updateAlmanaxRelease replaces the mapped almanax of a release, uploads the extra assets after it and
+// notifies doduapi. The journal keeps the assets until it is done, nil publishes without one.
+func updateAlmanaxRelease(journal *publishJournal, assetDataBytes []byte, version string, cfg *Config, extra ...releaseAsset) error {
+	ctx := context.Background()
+	client := githubClient(uploadHttpClient(cfg), cfg)
+	repo := cfg.dataRepo()
+	retry := cfg.retryPolicy()
+
+	var repRel *github.RepositoryRelease
+	err := retry.do(ctx, "get release", func() error {
+		var err error
+		repRel, _, err = client.Repositories.GetReleaseByTag(ctx, repo.owner, repo.name, version)
+		return githubRetryable(err)
+	})
 	if err != nil {
 		return err
 	}
 
-	defer func() {
-		assetFile.Close()
-		_ = os.Remove("tmp.json")
-	}()
-
-	_, _, err = client.Repositories.UploadReleaseAsset(context.Background(), DataRepoOwner, DataRepoName, repRel.GetID(), &github.UploadOptions{
-		Name:      assetName,
-		Label:     assetLabel,
-		MediaType: assetContentType,
-	}, assetFile)
+	// a process that dies from here on leaves the journal, the next one completes the publish
+	assets := append([]releaseAsset{{name: MappedAlmanaxFileName, label: MappedAlmanaxFileName, data: assetDataBytes}}, extra...)
+	err = journal.begin(version, assets, cfg.DoduapiUpdateToken != "")
+	if err != nil {
+		return fmt.Errorf("error keeping publish journal: %w", err)
+	}
+	err = uploadReleaseAssets(ctx, client, repRel, assets, cfg, journal)
 	if err != nil {
 		return err
 	}
 
-	if DoduapiUpdateToken != "" {
-		body := fmt.Sprintf(`{"version":"%s"}`, version)
-		req, err := http.NewRequest("POST", fmt.Sprintf("%s/%s", DoduapiUpdateEndpointUrl, DoduapiUpdateToken), strings.NewReader(body))
+	if cfg.DoduapiUpdateToken != "" {
+		err = notifyDoduapi(ctx, version, cfg)
 		if err != nil {
 			return err
 		}
-		req.Header.Set("Content-Type", "application/json")
-		_, err = http.DefaultClient.Do(req)
+	}
+	return journal.finish()
+}
+
+// uploadReleaseAssets replaces the assets of a release in order and marks each uploaded in the journal.
+func uploadReleaseAssets(ctx context.Context, client *github.Client, repRel *github.RepositoryRelease, assets []releaseAsset, cfg *Config, journal *publishJournal) error {
+	for _, asset := range assets {
+		// readiness fails until the new almanax is uploaded
+		if asset.name == MappedAlmanaxFileName {
+			health.beginPublish()
+		}
+		err := replaceReleaseAsset(ctx, client, repRel, asset, cfg)
+		if asset.name == MappedAlmanaxFileName {
+			health.endPublish()
+		}
 		if err != nil {
 			return err
 		}
+		err = journal.uploaded(asset.name)
+		if err != nil {
+			return fmt.Errorf("error updating publish journal: %w", err)
+		}
 	}
+	return nil
+}
+
+// notifyDoduapi tells doduapi to load the new data of a version.
+func notifyDoduapi(ctx context.Context, version string, cfg *Config) error {
+	body := fmt.Sprintf(`{"version":"%s"}`, version)
+	return cfg.retryPolicy().do(ctx, "notify doduapi", func() error {
+		ctx, cancel := context.WithTimeout(ctx, cfg.NotifyTimeout)
+		defer cancel()
 
-	return err
+		req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/update/%s", cfg.doduapiUrl(), cfg.DoduapiUpdateToken), strings.NewReader(body))
+		if err != nil {
+			return permanent(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		res, err := httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		return statusRetryable(res)
+	})
 }
 
-func createDateRange(fromDate string, toDate string) []string {
+// createDateRange returns the dates from fromDate to toDate, both included.
+func createDateRange(fromDate string, toDate string) ([]string, error) {
 	start, err := time.Parse("2006-01-02", fromDate)
 	if err != nil {
-		fmt.Println("error parsing from date: ", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("invalid from date: %w", err)
 	}
 
 	end, err := time.Parse("2006-01-02", toDate)
 	if err != nil {
-		fmt.Println("error parsing to date: ", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("invalid to date: %w", err)
 	}
 
 	var dateRange []string
@@ -243,46 +420,7 @@ func createDateRange(fromDate string, toDate string) []string {
 		dateRange = append(dateRange, current.Format("2006-01-02"))
 	}
 
-	return dateRange
-}
-
-func getAlmOfferingReceiver(date string) string {
-	almUrl := fmt.Sprintf("%s/%s?game=dofus", AlmanaxUrl, date)
-	req, err := http.NewRequest("GET", almUrl, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-	req.Header.Set("User-Agent", UserAgent)
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Error("error sending request, waiting and trying again", "err", err, "url", almUrl, "date", date)
-		time.Sleep(1 * time.Minute)
-		return getAlmOfferingReceiver(date)
-	}
-	defer res.Body.Close()
-
-	if res.StatusCode == 202 {
-		log.Info("date not yet available, waiting and trying again")
-		time.Sleep(1 * time.Minute)
-		return getAlmOfferingReceiver(date)
-	}
-
-	if res.StatusCode != 200 {
-		log.Fatalf("status code error: %d %s", res.StatusCode, res.Status)
-	}
-
-	doc, err := goquery.NewDocumentFromReader(res.Body)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	var receiver string
-	expr := regexp.MustCompile(`Quest: Offering for (\w+)`)
-	matches := expr.FindStringSubmatch(doc.Text())
-	if len(matches) > 1 {
-		receiver = matches[1]
-	}
-	return receiver
+	return dateRange, nil
 }
 
 type AlmApiData struct {
@@ -292,12 +430,12 @@ type AlmApiData struct {
 	Bonus          string `json:"description"`
 	BonusType      string `json:"bonus"`
 	Language       string `json:"language"`
-	ItemPictureUrl string `json:"item_picture_url"`
+	ItemPictureUrl string `json:"item_picture_url,omitempty"`
 	RewardKamas    int    `json:"reward_kamas"`
 }
 
 func loadLocalVersion(workdir string) (string, error) {
-	path := path.Join(workdir, "version")
+	path := path.Join(stateDir(workdir), "version")
 	file, err := os.Open(path)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -317,7 +455,7 @@ func loadLocalVersion(workdir string) (string, error) {
 }
 
 func saveLocalVersion(version string, workdir string) error {
-	path := path.Join(workdir, "version")
+	path := path.Join(stateDir(workdir), "version")
 	file, err := os.Create(path)
 	if err != nil {
 		return err
@@ -332,49 +470,6 @@ func saveLocalVersion(version string, workdir string) error {
 	return nil
 }
 
-func updateChan(ctx context.Context, interval time.Duration, update chan string, workdir string, readyForUpdate chan bool) {
-	timer := time.NewTicker(interval)
-
-	isReady := true
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case receivedReady := <-readyForUpdate:
-			isReady = receivedReady
-		case <-timer.C:
-			if !isReady {
-				continue
-			}
-
-			ghclient := github.NewClient(nil)
-			repRel, _, err := ghclient.Repositories.GetLatestRelease(context.Background(), DataRepoOwner, DataRepoName)
-			if err != nil {
-				log.Fatal("error getting latest gh release: ", err)
-				return
-			}
-
-			currentVersion := repRel.GetTagName()
-
-			localVersion, err := loadLocalVersion(workdir)
-			if err != nil {
-				log.Fatal("error loading local version: ", err)
-				return
-			}
-
-			if currentVersion != localVersion {
-				err = saveLocalVersion(currentVersion, workdir)
-				if err != nil {
-					log.Fatal("error saving local version: ", err)
-					return
-				}
-				update <- currentVersion
-			}
-		}
-	}
-}
-
 func parseWd(dir string) (string, error) {
 	var err error
 
@@ -401,111 +496,280 @@ func parseWd(dir string) (string, error) {
 }
 
 func main() {
-	cwd := os.Getenv("PWD")
-	var err error
-	if cwd == "" {
-		cwd, err = parseWd(".")
-	} else {
-		cwd, err = parseWd(cwd)
-	}
-	if err != nil {
-		log.Fatal("error parsing working directory: ", "error", err)
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		switch os.Args[1] {
+		case "export-site":
+			exportSiteCommand(os.Args[2:])
+			return
+		case "serve":
+			serveCommand(os.Args[2:])
+			return
+		case "offerings":
+			offeringsCommand(os.Args[2:])
+			return
+		case "config":
+			configCommand(os.Args[2:])
+			return
+		case "state":
+			stateCommand(os.Args[2:])
+			return
+		case "selfcheck":
+			selfcheckCommand(os.Args[2:])
+			return
+		case "smoke":
+			smokeCommand(os.Args[2:])
+			return
+		case "replay":
+			replayCommand(os.Args[2:])
+			return
+		case "yoy":
+			yoyCommand(os.Args[2:])
+			return
+		case "trigger":
+			triggerCommand(os.Args[2:])
+			return
+		case "prestop":
+			prestopCommand(os.Args[2:])
+			return
+		case "service":
+			serviceCommand(os.Args[2:])
+			return
+		case "dashboard":
+			dashboardCommand(os.Args[2:])
+			return
+		case "bundle":
+			bundleCommand(os.Args[2:])
+			return
+		case "plan":
+			planCommand(os.Args[2:])
+			return
+		case "map":
+			mapCommand(os.Args[2:])
+			return
+		case "verify":
+			verifyCommand(os.Args[2:])
+			return
+		default:
+			log.Fatal("unknown command", "command", os.Args[1])
+		}
 	}
 
-	ghAuthKey := os.Getenv("GH_AUTH_KEY")
-	if ghAuthKey == "" {
-		log.Fatal("no github auth key found")
+	if isService() {
+		runService(os.Args[1:])
+		return
 	}
 
-	DoduapiUpdateToken = os.Getenv("DODUAPI_UPDATE_TOKEN")
+	runDaemon(os.Args[1:])
+}
 
-	pollIntervalStr := os.Getenv("POLLING_INTERVAL")
-	if pollIntervalStr == "" {
-		pollIntervalStr = "5m"
+// runDaemon maps new game versions until the process is stopped.
+func runDaemon(args []string) {
+	cfg, sources, err := loadConfig(flag.NewFlagSet("alm-dates", flag.ExitOnError), args)
+	if err != nil {
+		log.Fatal("error loading config", "error", err)
 	}
 
-	endDurationStr := os.Getenv("END_DURATION")
-	if endDurationStr == "" {
-		endDurationStr = "1y"
+	if level, err := log.ParseLevel(cfg.LogLevel); err == nil {
+		log.SetLevel(level)
 	}
+	logConfig(&cfg, sources)
 
-	endDuration, err := ParseDuration(endDurationStr)
+	pipelines, err := loadPipelines(&cfg)
 	if err != nil {
-		log.Fatal("error parsing end duration: ", "error", err)
+		log.Fatal("error setting up pipelines", "error", err)
 	}
 
-	pollIerval, err := time.ParseDuration(pollIntervalStr)
-	if err != nil {
-		log.Fatal("error parsing polling interval: ", "error", err)
+	setupProcess(&cfg, pipelines)
+	startReaper()
+	go handleSignals()
+
+	if cfg.HealthAddr != "" {
+		go serveHealth(cfg.HealthAddr)
 	}
 
-	update := make(chan string)
-	context := context.Background()
-	readyForUpdate := make(chan bool)
-	go updateChan(context, pollIerval, update, cwd, readyForUpdate)
+	if cfg.DashboardAddr != "" {
+		go serveDashboard(cfg.DashboardAddr, cfg.DashboardToken, pipelines)
+	}
 
-	for {
-		select {
-		case <-context.Done():
-			return
-		case version := <-update:
+	if cfg.LeaseName != "" {
+		elector, err := newLeaseElector(cfg.LeaseName, cfg.LeaseNamespace, cfg.LeaseDuration)
+		if err != nil {
+			log.Fatal("error setting up leader election", "error", err)
+		}
+		health.lease = elector
+		go elector.run(context.Background())
+	}
 
-			readyForUpdate <- false
-			log.Info("update detected", "version", version)
+	var wg sync.WaitGroup
+	for _, p := range pipelines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := p.run(context.Background())
+			if err != nil {
+				p.degrade("pipeline stopped", err)
+			}
+		}()
+	}
+	wg.Wait()
+	log.Fatal("every pipeline stopped, see the errors above")
+}
 
-			func() {
-				defer func() {
-					readyForUpdate <- true
-					log.Info("ready for next update")
-				}()
+// setupProcess applies the settings shared by all pipelines of the process, the krosmoz and github budgets
+// included.
+func setupProcess(cfg *Config, pipelines []*pipeline) {
+	shutdownGrace = cfg.ShutdownGrace
+	retryPolicy = cfg.retryPolicy()
+	downloadTimeout = cfg.DownloadTimeout
+	krosmozLimiter = newTokenBucket(cfg.ScrapeRate, cfg.ScrapeWorkers)
+	krosmozThrottled = newKrosmozThrottle(retryPolicy, cfg.ThrottleMaxWait)
+	krosmozBudget = newRequestBudget(cfg.ScrapeBudget)
+	githubQuota = newGithubQuotaBudget(cfg.GithubQuotaReserve, cfg.GithubWriteInterval)
+	for _, p := range pipelines {
+		githubQuota.register(p.cfg.GhAuthKey, p.repo.String())
+		githubQuota.register(p.cfg.GhAuthKeySecondary, p.repo.String())
+	}
+	krosmozJitter = [2]time.Duration{cfg.ScrapeJitterMin, cfg.ScrapeJitterMax}
+	nameTolerance = cfg.NameTolerance
+	var err error
+	scrapeUserAgents, err = newUserAgentPool(cfg.UserAgents, cfg.UserAgentsFile)
+	if err != nil {
+		log.Fatal("error reading user agents", "error", err)
+	}
+	scrapeProxies = newProxyRotation(*cfg)
+	httpClient = newHttpClient(*cfg)
+	err = installFaults(cfg)
+	if err != nil {
+		log.Fatal("error setting up fault injection", "error", err)
+	}
+}
 
-				almData, err := loadAlmanaxData(version)
-				if err != nil {
-					log.Fatal("error loading almanax data: ", "error", err)
-				}
+// scrapedDate is the receiver a date resolved to, or why it could not be scraped.
+type scrapedDate struct {
+	receiver   int
+	source     provenanceEntry
+	mismatches []languageMismatch
+	err        error
+}
 
-				// map the data
-				today := time.Now()
-				inYear := today.Add(endDuration)
-				fromDate := today.Format("2006-01-02")
-				toDate := inYear.Format("2006-01-02")
+func scrapeDate(ds *almanax.Dataset, date string, scraper *scraper, aliases map[string]string) scrapedDate {
+	page, lang, fetchedAt, err := scraper.scrape(date)
+	if err != nil {
+		return scrapedDate{err: err}
+	}
 
-				dateRange := createDateRange(fromDate, toDate)
+	i := matchAlmanaxPage(ds, lang, page, aliases)
+	if candidates := fuzzyReceivers(ds, page.Receiver, aliases); i == -1 && len(candidates) > 1 {
+		ambiguous := ambiguousReceiverError{Date: date, Lang: lang, Receiver: page.Receiver}
+		for _, candidate := range candidates {
+			ambiguous.Candidates = append(ambiguous.Candidates, ds.Receivers[candidate].Name)
+		}
+		return scrapedDate{err: ambiguous}
+	}
+	if i == -1 {
+		mismatch := receiverMismatchError{Date: date, Lang: lang, Receiver: page.Receiver, Item: page.Item}
+		if suggested := matchOffering(ds, lang, page); suggested != -1 {
+			mismatch.Suggestion = ds.Receivers[suggested].Name
+		}
+		return scrapedDate{err: mismatch}
+	}
 
-				if len(almData[0].Days) != 0 && almData[0].Days[0] != "" {
-					log.Info("data already mapped, skipping", "version", version)
-					return
-				}
+	scraper.bonus.check(ds, date, lang, page, i)
+	return scrapedDate{
+		receiver: i,
+		source:   provenanceEntry{Url: almanaxPageUrl(lang, date), Lang: lang, FetchedAt: fetchedAt, Quantity: page.Quantity, Kamas: page.Kamas},
+		// the pages are not translated at the same time, a lagging language shows the wrong receiver
+		mismatches: scraper.crossCheck(ds, date, lang, i, aliases),
+	}
+}
 
-				log.Info("Mapping...")
-				start := time.Now()
-
-				for _, date := range dateRange {
-					offeringReceiverKrozmoz := getAlmOfferingReceiver(date)
-
-					found := false
-					for i, almDataLocal := range almData {
-						if almDataLocal.OfferingReceiver == offeringReceiverKrozmoz {
-							found = true
-							almData[i].Days = append(almData[i].Days, date)
-							break
-						}
-					}
-					if !found {
-						log.Fatal("could not find offering receiver: ", offeringReceiverKrozmoz)
-					}
-
-					time.Sleep(time.Duration(rand.Intn(2)+1) * time.Second)
+// mapDates scrapes the dates with scrape_workers concurrent workers and adds each to the days of its
+// receiver. Dates in the checkpoint are taken from it and every scraped date is recorded there, so after
+// an error the dates before it are not scraped again. Dates krosmoz did not generate yet, with an ambiguous
+// receiver or a receiver that matches nothing are left out, any other failure stops the mapping.
+func mapDates(ds *almanax.Dataset, dates []string, scraper *scraper, aliases map[string]string, cp *checkpoint) (provenance, error) {
+	sources := make(provenance)
+	// restored and month view dates are added out of order
+	if cp.len() > 0 || scraper.monthly {
+		defer ds.SortDays()
+	}
+	dates = cp.restore(ds, dates, sources)
+
+	disagreements := 0
+	if scraper.monthly {
+		dates, disagreements = mapMonths(ds, dates, scraper, aliases, sources, cp)
+	}
+
+	// the workers only read the dataset, the days are added here in the order of the dates
+	results := make([]chan scrapedDate, len(dates))
+	for n := range results {
+		results[n] = make(chan scrapedDate, 1)
+	}
+	next := make(chan int)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		defer close(next)
+		for n := range dates {
+			// the dates after a run limit are not scraped, the mapping stops at the first of them
+			if err := scraper.limits.exceeded(); err != nil {
+				for rest := n; rest < len(dates); rest++ {
+					results[rest] <- scrapedDate{err: err}
 				}
+				return
+			}
+			select {
+			case next <- n:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	for range scraper.workers {
+		go func() {
+			for n := range next {
+				results[n] <- scrapeDate(ds, dates[n], scraper, aliases)
+			}
+		}()
+	}
 
-				log.Info("Mapping done", "duration", time.Since(start))
-
-				err = updateAlmanaxRelease(almData, version, ghAuthKey)
-				if err != nil {
-					log.Fatal("error updating almanax release: ", err)
-				}
-			}()
+	for n, date := range dates {
+		result := <-results[n]
+		var scrapeErr scrapeError
+		if errors.As(result.err, &scrapeErr) && scrapeErr.unavailable() {
+			// krosmoz did not generate the page yet, a later backfill or horizon extension maps it
+			log.Warn("date unavailable, leaving it unmapped", "date", date, "url", scrapeErr.Url, "status", scrapeErr.Status, "attempts", scrapeErr.Attempts)
+			continue
+		}
+		var ambiguous ambiguousReceiverError
+		if errors.As(result.err, &ambiguous) {
+			// a guess could publish the wrong receiver, the date waits for an alias
+			log.Warn("receiver is ambiguous, leaving the date unmapped for review", "date", date, "receiver", ambiguous.Receiver, "candidates", ambiguous.Candidates)
+			scraper.review.add(ambiguous)
+			continue
+		}
+		var mismatch receiverMismatchError
+		if errors.As(result.err, &mismatch) {
+			// one unknown receiver should not throw away the other dates, the mapping reports it at the end
+			log.Error("offering receiver not found, leaving the date unmapped", "date", date, "lang", mismatch.Lang, "receiver", mismatch.Receiver, "item", mismatch.Item, "suggestion", mismatch.Suggestion)
+			scraper.unmatched.add(mismatch)
+			continue
+		}
+		if result.err != nil {
+			return sources, result.err
+		}
+		ds.Receivers[result.receiver].Days = append(ds.Receivers[result.receiver].Days, date)
+		sources[date] = result.source
+		cp.record(date, ds.Receivers[result.receiver].Name, result.source)
 
+		for _, mismatch := range result.mismatches {
+			log.Error("languages disagree on the receiver", "date", date, "mapped", mismatch.Mapped, "from", result.source.Lang, "lang", mismatch.Lang, "scraped", mismatch.Scraped, "resolved", mismatch.Resolved)
+			disagreements++
 		}
 	}
+
+	if disagreements > 0 {
+		log.Warn("languages disagreed on receivers, the mapping language was kept", "disagreements", disagreements)
+	}
+	return sources, nil
 }
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatDurationRoundTrip(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "0s"},
+		{90 * time.Millisecond, "90ms"},
+		{1500 * time.Microsecond, "1.5ms"},
+		{time.Nanosecond, "1ns"},
+		{45 * time.Second, "45s"},
+		{90 * time.Second, "1m30s"},
+		{5 * time.Minute, "5m"},
+		{time.Hour + 30*time.Minute, "1h30m"},
+		{time.Hour + 500*time.Millisecond, "1h0m0.5s"},
+		{12 * time.Hour, "12h"},
+		{day, "1d"},
+		{8 * day, "1w1d"},
+		{30*day + 12*time.Hour, "1M12h"},
+		{365*day + 2*7*day + 3*day, "1y2w3d"},
+		{2*365*day + 5*time.Minute + 7*time.Second, "2y5m7s"},
+		{-36 * time.Hour, "-1d12h"},
+		{-90 * time.Millisecond, "-90ms"},
+	}
+	for _, test := range tests {
+		formatted := FormatDuration(test.d)
+		if formatted != test.want {
+			t.Errorf("FormatDuration(%v) = %q, want %q", test.d, formatted, test.want)
+		}
+		parsed, err := ParseDuration(formatted)
+		if err != nil {
+			t.Errorf("ParseDuration(%q): %v", formatted, err)
+			continue
+		}
+		if parsed != test.d {
+			t.Errorf("ParseDuration(FormatDuration(%v)) = %v via %q", test.d, parsed, formatted)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		s    string
+		want time.Duration
+	}{
+		{"10d", 10 * day},
+		{"10D", 10 * day},
+		{"-1.5w", -(10*day + 12*time.Hour)},
+		{"3Y4M5d", 3*365*day + 4*30*day + 5*day},
+		{"2W", 14 * day},
+		{"1h30m", time.Hour + 30*time.Minute},
+		{"250ms", 250 * time.Millisecond},
+	}
+	for _, test := range tests {
+		got, err := ParseDuration(test.s)
+		if err != nil {
+			t.Errorf("ParseDuration(%q): %v", test.s, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseDuration(%q) = %v, want %v", test.s, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maintenanceWindow is an announced krosmoz maintenance.
+type maintenanceWindow struct {
+	Name  string    `json:"name"`
+	From  time.Time `json:"from"`
+	Until time.Time `json:"until"`
+}
+
+func (w maintenanceWindow) covers(t time.Time) bool {
+	return !t.Before(w.From) && t.Before(w.Until)
+}
+
+// maintenanceSchedule holds the windows the last poll of maintenance_feed announced.
+type maintenanceSchedule struct {
+	mu      sync.Mutex
+	windows []maintenanceWindow
+}
+
+func (s *maintenanceSchedule) set(windows []maintenanceWindow) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.windows = windows
+}
+
+// current returns the window krosmoz is in maintenance for right now.
+func (s *maintenanceSchedule) current() (maintenanceWindow, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, window := range s.windows {
+		if window.covers(now) {
+			return window, true
+		}
+	}
+	return maintenanceWindow{}, false
+}
+
+// statusPage is the scheduled maintenance list of a statuspage.io status page, like
+// /api/v2/scheduled-maintenances/upcoming.json.
+type statusPage struct {
+	ScheduledMaintenances []struct {
+		Name           string    `json:"name"`
+		Status         string    `json:"status"`
+		ScheduledFor   time.Time `json:"scheduled_for"`
+		ScheduledUntil time.Time `json:"scheduled_until"`
+	} `json:"scheduled_maintenances"`
+}
+
+type rssFeed struct {
+	Items []struct {
+		Title       string `xml:"title"`
+		Description string `xml:"description"`
+		PubDate     string `xml:"pubDate"`
+	} `xml:"channel>item"`
+}
+
+// maintenanceZone is a time zone as announcements write it.
+const maintenanceZone = `(Z|UTC|GMT|[+-]\d{2}:?\d{2})?`
+
+// maintenanceStartRegex finds the announced start in an rss item, like 2025-03-04 06:00 UTC, and
+// maintenanceEndRegex an end right after it, like "- 10:00" or "to 2025-03-05 02:00 UTC".
+var (
+	maintenanceStartRegex = regexp.MustCompile(`(\d{4}-\d{2}-\d{2})[ T](\d{2}:\d{2})(?::\d{2})?\s*` + maintenanceZone)
+	maintenanceEndRegex   = regexp.MustCompile(`^\s*(?:-|–|to|until)\s*(?:(\d{4}-\d{2}-\d{2})[ T])?(\d{2}:\d{2})(?::\d{2})?\s*` + maintenanceZone)
+)
+
+// parseAnnouncedWindow reads the window an rss item announces. Without a time zone the times are taken as
+// UTC, without an end the window lasts assumed. It is false if the item names no start.
+func parseAnnouncedWindow(text string, assumed time.Duration) (time.Time, time.Time, bool) {
+	start := maintenanceStartRegex.FindStringSubmatchIndex(text)
+	if start == nil {
+		return time.Time{}, time.Time{}, false
+	}
+	group := func(match []int, i int, s string) string {
+		if match[2*i] < 0 {
+			return ""
+		}
+		return s[match[2*i]:match[2*i+1]]
+	}
+	date, clock, zone := group(start, 1, text), group(start, 2, text), group(start, 3, text)
+
+	rest := text[start[1]:]
+	end := maintenanceEndRegex.FindStringSubmatchIndex(rest)
+	endDate, endClock := date, ""
+	if end != nil {
+		if d := group(end, 1, rest); d != "" {
+			endDate = d
+		}
+		endClock = group(end, 2, rest)
+		if zone == "" {
+			zone = group(end, 3, rest)
+		}
+	}
+
+	location := time.UTC
+	if zone != "" && zone != "Z" && zone != "UTC" && zone != "GMT" {
+		offset, err := time.Parse("-0700", strings.Replace(zone, ":", "", 1))
+		if err != nil {
+			return time.Time{}, time.Time{}, false
+		}
+		_, seconds := offset.Zone()
+		location = time.FixedZone(zone, seconds)
+	}
+
+	from, err := time.ParseInLocation("2006-01-02 15:04", date+" "+clock, location)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	until := from.Add(assumed)
+	if endClock != "" {
+		parsed, err := time.ParseInLocation("2006-01-02 15:04", endDate+" "+endClock, location)
+		if err == nil {
+			// an end without a date past midnight is on the next day
+			if !parsed.After(from) && endDate == date {
+				parsed = parsed.AddDate(0, 0, 1)
+			}
+			if parsed.After(from) {
+				until = parsed
+			}
+		}
+	}
+	return from, until, true
+}
+
+// parseMaintenance reads the windows of a status page or an rss feed. Status page maintenances carry their
+// window, an rss item that mentions a maintenance has to name its start, announcements are published well
+// before the maintenance.
+func parseMaintenance(data []byte, assumed time.Duration) ([]maintenanceWindow, error) {
+	var windows []maintenanceWindow
+
+	var page statusPage
+	if json.Unmarshal(data, &page) == nil && page.ScheduledMaintenances != nil {
+		for _, m := range page.ScheduledMaintenances {
+			if m.Status == "completed" || m.ScheduledFor.IsZero() {
+				continue
+			}
+			until := m.ScheduledUntil
+			if until.IsZero() {
+				until = m.ScheduledFor.Add(assumed)
+			}
+			windows = append(windows, maintenanceWindow{Name: m.Name, From: m.ScheduledFor, Until: until})
+		}
+		return windows, nil
+	}
+
+	var feed rssFeed
+	err := xml.Unmarshal(data, &feed)
+	if err != nil {
+		return nil, errors.New("neither a status page nor an rss feed")
+	}
+	for _, item := range feed.Items {
+		text := item.Title + " " + item.Description
+		if !strings.Contains(strings.ToLower(text), "maintenance") {
+			continue
+		}
+		from, until, ok := parseAnnouncedWindow(text, assumed)
+		if !ok {
+			continue
+		}
+		windows = append(windows, maintenanceWindow{Name: strings.TrimSpace(item.Title), From: from, Until: until})
+	}
+	return windows, nil
+}
+
+func fetchMaintenance(ctx context.Context, cfg *Config) ([]maintenanceWindow, error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.NotifyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cfg.MaintenanceFeed, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("maintenance feed answered %d", res.StatusCode)
+	}
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	return parseMaintenance(data, cfg.MaintenanceDuration)
+}
+
+// watchMaintenance polls maintenance_feed every maintenance_check_interval. A failed poll keeps the
+// windows of the last one.
+func (p *pipeline) watchMaintenance(ctx context.Context) {
+	for {
+		windows, err := fetchMaintenance(ctx, &p.cfg)
+		if err != nil {
+			p.log.Warn("could not read maintenance feed", "error", err)
+		} else {
+			p.maintenance.set(windows)
+			if window, ok := p.maintenance.current(); ok {
+				p.log.Info("krosmoz is in announced maintenance, jobs requesting it wait", "maintenance", window.Name, "until", window.Until.Format(time.RFC3339))
+			}
+		}
+
+		if !sleepCtx(ctx, p.cfg.MaintenanceCheckInterval) {
+			return
+		}
+	}
+}
+
+// duringMaintenance fails a command that requests krosmoz while an announced maintenance runs. Commands
+// do not wait like the daemon jobs, the operator runs them again later.
+func (p *pipeline) duringMaintenance() error {
+	if p.cfg.MaintenanceFeed == "" {
+		return nil
+	}
+	windows, err := fetchMaintenance(context.Background(), &p.cfg)
+	if err != nil {
+		p.log.Warn("could not read maintenance feed", "error", err)
+		return nil
+	}
+	p.maintenance.set(windows)
+	if window, ok := p.maintenance.current(); ok {
+		return fmt.Errorf("krosmoz is in announced maintenance %q until %s", window.Name, window.Until.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// krosmozFailure reports whether a job error comes from krosmoz not answering, which an announced
+// maintenance explains.
+func krosmozFailure(err error) bool {
+	var scrapeErr scrapeError
+	var throttled throttledError
+	return errors.As(err, &scrapeErr) || errors.As(err, &throttled)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseAnnouncedWindow(t *testing.T) {
+	at := func(s string) time.Time {
+		parsed, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	tests := []struct {
+		text  string
+		ok    bool
+		from  time.Time
+		until time.Time
+	}{
+		{"Maintenance on 2025-03-04 06:00 UTC", true, at("2025-03-04T06:00:00Z"), at("2025-03-04T08:00:00Z")},
+		{"Maintenance 2025-03-04 06:00 - 10:00", true, at("2025-03-04T06:00:00Z"), at("2025-03-04T10:00:00Z")},
+		{"Maintenance 2025-03-04T07:00+01:00 until 11:30", true, at("2025-03-04T06:00:00Z"), at("2025-03-04T10:30:00Z")},
+		{"Maintenance 2025-03-04 23:00 to 02:00 UTC", true, at("2025-03-04T23:00:00Z"), at("2025-03-05T02:00:00Z")},
+		{"Maintenance 2025-03-04 23:00 to 2025-03-05 04:00", true, at("2025-03-04T23:00:00Z"), at("2025-03-05T04:00:00Z")},
+		{"Maintenance next tuesday morning", false, time.Time{}, time.Time{}},
+	}
+	for _, test := range tests {
+		from, until, ok := parseAnnouncedWindow(test.text, 2*time.Hour)
+		if ok != test.ok || !from.Equal(test.from) || !until.Equal(test.until) {
+			t.Errorf("parseAnnouncedWindow(%q) = %s %s %v, want %s %s %v", test.text, from, until, ok, test.from, test.until, test.ok)
+		}
+	}
+}
+
+func TestParseMaintenanceRss(t *testing.T) {
+	feed := `<rss><channel>
+<item><title>Maintenance announced</title><description>Servers are down on 2025-03-04 06:00 UTC - 10:00 UTC.</description><pubDate>Fri, 28 Feb 2025 10:00:00 +0000</pubDate></item>
+<item><title>Maintenance soon</title><description>More news later.</description><pubDate>Fri, 28 Feb 2025 10:00:00 +0000</pubDate></item>
+<item><title>New event 2025-03-04 06:00</title><description>Have fun.</description></item>
+</channel></rss>`
+	windows, err := parseMaintenance([]byte(feed), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(windows) != 1 {
+		t.Fatalf("got %d windows, want only the one with an announced start", len(windows))
+	}
+	window := windows[0]
+	if !window.From.Equal(time.Date(2025, 3, 4, 6, 0, 0, 0, time.UTC)) || !window.Until.Equal(time.Date(2025, 3, 4, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("window is %s to %s, want the announced one and not the publication", window.From, window.Until)
+	}
+}
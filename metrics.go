@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricDef is a metric of GET /metrics. Names and labels are stable, dashboards and alerts rely on them,
+// and the README documents them. Summaries are exposed as a _sum and a _count series.
+type metricDef struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+const (
+	metricCounter = "counter"
+	metricGauge   = "gauge"
+	metricSummary = "summary"
+)
+
+// The phases of a mapping job for alm_phase_duration_seconds.
+const (
+	phaseCanary    = "canary"
+	phaseMap       = "map"
+	phaseValidate  = "validate"
+	phaseSecondary = "secondary"
+	phasePublish   = "publish"
+)
+
+var (
+	metricJobs           = metricDef{"alm_jobs_total", "Finished jobs by kind and result, ok or failed.", metricCounter, []string{"game", "tenant", "kind", "result"}}
+	metricPhaseDuration  = metricDef{"alm_phase_duration_seconds", "Time spent in a phase of a job: canary, map, validate, secondary or publish.", metricSummary, []string{"game", "tenant", "phase"}}
+	metricDatesMapped    = metricDef{"alm_dates_mapped_total", "Dates mapped by the strategy that mapped them.", metricCounter, []string{"game", "tenant", "strategy"}}
+	metricScrapeRequests = metricDef{"alm_scrape_requests_total", "Krosmoz requests by page language and answer status, error for requests without an answer.", metricCounter, []string{"language", "status"}}
+	metricQueueDepth     = metricDef{"alm_job_queue_depth", "Jobs waiting in the queue, the running one not included.", metricGauge, []string{"game", "tenant"}}
+	metricOldestWait     = metricDef{"alm_job_oldest_wait_seconds", "How long the longest waiting job is queued.", metricGauge, []string{"game", "tenant"}}
+	metricJobWait        = metricDef{"alm_job_wait_seconds", "Time from queueing a job until it started.", metricSummary, []string{"game", "tenant", "kind"}}
+	metricJobsShed       = metricDef{"alm_jobs_shed_total", "New jobs a full queue coalesced, dropped or rejected, by the action taken.", metricCounter, []string{"game", "tenant", "action"}}
+	metricProxyRequests  = metricDef{"alm_scrape_proxy_requests_total", "Krosmoz requests sent through a proxy.", metricCounter, []string{"proxy"}}
+	metricProxyFailures  = metricDef{"alm_scrape_proxy_failures_total", "Krosmoz requests through a proxy that failed or were blocked.", metricCounter, []string{"proxy"}}
+	metricProxyCooling   = metricDef{"alm_scrape_proxy_cooling_down", "Whether a proxy is skipped after failing.", metricGauge, []string{"proxy"}}
+	metricTimeToServe    = metricDef{"alm_time_to_serve_seconds", "Time from detecting a game version until doduapi serves its mapping.", metricSummary, []string{"game", "tenant"}}
+	metricLiveCorrupt    = metricDef{"alm_live_asset_corrupt", "Whether the last check found the published almanax corrupt or truncated.", metricGauge, []string{"game", "tenant"}}
+	metricTokenExpiry    = metricDef{"alm_github_token_expiry_timestamp_seconds", "Unix time the github token of a pipeline expires at, missing for tokens without expiration.", metricGauge, []string{"game", "tenant"}}
+)
+
+// metricDefs are all metrics in the order they are exposed and exported to the grafana dashboard.
+var metricDefs = []metricDef{
+	metricJobs,
+	metricPhaseDuration,
+	metricDatesMapped,
+	metricScrapeRequests,
+	metricQueueDepth,
+	metricOldestWait,
+	metricJobWait,
+	metricJobsShed,
+	metricProxyRequests,
+	metricProxyFailures,
+	metricProxyCooling,
+	metricTokenExpiry,
+	metricTimeToServe,
+	metricLiveCorrupt,
+}
+
+// metricsRegistry holds the values of the metrics by series name and label values. Values that are
+// state, like the queue depth, are set by collectors when the metrics are read.
+type metricsRegistry struct {
+	mu         sync.Mutex
+	values     map[string]map[string]float64
+	collectors []func()
+}
+
+var metrics = &metricsRegistry{values: make(map[string]map[string]float64)}
+
+func (m *metricsRegistry) update(series string, labels []string, fn func(float64) float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.values[series] == nil {
+		m.values[series] = make(map[string]float64)
+	}
+	key := strings.Join(labels, "\xff")
+	m.values[series][key] = fn(m.values[series][key])
+}
+
+// add increases a counter.
+func (m *metricsRegistry) add(def metricDef, value float64, labels ...string) {
+	m.update(def.name, labels, func(v float64) float64 { return v + value })
+}
+
+// set sets a gauge, or a counter a collector keeps itself.
+func (m *metricsRegistry) set(def metricDef, value float64, labels ...string) {
+	m.update(def.name, labels, func(float64) float64 { return value })
+}
+
+// observe adds a value to a summary.
+func (m *metricsRegistry) observe(def metricDef, value float64, labels ...string) {
+	m.update(def.name+"_sum", labels, func(v float64) float64 { return v + value })
+	m.update(def.name+"_count", labels, func(v float64) float64 { return v + 1 })
+}
+
+// setSummary sets a summary a collector keeps itself.
+func (m *metricsRegistry) setSummary(def metricDef, sum float64, count int, labels ...string) {
+	m.update(def.name+"_sum", labels, func(float64) float64 { return sum })
+	m.update(def.name+"_count", labels, func(float64) float64 { return float64(count) })
+}
+
+// timePhase starts timing a phase of a job of the pipeline, the returned function ends it.
+func (p *pipeline) timePhase(phase string) func() {
+	start := time.Now()
+	return func() {
+		metrics.observe(metricPhaseDuration, time.Since(start).Seconds(), p.cfg.Game, p.name, phase)
+	}
+}
+
+// collect registers a function that sets the state metrics before they are read.
+func (m *metricsRegistry) collect(fn func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.collectors = append(m.collectors, fn)
+}
+
+// collectQueue reports the state of the job queue of a pipeline.
+func (m *metricsRegistry) collectQueue(game string, tenant string, q *jobQueue) {
+	m.collect(func() {
+		snap := q.snapshot()
+		m.set(metricQueueDepth, float64(snap.depth), game, tenant)
+		m.set(metricOldestWait, snap.oldestWait.Seconds(), game, tenant)
+		for kind, count := range snap.stats.started {
+			m.setSummary(metricJobWait, snap.stats.waitSeconds[kind], count, game, tenant, string(kind))
+		}
+		for action, count := range snap.stats.shed {
+			m.set(metricJobsShed, float64(count), game, tenant, action)
+		}
+	})
+}
+
+func (m *metricsRegistry) collectProxies() {
+	for _, proxy := range scrapeProxies.status() {
+		m.set(metricProxyRequests, float64(proxy.requests), proxy.proxy)
+		m.set(metricProxyFailures, float64(proxy.failures), proxy.proxy)
+		cooling := 0.0
+		if proxy.coolingDown {
+			cooling = 1
+		}
+		m.set(metricProxyCooling, cooling, proxy.proxy)
+	}
+}
+
+// handleMetrics writes the metrics in the prometheus text format.
+func (m *metricsRegistry) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	collectors := slices.Clone(m.collectors)
+	m.mu.Unlock()
+	for _, collect := range collectors {
+		collect()
+	}
+	m.collectProxies()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	for _, def := range metricDefs {
+		fmt.Fprintf(&b, "# HELP %s %s\n", def.name, def.help)
+		fmt.Fprintf(&b, "# TYPE %s %s\n", def.name, def.kind)
+		series := []string{def.name}
+		if def.kind == metricSummary {
+			series = []string{def.name + "_sum", def.name + "_count"}
+		}
+		for _, name := range series {
+			for _, key := range sortedKeys(m.values[name]) {
+				fmt.Fprintf(&b, "%s{%s} %g\n", name, formatLabels(def.labels, strings.Split(key, "\xff")), m.values[name][key])
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = w.Write([]byte(b.String()))
+}
+
+func formatLabels(names []string, values []string) string {
+	pairs := make([]string, 0, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=%q", name, value))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func sortedKeys[K ~string, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
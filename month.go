@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/charmbracelet/log"
+	"github.com/dofusdude/alm-dates/almanax"
+)
+
+// monthCellSelector finds the calendar cell around a day link of the month view.
+const monthCellSelector = "td, li, article"
+
+var dayLinkRegex = regexp.MustCompile(`/almanax/(\d{4}-\d{2}-\d{2})`)
+
+// monthPageUrl is the krosmoz calendar of a month like 2025-03.
+func monthPageUrl(lang string, month string) string {
+	return fmt.Sprintf("%s/%s/almanax/%s?game=dofus", KrosmozUrl, lang, month)
+}
+
+// monthDay is a day cell of the month view.
+type monthDay struct {
+	page almanaxPage
+	html string
+}
+
+// extractMonthPage reads the receiver and offering of every day cell of a month view, by date.
+func extractMonthPage(doc *goquery.Document, lang string) map[string]monthDay {
+	days := make(map[string]monthDay)
+	if _, ok := almanaxPagePatterns[lang]; !ok {
+		return days
+	}
+
+	doc.Find("a[href]").Each(func(_ int, link *goquery.Selection) {
+		href, _ := link.Attr("href")
+		matches := dayLinkRegex.FindStringSubmatch(href)
+		if len(matches) < 2 {
+			return
+		}
+		if _, ok := days[matches[1]]; ok {
+			return
+		}
+
+		cell := link.ParentsFiltered(monthCellSelector).First()
+		if cell.Length() == 0 {
+			cell = link
+		}
+		// a cell linking several dates is not a day of the calendar, like the navigation of a day page
+		if dayLinks(cell) != 1 {
+			return
+		}
+		var page almanaxPage
+		extractQuest(cell.Text(), lang, &page)
+		if page.Receiver == "" {
+			return
+		}
+		html, _ := goquery.OuterHtml(cell)
+		days[matches[1]] = monthDay{page: page, html: html}
+	})
+	return days
+}
+
+// dayLinks counts the different dates a selection links to.
+func dayLinks(sel *goquery.Selection) int {
+	dates := make(map[string]bool)
+	sel.Find("a[href]").AddSelection(sel.Filter("a[href]")).Each(func(_ int, link *goquery.Selection) {
+		href, _ := link.Attr("href")
+		if matches := dayLinkRegex.FindStringSubmatch(href); len(matches) > 1 {
+			dates[matches[1]] = true
+		}
+	})
+	return len(dates)
+}
+
+// fetchMonth requests and extracts a month view, nil if krosmoz does not serve it.
+func (s *scraper) fetchMonth(lang string, month string) (map[string]monthDay, error) {
+	var html []byte
+	var status int
+	err := s.retry.do(context.Background(), "scrape month", func() error {
+		var err error
+		s.limits.request()
+		html, status, err = fetchKrosmozHtml(lang, monthPageUrl(lang, month), s.timeout)
+		return err
+	})
+	if err != nil || status != 200 {
+		return nil, err
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(html))
+	if err != nil {
+		return nil, err
+	}
+	return extractMonthPage(doc, lang), nil
+}
+
+// scrapeMonth returns the days of a month view in the first language that serves it, with that language.
+// The cells are kept for replay, next to the day pages.
+func (s *scraper) scrapeMonth(month string) (map[string]monthDay, string) {
+	for _, lang := range s.languages {
+		days, err := s.fetchMonth(lang, month)
+		if err != nil {
+			log.Warn("could not scrape month", "month", month, "lang", lang, "error", err)
+			continue
+		}
+		if len(days) == 0 {
+			log.Debug("month view unavailable", "month", month, "lang", lang)
+			continue
+		}
+
+		if s.pages != "" {
+			for date, day := range days {
+				err = saveMonthCell(s.pages, lang, date, []byte(day.html))
+				if err != nil {
+					log.Warn("error keeping page for replay", "date", date, "lang", lang, "error", err)
+				}
+			}
+		}
+		return days, lang
+	}
+	return nil, ""
+}
+
+// mapMonths maps the dates from one month view request per month and returns the dates that still need a
+// request per day, because the view did not show them or they could not be matched, and the number of
+// receivers the cross check languages disagree on. The mapped dates are added to sources.
+func mapMonths(ds *almanax.Dataset, dates []string, scraper *scraper, aliases map[string]string, sources provenance, cp *checkpoint) ([]string, int) {
+	var months []string
+	byMonth := make(map[string][]string)
+	for _, date := range dates {
+		month := date[:7]
+		if _, ok := byMonth[month]; !ok {
+			months = append(months, month)
+		}
+		byMonth[month] = append(byMonth[month], date)
+	}
+
+	var left []string
+	disagreements := 0
+	for n, month := range months {
+		// the day requests report the limit, they get the dates of the months left
+		if scraper.limits.exceeded() != nil {
+			for _, rest := range months[n:] {
+				left = append(left, byMonth[rest]...)
+			}
+			break
+		}
+		days, lang := scraper.scrapeMonth(month)
+		fetchedAt := time.Now().UTC()
+		mapped := make(map[string]int)
+		for _, date := range byMonth[month] {
+			day, ok := days[date]
+			if !ok {
+				left = append(left, date)
+				continue
+			}
+			i := matchAlmanaxPage(ds, lang, day.page, aliases)
+			if i == -1 {
+				left = append(left, date)
+				continue
+			}
+			scraper.bonus.check(ds, date, lang, day.page, i)
+			ds.Receivers[i].Days = append(ds.Receivers[i].Days, date)
+			mapped[date] = i
+			sources[date] = provenanceEntry{Url: monthPageUrl(lang, month), Lang: lang, FetchedAt: fetchedAt, Quantity: day.page.Quantity, Kamas: day.page.Kamas}
+			cp.record(date, ds.Receivers[i].Name, sources[date])
+		}
+		log.Info("month scraped", "month", month, "lang", lang, "mapped", len(mapped), "left", len(byMonth[month])-len(mapped))
+
+		if len(mapped) > 0 {
+			for _, other := range scraper.checkLanguages() {
+				if other == lang {
+					continue
+				}
+				otherDays, err := scraper.fetchMonth(other, month)
+				if err != nil {
+					log.Warn("could not cross check month", "month", month, "lang", other, "error", err)
+					continue
+				}
+				for date, i := range mapped {
+					day, ok := otherDays[date]
+					if !ok {
+						continue
+					}
+					if mismatch, ok := compareLanguage(ds, date, other, day.page, i, aliases); !ok {
+						log.Error("languages disagree on the receiver", "date", date, "mapped", mismatch.Mapped, "from", lang, "lang", mismatch.Lang, "scraped", mismatch.Scraped, "resolved", mismatch.Resolved)
+						disagreements++
+						continue
+					}
+					scraper.bonus.check(ds, date, other, day.page, i)
+				}
+			}
+		}
+	}
+	return left, disagreements
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/charmbracelet/log"
+	"github.com/dofusdude/alm-dates/almanax"
+)
+
+// diacriticFolds maps accented latin letters to their base letter. It covers the languages krosmoz is
+// available in and the latin extended letters that show up in names borrowed from other languages.
+var diacriticFolds = strings.NewReplacer(
+	"à", "a", "á", "a", "â", "a", "ã", "a", "ä", "a", "å", "a", "ā", "a", "ă", "a", "ą", "a",
+	"ç", "c", "ć", "c", "č", "c",
+	"ď", "d", "đ", "d", "ð", "d",
+	"è", "e", "é", "e", "ê", "e", "ë", "e", "ē", "e", "ė", "e", "ę", "e", "ě", "e",
+	"ğ", "g",
+	"ì", "i", "í", "i", "î", "i", "ï", "i", "ī", "i", "ı", "i",
+	"ł", "l",
+	"ñ", "n", "ń", "n", "ň", "n",
+	"ò", "o", "ó", "o", "ô", "o", "õ", "o", "ö", "o", "ø", "o", "ō", "o", "ő", "o",
+	"ř", "r",
+	"ś", "s", "š", "s", "ş", "s",
+	"ť", "t", "ţ", "t",
+	"ù", "u", "ú", "u", "û", "u", "ü", "u", "ū", "u", "ů", "u", "ű", "u",
+	"ý", "y", "ÿ", "y",
+	"ź", "z", "ż", "z", "ž", "z",
+	"æ", "ae", "œ", "oe", "ß", "ss", "þ", "th",
+)
+
+// normalizeName makes receiver and item names comparable: lower case without diacritics, apostrophes and
+// hyphens, with single spaces between words. "Chafer Lancier", "chafer-lancier" and "Châfer  Lancier"
+// are all "chafer lancier".
+func normalizeName(name string) string {
+	name = diacriticFolds.Replace(strings.ToLower(name))
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r == '\'' || r == '’' || r == '`' || r == '´':
+			return -1
+		case r == '-' || unicode.IsSpace(r):
+			return ' '
+		case unicode.Is(unicode.Mn, r):
+			return -1
+		}
+		return r
+	}, name)
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// parseReceiverAliases reads "scraped=mapped" pairs into a lookup of normalized names.
+func parseReceiverAliases(pairs []string) map[string]string {
+	aliases := make(map[string]string)
+	for _, pair := range pairs {
+		scraped, mapped, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		aliases[normalizeName(scraped)] = normalizeName(mapped)
+	}
+	return aliases
+}
+
+// sameReceiver compares a scraped receiver with a mapped one after normalization and alias lookup.
+func sameReceiver(scraped string, mapped string, aliases map[string]string) bool {
+	scraped = normalizeName(scraped)
+	if alias, ok := aliases[scraped]; ok {
+		scraped = alias
+	}
+	return scraped != "" && scraped == normalizeName(mapped)
+}
+
+// nameTolerance is the edit distance up to which a scraped receiver name still matches a mapped one, set
+// from name_tolerance.
+var nameTolerance = 1
+
+// fuzzyMinLength is the shortest normalized name matched with nameTolerance, one edit turns a short name
+// into another one too easily.
+const fuzzyMinLength = 6
+
+// findReceiver returns the index of the mapped entry for a scraped receiver or -1.
+func findReceiver(ds *almanax.Dataset, scraped string, aliases map[string]string) int {
+	for i := range ds.Receivers {
+		if sameReceiver(scraped, ds.Receivers[i].Name, aliases) {
+			return i
+		}
+	}
+	return -1
+}
+
+// fuzzyReceivers returns the entries closest to a scraped receiver within nameTolerance edits.
+func fuzzyReceivers(ds *almanax.Dataset, scraped string, aliases map[string]string) []int {
+	name := normalizeName(scraped)
+	if alias, ok := aliases[name]; ok {
+		name = alias
+	}
+	if nameTolerance <= 0 || len([]rune(name)) < fuzzyMinLength {
+		return nil
+	}
+
+	var candidates []int
+	best := nameTolerance + 1
+	for i := range ds.Receivers {
+		distance := editDistance(name, normalizeName(ds.Receivers[i].Name))
+		switch {
+		case distance > nameTolerance:
+		case distance < best:
+			best = distance
+			candidates = []int{i}
+		case distance == best:
+			candidates = append(candidates, i)
+		}
+	}
+	return candidates
+}
+
+// editDistance is the levenshtein distance of two strings in runes.
+func editDistance(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := range ra {
+		current[0] = i + 1
+		for j := range rb {
+			cost := 1
+			if ra[i] == rb[j] {
+				cost = 0
+			}
+			current[j+1] = min(previous[j+1]+1, current[j]+1, previous[j]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+// matchAlmanaxPage returns the index of the mapped entry for a scraped page or -1. The seed only has english
+// receiver names, so pages in other languages that don't match by name or alias are bridged through the
+// multilang offering item names and quantity, and the bonus text if several receivers want the same item.
+// A name that still matches nothing matches the only entry within nameTolerance edits, several at the same
+// distance match none.
+func matchAlmanaxPage(ds *almanax.Dataset, lang string, page almanaxPage, aliases map[string]string) int {
+	if i := findReceiver(ds, page.Receiver, aliases); i != -1 {
+		return i
+	}
+	if lang != "en" {
+		if i := matchOffering(ds, lang, page); i != -1 {
+			return i
+		}
+	}
+	if candidates := fuzzyReceivers(ds, page.Receiver, aliases); len(candidates) == 1 {
+		log.Debug("receiver matched despite a typo", "scraped", page.Receiver, "mapped", ds.Receivers[candidates[0]].Name, "lang", lang)
+		return candidates[0]
+	}
+	return -1
+}
+
+// matchOffering returns the index of the only mapped entry that wants the offering of a scraped page, by
+// the item name and quantity and the bonus text if several want the same item, or -1.
+func matchOffering(ds *almanax.Dataset, lang string, page almanaxPage) int {
+	item := normalizeName(page.Item)
+	if item == "" {
+		return -1
+	}
+
+	var candidates []int
+	for i := range ds.Receivers {
+		offering := ds.Receivers[i].Offering
+		if normalizeName(offering.ItemName[lang]) == item && (page.Quantity == 0 || offering.Quantity == page.Quantity) {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+
+	bonus := normalizeName(page.Bonus)
+	if bonus == "" {
+		return -1
+	}
+
+	match := -1
+	for _, i := range candidates {
+		mapped := normalizeName(ds.Receivers[i].Bonus.Description[lang])
+		if mapped != "" && strings.Contains(bonus, mapped) {
+			if match != -1 {
+				return -1
+			}
+			match = i
+		}
+	}
+	return match
+}
+
+// receiverMismatchError is a scraped receiver that matches no mapped one by name, alias or offering.
+// Suggestion is the mapped receiver that wants the same offering, empty if there is none or several.
+type receiverMismatchError struct {
+	Date       string `json:"date"`
+	Lang       string `json:"lang"`
+	Receiver   string `json:"receiver"`
+	Item       string `json:"item"`
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+func (e receiverMismatchError) Error() string {
+	msg := fmt.Sprintf("could not find offering receiver %q (item %q) of %s in %s", e.Receiver, e.Item, e.Date, e.Lang)
+	if e.Suggestion != "" {
+		msg += fmt.Sprintf(", the offering suggests %q", e.Suggestion)
+	}
+	return msg
+}
+
+// ambiguousReceiverError is a scraped receiver that is as close to several mapped ones, the date is left
+// for review instead of guessing.
+type ambiguousReceiverError struct {
+	Date       string   `json:"date"`
+	Lang       string   `json:"lang"`
+	Receiver   string   `json:"receiver"`
+	Candidates []string `json:"candidates"`
+}
+
+func (e ambiguousReceiverError) Error() string {
+	return fmt.Sprintf("offering receiver %q of %s in %s is as close to %s", e.Receiver, e.Date, e.Lang, strings.Join(e.Candidates, ", "))
+}
+
+// alias is the receiver_aliases pair that would resolve the mismatch, empty without a suggestion.
+func (e receiverMismatchError) alias() string {
+	if e.Suggestion == "" || normalizeName(e.Receiver) == "" {
+		return ""
+	}
+	return e.Receiver + "=" + e.Suggestion
+}
+
+// reviewList collects the ambiguous receivers of a job for its run record.
+type reviewList struct {
+	mu        sync.Mutex
+	ambiguous []ambiguousReceiverError
+}
+
+func (r *reviewList) add(ambiguous ambiguousReceiverError) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ambiguous = append(r.ambiguous, ambiguous)
+}
+
+// dates returns the dates of the collected receivers, they stay collected.
+func (r *reviewList) dates() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var dates []string
+	for _, ambiguous := range r.ambiguous {
+		dates = append(dates, ambiguous.Date)
+	}
+	return dates
+}
+
+// take returns the collected receivers and starts over.
+func (r *reviewList) take() []ambiguousReceiverError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ambiguous := r.ambiguous
+	r.ambiguous = nil
+	return ambiguous
+}
+
+// unmatchedReceiversError is a mapping that left dates unmapped because their receiver matches nothing. It
+// unwraps to the mismatch of every date, so the playbook sees them.
+type unmatchedReceiversError struct {
+	Dates []receiverMismatchError
+}
+
+func (e unmatchedReceiversError) Error() string {
+	return fmt.Sprintf("%d dates match no offering receiver, first %s", len(e.Dates), e.Dates[0].Error())
+}
+
+func (e unmatchedReceiversError) Unwrap() []error {
+	errs := make([]error, len(e.Dates))
+	for n, mismatch := range e.Dates {
+		errs[n] = mismatch
+	}
+	return errs
+}
+
+// unmatchedList collects the dates of a job whose receiver matches nothing, for the report at the end of
+// the mapping and the run record.
+type unmatchedList struct {
+	mu       sync.Mutex
+	mismatch []receiverMismatchError
+}
+
+func (u *unmatchedList) add(mismatch receiverMismatchError) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.mismatch = append(u.mismatch, mismatch)
+}
+
+// list returns the collected dates by date, they stay collected.
+func (u *unmatchedList) list() []receiverMismatchError {
+	u.mu.Lock()
+	mismatch := slices.Clone(u.mismatch)
+	u.mu.Unlock()
+	return sortMismatches(mismatch)
+}
+
+// take returns the collected dates by date and starts over.
+func (u *unmatchedList) take() []receiverMismatchError {
+	u.mu.Lock()
+	mismatch := u.mismatch
+	u.mismatch = nil
+	u.mu.Unlock()
+	return sortMismatches(mismatch)
+}
+
+func sortMismatches(mismatch []receiverMismatchError) []receiverMismatchError {
+	slices.SortFunc(mismatch, func(a, b receiverMismatchError) int {
+		return strings.Compare(a.Date, b.Date)
+	})
+	return mismatch
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/dofusdude/alm-dates/almanax"
+)
+
+func TestNormalizeName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Chafer Lancier", "chafer lancier"},
+		{"chafer-lancier", "chafer lancier"},
+		{"Châfer  Lancier", "chafer lancier"},
+		{" Maître\tCorbac ", "maitre corbac"},
+		{"Kerub's Crepe", "kerubs crepe"},
+		{"Kerub’s Crêpe", "kerubs crepe"},
+		{"Oeil de Grœnland", "oeil de groenland"},
+		{"Straße", "strasse"},
+		// a combining accent instead of the precomposed letter
+		{"Café", "cafe"},
+		{"", ""},
+		{"'-'", ""},
+	}
+	for _, test := range tests {
+		if got := normalizeName(test.name); got != test.want {
+			t.Errorf("normalizeName(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestExtractReceiver(t *testing.T) {
+	tests := []struct {
+		lang string
+		text string
+		want string
+	}{
+		{"en", "Quest: Offering for Antho\nFind 3 Gobball Wool and take the offering to Antho", "Antho"},
+		{"en", "Quest:   Offering for  Chafer Lancier\n", "Chafer Lancier"},
+		{"en", "Quest: Offering for Kerub's Apprentice-Smith.", "Kerub's Apprentice-Smith"},
+		{"fr", "Quête : Offrande à Maître Corbac’s\nRécupérer", "Maître Corbac’s"},
+		{"de", "Quest: Opfergabe an Ügly Gürtelwolf", "Ügly Gürtelwolf"},
+		{"es", "Misión: Ofrenda a Señor Ñu", "Señor Ñu"},
+		{"pt", "Missão: Oferenda para João", "João"},
+		// digits and punctuation end the name
+		{"en", "Quest: Offering for Boss2000", "Boss"},
+		{"en", "Quest: Offering for", ""},
+		{"en", "Quête : Offrande à Antho", ""},
+	}
+	for _, test := range tests {
+		if got := extractReceiver(test.text, test.lang); got != test.want {
+			t.Errorf("extractReceiver(%q, %s) = %q, want %q", test.text, test.lang, got, test.want)
+		}
+	}
+}
+
+func TestExtractOffering(t *testing.T) {
+	tests := []struct {
+		lang     string
+		text     string
+		quantity int
+		item     string
+	}{
+		{"en", "Find 3 Gobball Wool and take the offering to Antho", 3, "Gobball Wool"},
+		{"en", "Find  12   Kerub's Crêpe and take the offering to Antho", 12, "Kerub's Crêpe"},
+		{"fr", "Récupérer 7 Laine de Bouftou et rapporter l'offrande à Antho", 7, "Laine de Bouftou"},
+		{"en", "Find some Gobball Wool and take the offering to Antho", 0, ""},
+	}
+	for _, test := range tests {
+		quantity, item := extractOffering(test.text, test.lang)
+		if quantity != test.quantity || item != test.item {
+			t.Errorf("extractOffering(%q, %s) = %d %q, want %d %q", test.text, test.lang, quantity, item, test.quantity, test.item)
+		}
+	}
+}
+
+func TestSameReceiver(t *testing.T) {
+	aliases := parseReceiverAliases([]string{"Maitre Corbac=Master Crow", "broken pair", " Kérub = Kerub the Crepe "})
+	tests := []struct {
+		scraped string
+		mapped  string
+		want    bool
+	}{
+		{"Chafer-Lancier", "Chafer Lancier", true},
+		{"CHAFER LANCIER", "chafer lancier", true},
+		{"Maître Corbac", "Master Crow", true},
+		{"maitre-corbac", "master crow", true},
+		{"Kerub", "Kerub the Crepe", true},
+		{"Master Crow", "Maitre Corbac", false},
+		{"Chafer", "Chafer Lancier", false},
+		{"", "", false},
+		{"broken pair", "", false},
+	}
+	for _, test := range tests {
+		if got := sameReceiver(test.scraped, test.mapped, aliases); got != test.want {
+			t.Errorf("sameReceiver(%q, %q) = %v, want %v", test.scraped, test.mapped, got, test.want)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"antho", "antho", 0},
+		{"antho", "anthos", 1},
+		{"antho", "anhto", 2},
+		{"kitten", "sitting", 3},
+		{"éa", "ea", 1},
+		{"", "abc", 3},
+	}
+	for _, test := range tests {
+		if got := editDistance(test.a, test.b); got != test.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+		if got := editDistance(test.b, test.a); got != test.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", test.b, test.a, got, test.want)
+		}
+	}
+}
+
+func TestMatchAlmanaxPage(t *testing.T) {
+	ds := &almanax.Dataset{Receivers: []almanax.Receiver{
+		{Name: "Chafer Lancier", Offering: almanax.Offering{ItemName: almanax.Text{"en": "Bone", "fr": "Os"}, Quantity: 3}},
+		{Name: "Gobball Keeper", Offering: almanax.Offering{ItemName: almanax.Text{"en": "Wool", "fr": "Laine"}, Quantity: 5}, Bonus: almanax.Bonus{Description: almanax.Text{"fr": "Plus de laine"}}},
+		{Name: "Gobball Reeper", Offering: almanax.Offering{ItemName: almanax.Text{"en": "Wool", "fr": "Laine"}, Quantity: 5}, Bonus: almanax.Bonus{Description: almanax.Text{"fr": "Moins de laine"}}},
+		{Name: "Antho", Offering: almanax.Offering{ItemName: almanax.Text{"en": "Flower", "fr": "Fleur"}, Quantity: 1}},
+	}}
+	aliases := parseReceiverAliases([]string{"Lancier Chafer=Chafer Lancier"})
+	tests := []struct {
+		name string
+		lang string
+		page almanaxPage
+		want int
+	}{
+		{"exact name", "en", almanaxPage{Receiver: "Chafer Lancier"}, 0},
+		{"normalized name", "en", almanaxPage{Receiver: "chafer-lancier"}, 0},
+		{"alias", "en", almanaxPage{Receiver: "Lancier Chafer"}, 0},
+		{"offering in another language", "fr", almanaxPage{Receiver: "Chafer Lancier d'Os", Item: "Os", Quantity: 3}, 0},
+		{"offering with another quantity", "fr", almanaxPage{Receiver: "Chafer Lancier d'Os", Item: "Os", Quantity: 4}, -1},
+		{"offering told apart by the bonus", "fr", almanaxPage{Receiver: "Gardien", Item: "Laine", Quantity: 5, Bonus: "Aujourd'hui : moins de laine !"}, 2},
+		{"offering several want", "fr", almanaxPage{Receiver: "Gardien", Item: "Laine", Quantity: 5}, -1},
+		{"offering is not compared in english", "en", almanaxPage{Receiver: "Someone", Item: "Bone", Quantity: 3}, -1},
+		{"typo", "en", almanaxPage{Receiver: "Chafer Lancer"}, 0},
+		{"typo as close to two", "en", almanaxPage{Receiver: "Gobball Meeper"}, -1},
+		{"short names match exactly", "en", almanaxPage{Receiver: "Anth"}, -1},
+		{"two typos", "en", almanaxPage{Receiver: "Chafr Lancer"}, -1},
+	}
+	for _, test := range tests {
+		if got := matchAlmanaxPage(ds, test.lang, test.page, aliases); got != test.want {
+			t.Errorf("%s: matchAlmanaxPage(%+v) = %d, want %d", test.name, test.page, got, test.want)
+		}
+	}
+
+	candidates := fuzzyReceivers(ds, "Gobball Meeper", aliases)
+	if !slices.Equal(candidates, []int{1, 2}) {
+		t.Errorf("fuzzyReceivers = %v, want both gobball receivers", candidates)
+	}
+}
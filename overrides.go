@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/dofusdude/alm-dates/almanax"
+)
+
+const overridesFileName = "overrides.json"
+
+// strategyOverride is the provenance strategy of dates taken from the overrides file. It is not a
+// configurable strategy, the overrides always come first.
+const strategyOverride = "override"
+
+// dateOverride corrects a date krosmoz shows wrong or not at all: the receiver it gets, or skip to leave
+// it unmapped.
+type dateOverride struct {
+	Receiver string `json:"receiver,omitempty"`
+	Skip     bool   `json:"skip,omitempty"`
+	// Reason is for the operators, it is logged with the override
+	Reason string `json:"reason,omitempty"`
+}
+
+func overridesPath(workdir string) string {
+	return filepath.Join(stateDir(workdir), overridesFileName)
+}
+
+// loadOverrides reads the overrides of the workdir by date, empty if there is no overrides file.
+func loadOverrides(workdir string) (map[string]dateOverride, error) {
+	data, err := os.ReadFile(overridesPath(workdir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var overrides map[string]dateOverride
+	err = json.Unmarshal(data, &overrides)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", overridesFileName, err)
+	}
+	for date, override := range overrides {
+		if !isDate(date) {
+			return nil, fmt.Errorf("%s: invalid date %q, expected YYYY-MM-DD", overridesFileName, date)
+		}
+		if override.Skip == (override.Receiver != "") {
+			return nil, fmt.Errorf("%s: %s needs either a receiver or skip", overridesFileName, date)
+		}
+	}
+	return overrides, nil
+}
+
+// resolve returns the index of the receiver of an override, -1 for a skipped date.
+func (o dateOverride) resolve(ds *almanax.Dataset, date string, aliases map[string]string) (int, error) {
+	if o.Skip {
+		return -1, nil
+	}
+	i := findReceiver(ds, o.Receiver, aliases)
+	if i == -1 {
+		return -1, fmt.Errorf("%s: receiver %q of %s is not in the data", overridesFileName, o.Receiver, date)
+	}
+	return i, nil
+}
+
+// overrideStrategy maps the dates of the overrides file before any other strategy, skipped dates are
+// neither mapped nor left to the others.
+type overrideStrategy struct {
+	overrides map[string]dateOverride
+	aliases   map[string]string
+}
+
+func (overrideStrategy) name() string {
+	return strategyOverride
+}
+
+func (o overrideStrategy) mapDates(ds *almanax.Dataset, dates []string, sources provenance) ([]string, error) {
+	var left []string
+	for _, date := range dates {
+		override, ok := o.overrides[date]
+		if !ok {
+			left = append(left, date)
+			continue
+		}
+		i, err := override.resolve(ds, date, o.aliases)
+		if err != nil {
+			return nil, err
+		}
+		log.Info("date overridden", "date", date, "receiver", override.Receiver, "skip", override.Skip, "reason", override.Reason)
+		if i == -1 {
+			continue
+		}
+		ds.Receivers[i].Days = append(ds.Receivers[i].Days, date)
+		sources[date] = provenanceEntry{FetchedAt: time.Now().UTC(), Strategy: strategyOverride}
+	}
+	return left, nil
+}
+
+// clearOverridden removes the dates of a range whose receiver differs from their override, so a published
+// release is corrected without mapping the dates that are right again.
+func clearOverridden(ds *almanax.Dataset, dates []string, overrides map[string]dateOverride, aliases map[string]string) {
+	if len(overrides) == 0 {
+		return
+	}
+	days := almanax.NewIndex(ds)
+	var wrong []string
+	for _, date := range dates {
+		override, ok := overrides[date]
+		if !ok {
+			continue
+		}
+		receiver, mapped := days.Day(date)
+		if mapped && (override.Skip || !sameReceiver(override.Receiver, receiver.Name, aliases)) {
+			wrong = append(wrong, date)
+		}
+	}
+	clearDates(ds, wrong)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/dofusdude/alm-dates/almanax"
+	"github.com/google/go-github/v67/github"
+)
+
+// patchOp is a JSON Patch (RFC 6902) operation.
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+func escapePointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
+
+// diffJSON appends the operations that turn a into b. Arrays of the same length are compared by element,
+// others are replaced as a whole.
+func diffJSON(path string, a any, b any, ops *[]patchOp) error {
+	switch av := a.(type) {
+	case map[string]any:
+		bv, ok := b.(map[string]any)
+		if !ok {
+			break
+		}
+
+		keys := make([]string, 0, len(av)+len(bv))
+		for key := range av {
+			keys = append(keys, key)
+		}
+		for key := range bv {
+			if _, ok := av[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			child := path + "/" + escapePointer(key)
+			aChild, inA := av[key]
+			bChild, inB := bv[key]
+			switch {
+			case !inB:
+				*ops = append(*ops, patchOp{Op: "remove", Path: child})
+			case !inA:
+				err := appendValueOp(ops, "add", child, bChild)
+				if err != nil {
+					return err
+				}
+			default:
+				err := diffJSON(child, aChild, bChild, ops)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	case []any:
+		bv, ok := b.([]any)
+		if !ok || len(av) != len(bv) {
+			break
+		}
+		for i := range av {
+			err := diffJSON(path+"/"+strconv.Itoa(i), av[i], bv[i], ops)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if reflect.DeepEqual(a, b) {
+		return nil
+	}
+	return appendValueOp(ops, "replace", path, b)
+}
+
+func appendValueOp(ops *[]patchOp, op string, path string, value any) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	*ops = append(*ops, patchOp{Op: op, Path: path, Value: raw})
+	return nil
+}
+
+// jsonPatch returns the JSON Patch from the old to the new document.
+func jsonPatch(oldData []byte, newData []byte) ([]patchOp, error) {
+	var oldDoc, newDoc any
+	err := json.Unmarshal(oldData, &oldDoc)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(newData, &newDoc)
+	if err != nil {
+		return nil, err
+	}
+
+	ops := []patchOp{}
+	err = diffJSON("", oldDoc, newDoc, &ops)
+	return ops, err
+}
+
+// previousAsset finds the mapped almanax consumers hold before a publish: the asset of the same release if
+// it is already mapped, otherwise the asset of the release before it.
+func previousAsset(repo dataRepo, version string) ([]byte, string, error) {
+	data, _, err := downloadAlmanaxAsset(repo, version)
+	if err == nil {
+		ds, err := almanax.Decode(data)
+		if err == nil && ds.Mapped() {
+			return data, version, nil
+		}
+	}
+
+	ctx := context.Background()
+	client := github.NewClient(httpClient)
+	var releases []*github.RepositoryRelease
+	err = retryPolicy.do(ctx, "list releases", func() error {
+		var err error
+		releases, _, err = client.Repositories.ListReleases(ctx, repo.owner, repo.name, &github.ListOptions{PerPage: 50})
+		return githubRetryable(err)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	// releases are listed newest first
+	for i, release := range releases {
+		if release.GetTagName() != version || i+1 == len(releases) {
+			continue
+		}
+		previous := releases[i+1].GetTagName()
+		data, _, err := downloadAlmanaxAsset(repo, previous)
+		if err != nil {
+			return nil, "", err
+		}
+		return data, previous, nil
+	}
+	return nil, "", nil
+}
+
+// patchAsset builds the patch release asset against the previous mapped almanax base of baseVersion, nil
+// if there is none.
+func patchAsset(data []byte, base []byte, baseVersion string, version string) (*releaseAsset, error) {
+	if base == nil {
+		return nil, nil
+	}
+
+	ops, err := jsonPatch(base, data)
+	if err != nil {
+		return nil, err
+	}
+	patch, err := json.Marshal(ops)
+	if err != nil {
+		return nil, err
+	}
+
+	label := fmt.Sprintf("changes from %s", baseVersion)
+	if baseVersion == version {
+		label = fmt.Sprintf("changes from the previous upload of %s", version)
+	}
+	log.Info("publishing patch", "from", baseVersion, "operations", len(ops), "bytes", len(patch))
+	return &releaseAsset{name: MappedAlmanaxPatchFileName, label: label, data: patch}, nil
+}
@@ -0,0 +1,766 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/dofusdude/alm-dates/almanax"
+)
+
+// pipeline maps the almanax of one game into its data repo. Tenants run several independent pipelines
+// in one process, each with its own config, workdir and tokens.
+type pipeline struct {
+	name    string
+	cfg     Config
+	workdir string
+	repo    dataRepo
+	scraper *scraper
+	aliases map[string]string
+	log     *log.Logger
+	queue   *jobQueue
+	// remediations counts the playbook remediations of the failing job in a row
+	remediations map[string]int
+	progress     jobProgress
+	alerts       alertLog
+	maintenance  maintenanceSchedule
+}
+
+func newPipeline(name string, cfg Config) (*pipeline, error) {
+	workdir, err := parseWd(cfg.Workdir)
+	if err != nil {
+		return nil, err
+	}
+
+	err = migrateWorkdir(workdir)
+	if err != nil {
+		return nil, err
+	}
+
+	// aliases from the config win over the ones the playbook learned
+	learned, err := loadLearnedAliases(workdir)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := log.Default()
+	if name != "" {
+		logger = logger.With("tenant", name)
+	}
+
+	return &pipeline{
+		name:    name,
+		cfg:     cfg,
+		workdir: workdir,
+		repo:    cfg.dataRepo(),
+		scraper: newScraper(&cfg, pagesDir(workdir)),
+		aliases: parseReceiverAliases(append(learned, cfg.ReceiverAliases...)),
+		log:     logger,
+		alerts:  alertLog{window: cfg.AlertWindow, escalateAfter: cfg.AlertEscalateAfter},
+	}, nil
+}
+
+type tenantConfig struct {
+	name string
+	cfg  Config
+}
+
+// loadTenants applies each tenant config file on top of the base config. The tenant workdir defaults to
+// a subfolder named like the file.
+func loadTenants(cfg *Config) ([]tenantConfig, error) {
+	var tenants []tenantConfig
+	for _, path := range cfg.Tenants {
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		for _, tenant := range tenants {
+			if tenant.name == name {
+				return nil, fmt.Errorf("tenant %s is configured twice", name)
+			}
+		}
+
+		tenantCfg := *cfg
+		tenantCfg.Tenants = nil
+		err := tenantCfg.loadConfigFile(path, make(configSources))
+		if err != nil {
+			return nil, err
+		}
+		if len(tenantCfg.Tenants) > 0 {
+			return nil, fmt.Errorf("%s: a tenant can not have tenants", path)
+		}
+		// the krosmoz and github budgets, the http client and the servers are shared by all tenants
+		base := cfg.fields()
+		for i, field := range tenantCfg.fields() {
+			if field.process && !reflect.DeepEqual(field.value.Interface(), base[i].value.Interface()) {
+				return nil, fmt.Errorf("%s: %s applies to the whole process and can only be set in the base config", path, field.key)
+			}
+		}
+		if tenantCfg.Workdir == cfg.Workdir {
+			tenantCfg.Workdir = filepath.Join(cfg.Workdir, name)
+		}
+
+		tenants = append(tenants, tenantConfig{name: name, cfg: tenantCfg})
+	}
+	return tenants, nil
+}
+
+// loadPipelines returns the single pipeline of the config or one per tenant.
+func loadPipelines(cfg *Config) ([]*pipeline, error) {
+	if len(cfg.Tenants) == 0 {
+		err := logProblems(cfg.validate(), "")
+		if err != nil {
+			return nil, err
+		}
+		p, err := newPipeline("", *cfg)
+		if err != nil {
+			return nil, err
+		}
+		return []*pipeline{p}, nil
+	}
+
+	tenants, err := loadTenants(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var pipelines []*pipeline
+	for _, tenant := range tenants {
+		err = logProblems(tenant.cfg.validate(), tenant.name)
+		if err != nil {
+			return nil, err
+		}
+
+		err = os.MkdirAll(tenant.cfg.Workdir, os.ModePerm)
+		if err != nil {
+			return nil, err
+		}
+
+		p, err := newPipeline(tenant.name, tenant.cfg)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", tenant.name, err)
+		}
+		pipelines = append(pipelines, p)
+	}
+	return pipelines, nil
+}
+
+// logProblems logs config warnings and returns the first error.
+func logProblems(problems []configProblem, tenant string) error {
+	for _, problem := range problems {
+		if problem.warning {
+			log.Warn("config", "tenant", tenant, "key", problem.key, "problem", problem.message)
+		}
+	}
+	for _, problem := range problems {
+		if problem.warning {
+			continue
+		}
+		if tenant != "" {
+			return fmt.Errorf("tenant %s: invalid config %s: %s", tenant, problem.key, problem.message)
+		}
+		return fmt.Errorf("invalid config %s: %s", problem.key, problem.message)
+	}
+	return nil
+}
+
+// degrade marks the tenant as not working for the health endpoints and the dashboard and raises an alert.
+// The other tenants of the process keep running.
+func (p *pipeline) degrade(message string, err error) {
+	p.log.Error(message, "error", err)
+	p.alerts.add(message, err)
+	health.degrade(p.name, fmt.Sprintf("%s: %v", message, err))
+}
+
+// undegrade clears a degraded state that came from the message once it works again.
+func (p *pipeline) undegrade(message string) {
+	if strings.HasPrefix(health.degradedReason(p.name), message+":") {
+		health.recover(p.name)
+		p.alerts.resolve(message)
+	}
+}
+
+const (
+	degradedTrigger = "trigger stopped"
+	degradedQueue   = "error saving job queue"
+)
+
+// run queues the jobs of all trigger sources and executes them one at a time until the context is done.
+// Mapping jobs also wait for the ones of other tenants, so only one scrapes krosmoz at a time. It only
+// returns an error if the pipeline can not start, failures after that degrade the tenant.
+func (p *pipeline) run(ctx context.Context) error {
+	p.log.Info("watching data repo", "repo", p.repo, "workdir", p.workdir)
+
+	queue, err := openJobQueue(p.workdir, p.cfg.QueueLimit, p.cfg.QueuePolicy, p.cfg.JobAttempts)
+	if err != nil {
+		return fmt.Errorf("error opening job queue: %w", err)
+	}
+	p.queue = queue
+	metrics.collectQueue(p.cfg.Game, p.name, queue)
+
+	// a half replaced release is completed before anything else touches it
+	err = p.recoverPublish()
+	if err != nil {
+		p.log.Error("error completing interrupted publish, it is tried again on the next start", "error", err)
+	}
+
+	err = p.resumeCheckpoints()
+	if err != nil {
+		return fmt.Errorf("error reading checkpoints: %w", err)
+	}
+
+	sources, err := p.triggerSources()
+	if err != nil {
+		return fmt.Errorf("error setting up triggers: %w", err)
+	}
+
+	events := make(chan triggerEvent)
+	for _, source := range sources {
+		go func() {
+			err := source.Run(ctx, events)
+			if err != nil {
+				// queued jobs and the other triggers keep running, the tenant just misses these events
+				p.degrade(degradedTrigger, fmt.Errorf("%s: %w", source.Name(), err))
+			}
+		}()
+	}
+	go p.queueEvents(ctx, events)
+	credentialsFor(&p.cfg).onFailover(p.alerts.add)
+	if p.cfg.TokenCheckInterval > 0 {
+		go p.watchToken(ctx)
+	}
+	if p.cfg.LocaleCheckInterval > 0 {
+		go p.watchLocales(ctx)
+	}
+	if p.cfg.LiveCheckInterval > 0 {
+		go p.watchLive(ctx)
+	}
+	if p.cfg.MaintenanceFeed != "" {
+		go p.watchMaintenance(ctx)
+	}
+
+	for {
+		j, ok := queue.next(ctx)
+		if !ok {
+			return nil
+		}
+
+		if j.mapping() {
+			mappingMu.Lock()
+		}
+		_, maintenance := p.maintenance.current()
+		if !health.canStartUpdate() || p.paused() || (j.scrapes() && maintenance) {
+			if j.mapping() {
+				mappingMu.Unlock()
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(10 * time.Second):
+				continue
+			}
+		}
+
+		if queue.exhausted(j) {
+			if j.mapping() {
+				mappingMu.Unlock()
+			}
+			p.log.Error("job keeps failing, parking it", "kind", j.Kind, "version", j.Version, "attempts", j.Attempts)
+			p.alerts.add(fmt.Sprintf("%s job parked after %d attempts, queue it again once fixed", j.Kind, j.Attempts), nil)
+			err = queue.park(j)
+			if err != nil {
+				p.degrade(degradedQueue, err)
+			}
+			continue
+		}
+
+		p.log.Info("running job", "kind", j.Kind, "version", j.Version, "from", j.From, "to", j.To, "trigger", j.Trigger, "waited", FormatDuration(time.Since(j.Queued).Round(time.Second)), "attempt", j.Attempts+1)
+		j, err = queue.start(j)
+		if err != nil {
+			p.degrade(degradedQueue, err)
+		}
+		p.progress.begin(j)
+		p.scraper.limits.begin(p.repo.String())
+		start := time.Now()
+		err = p.execute(j)
+		if j.mapping() {
+			mappingMu.Unlock()
+		}
+		p.progress.end()
+		p.recordRun(j, start, err)
+		var limit runLimitError
+		limited := errors.As(err, &limit)
+		result := "ok"
+		switch {
+		case limited:
+			result = "limited"
+		case err != nil:
+			result = "failed"
+		}
+		metrics.add(metricJobs, 1, p.cfg.Game, p.name, string(j.Kind), result)
+		if limited {
+			// not a failure, the checkpoint keeps what the job got through
+			err = p.rescheduleRun(j, limit)
+			if err != nil {
+				p.degrade(degradedQueue, err)
+			} else {
+				p.undegrade(degradedQueue)
+			}
+			continue
+		}
+		if window, ok := p.maintenance.current(); ok && err != nil && krosmozFailure(err) {
+			// krosmoz is down on purpose, the job stays queued and waits above until the maintenance ends
+			p.log.Warn("job failed during announced krosmoz maintenance, retrying once it ends", "kind", j.Kind, "maintenance", window.Name, "until", window.Until.Format(time.RFC3339), "error", err)
+			continue
+		}
+		if err != nil {
+			p.log.Error("job failed", "kind", j.Kind, "version", j.Version, "error", err)
+			var drift schemaDriftError
+			if errors.As(err, &drift) {
+				p.alerts.add(schemaDriftAlert, err)
+			} else {
+				p.alerts.add(fmt.Sprintf("%s job failed", j.Kind), err)
+			}
+			if p.remediate(ctx, j, err) {
+				continue
+			}
+		} else {
+			p.log.Info("job done", "kind", j.Kind, "duration", FormatDuration(time.Since(start).Round(time.Second)))
+			p.resolveAlerts(j)
+		}
+		p.resolved(j)
+
+		err = queue.done(j)
+		if err != nil {
+			p.degrade(degradedQueue, err)
+		} else {
+			p.undegrade(degradedQueue)
+		}
+	}
+}
+
+const schemaDriftAlert = "scraper schema drift, krosmoz changed its almanax pages and the scraper needs an update"
+
+// resolveAlerts closes the alerts a job that ran through shows recovered: its own failures, and for mapping
+// jobs krosmoz and github answering again.
+func (p *pipeline) resolveAlerts(j job) {
+	classes := []string{fmt.Sprintf("%s job failed", j.Kind)}
+	if j.mapping() {
+		classes = append(classes, failureKrosmozBlocked, failureGithubQuota, failureReceiverMismatch, schemaDriftAlert)
+	}
+	p.alerts.resolve(classes...)
+}
+
+func (p *pipeline) queueEvents(ctx context.Context, events <-chan triggerEvent) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-events:
+			event.Job.Trigger = event.Source
+			// only the queue holds jobs back, counts their attempts and parks them
+			event.Job.NotBefore, event.Job.Attempts, event.Job.Parked = nil, 0, nil
+			added, err := p.queue.push(event.Job)
+			if err != nil {
+				p.degrade(degradedQueue, err)
+				continue
+			}
+			p.undegrade(degradedQueue)
+			if added {
+				p.log.Debug("job queued", "kind", event.Job.Kind, "trigger", event.Source)
+			}
+		}
+	}
+}
+
+func (p *pipeline) execute(j job) error {
+	if j.Kind == jobCleanup {
+		return p.cleanupReleases()
+	}
+
+	version := j.Version
+	if version == "" {
+		var err error
+		version, err = loadLocalVersion(p.workdir)
+		if err != nil {
+			return err
+		}
+		if version == "" {
+			return fmt.Errorf("no version seen yet")
+		}
+	}
+
+	switch j.Kind {
+	case jobMapVersion:
+		return p.mapVersion(version, j.Force, j.Queued)
+	case jobExtendHorizon:
+		return extendHorizon(p)
+	case jobValidate:
+		return p.validateVersion(version)
+	case jobBackfill:
+		return p.backfill(version, j.From, j.To)
+	}
+	return fmt.Errorf("unknown job kind %s", j.Kind)
+}
+
+// mapVersion maps the dates from today until end_duration for a new game version and publishes them. Dates
+// the release already has are kept and not scraped again unless the mapping is forced. detected is when the
+// version was noticed, the time until doduapi serves the mapping is tracked from then.
+func (p *pipeline) mapVersion(version string, force bool, detected time.Time) error {
+	ds, err := loadDataset(p.repo, version)
+	if err != nil {
+		return err
+	}
+	before := assignments(ds)
+
+	today := time.Now().In(p.cfg.location())
+	fromDate := today.Format("2006-01-02")
+	toDate := today.Add(p.cfg.EndDuration).Format("2006-01-02")
+	dateRange, err := createDateRange(fromDate, toDate)
+	if err != nil {
+		return err
+	}
+
+	// a release that is already mapped only gets the dates of the window it is missing, unless the
+	// incremental strategy is off
+	if !p.incremental() || force {
+		clearDates(ds, dateRange)
+	}
+	overrides, err := loadOverrides(p.workdir)
+	if err != nil {
+		return err
+	}
+	clearOverridden(ds, dateRange, overrides, p.aliases)
+	missing := missingDates(ds, dateRange)
+	if len(missing) == 0 {
+		p.log.Info("data already mapped, skipping", "version", version)
+		return nil
+	}
+	if len(missing) < len(dateRange) {
+		p.log.Info("extending existing mapping", "version", version, "mapped", len(dateRange)-len(missing), "missing", len(missing))
+	}
+	ds.Metadata = p.cfg.metadata()
+
+	if !ds.Mapped() {
+		err = p.carryTombstones(ds, version, fromDate)
+		if err != nil {
+			return err
+		}
+	}
+
+	// a resumed run already passed the canary before it stopped
+	if p.cfg.CanaryDates > 0 && !hasCheckpoint(p.workdir, version) {
+		done := p.timePhase(phaseCanary)
+		failures := canaryScrape(ds, p.cfg.ScrapeLanguages[0], missing, p.cfg.CanaryDates, p.cfg.ScrapeTimeout, p.aliases)
+		done()
+		for _, failure := range failures {
+			p.log.Error("canary scrape failed", "date", failure.Date, "url", failure.Url, "diagnosis", failure.Diagnosis)
+		}
+		if len(failures) > 0 {
+			return fmt.Errorf("aborting mapping, canary scrape failed for %d of %d dates", len(failures), min(p.cfg.CanaryDates, len(missing)))
+		}
+	}
+
+	p.keepReplayRecord(version, missing, ds)
+	p.log.Info("Mapping...")
+	start := time.Now()
+	sources, err := p.mapDates(ds, version, missing)
+	if err != nil {
+		return err
+	}
+	ds.SortDays()
+	p.log.Info("Mapping done", "duration", FormatDuration(time.Since(start).Round(time.Second)))
+
+	if p.cfg.ValidatePercent > 0 {
+		done := p.timePhase(phaseValidate)
+		mismatches := validateMapping(ds, p.scraper, p.cfg.ValidatePercent, p.cfg.ValidateWorkers, p.aliases, overrides)
+		done()
+		for _, mismatch := range mismatches {
+			p.log.Error("validation mismatch", "date", mismatch.Date, "mapped", mismatch.Mapped, "scraped", mismatch.Scraped)
+		}
+		if len(mismatches) > 0 {
+			// the checkpointed dates may come from the same wrong pages, the next run starts over
+			p.discardCheckpoint(version)
+			return fmt.Errorf("not publishing %s, validation pass disagrees with the mapping on %d dates", version, len(mismatches))
+		}
+	}
+
+	p.crossValidate(ds, missing)
+
+	err = p.checkCoverage(ds, dateRange)
+	if err != nil {
+		return err
+	}
+
+	err = p.publish(ds, version, sources, before)
+	if err != nil {
+		return fmt.Errorf("error updating almanax release: %w", err)
+	}
+	p.discardCheckpoint(version)
+	if p.cfg.ServeSlo > 0 {
+		go p.trackTimeToServe(context.Background(), version, detected, ds)
+	}
+
+	// earlier dates of the release may reach further than the window
+	_, horizon := ds.Coverage()
+	err = saveHorizon(p.workdir, max(toDate, horizon))
+	if err != nil {
+		return err
+	}
+
+	// a new release may push an older one out of keep_releases, a batch of the map command cleans up once after
+	// its last version
+	if p.cfg.KeepReleases > 0 && p.queue != nil {
+		_, err = p.queue.push(job{Kind: jobCleanup, Trigger: "map-version"})
+	}
+	return err
+}
+
+// validateVersion scrapes a share of the published dates again and reports mismatches without publishing.
+func (p *pipeline) validateVersion(version string) error {
+	ds, err := loadDataset(p.repo, version)
+	if err != nil {
+		return err
+	}
+
+	overrides, err := loadOverrides(p.workdir)
+	if err != nil {
+		return err
+	}
+
+	percent := p.cfg.ValidatePercent
+	if percent <= 0 {
+		percent = 10
+	}
+	done := p.timePhase(phaseValidate)
+	mismatches := validateMapping(ds, p.scraper, percent, p.cfg.ValidateWorkers, p.aliases, overrides)
+	done()
+	for _, mismatch := range mismatches {
+		p.log.Error("validation mismatch", "date", mismatch.Date, "mapped", mismatch.Mapped, "scraped", mismatch.Scraped)
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("%d of the published dates disagree with krosmoz", len(mismatches))
+	}
+	return nil
+}
+
+// backfill maps the dates of a range that are not mapped yet and publishes them.
+func (p *pipeline) backfill(version string, from string, to string) error {
+	if !isDate(from) || !isDate(to) || to < from {
+		return fmt.Errorf("invalid backfill range %s to %s", from, to)
+	}
+
+	ds, err := loadDataset(p.repo, version)
+	if err != nil {
+		return err
+	}
+
+	before := assignments(ds)
+	overrides, err := loadOverrides(p.workdir)
+	if err != nil {
+		return err
+	}
+	dateRange, err := createDateRange(from, to)
+	if err != nil {
+		return err
+	}
+	clearOverridden(ds, dateRange, overrides, p.aliases)
+	missing := missingDates(ds, dateRange)
+	if len(missing) == 0 {
+		p.log.Info("backfill range already mapped", "from", from, "to", to)
+		return nil
+	}
+
+	p.log.Info("backfilling", "version", version, "dates", len(missing))
+	ds.Metadata = p.cfg.metadata()
+	p.keepReplayRecord(version, missing, ds)
+	sources, err := p.mapDates(ds, version, missing)
+	if err != nil {
+		return err
+	}
+	ds.SortDays()
+	p.crossValidate(ds, missing)
+
+	err = p.checkCoverage(ds, dateRange)
+	if err != nil {
+		return err
+	}
+
+	err = p.publish(ds, version, sources, before)
+	if err != nil {
+		return err
+	}
+	p.discardCheckpoint(version)
+	return nil
+}
+
+// carryTombstones keeps the days of the receivers the previous release has and the new one does not. It
+// fails rather than publishing without them, the next release would lose them for good.
+func (p *pipeline) carryTombstones(ds *almanax.Dataset, version string, before string) error {
+	data, previousVersion, err := previousAsset(p.repo, version)
+	if err != nil || data == nil {
+		return err
+	}
+	previous, err := almanax.Decode(data)
+	if err != nil {
+		return err
+	}
+
+	removed := carryTombstones(ds, previous, before)
+	if removed > 0 || len(ds.Tombstones) > 0 {
+		p.log.Info("tombstones carried", "from", previousVersion, "removed", removed, "tombstones", len(ds.Tombstones))
+	}
+	return nil
+}
+
+// missingDates returns the dates that have no receiver in the dataset yet.
+func missingDates(ds *almanax.Dataset, dates []string) []string {
+	days := almanax.NewIndex(ds)
+	var missing []string
+	for _, date := range dates {
+		if _, ok := days.Day(date); !ok {
+			missing = append(missing, date)
+		}
+	}
+	return missing
+}
+
+// mapDates maps the dates of the version with the configured strategies in order, each gets the dates the
+// ones before it left.
+func (p *pipeline) mapDates(ds *almanax.Dataset, version string, dates []string) (provenance, error) {
+	defer p.timePhase(phaseMap)()
+	overrides, err := loadOverrides(p.workdir)
+	if err != nil {
+		return nil, err
+	}
+	strategies := p.strategies(version)
+	if len(overrides) > 0 {
+		strategies = append([]mappingStrategy{overrideStrategy{overrides: overrides, aliases: p.aliases}}, strategies...)
+	}
+
+	sources := make(provenance)
+	left := dates
+	for _, strategy := range strategies {
+		if len(left) == 0 {
+			break
+		}
+		before := len(left)
+		var err error
+		left, err = strategy.mapDates(ds, left, sources)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", strategy.name(), err)
+		}
+		p.log.Info("strategy done", "strategy", strategy.name(), "mapped", before-len(left), "left", len(left))
+		metrics.add(metricDatesMapped, float64(before-len(left)), p.cfg.Game, p.name, strategy.name())
+	}
+	if len(left) > 0 {
+		p.log.Warn("no strategy mapped some dates, they stay unmapped", "dates", len(left), "first", left[0])
+	}
+
+	unmatched := p.scraper.unmatched.list()
+	if len(unmatched) > 0 {
+		p.log.Error("some offering receivers match nothing", "dates", len(unmatched), "publish_partial", p.cfg.PublishPartial)
+		for _, mismatch := range unmatched {
+			p.log.Error("unmatched date", "date", mismatch.Date, "lang", mismatch.Lang, "receiver", mismatch.Receiver, "item", mismatch.Item, "suggestion", mismatch.Suggestion)
+		}
+		if !p.cfg.PublishPartial {
+			return nil, unmatchedReceiversError{Dates: unmatched}
+		}
+	}
+	return sources, nil
+}
+
+// checkCoverage checks the window of a run before it is published. Dates skipped by an override, left for
+// review or left unmatched with publish_partial are not gaps. In lenient coverage_mode the problems are
+// only logged.
+func (p *pipeline) checkCoverage(ds *almanax.Dataset, dates []string) error {
+	exempt := make(map[string]bool)
+	overrides, err := loadOverrides(p.workdir)
+	if err != nil {
+		return err
+	}
+	for date, override := range overrides {
+		exempt[date] = override.Skip
+	}
+	for _, date := range p.scraper.review.dates() {
+		exempt[date] = true
+	}
+	if p.cfg.PublishPartial {
+		for _, mismatch := range p.scraper.unmatched.list() {
+			exempt[mismatch.Date] = true
+		}
+	}
+
+	err = checkCoverage(ds, dates, exempt)
+	var problem coverageError
+	if !errors.As(err, &problem) {
+		return err
+	}
+	for _, date := range problem.Gaps {
+		p.log.Error("date of the window is not mapped", "date", date)
+	}
+	for date, receivers := range problem.Duplicates {
+		p.log.Error("date is mapped to several receivers", "date", date, "receivers", receivers)
+	}
+	if p.cfg.CoverageMode == "lenient" {
+		p.log.Warn("publishing despite the coverage problems", "gaps", len(problem.Gaps), "duplicates", len(problem.Duplicates))
+		return nil
+	}
+	return err
+}
+
+// clearDates removes the dates from the days of the receivers, so they are mapped again.
+func clearDates(ds *almanax.Dataset, dates []string) {
+	clear := make(map[string]bool, len(dates))
+	for _, date := range dates {
+		clear[date] = true
+	}
+	for i := range ds.Receivers {
+		ds.Receivers[i].Days = slices.DeleteFunc(ds.Receivers[i].Days, func(date string) bool {
+			return clear[date]
+		})
+	}
+}
+
+// discardCheckpoint removes the checkpoint of a published version, the next run of it starts fresh.
+func (p *pipeline) discardCheckpoint(version string) {
+	err := removeCheckpoint(p.workdir, version)
+	if err != nil {
+		p.log.Warn("error removing checkpoint", "version", version, "error", err)
+	}
+}
+
+// resumeCheckpoints reports the runs that stopped before publishing. Their jobs are still queued and
+// continue from the checkpoint, a checkpoint without a queued job is never picked up and is removed.
+func (p *pipeline) resumeCheckpoints() error {
+	versions, err := unfinishedRuns(p.workdir)
+	if err != nil {
+		return err
+	}
+
+	local, err := loadLocalVersion(p.workdir)
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		if !p.queue.queued(version, local) {
+			p.log.Warn("discarding checkpoint without a queued job", "version", version)
+			p.discardCheckpoint(version)
+			continue
+		}
+		p.log.Info("unfinished run found, resuming from checkpoint", "version", version)
+	}
+	return nil
+}
+
+// keepReplayRecord saves what a run starts from, so replay can rebuild its output from the kept pages.
+func (p *pipeline) keepReplayRecord(version string, dates []string, seed *almanax.Dataset) {
+	err := saveReplayRecord(p.workdir, version, p.cfg.ScrapeLanguages, dates, seed)
+	if err != nil {
+		p.log.Warn("error keeping replay record", "version", version, "error", err)
+	}
+}
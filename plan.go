@@ -0,0 +1,225 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/dofusdude/alm-dates/almanax"
+)
+
+// runPlan is the estimate of a map-version run with a config.
+type runPlan struct {
+	Dates    int
+	Missing  int
+	Cached   int
+	Scraped  int
+	Canary   int
+	Cross    int
+	Validate int
+	// Krosmoz is the number of krosmoz requests, Rate the requests per second they are sent at
+	Krosmoz  int
+	Rate     float64
+	Duration time.Duration
+	Github   int
+	// GithubFree is the github quota of an hour left above github_quota_reserve
+	GithubFree int
+	Notes      []string
+}
+
+// githubHourlyQuota is the rate limit of an authenticated github token.
+const githubHourlyQuota = 5000
+
+// planRun estimates a map-version run. ds is the release the run starts from, nil if it is not known, then
+// every date is taken as missing. latency is how long krosmoz takes to answer.
+func planRun(cfg *Config, workdir string, ds *almanax.Dataset, today time.Time, latency time.Duration) runPlan {
+	var plan runPlan
+	// both ends are formatted dates, the range can not fail
+	dates, _ := createDateRange(today.Format("2006-01-02"), today.Add(cfg.EndDuration).Format("2006-01-02"))
+	plan.Dates = len(dates)
+
+	missing := dates
+	if ds != nil && slices.Contains(cfg.Strategies, strategyIncremental) {
+		missing = missingDates(ds, dates)
+	}
+	plan.Missing = len(missing)
+
+	// kept pages are replayed or reused from the cache instead of requested
+	pages := pagesDir(workdir)
+	replay := slices.Contains(cfg.Strategies, strategyReplayFromCache)
+	var scrape []string
+	for _, date := range missing {
+		if keptPage(pages, cfg.ScrapeLanguages[0], date, cfg.PageCacheTtl, replay) {
+			plan.Cached++
+			continue
+		}
+		scrape = append(scrape, date)
+	}
+	if slices.Contains(cfg.Strategies, strategyCycleInference) {
+		plan.Notes = append(plan.Notes, "cycle-inference is not estimated, its dates are counted as scraped")
+	}
+	if !slices.Contains(cfg.Strategies, strategyFullScrape) {
+		scrape = nil
+		plan.Notes = append(plan.Notes, "full-scrape is off, dates no other strategy maps stay unmapped")
+	}
+
+	others := 0
+	for _, lang := range cfg.CrossCheckLanguages {
+		if lang != cfg.ScrapeLanguages[0] {
+			others++
+		}
+	}
+	if cfg.ScrapeMode == "month" {
+		months := make(map[string]bool)
+		for _, date := range scrape {
+			months[date[:7]] = true
+		}
+		plan.Scraped = len(months)
+		plan.Cross = len(months) * others
+		plan.Notes = append(plan.Notes, "month mode requests the days a month view misses again, they are not counted")
+	} else {
+		plan.Scraped = len(scrape)
+		plan.Cross = len(scrape) * others
+	}
+	if len(missing) > 0 {
+		plan.Canary = min(cfg.CanaryDates, len(missing))
+	}
+	if cfg.ValidatePercent > 0 {
+		plan.Validate = int(math.Ceil(float64(len(missing)) * cfg.ValidatePercent / 100))
+	}
+	plan.Krosmoz = plan.Canary + plan.Scraped + plan.Cross + plan.Validate
+
+	// the workers send one request after another, each waits for the jitter before it
+	plan.Rate = cfg.ScrapeRate
+	if cfg.ScrapeBudget > 0 {
+		plan.Rate = min(plan.Rate, float64(cfg.ScrapeBudget)/60)
+	}
+	perRequest := latency + (cfg.ScrapeJitterMin+cfg.ScrapeJitterMax)/2
+	if perRequest > 0 {
+		plan.Rate = min(plan.Rate, float64(cfg.ScrapeWorkers)/perRequest.Seconds())
+	}
+	if plan.Rate > 0 {
+		plan.Duration = time.Duration(float64(plan.Krosmoz) / plan.Rate * float64(time.Second))
+	}
+
+	plan.Github = planGithubCalls(cfg)
+	plan.GithubFree = githubHourlyQuota - cfg.GithubQuotaReserve
+	if plan.Github > plan.GithubFree {
+		plan.Notes = append(plan.Notes, "the publish needs more github requests than an hour of quota above the reserve")
+	}
+	return plan
+}
+
+// keptPage reports whether the page of a date is kept and used instead of a request, by the replay strategy
+// or within the page cache ttl.
+func keptPage(pages string, lang string, date string, ttl time.Duration, replay bool) bool {
+	info, err := os.Stat(pagePath(pages, lang, date))
+	if err != nil {
+		return false
+	}
+	return replay || (ttl > 0 && time.Since(info.ModTime()) <= ttl)
+}
+
+// planGithubCalls counts the github requests of a publish: reading the release and the assets it builds on,
+// then a delete and an upload per asset.
+func planGithubCalls(cfg *Config) int {
+	// the mapped almanax, the previous release for the patch and the published provenance
+	reads := 3
+	assets := 4
+	if cfg.History {
+		reads++
+		assets++
+	}
+	if cfg.FlavorAsset {
+		reads++
+		assets++
+	}
+	if cfg.DiffAsset {
+		assets++
+	}
+	if cfg.ContentAddressed {
+		assets += 2
+	}
+	// the release itself
+	return reads + 1 + 2*assets
+}
+
+func writePlan(w io.Writer, plan runPlan) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	rows := [][2]string{
+		{"dates in the window", fmt.Sprint(plan.Dates)},
+		{"dates to map", fmt.Sprint(plan.Missing)},
+		{"from kept pages", fmt.Sprint(plan.Cached)},
+		{"canary requests", fmt.Sprint(plan.Canary)},
+		{"scrape requests", fmt.Sprint(plan.Scraped)},
+		{"cross check requests", fmt.Sprint(plan.Cross)},
+		{"validation requests", fmt.Sprint(plan.Validate)},
+		{"krosmoz requests", fmt.Sprint(plan.Krosmoz)},
+		{"krosmoz rate", fmt.Sprintf("%.2f/s", plan.Rate)},
+		{"expected duration", FormatDuration(plan.Duration.Round(time.Second))},
+		{"github requests", fmt.Sprintf("%d of %d an hour above the reserve", plan.Github, plan.GithubFree)},
+	}
+	for _, row := range rows {
+		fmt.Fprintf(tw, "%s\t%s\n", row[0], row[1])
+	}
+	for _, note := range plan.Notes {
+		fmt.Fprintf(tw, "note\t%s\n", note)
+	}
+	return tw.Flush()
+}
+
+// planCommand estimates a map-version run with the config without sending a single krosmoz request.
+func planCommand(args []string) {
+	flags := flag.NewFlagSet("plan", flag.ExitOnError)
+	version := flags.String("version", "", "game version whose release the run starts from, defaults to the last seen version")
+	offline := flags.Bool("offline", false, "do not download the release, every date counts as missing")
+	latency := flags.Duration("latency", time.Second, "how long krosmoz takes to answer a request")
+	cfg, _, err := loadConfig(flags, args)
+	if err != nil {
+		log.Fatal("error loading config", "error", err)
+	}
+	// the estimate only needs the scrape settings to make sense
+	planned := []string{"end_duration", "scrape_languages", "scrape_workers", "scrape_rate", "scrape_mode", "strategies", "scrape_budget", "scrape_jitter_max"}
+	for _, problem := range cfg.validate() {
+		if !problem.warning && slices.Contains(planned, problem.key) {
+			log.Fatal("invalid config", "key", problem.key, "problem", problem.message)
+		}
+	}
+	retryPolicy = cfg.retryPolicy()
+	downloadTimeout = cfg.DownloadTimeout
+	httpClient = newHttpClient(cfg)
+
+	workdir, err := parseWd(cfg.Workdir)
+	if err != nil {
+		log.Fatal("error parsing working directory", "error", err)
+	}
+
+	var ds *almanax.Dataset
+	if !*offline {
+		if *version == "" {
+			*version, err = loadLocalVersion(workdir)
+			if err != nil {
+				log.Fatal("error loading local version", "error", err)
+			}
+		}
+		if *version != "" {
+			ds, err = loadDataset(cfg.dataRepo(), *version)
+			if err != nil {
+				log.Warn("could not load the release, every date counts as missing", "version", *version, "error", err)
+				ds = nil
+			}
+		}
+	}
+
+	plan := planRun(&cfg, workdir, ds, time.Now().In(cfg.location()), *latency)
+	err = writePlan(os.Stdout, plan)
+	if err != nil {
+		log.Fatal("error writing plan", "error", err)
+	}
+}
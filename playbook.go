@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v67/github"
+)
+
+// The failure classes the playbook has remediations for.
+const (
+	failureKrosmozBlocked   = "krosmoz-blocked"
+	failureGithubQuota      = "github-quota"
+	failureReceiverMismatch = "receiver-mismatch"
+)
+
+// The remediations, a failed job runs again after one of them except for none.
+const (
+	remedySwitchProxy = "switch-proxy"
+	remedyPause       = "pause"
+	remedyWaitReset   = "wait-reset"
+	remedyApplyAlias  = "apply-alias"
+	remedyNone        = "none"
+)
+
+// playbookRemedies are the remediations each failure class can be configured with.
+var playbookRemedies = map[string][]string{
+	failureKrosmozBlocked:   {remedySwitchProxy, remedyPause, remedyNone},
+	failureGithubQuota:      {remedyWaitReset, remedyNone},
+	failureReceiverMismatch: {remedyApplyAlias, remedyNone},
+}
+
+const learnedAliasesFileName = "learned_aliases"
+
+// classifyFailure returns the failure class of a job error, empty if the playbook does not know it.
+func classifyFailure(err error) string {
+	var scrapeErr scrapeError
+	if errors.As(err, &scrapeErr) && (scrapeErr.Status == http.StatusForbidden || scrapeErr.Status == http.StatusTooManyRequests) {
+		return failureKrosmozBlocked
+	}
+	var throttled throttledError
+	if errors.As(err, &throttled) {
+		return failureKrosmozBlocked
+	}
+	var rateLimit *github.RateLimitError
+	var abuse *github.AbuseRateLimitError
+	if errors.As(err, &rateLimit) || errors.As(err, &abuse) {
+		return failureGithubQuota
+	}
+	var mismatch receiverMismatchError
+	if errors.As(err, &mismatch) {
+		return failureReceiverMismatch
+	}
+	return ""
+}
+
+// parsePlaybook reads "class=remedy" pairs, validate makes sure they are known.
+func parsePlaybook(pairs []string) map[string]string {
+	remedies := make(map[string]string)
+	for _, pair := range pairs {
+		class, remedy, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		remedies[strings.TrimSpace(class)] = strings.TrimSpace(remedy)
+	}
+	return remedies
+}
+
+// quotaReset is when github accepts requests again after a rate limit, zero if it did not say.
+func quotaReset(err error) time.Time {
+	var rateLimit *github.RateLimitError
+	if errors.As(err, &rateLimit) {
+		return rateLimit.Rate.Reset.Time
+	}
+	var abuse *github.AbuseRateLimitError
+	if errors.As(err, &abuse) && abuse.RetryAfter != nil {
+		return time.Now().Add(*abuse.RetryAfter)
+	}
+	return time.Time{}
+}
+
+// remediate applies the configured remediation for the failure class of a job error and reports whether
+// the job should run again. A job is remediated at most playbook_attempts times in a row, the checkpoint
+// lets it continue where it failed.
+func (p *pipeline) remediate(ctx context.Context, j job, err error) bool {
+	class := classifyFailure(err)
+	remedy := parsePlaybook(p.cfg.Playbook)[class]
+	if class == "" || remedy == "" || remedy == remedyNone {
+		return false
+	}
+
+	if p.remediations == nil {
+		p.remediations = make(map[string]int)
+	}
+	if p.remediations[j.key()] >= p.cfg.PlaybookAttempts {
+		p.log.Error("playbook gave up, job needs a look", "kind", j.Kind, "failure", class, "remedy", remedy, "attempts", p.remediations[j.key()])
+		p.alerts.add(fmt.Sprintf("playbook gave up on %s after %d %s remediations", class, p.remediations[j.key()], remedy), err)
+		delete(p.remediations, j.key())
+		return false
+	}
+	p.remediations[j.key()]++
+
+	switch remedy {
+	case remedySwitchProxy:
+		proxy, ok := scrapeProxies.next()
+		if !ok {
+			return false
+		}
+		if proxy == "" {
+			proxy = "direct"
+		}
+		p.log.Warn("krosmoz blocks requests, switching proxy", "kind", j.Kind, "proxy", proxy)
+		return true
+	case remedyPause:
+		p.log.Warn("krosmoz blocks requests, pausing", "kind", j.Kind, "for", FormatDuration(p.cfg.PlaybookPause))
+		p.alerts.add("krosmoz blocks requests, pausing for "+FormatDuration(p.cfg.PlaybookPause), err)
+		return sleepCtx(ctx, p.cfg.PlaybookPause)
+	case remedyWaitReset:
+		wait := p.cfg.PlaybookPause
+		if reset := quotaReset(err); !reset.IsZero() {
+			wait = time.Until(reset) + time.Second
+		}
+		p.log.Warn("github quota exhausted, waiting for the reset", "kind", j.Kind, "for", FormatDuration(wait.Round(time.Second)))
+		return sleepCtx(ctx, wait)
+	case remedyApplyAlias:
+		// a mapping reports all of its unmatched dates at once, each suggestion is applied
+		var mismatches []receiverMismatchError
+		var unmatched unmatchedReceiversError
+		if errors.As(err, &unmatched) {
+			mismatches = unmatched.Dates
+		} else {
+			var mismatch receiverMismatchError
+			errors.As(err, &mismatch)
+			mismatches = []receiverMismatchError{mismatch}
+		}
+
+		applied := false
+		for _, mismatch := range mismatches {
+			alias := mismatch.alias()
+			if alias == "" {
+				p.log.Error("receiver mismatch without alias suggestion", "receiver", mismatch.Receiver, "item", mismatch.Item, "date", mismatch.Date)
+				continue
+			}
+			err = p.learnAlias(alias)
+			if err != nil {
+				p.log.Error("error saving alias suggestion", "alias", alias, "error", err)
+				return false
+			}
+			p.log.Warn("applied alias suggestion, add it to receiver_aliases to keep it", "alias", alias, "date", mismatch.Date, "lang", mismatch.Lang)
+			p.alerts.add("applied alias suggestion "+alias+", add it to receiver_aliases to keep it", nil)
+			applied = true
+		}
+		return applied
+	}
+	return false
+}
+
+// resolved forgets the remediations of a job that ran through.
+func (p *pipeline) resolved(j job) {
+	delete(p.remediations, j.key())
+}
+
+// learnAlias adds a receiver alias to the aliases of the pipeline and keeps it in the workdir state, so it
+// survives restarts. The aliases are replaced rather than changed, running scrape workers still read them.
+func (p *pipeline) learnAlias(pair string) error {
+	err := os.MkdirAll(stateDir(p.workdir), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(stateDir(p.workdir), learnedAliasesFileName), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(f, pair)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	aliases := maps.Clone(p.aliases)
+	maps.Copy(aliases, parseReceiverAliases([]string{pair}))
+	p.aliases = aliases
+	return nil
+}
+
+// loadLearnedAliases returns the aliases the playbook applied, one scraped=mapped pair per line.
+func loadLearnedAliases(workdir string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(stateDir(workdir), learnedAliasesFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var pairs []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			pairs = append(pairs, line)
+		}
+	}
+	return pairs, nil
+}
+
+// sleepCtx waits for the duration and reports whether it was not interrupted by the context.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
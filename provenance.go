@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/dofusdude/alm-dates/almanax"
+)
+
+// provenanceEntry is the krosmoz page a date was mapped from. Inferred dates have no page but the date
+// they were inferred from.
+type provenanceEntry struct {
+	Url          string    `json:"url,omitempty"`
+	Lang         string    `json:"lang,omitempty"`
+	FetchedAt    time.Time `json:"fetched_at"`
+	Strategy     string    `json:"strategy,omitempty"`
+	InferredFrom string    `json:"inferred_from,omitempty"`
+	// Quantity and Kamas are the offering quantity and kamas reward the page showed, zero if it showed none
+	Quantity int `json:"quantity,omitempty"`
+	Kamas    int `json:"kamas,omitempty"`
+}
+
+// provenance maps dates to the page they were mapped from.
+type provenance map[string]provenanceEntry
+
+// provenanceAsset is published next to the mapped almanax. It is separate because the fetch times would make
+// the mapped almanax differ between otherwise identical runs.
+type provenanceAsset struct {
+	Version string     `json:"version"`
+	Dates   provenance `json:"dates"`
+}
+
+// previousProvenance returns the provenance already published with a release, empty if there is none.
+func previousProvenance(repo dataRepo, version string) (provenance, error) {
+	data, _, err := downloadReleaseAsset(repo, version, MappedAlmanaxProvenanceFileName)
+	if errors.Is(err, errAssetNotFound) {
+		return provenance{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var asset provenanceAsset
+	err = json.Unmarshal(data, &asset)
+	if err != nil {
+		return nil, err
+	}
+	if asset.Dates == nil {
+		asset.Dates = provenance{}
+	}
+	return asset.Dates, nil
+}
+
+// mergeProvenance merges the new entries into the published ones and keeps only the mapped dates.
+func mergeProvenance(ds *almanax.Dataset, version string, cfg *Config, entries provenance) provenance {
+	merged, err := previousProvenance(cfg.dataRepo(), version)
+	if err != nil {
+		log.Warn("could not load the published provenance, only the new dates are kept", "version", version, "error", err)
+		merged = provenance{}
+	}
+	for date, entry := range entries {
+		merged[date] = entry
+	}
+
+	dates := make(provenance)
+	for _, day := range ds.Days() {
+		if entry, ok := merged[day.Date]; ok {
+			dates[day.Date] = entry
+		}
+	}
+	return dates
+}
+
+func buildProvenanceAsset(version string, dates provenance) (*releaseAsset, error) {
+	data, err := json.MarshalIndent(provenanceAsset{Version: version, Dates: dates}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return &releaseAsset{name: MappedAlmanaxProvenanceFileName, label: "krosmoz pages the dates were mapped from", data: data}, nil
+}
+
+// enrichedAsset is published next to the mapped almanax with the values of every date as krosmoz showed
+// them, the game data may have older ones.
+type enrichedAsset struct {
+	Version string       `json:"version"`
+	Dates   []AlmApiData `json:"dates"`
+}
+
+// buildEnrichedAsset lists the mapped dates with the offering quantity and kamas reward of their page, dates
+// without one, like inferred dates, have the values of the game data. Texts are in the page language.
+func buildEnrichedAsset(ds *almanax.Dataset, version string, dates provenance) (*releaseAsset, error) {
+	asset := enrichedAsset{Version: version, Dates: []AlmApiData{}}
+	for _, day := range ds.Days() {
+		entry := dates[day.Date]
+		lang := entry.Lang
+		if lang == "" {
+			lang = "en"
+		}
+		receiver := day.Receiver
+		data := AlmApiData{
+			Date:         day.Date,
+			ItemQuantity: receiver.Offering.Quantity,
+			ItemName:     receiver.Offering.ItemName.Get(lang),
+			Bonus:        receiver.Bonus.Description.Get(lang),
+			BonusType:    receiver.Bonus.Type.Get(lang),
+			Language:     lang,
+			RewardKamas:  receiver.RewardKamas,
+		}
+		if entry.Quantity > 0 {
+			data.ItemQuantity = entry.Quantity
+		}
+		if entry.Kamas > 0 {
+			data.RewardKamas = entry.Kamas
+		}
+		asset.Dates = append(asset.Dates, data)
+	}
+
+	data, err := json.MarshalIndent(asset, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return &releaseAsset{name: MappedAlmanaxEnrichedFileName, label: "offering quantities and kamas rewards as krosmoz shows them", data: data}, nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// The proxy modes: round-robin sends every krosmoz request through the next proxy, failover sends them
+// directly until krosmoz blocks the address and then through one proxy at a time.
+const (
+	proxyRoundRobin = "round-robin"
+	proxyFailover   = "failover"
+)
+
+// scrapeProxies are the proxies krosmoz requests go through, set from the config on startup like
+// httpClient.
+var scrapeProxies = newProxyRotation(defaultConfig())
+
+// proxyState is a proxy with its failure tracking.
+type proxyState struct {
+	url      *url.URL
+	requests int
+	failures int
+	// failing counts the failures in a row, the proxy cools down when it reaches max failures
+	failing int
+	until   time.Time
+}
+
+// proxyRotation picks the proxy of each krosmoz request. Proxies failing max failures requests in a row
+// are skipped for the cooldown.
+type proxyRotation struct {
+	mu          sync.Mutex
+	proxies     []*proxyState
+	roundRobin  bool
+	maxFailures int
+	cooldown    time.Duration
+	// active is the proxy of the failover mode, -1 for the direct connection
+	active int
+	// turn is the proxy whose turn is next in the round-robin mode
+	turn int
+}
+
+// newProxyRotation parses the proxy urls of the config, validate makes sure they parse.
+func newProxyRotation(cfg Config) *proxyRotation {
+	r := &proxyRotation{
+		roundRobin:  cfg.ProxyMode != proxyFailover,
+		maxFailures: cfg.ProxyMaxFailures,
+		cooldown:    cfg.ProxyCooldown,
+		active:      -1,
+	}
+	for _, proxy := range cfg.proxyUrls() {
+		u, err := url.Parse(proxy)
+		if err == nil {
+			r.proxies = append(r.proxies, &proxyState{url: u})
+		}
+	}
+	return r
+}
+
+// pick returns the proxy of the next krosmoz request, nil for the direct connection, and moves the
+// round-robin on.
+func (r *proxyRotation) pick() *proxyState {
+	return r.choose(true)
+}
+
+// peek returns the proxy pick would return, without moving the round-robin on.
+func (r *proxyRotation) peek() *proxyState {
+	return r.choose(false)
+}
+
+// choose returns the proxy whose turn it is. Without a proxy that is not cooling down the one cooling down
+// the shortest is used.
+func (r *proxyRotation) choose(advance bool) *proxyState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.proxies) == 0 {
+		return nil
+	}
+	if !r.roundRobin {
+		if r.active == -1 {
+			return nil
+		}
+		return r.proxies[r.active]
+	}
+
+	now := time.Now()
+	var soonest *proxyState
+	for n := range r.proxies {
+		i := (r.turn + n) % len(r.proxies)
+		proxy := r.proxies[i]
+		if !now.Before(proxy.until) {
+			if advance {
+				r.turn = i + 1
+			}
+			return proxy
+		}
+		if soonest == nil || proxy.until.Before(soonest.until) {
+			soonest = proxy
+		}
+	}
+	return soonest
+}
+
+// report tracks the outcome of a request through a proxy. A failing proxy cools down, in the failover mode
+// the next one takes over.
+func (r *proxyRotation) report(proxy *proxyState, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	proxy.requests++
+	if ok {
+		proxy.failing = 0
+		return
+	}
+	proxy.failures++
+	proxy.failing++
+	if r.maxFailures <= 0 || proxy.failing < r.maxFailures {
+		return
+	}
+
+	proxy.failing = 0
+	proxy.until = time.Now().Add(r.cooldown)
+	log.Warn("proxy keeps failing, cooling it down", "proxy", proxy.url.Redacted(), "for", FormatDuration(r.cooldown))
+	if !r.roundRobin && r.active != -1 && r.proxies[r.active] == proxy {
+		r.active = (r.active + 1) % len(r.proxies)
+	}
+}
+
+// next switches the failover mode to the next proxy and returns its host, empty for the direct connection.
+// The round-robin mode already spreads the requests, it moves on to the next proxy. It is false without
+// proxies.
+func (r *proxyRotation) next() (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.proxies) == 0 {
+		return "", false
+	}
+	if r.roundRobin {
+		r.turn++
+		return r.proxies[r.turn%len(r.proxies)].url.Host, true
+	}
+	r.active++
+	if r.active == len(r.proxies) {
+		r.active = -1
+		return "", true
+	}
+	return r.proxies[r.active].url.Host, true
+}
+
+// proxyStatus is a proxy for the metrics, without its credentials.
+type proxyStatus struct {
+	proxy       string
+	requests    int
+	failures    int
+	coolingDown bool
+}
+
+func (r *proxyRotation) status() []proxyStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	statuses := make([]proxyStatus, 0, len(r.proxies))
+	for _, proxy := range r.proxies {
+		statuses = append(statuses, proxyStatus{
+			proxy:       proxy.url.Redacted(),
+			requests:    proxy.requests,
+			failures:    proxy.failures,
+			coolingDown: time.Now().Before(proxy.until),
+		})
+	}
+	return statuses
+}
+
+type proxyContextKey struct{}
+
+// proxyTransport sends krosmoz requests through the proxy the rotation picks and reports how they went.
+// Requests to other hosts use the proxy of the environment.
+type proxyTransport struct {
+	next http.RoundTripper
+}
+
+func (t *proxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != krosmozHost {
+		return t.next.RoundTrip(req)
+	}
+	proxy := scrapeProxies.pick()
+	if proxy == nil {
+		return t.next.RoundTrip(req)
+	}
+
+	res, err := t.next.RoundTrip(req.WithContext(context.WithValue(req.Context(), proxyContextKey{}, proxy.url)))
+	scrapeProxies.report(proxy, err == nil && !proxyFailed(res.StatusCode))
+	return res, err
+}
+
+// proxyFailed reports whether a status means the proxy could not get the page: krosmoz blocks its address
+// or the proxy itself fails.
+func proxyFailed(status int) bool {
+	switch status {
+	case http.StatusForbidden, http.StatusProxyAuthRequired, http.StatusTooManyRequests, http.StatusBadGateway:
+		return true
+	}
+	return false
+}
+
+// proxyFromContext is the Proxy of the http transport, it uses the proxy proxyTransport picked.
+func proxyFromContext(req *http.Request) (*url.URL, error) {
+	if proxy, ok := req.Context().Value(proxyContextKey{}).(*url.URL); ok {
+		return proxy, nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+// startReaper is only needed for pid 1 on unix.
+func startReaper() {}
+
+func trackChild() func() {
+	return func() {}
+}
@@ -0,0 +1,54 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/charmbracelet/log"
+)
+
+// childrenMu is held for reading while a child the process started itself runs. Waiting for any child
+// would also take its exit status from os/exec, so the reaper only reaps while none runs.
+var childrenMu sync.RWMutex
+
+// trackChild keeps the reaper away from the children started until the returned func is called.
+func trackChild() func() {
+	childrenMu.RLock()
+	return childrenMu.RUnlock
+}
+
+// startReaper reaps orphaned child processes when running as pid 1, like a container entrypoint
+// without an init wrapper. Outside of pid 1 the parent init does that.
+func startReaper() {
+	if os.Getpid() != 1 {
+		return
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGCHLD)
+
+	go func() {
+		for range sigs {
+			reapOrphans()
+		}
+	}()
+}
+
+// reapOrphans waits for the exited children once no tracked child runs, those left are orphans that were
+// handed to pid 1. Orphans that exit in the meantime are reaped with the next one.
+func reapOrphans() {
+	childrenMu.Lock()
+	defer childrenMu.Unlock()
+	for {
+		var status syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil)
+		if err != nil || pid <= 0 {
+			return
+		}
+		log.Debug("reaped child process", "pid", pid, "status", status.ExitStatus())
+	}
+}
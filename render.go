@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	neturl "net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// browserNames are looked up in the PATH when render_browser is not set.
+var browserNames = []string{"chromium", "chromium-browser", "google-chrome", "google-chrome-stable", "chrome"}
+
+// challengeMarkers are in the anti-bot challenge pages krosmoz serves instead of the almanax.
+var challengeMarkers = [][]byte{[]byte("cf-chl"), []byte("challenge-platform"), []byte("Just a moment...")}
+
+// isChallengePage reports whether krosmoz answered with an anti-bot challenge.
+func isChallengePage(html []byte) bool {
+	for _, marker := range challengeMarkers {
+		if bytes.Contains(html, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// findBrowser returns the configured browser or the first chromium like browser in the PATH, empty if
+// there is none.
+func findBrowser(configured string) string {
+	if configured != "" {
+		path, err := exec.LookPath(configured)
+		if err != nil {
+			return ""
+		}
+		return path
+	}
+	for _, name := range browserNames {
+		if path, err := exec.LookPath(name); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// renderPoll is how often a rendered page is read again while it still shows the challenge.
+const renderPoll = 500 * time.Millisecond
+
+// renderPage loads a page in a headless browser over the devtools protocol and returns the document once
+// the challenge scripts let it through, so a challenge that the browser passes leads to the real page. It
+// waits for its turn at krosmoz like any other request and goes through the proxy whose turn it is, whose
+// credentials are answered over the protocol instead of the command line. The outcome counts for the proxy
+// like a request.
+func renderPage(browser string, url string, timeout time.Duration) ([]byte, error) {
+	err := waitKrosmozTurn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := scrapeProxies.peek()
+	var proxyUser *neturl.Userinfo
+	if proxy != nil {
+		proxyUser = proxy.url.User
+		// chromium has no way to authenticate to a socks proxy
+		if proxyUser != nil && strings.HasPrefix(proxy.url.Scheme, "socks") {
+			return nil, fmt.Errorf("rendering %s: the browser can not authenticate to the socks proxy %s", url, proxy.url.Redacted())
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	html, err := renderInBrowser(ctx, browser, url, proxy, proxyUser)
+	if proxy != nil {
+		scrapeProxies.report(proxy, err == nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("rendering %s: %w", url, err)
+	}
+	return html, nil
+}
+
+func renderInBrowser(ctx context.Context, browser string, url string, proxy *proxyState, proxyUser *neturl.Userinfo) ([]byte, error) {
+	profile, err := os.MkdirTemp("", "alm-dates-render-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(profile)
+
+	args := []string{
+		"--headless=new",
+		"--disable-gpu",
+		"--no-first-run",
+		"--no-default-browser-check",
+		"--remote-debugging-port=0",
+		"--user-data-dir=" + profile,
+		"--user-agent=" + scrapeUserAgents.next(),
+	}
+	if proxy != nil {
+		// only scheme and host, the credentials would be visible in the process list
+		args = append(args, "--proxy-server="+proxy.url.Scheme+"://"+proxy.url.Host)
+	}
+	args = append(args, "about:blank")
+
+	cmd := exec.CommandContext(ctx, browser, args...)
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	untrack := trackChild()
+	err = cmd.Start()
+	if err != nil {
+		untrack()
+		return nil, err
+	}
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		untrack()
+	}()
+
+	debuggerUrl, err := devtoolsUrl(ctx, stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	loaded := make(chan struct{}, 1)
+	conn, err := dialCdp(debuggerUrl, func(c *cdpConn, event cdpEvent) {
+		handleRenderEvent(c, event, proxyUser, loaded)
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.close()
+
+	var target struct {
+		TargetId string `json:"targetId"`
+	}
+	err = conn.call(ctx, "", "Target.createTarget", map[string]any{"url": "about:blank"}, &target)
+	if err != nil {
+		return nil, err
+	}
+	var attached struct {
+		SessionId string `json:"sessionId"`
+	}
+	err = conn.call(ctx, "", "Target.attachToTarget", map[string]any{"targetId": target.TargetId, "flatten": true}, &attached)
+	if err != nil {
+		return nil, err
+	}
+	session := attached.SessionId
+
+	if proxyUser != nil {
+		err = conn.call(ctx, session, "Fetch.enable", map[string]any{"handleAuthRequests": true, "patterns": []map[string]string{{"urlPattern": "*"}}}, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = conn.call(ctx, session, "Page.enable", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var navigated struct {
+		ErrorText string `json:"errorText"`
+	}
+	err = conn.call(ctx, session, "Page.navigate", map[string]any{"url": url}, &navigated)
+	if err != nil {
+		return nil, err
+	}
+	if navigated.ErrorText != "" {
+		return nil, fmt.Errorf("navigating: %s", navigated.ErrorText)
+	}
+
+	select {
+	case <-loaded:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for the page to load: %w", ctx.Err())
+	}
+
+	// the challenge scripts redirect to the real page on their own, the document is read until they did
+	for {
+		var evaluated struct {
+			Result struct {
+				Value string `json:"value"`
+			} `json:"result"`
+		}
+		err = conn.call(ctx, session, "Runtime.evaluate", map[string]any{"expression": "document.documentElement.outerHTML", "returnByValue": true}, &evaluated)
+		if err != nil {
+			return nil, err
+		}
+		html := []byte(evaluated.Result.Value)
+		if !isChallengePage(html) {
+			_ = conn.call(ctx, "", "Browser.close", nil, nil)
+			return html, nil
+		}
+
+		select {
+		case <-time.After(renderPoll):
+		case <-ctx.Done():
+			return nil, errors.New("the browser did not pass the challenge")
+		}
+	}
+}
+
+// handleRenderEvent answers the proxy credentials and lets the requests the authentication paused go on.
+func handleRenderEvent(c *cdpConn, event cdpEvent, proxyUser *neturl.Userinfo, loaded chan struct{}) {
+	switch event.Method {
+	case "Page.loadEventFired":
+		select {
+		case loaded <- struct{}{}:
+		default:
+		}
+	case "Fetch.requestPaused":
+		var paused struct {
+			RequestId string `json:"requestId"`
+		}
+		if json.Unmarshal(event.Params, &paused) == nil {
+			_, _ = c.send(event.SessionId, "Fetch.continueRequest", map[string]any{"requestId": paused.RequestId})
+		}
+	case "Fetch.authRequired":
+		var auth struct {
+			RequestId     string `json:"requestId"`
+			AuthChallenge struct {
+				Source string `json:"source"`
+			} `json:"authChallenge"`
+		}
+		if json.Unmarshal(event.Params, &auth) != nil {
+			return
+		}
+		response := map[string]any{"response": "CancelAuth"}
+		// only the proxy gets the credentials, never krosmoz
+		if auth.AuthChallenge.Source == "Proxy" && proxyUser != nil {
+			password, _ := proxyUser.Password()
+			response = map[string]any{"response": "ProvideCredentials", "username": proxyUser.Username(), "password": password}
+		}
+		_, _ = c.send(event.SessionId, "Fetch.continueWithAuth", map[string]any{"requestId": auth.RequestId, "authChallengeResponse": response})
+	}
+}
+
+// devtoolsUrl reads the devtools address the browser prints on start. The rest of its output is discarded,
+// so it does not block on a full pipe.
+func devtoolsUrl(ctx context.Context, stderr io.Reader) (string, error) {
+	found := make(chan string, 1)
+	failed := make(chan error, 1)
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		var output []string
+		for scanner.Scan() {
+			if address, ok := strings.CutPrefix(scanner.Text(), "DevTools listening on "); ok {
+				found <- address
+				_, _ = io.Copy(io.Discard, stderr)
+				return
+			}
+			output = append(output, scanner.Text())
+		}
+		failed <- fmt.Errorf("browser exited before it listened for devtools: %s", strings.Join(output, "\n"))
+	}()
+
+	select {
+	case address := <-found:
+		return address, nil
+	case err := <-failed:
+		return "", err
+	case <-ctx.Done():
+		return "", fmt.Errorf("waiting for the browser to start: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/charmbracelet/log"
+	"github.com/dofusdude/alm-dates/almanax"
+)
+
+// pagesDir holds the fetched krosmoz pages as <lang>/<date>.html, with <lang>/<date>.validators.json for
+// conditional requests, and the cells of month views as <lang>/<date>.month.html.
+func pagesDir(workdir string) string {
+	return filepath.Join(cacheDir(workdir), "pages")
+}
+
+// replayDir holds a replayRecord per version.
+func replayDir(workdir string) string {
+	return filepath.Join(cacheDir(workdir), "replay")
+}
+
+func pagePath(dir string, lang string, date string) string {
+	return filepath.Join(dir, lang, date+".html")
+}
+
+// savePage keeps a page, the validators of a page it replaces do not belong to it and are removed.
+func savePage(dir string, lang string, date string, html []byte) error {
+	path := pagePath(dir, lang, date)
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(path, html, 0644)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(validatorsPath(dir, lang, date))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func monthCellPath(dir string, lang string, date string) string {
+	return filepath.Join(dir, lang, date+".month.html")
+}
+
+// saveMonthCell keeps the cell of a date from a month view. It is not a day page, so the page cache and the
+// replay strategy do not take it for one.
+func saveMonthCell(dir string, lang string, date string, html []byte) error {
+	path := monthCellPath(dir, lang, date)
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, html, 0644)
+}
+
+// removePage drops a kept page with its validators.
+func removePage(dir string, lang string, date string) error {
+	for _, path := range []string{pagePath(dir, lang, date), validatorsPath(dir, lang, date)} {
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// pageValidators are the ETag and Last-Modified of a kept page, sent back to krosmoz to ask whether it changed.
+type pageValidators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+func (v pageValidators) empty() bool {
+	return v.ETag == "" && v.LastModified == ""
+}
+
+func validatorsPath(dir string, lang string, date string) string {
+	return filepath.Join(dir, lang, date+".validators.json")
+}
+
+func savePageValidators(dir string, lang string, date string, validators pageValidators) error {
+	data, err := json.Marshal(validators)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(validatorsPath(dir, lang, date), data, 0644)
+}
+
+// loadPageValidators returns the validators of a kept page, empty if there are none.
+func loadPageValidators(dir string, lang string, date string) (pageValidators, error) {
+	var validators pageValidators
+	data, err := os.ReadFile(validatorsPath(dir, lang, date))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return validators, nil
+		}
+		return validators, err
+	}
+	err = json.Unmarshal(data, &validators)
+	return validators, err
+}
+
+// loadPage returns the kept page of a date, nil if there is none.
+func loadPage(dir string, lang string, date string) ([]byte, error) {
+	html, err := os.ReadFile(pagePath(dir, lang, date))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return html, err
+}
+
+// loadKeptAlmanaxPage reads the kept page of a date, or the month view cell it was mapped from if there is
+// no day page. ok is false if there is neither.
+func loadKeptAlmanaxPage(dir string, lang string, date string) (almanaxPage, bool, error) {
+	html, err := loadPage(dir, lang, date)
+	if err != nil {
+		return almanaxPage{}, false, err
+	}
+
+	cell := false
+	if html == nil {
+		html, err = os.ReadFile(monthCellPath(dir, lang, date))
+		if os.IsNotExist(err) {
+			return almanaxPage{}, false, nil
+		}
+		if err != nil {
+			return almanaxPage{}, false, err
+		}
+		cell = true
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(html))
+	if err != nil {
+		return almanaxPage{}, false, err
+	}
+	if cell {
+		var page almanaxPage
+		extractQuest(doc.Text(), lang, &page)
+		return page, true, nil
+	}
+	return extractAlmanaxPage(doc, lang), true, nil
+}
+
+// replayRecord is what a mapping run started from: the downloaded asset, the dates it scraped and the
+// languages it tried. Together with the kept pages it is enough to build the published asset again.
+type replayRecord struct {
+	Version   string          `json:"version"`
+	Languages []string        `json:"languages"`
+	Dates     []string        `json:"dates"`
+	Seed      json.RawMessage `json:"seed"`
+}
+
+func replayRecordPath(workdir string, version string) string {
+	return filepath.Join(replayDir(workdir), version+".json")
+}
+
+// saveReplayRecord keeps the seed of a run, a later run of the same version replaces it.
+func saveReplayRecord(workdir string, version string, languages []string, dates []string, seed *almanax.Dataset) error {
+	data, err := seed.Encode()
+	if err != nil {
+		return err
+	}
+	record, err := json.Marshal(replayRecord{Version: version, Languages: languages, Dates: dates, Seed: data})
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(replayDir(workdir), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(replayRecordPath(workdir, version), record, 0644)
+}
+
+func loadReplayRecord(workdir string, version string) (replayRecord, error) {
+	var record replayRecord
+	data, err := os.ReadFile(replayRecordPath(workdir, version))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return record, fmt.Errorf("no replay record for %s in %s", version, replayDir(workdir))
+		}
+		return record, err
+	}
+	err = json.Unmarshal(data, &record)
+	return record, err
+}
+
+// replay maps the dates of a record from the kept pages, without network access.
+func replay(workdir string, record replayRecord, aliases map[string]string) (*almanax.Dataset, error) {
+	ds, err := almanax.Decode(record.Seed)
+	if err != nil {
+		return nil, fmt.Errorf("seed: %w", err)
+	}
+
+	overrides, err := loadOverrides(workdir)
+	if err != nil {
+		return nil, err
+	}
+
+	pages := pagesDir(workdir)
+	for _, date := range record.Dates {
+		if override, ok := overrides[date]; ok {
+			i, err := override.resolve(ds, date, aliases)
+			if err != nil {
+				return nil, err
+			}
+			if i != -1 {
+				ds.Receivers[i].Days = append(ds.Receivers[i].Days, date)
+			}
+			continue
+		}
+
+		var page almanaxPage
+		var lang string
+		kept := false
+		for _, lang = range record.Languages {
+			page, kept, err = loadKeptAlmanaxPage(pages, lang, date)
+			if err != nil {
+				return nil, fmt.Errorf("%s/%s: %w", lang, date, err)
+			}
+			if kept {
+				break
+			}
+		}
+		if !kept {
+			return nil, fmt.Errorf("no kept page for %s", date)
+		}
+
+		i := matchAlmanaxPage(ds, lang, page, aliases)
+		if i == -1 {
+			return nil, fmt.Errorf("could not find offering receiver %q for %s in %s", page.Receiver, date, lang)
+		}
+		ds.Receivers[i].Days = append(ds.Receivers[i].Days, date)
+	}
+
+	ds.SortDays()
+	return ds, nil
+}
+
+// replayAsset is replay encoded like a publish.
+func replayAsset(workdir string, record replayRecord, aliases map[string]string) ([]byte, error) {
+	ds, err := replay(workdir, record, aliases)
+	if err != nil {
+		return nil, err
+	}
+	return ds.Encode()
+}
+
+// verifyReproducible builds the asset again and reads it back, both have to give exactly the same bytes.
+func verifyReproducible(data []byte, build func() ([]byte, error)) error {
+	again, err := build()
+	if err != nil {
+		return fmt.Errorf("second build: %w", err)
+	}
+	if i := firstDifference(data, again); i != -1 {
+		return fmt.Errorf("second build differs at byte %d", i)
+	}
+
+	ds, err := almanax.Decode(data)
+	if err != nil {
+		return fmt.Errorf("reading back: %w", err)
+	}
+	roundTrip, err := ds.Encode()
+	if err != nil {
+		return fmt.Errorf("writing again: %w", err)
+	}
+	if i := firstDifference(data, roundTrip); i != -1 {
+		return fmt.Errorf("reading back and writing again differs at byte %d", i)
+	}
+	return nil
+}
+
+// firstDifference returns the offset of the first differing byte, -1 if a and b are equal.
+func firstDifference(a []byte, b []byte) int {
+	for i := range min(len(a), len(b)) {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	if len(a) != len(b) {
+		return min(len(a), len(b))
+	}
+	return -1
+}
+
+func replayCommand(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	version := flags.String("version", "", "game version to rebuild, defaults to the last seen version")
+	out := flags.String("out", "", "file the rebuilt asset is written to, defaults to stdout")
+	expect := flags.String("expect", "", "asset to compare the rebuilt one with, exits with 1 if they differ")
+	verify := flags.Bool("verify-reproducible", false, "build twice and read the result back, exits with 1 if the bytes differ")
+	cfg, _, err := loadConfig(flags, args)
+	if err != nil {
+		log.Fatal("error loading config", "error", err)
+	}
+
+	workdir, err := parseWd(cfg.Workdir)
+	if err != nil {
+		log.Fatal("error parsing working directory", "error", err)
+	}
+	err = migrateWorkdir(workdir)
+	if err != nil {
+		log.Fatal("error migrating working directory", "error", err)
+	}
+	if *version == "" {
+		*version, err = loadLocalVersion(workdir)
+		if err != nil || *version == "" {
+			log.Fatal("no version given and none seen yet", "error", err)
+		}
+	}
+
+	record, err := loadReplayRecord(workdir, *version)
+	if err != nil {
+		log.Fatal("error loading replay record", "error", err)
+	}
+	nameTolerance = cfg.NameTolerance
+	aliases := parseReceiverAliases(cfg.ReceiverAliases)
+	data, err := replayAsset(workdir, record, aliases)
+	if err != nil {
+		log.Fatal("error replaying", "version", *version, "error", err)
+	}
+
+	if *verify {
+		err = verifyReproducible(data, func() ([]byte, error) {
+			return replayAsset(workdir, record, aliases)
+		})
+		if err != nil {
+			log.Error("output is not reproducible", "version", *version, "error", err)
+			os.Exit(1)
+		}
+		log.Info("output is reproducible", "version", *version, "bytes", len(data))
+	}
+
+	if *out == "" {
+		_, err = os.Stdout.Write(data)
+	} else {
+		err = os.WriteFile(*out, data, 0644)
+	}
+	if err != nil {
+		log.Fatal("error writing almanax", "error", err)
+	}
+	log.Info("replayed", "version", *version, "dates", len(record.Dates))
+
+	if *expect != "" {
+		expected, err := os.ReadFile(*expect)
+		if err != nil {
+			log.Fatal("error reading expected almanax", "error", err)
+		}
+		if i := firstDifference(bytes.TrimSpace(expected), bytes.TrimSpace(data)); i != -1 {
+			log.Error("replayed almanax differs", "expected", *expect, "at", i)
+			os.Exit(1)
+		}
+		log.Info("replayed almanax matches", "expected", *expect)
+	}
+}
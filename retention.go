@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/google/go-github/v67/github"
+)
+
+var contentAddressedRegex = regexp.MustCompile(`^MAPPED_ALMANAX-[0-9a-f]{6}\.json$`)
+
+// producedAsset reports whether alm-dates uploaded the asset next to the mapped almanax. The mapped almanax
+// itself comes with the data release and is never removed, neither is its provenance. The history of an
+// older release is contained in the newer ones.
+func producedAsset(name string) bool {
+	return name == MappedAlmanaxPatchFileName || name == MappedAlmanaxPointerFileName || name == MappedAlmanaxEnrichedFileName || name == MappedAlmanaxFlavorFileName || name == MappedAlmanaxDiffFileName || name == AlmanaxHistoryFileName || contentAddressedRegex.MatchString(name)
+}
+
+// listReleases returns all releases of the data repo, newest first.
+func listReleases(ctx context.Context, client *github.Client, repo dataRepo, retry RetryPolicy) ([]*github.RepositoryRelease, error) {
+	var releases []*github.RepositoryRelease
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		var page []*github.RepositoryRelease
+		var res *github.Response
+		err := retry.do(ctx, "list releases", func() error {
+			var err error
+			page, res, err = client.Repositories.ListReleases(ctx, repo.owner, repo.name, opts)
+			return githubRetryable(err)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		releases = append(releases, page...)
+		if res.NextPage == 0 {
+			return releases, nil
+		}
+		opts.Page = res.NextPage
+	}
+}
+
+// staleAssets returns the produced assets to remove from a release. Releases after the newest keep
+// releases lose all of them, kept releases only the content addressed copies of earlier uploads.
+func staleAssets(release *github.RepositoryRelease, kept bool) []*github.ReleaseAsset {
+	var newest *github.ReleaseAsset
+	if kept {
+		for _, asset := range release.Assets {
+			if contentAddressedRegex.MatchString(asset.GetName()) && (newest == nil || asset.GetCreatedAt().After(newest.GetCreatedAt().Time)) {
+				newest = asset
+			}
+		}
+	}
+
+	var stale []*github.ReleaseAsset
+	for _, asset := range release.Assets {
+		if !producedAsset(asset.GetName()) {
+			continue
+		}
+		if kept && (!contentAddressedRegex.MatchString(asset.GetName()) || asset == newest) {
+			continue
+		}
+		stale = append(stale, asset)
+	}
+	return stale
+}
+
+// archiveAsset downloads a release asset into dir/<tag>/<name>.
+func archiveAsset(ctx context.Context, client *github.Client, repo dataRepo, dir string, tag string, asset *github.ReleaseAsset, retry RetryPolicy) error {
+	path := filepath.Join(dir, tag, asset.GetName())
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	return retry.do(ctx, "archive asset", func() error {
+		reader, _, err := client.Repositories.DownloadReleaseAsset(ctx, repo.owner, repo.name, asset.GetID(), httpClient)
+		if err != nil {
+			return githubRetryable(err)
+		}
+		defer reader.Close()
+
+		file, err := os.Create(path)
+		if err != nil {
+			return permanent(err)
+		}
+		defer file.Close()
+
+		_, err = io.Copy(file, reader)
+		return err
+	})
+}
+
+// cleanupReleases removes the assets alm-dates added to releases older than the newest keep_releases,
+// archiving them first if archive_dir is set.
+func (p *pipeline) cleanupReleases() error {
+	if p.cfg.KeepReleases <= 0 {
+		p.log.Info("keep_releases is not set, not cleaning up releases")
+		return nil
+	}
+
+	ctx := context.Background()
+	client := githubClient(httpClient, &p.cfg)
+	retry := p.cfg.retryPolicy()
+
+	releases, err := listReleases(ctx, client, p.repo, retry)
+	if err != nil {
+		return err
+	}
+
+	archiveDir := p.cfg.ArchiveDir
+	if archiveDir != "" && !filepath.IsAbs(archiveDir) {
+		archiveDir = filepath.Join(p.workdir, archiveDir)
+	}
+
+	removed := 0
+	for i, release := range releases {
+		for _, asset := range staleAssets(release, i < p.cfg.KeepReleases) {
+			if archiveDir != "" {
+				err = archiveAsset(ctx, client, p.repo, archiveDir, release.GetTagName(), asset, retry)
+				if err != nil {
+					return err
+				}
+			}
+
+			err = retry.do(ctx, "delete asset", func() error {
+				_, err := client.Repositories.DeleteReleaseAsset(ctx, p.repo.owner, p.repo.name, asset.GetID())
+				return githubRetryable(err)
+			})
+			if err != nil {
+				return err
+			}
+			p.log.Debug("removed release asset", "release", release.GetTagName(), "asset", asset.GetName())
+			removed++
+		}
+	}
+
+	p.log.Info("releases cleaned up", "releases", len(releases), "removed", removed, "archive", archiveDir)
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/google/go-github/v67/github"
+	"golang.org/x/exp/rand"
+)
+
+// RetryPolicy is the exponential backoff used for every remote call: krosmoz, github and doduapi.
+type RetryPolicy struct {
+	Initial    time.Duration
+	Multiplier float64
+	Max        time.Duration
+	// Attempts is the number of tries including the first one, 0 retries forever.
+	Attempts int
+	// Jitter is the share of a delay that is randomized, between 0 and 1.
+	Jitter float64
+}
+
+// retryPolicy is used by calls that are not bound to a pipeline config, it is set from the config on startup.
+var retryPolicy = RetryPolicy{Initial: 5 * time.Second, Multiplier: 2, Max: 5 * time.Minute, Attempts: 8, Jitter: 0.2}
+
+// downloadTimeout bounds a release asset download, set from the config on startup like retryPolicy.
+var downloadTimeout = 2 * time.Minute
+
+// delay returns the wait before the retry after the given failed attempt, starting at 1.
+func (r RetryPolicy) delay(attempt int) time.Duration {
+	d := float64(r.Initial) * math.Pow(r.Multiplier, float64(attempt-1))
+	if r.Max > 0 {
+		d = min(d, float64(r.Max))
+	}
+	if r.Jitter > 0 {
+		d += d * r.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// permanentError stops a retry, the call would fail the same way again.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+func permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// do runs fn until it succeeds, returns a permanent error, the attempts are used up or ctx is done.
+func (r RetryPolicy) do(ctx context.Context, op string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		var perm permanentError
+		if errors.As(err, &perm) {
+			return perm.err
+		}
+		if r.Attempts > 0 && attempt >= r.Attempts {
+			return fmt.Errorf("%s failed after %d attempts: %w", op, attempt, err)
+		}
+
+		wait := r.delay(attempt)
+		log.Warn("retrying", "op", op, "attempt", attempt, "in", FormatDuration(wait.Round(time.Second)), "error", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// githubRetryable marks github errors permanent unless the request may work later: server errors and
+// rate limits. Network errors stay retryable.
+func githubRetryable(err error) error {
+	var rateLimit *github.RateLimitError
+	var abuse *github.AbuseRateLimitError
+	if errors.As(err, &rateLimit) || errors.As(err, &abuse) {
+		return err
+	}
+
+	var res *github.ErrorResponse
+	if errors.As(err, &res) && res.Response != nil && res.Response.StatusCode < 500 && res.Response.StatusCode != http.StatusTooManyRequests {
+		return permanent(err)
+	}
+	return err
+}
+
+// statusRetryable turns an unexpected http status into an error with the start of the body, permanent
+// for client errors.
+func statusRetryable(res *http.Response) error {
+	if res.StatusCode < 300 {
+		return nil
+	}
+
+	err := fmt.Errorf("status code error: %d %s", res.StatusCode, http.StatusText(res.StatusCode))
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+	if msg := strings.TrimSpace(string(body)); msg != "" {
+		err = fmt.Errorf("%w: %s", err, msg)
+	}
+	if res.StatusCode < 500 && res.StatusCode != http.StatusTooManyRequests {
+		return permanent(err)
+	}
+	return err
+}
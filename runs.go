@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const runsFileName = "runs.jsonl"
+
+// runRecord is a finished job in the run history.
+type runRecord struct {
+	Kind     jobKind   `json:"kind"`
+	Version  string    `json:"version,omitempty"`
+	Trigger  string    `json:"trigger,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Error    string    `json:"error,omitempty"`
+	// BonusDivergences are the scraped pages whose bonus differs from the data repo
+	BonusDivergences []bonusDivergence `json:"bonus_divergences,omitempty"`
+	// Ambiguous are the dates left unmapped because their receiver is as close to several mapped ones
+	Ambiguous []ambiguousReceiverError `json:"ambiguous_receivers,omitempty"`
+	// Unmatched are the dates left unmapped because their receiver matches no mapped one
+	Unmatched []receiverMismatchError `json:"unmatched_receivers,omitempty"`
+	// Secondary are the sampled dates the secondary source gives another offering for
+	Secondary []secondaryDisagreement `json:"secondary_disagreements,omitempty"`
+}
+
+// appendRun adds a finished job to the run history in the workdir state.
+func appendRun(workdir string, run runRecord) error {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(stateDir(workdir), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(stateDir(workdir), runsFileName), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// loadRuns returns the last runs of the history, newest first. Unreadable lines are skipped.
+func loadRuns(workdir string, last int) ([]runRecord, error) {
+	f, err := os.Open(filepath.Join(stateDir(workdir), runsFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var runs []runRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var run runRecord
+		if json.Unmarshal(scanner.Bytes(), &run) != nil {
+			continue
+		}
+		runs = append(runs, run)
+		if len(runs) > last {
+			runs = runs[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for a, b := 0, len(runs)-1; a < b; a, b = a+1, b-1 {
+		runs[a], runs[b] = runs[b], runs[a]
+	}
+	return runs, nil
+}
+
+// recordRun keeps a finished job in the run history.
+func (p *pipeline) recordRun(j job, started time.Time, err error) {
+	run := runRecord{Kind: j.Kind, Version: j.Version, Trigger: j.Trigger, Started: started.UTC(), Finished: time.Now().UTC()}
+	if err != nil {
+		run.Error = err.Error()
+	}
+	run.BonusDivergences = p.scraper.bonus.take()
+	if len(run.BonusDivergences) > 0 {
+		p.log.Warn("scraped bonuses differ from the data repo, it may map them to the wrong receivers", "kind", j.Kind, "version", j.Version, "divergences", len(run.BonusDivergences))
+	}
+	run.Ambiguous = p.scraper.review.take()
+	if len(run.Ambiguous) > 0 {
+		p.alerts.add(fmt.Sprintf("%d dates left unmapped, their receiver is ambiguous, add receiver_aliases for them", len(run.Ambiguous)), nil)
+	}
+	run.Unmatched = p.scraper.unmatched.take()
+	if len(run.Unmatched) > 0 && err == nil {
+		p.alerts.add(fmt.Sprintf("%d dates published unmapped, their receiver matches nothing, add receiver_aliases for them", len(run.Unmatched)), nil)
+	}
+	run.Secondary = p.scraper.secondary.take()
+	if len(run.Secondary) > 0 {
+		p.alerts.add(fmt.Sprintf("%d sampled dates disagree with the secondary source, check them on krosmoz", len(run.Secondary)), nil)
+	}
+	if err := appendRun(p.workdir, run); err != nil {
+		p.log.Warn("error recording run", "error", err)
+	}
+}
@@ -0,0 +1,569 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/charmbracelet/log"
+)
+
+const KrosmozUrl = "https://www.krosmoz.com"
+
+// krosmozHost is the host of KrosmozUrl, its requests go through the scrape proxies.
+const krosmozHost = "www.krosmoz.com"
+
+// receiverNamePattern captures names of several words with accents, apostrophes and hyphens.
+const receiverNamePattern = `([\p{L}\p{M}'’\-]+(?: [\p{L}\p{M}'’\-]+)*)`
+
+var kamasRegex = regexp.MustCompile(`(?i)(\d[\d .,]*)\s*kamas`)
+
+// almanaxPage is what could be extracted from a krosmoz almanax page, fields that were not found are empty.
+type almanaxPage struct {
+	Receiver string
+	Bonus    string
+	Item     string
+	Quantity int
+	Kamas    int
+}
+
+// missingFields lists the fields the extraction did not find.
+func (p almanaxPage) missingFields() []string {
+	var missing []string
+	if p.Receiver == "" {
+		missing = append(missing, "receiver")
+	}
+	if p.Bonus == "" {
+		missing = append(missing, "bonus")
+	}
+	if p.Item == "" || p.Quantity == 0 {
+		missing = append(missing, "item")
+	}
+	if p.Kamas == 0 {
+		missing = append(missing, "kamas")
+	}
+	return missing
+}
+
+func almanaxPageUrl(lang string, date string) string {
+	return fmt.Sprintf("%s/%s/almanax/%s?game=dofus", KrosmozUrl, lang, date)
+}
+
+// fetchAlmanaxHtml requests the almanax page of a date. The html is nil if the status is not 200,
+// krosmoz answers 202 for dates it did not generate yet.
+func fetchAlmanaxHtml(lang string, date string, timeout time.Duration) ([]byte, int, error) {
+	return fetchKrosmozHtml(lang, almanaxPageUrl(lang, date), timeout)
+}
+
+func fetchKrosmozHtml(lang string, url string, timeout time.Duration) ([]byte, int, error) {
+	answer, err := fetchKrosmozPage(lang, url, timeout, pageValidators{})
+	return answer.html, answer.status, err
+}
+
+// krosmozAnswer is the answer to a krosmoz page request, html is nil unless the status is 200.
+type krosmozAnswer struct {
+	html       []byte
+	status     int
+	validators pageValidators
+}
+
+// fetchKrosmozPage requests a krosmoz page after the backoff of earlier throttled requests and the rate
+// limiter, the budget and the politeness delay let it through. With validators of a kept page the request
+// is conditional and krosmoz answers 304 if the page did not change. A request the throttle gives up on
+// fails with a permanent throttledError.
+func fetchKrosmozPage(lang string, url string, timeout time.Duration, validators pageValidators) (krosmozAnswer, error) {
+	err := krosmozThrottled.wait(context.Background())
+	if err != nil {
+		return krosmozAnswer{}, permanent(err)
+	}
+	// the timeout starts when it is the turn of the request
+	err = waitKrosmozTurn(context.Background())
+	if err != nil {
+		return krosmozAnswer{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return krosmozAnswer{}, err
+	}
+	req.Header.Set("User-Agent", scrapeUserAgents.next())
+	if validators.ETag != "" {
+		req.Header.Set("If-None-Match", validators.ETag)
+	}
+	if validators.LastModified != "" {
+		req.Header.Set("If-Modified-Since", validators.LastModified)
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		metrics.add(metricScrapeRequests, 1, lang, "error")
+		return krosmozAnswer{}, err
+	}
+	defer res.Body.Close()
+	metrics.add(metricScrapeRequests, 1, lang, strconv.Itoa(res.StatusCode))
+	krosmozThrottled.report(res.StatusCode, res.Header.Get("Retry-After"))
+
+	answer := krosmozAnswer{status: res.StatusCode}
+	if res.StatusCode != 200 {
+		return answer, nil
+	}
+
+	answer.html, err = io.ReadAll(res.Body)
+	if err != nil {
+		return krosmozAnswer{status: res.StatusCode}, err
+	}
+	answer.validators = pageValidators{ETag: res.Header.Get("ETag"), LastModified: res.Header.Get("Last-Modified")}
+	return answer, nil
+}
+
+// fetchAlmanaxDocument is fetchAlmanaxHtml parsed into a document.
+func fetchAlmanaxDocument(lang string, date string, timeout time.Duration) (*goquery.Document, int, error) {
+	html, status, err := fetchAlmanaxHtml(lang, date, timeout)
+	if err != nil || html == nil {
+		return nil, status, err
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(html))
+	if err != nil {
+		return nil, status, err
+	}
+	return doc, status, nil
+}
+
+// scraper fetches almanax pages, trying the languages in order.
+type scraper struct {
+	languages []string
+	// fallbackAfter is the number of 202 or 404 answers after which the next language is tried for a date.
+	fallbackAfter int
+	retry         RetryPolicy
+	timeout       time.Duration
+	// pages is the directory the fetched pages are kept in for replay, disabled if empty
+	pages string
+	// cacheTtl is how long a kept page is used instead of requesting it again, 0 disables it
+	cacheTtl time.Duration
+	// crossCheckLanguages are scraped for every mapped date to check the receiver, the locale discovery
+	// adds to them while jobs run
+	crossCheckLanguages []string
+	languagesMu         sync.Mutex
+	// monthly scrapes the month views first, only dates missing there are requested per day
+	monthly bool
+	// workers is the number of dates scraped at the same time
+	workers int
+	// browser renders pages plain requests could not extract anything from, disabled if empty
+	browser string
+	// bonus collects the pages whose bonus differs from the data
+	bonus bonusReport
+	// review collects the dates whose receiver was too close to several mapped ones
+	review reviewList
+	// unmatched collects the dates whose receiver matched nothing
+	unmatched unmatchedList
+	// secondary collects the dates the secondary source disagrees with
+	secondary secondaryReport
+	// limits caps the requests and the duration of a job
+	limits *runLimits
+}
+
+func newScraper(cfg *Config, pages string) *scraper {
+	return &scraper{
+		languages:           cfg.ScrapeLanguages,
+		fallbackAfter:       cfg.FallbackAfter,
+		retry:               cfg.retryPolicy(),
+		timeout:             cfg.ScrapeTimeout,
+		pages:               pages,
+		cacheTtl:            cfg.PageCacheTtl,
+		crossCheckLanguages: cfg.CrossCheckLanguages,
+		monthly:             cfg.ScrapeMode == "month",
+		workers:             cfg.ScrapeWorkers,
+		browser:             cfg.renderBrowser(),
+		limits:              newRunLimits(cfg),
+	}
+}
+
+// checkLanguages returns the cross check languages.
+func (s *scraper) checkLanguages() []string {
+	s.languagesMu.Lock()
+	defer s.languagesMu.Unlock()
+	return s.crossCheckLanguages
+}
+
+// enableCrossCheck adds a language to the cross check languages.
+func (s *scraper) enableCrossCheck(lang string) {
+	s.languagesMu.Lock()
+	defer s.languagesMu.Unlock()
+	if !slices.Contains(s.crossCheckLanguages, lang) {
+		s.crossCheckLanguages = append(slices.Clip(s.crossCheckLanguages), lang)
+	}
+}
+
+// scrapeError is a date that could not be scraped. Status is the last answer of krosmoz, 0 if the
+// requests failed.
+type scrapeError struct {
+	Date     string
+	Url      string
+	Status   int
+	Attempts int
+	Err      error
+}
+
+func (e scrapeError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("scraping %s failed after %d attempts: %s", e.Date, e.Attempts, e.Err)
+	}
+	return fmt.Sprintf("scraping %s: status %d %s after %d attempts", e.Date, e.Status, http.StatusText(e.Status), e.Attempts)
+}
+
+func (e scrapeError) Unwrap() error {
+	return e.Err
+}
+
+// unavailable reports whether krosmoz has no page for the date yet, so it can be mapped later.
+func (e scrapeError) unavailable() bool {
+	return e.Err == nil && (e.Status == http.StatusAccepted || e.Status == http.StatusNotFound)
+}
+
+// scrape fetches and extracts the almanax page of a date and returns it with its language and when it was
+// fetched. Pages fetched within the cache ttl are read from the workdir instead. Failed requests and
+// unavailable pages are retried with the backoff of the retry policy. Unavailable pages move on to the next
+// language, the last language waits for krosmoz to generate the page up to the retry attempts. A date that
+// can not be scraped returns a scrapeError.
+func (s *scraper) scrape(date string) (almanaxPage, string, time.Time, error) {
+	return s.scrapePage(date, true)
+}
+
+func (s *scraper) scrapePage(date string, cached bool) (almanaxPage, string, time.Time, error) {
+	for i, lang := range s.languages {
+		if cached {
+			if html, fetchedAt := s.cachedPage(lang, date); html != nil {
+				page, err := parseAlmanaxPage(html, lang, date)
+				if err == nil && page.Receiver != "" {
+					log.Debug("page from cache", "date", date, "lang", lang, "fetched", fetchedAt)
+					return page, lang, fetchedAt, nil
+				}
+				// a kept page without the offering is requested again instead of failing every run until the ttl ends
+				log.Warn("dropping cached page that does not parse", "date", date, "lang", lang, "error", err)
+				err = removePage(s.pages, lang, date)
+				if err != nil {
+					log.Warn("error removing cached page", "date", date, "lang", lang, "error", err)
+				}
+			}
+		}
+
+		url := almanaxPageUrl(lang, date)
+		last := i == len(s.languages)-1
+		unavailable := 0
+		failures := 0
+		validators := s.keptValidators(lang, date)
+		for {
+			s.limits.request()
+			answer, err := fetchKrosmozPage(lang, url, s.timeout, validators)
+			html, status := answer.html, answer.status
+			var throttled throttledError
+			if errors.As(err, &throttled) {
+				return almanaxPage{}, lang, time.Time{}, scrapeError{Date: date, Url: url, Status: throttled.Status, Attempts: failures + unavailable + 1, Err: err}
+			}
+			if err != nil {
+				failures++
+				if s.retry.Attempts > 0 && failures >= s.retry.Attempts {
+					return almanaxPage{}, lang, time.Time{}, scrapeError{Date: date, Url: url, Attempts: failures, Err: err}
+				}
+				log.Error("error sending request, waiting and trying again", "err", err, "url", url, "date", date)
+				time.Sleep(s.retry.delay(failures))
+				continue
+			}
+
+			if status == http.StatusNotModified {
+				if page, ok := s.revalidated(lang, date); ok {
+					return page, lang, time.Now().UTC(), nil
+				}
+				// the kept page is gone, ask for the whole page
+				validators = pageValidators{}
+				continue
+			}
+
+			if s.browser != "" && s.blocked(html, status, lang, date) {
+				if rendered := s.render(url, date); rendered != nil {
+					html, status = rendered, 200
+					answer.validators = pageValidators{}
+				}
+			}
+
+			if status == 200 {
+				page, err := parseAlmanaxPage(html, lang, date)
+				// a challenge or a broken page is not kept, the cache would answer with it until the ttl ends
+				if err == nil && page.Receiver != "" {
+					s.keepPage(lang, date, html, answer.validators)
+				}
+				return page, lang, time.Now().UTC(), err
+			}
+
+			// the next request waits for the backoff of the throttle, which gives up on its own
+			if status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable {
+				continue
+			}
+
+			unavailable++
+			if status != 202 && (status != 404 || last) {
+				return almanaxPage{}, lang, time.Time{}, scrapeError{Date: date, Url: url, Status: status, Attempts: unavailable}
+			}
+
+			if !last && unavailable >= s.fallbackAfter {
+				log.Warn("page unavailable, falling back", "date", date, "status", status, "from", lang, "to", s.languages[i+1])
+				break
+			}
+			if s.retry.Attempts > 0 && unavailable >= s.retry.Attempts {
+				return almanaxPage{}, lang, time.Time{}, scrapeError{Date: date, Url: url, Status: status, Attempts: unavailable}
+			}
+
+			log.Info("date not yet available, waiting and trying again", "date", date, "lang", lang, "status", status)
+			time.Sleep(s.retry.delay(unavailable))
+		}
+	}
+
+	panic("scraper without languages")
+}
+
+// blocked reports whether an answer looks like krosmoz kept the page from the scraper: a denied request, an
+// anti-bot challenge or a page without the offering quest.
+func (s *scraper) blocked(html []byte, status int, lang string, date string) bool {
+	if status == http.StatusForbidden || status == http.StatusServiceUnavailable {
+		return true
+	}
+	if status != 200 {
+		return false
+	}
+	if isChallengePage(html) {
+		return true
+	}
+	// a page in a new layout is worth rendering too, the error is for the caller that reads it
+	page, _ := parseAlmanaxPage(html, lang, date)
+	return page.Receiver == ""
+}
+
+// render fetches a page with the browser, nil if that failed too.
+func (s *scraper) render(url string, date string) []byte {
+	log.Warn("krosmoz page not extractable, rendering it in the browser", "date", date, "url", url)
+	html, err := renderPage(s.browser, url, s.timeout)
+	if err != nil {
+		log.Error("error rendering page", "date", date, "error", err)
+		return nil
+	}
+	return html
+}
+
+// parseAlmanaxPage extracts a fetched almanax page. A page without receiver that also lacks blocks of the
+// almanax layout returns a schemaDriftError, krosmoz changed its pages.
+func parseAlmanaxPage(html []byte, lang string, date string) (almanaxPage, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(html))
+	if err != nil {
+		return almanaxPage{}, fmt.Errorf("parsing %s: %w", almanaxPageUrl(lang, date), err)
+	}
+	page := extractAlmanaxPage(doc, lang)
+	if page.Receiver == "" {
+		if missing := missingBlocks(doc); len(missing) > 0 {
+			return page, schemaDriftError{Date: date, Lang: lang, Url: almanaxPageUrl(lang, date), Missing: missing}
+		}
+	}
+	return page, nil
+}
+
+// schemaDriftError is an almanax page that lacks blocks the scraper reads, after a redesign of krosmoz the
+// scraper needs an update rather than a retry.
+type schemaDriftError struct {
+	Date    string
+	Lang    string
+	Url     string
+	Missing []string
+}
+
+func (e schemaDriftError) Error() string {
+	return fmt.Sprintf("scraper schema drift: the %s page of %s lacks the %s", e.Lang, e.Date, strings.Join(e.Missing, ", "))
+}
+
+// missingBlocks lists the blocks of the almanax layout a page lacks: the offering quest, its bonus section
+// and the image of the offered item.
+func missingBlocks(doc *goquery.Document) []string {
+	block := doc.Find(questBlockSelector).First()
+	if block.Length() == 0 {
+		return []string{"offering quest", "bonus section", "item image"}
+	}
+	var missing []string
+	if block.Find(questBonusSelector).Length() == 0 {
+		missing = append(missing, "bonus section")
+	}
+	if block.Find("img").Length() == 0 {
+		missing = append(missing, "item image")
+	}
+	return missing
+}
+
+// keepPage saves a fetched page for replay and the cache, with the validators of its answer for
+// conditional requests.
+func (s *scraper) keepPage(lang string, date string, html []byte, validators pageValidators) {
+	if s.pages == "" {
+		return
+	}
+	err := savePage(s.pages, lang, date, html)
+	if err == nil && !validators.empty() {
+		err = savePageValidators(s.pages, lang, date, validators)
+	}
+	if err != nil {
+		log.Warn("error keeping page for replay", "date", date, "lang", lang, "error", err)
+	}
+}
+
+// keptValidators returns the validators of the kept page of a date, empty if there is none.
+func (s *scraper) keptValidators(lang string, date string) pageValidators {
+	if s.pages == "" {
+		return pageValidators{}
+	}
+	validators, err := loadPageValidators(s.pages, lang, date)
+	if err != nil {
+		log.Warn("error reading page validators", "date", date, "lang", lang, "error", err)
+	}
+	return validators
+}
+
+// revalidated returns the kept page of a date after krosmoz answered that it did not change, and marks it
+// as fetched now for the cache.
+func (s *scraper) revalidated(lang string, date string) (almanaxPage, bool) {
+	html, err := loadPage(s.pages, lang, date)
+	if err != nil || html == nil {
+		return almanaxPage{}, false
+	}
+	page, err := parseAlmanaxPage(html, lang, date)
+	if err != nil {
+		return almanaxPage{}, false
+	}
+	now := time.Now()
+	err = os.Chtimes(pagePath(s.pages, lang, date), now, now)
+	if err != nil {
+		log.Warn("error refreshing cached page", "date", date, "lang", lang, "error", err)
+	}
+	log.Debug("page not modified", "date", date, "lang", lang)
+	return page, true
+}
+
+// cachedPage returns the kept page of a date with the time it was fetched, nil if there is none or it is
+// older than the cache ttl.
+func (s *scraper) cachedPage(lang string, date string) ([]byte, time.Time) {
+	if s.pages == "" || s.cacheTtl <= 0 {
+		return nil, time.Time{}
+	}
+
+	path := pagePath(s.pages, lang, date)
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) > s.cacheTtl {
+		return nil, time.Time{}
+	}
+	html, err := os.ReadFile(path)
+	if err != nil {
+		log.Warn("error reading cached page", "date", date, "lang", lang, "error", err)
+		return nil, time.Time{}
+	}
+	return html, info.ModTime().UTC()
+}
+
+func parseNumber(s string) int {
+	n, err := strconv.Atoi(strings.NewReplacer(" ", "", ".", "", ",", "").Replace(s))
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// The blocks of the dofus offering quest on a krosmoz almanax page.
+const (
+	questBlockSelector    = "#achievement_dofus"
+	questTitleSelector    = ".title"
+	questBonusSelector    = ".more"
+	questOfferingSelector = ".more-infos"
+)
+
+// extractQuest reads the receiver and the offered item from the quest text.
+func extractQuest(text string, lang string, page *almanaxPage) {
+	page.Receiver = extractReceiver(text, lang)
+	page.Quantity, page.Item = extractOffering(text, lang)
+}
+
+func extractReceiver(text string, lang string) string {
+	if matches := almanaxPagePatterns[lang].receiver.FindStringSubmatch(collapseSpaces(text)); len(matches) > 1 {
+		return matches[1]
+	}
+	return ""
+}
+
+func extractOffering(text string, lang string) (int, string) {
+	item := almanaxPagePatterns[lang].item
+	if matches := item.FindStringSubmatch(collapseSpaces(text)); matches != nil {
+		return parseNumber(matches[item.SubexpIndex("quantity")]), strings.TrimSpace(matches[item.SubexpIndex("item")])
+	}
+	return 0, ""
+}
+
+// collapseSpaces turns runs of spaces within a line, non-breaking ones like in "Quête : Offrande"
+// included, into single spaces so the patterns match names and prefixes however the page spaces them.
+// Line breaks are kept, they end a name.
+func collapseSpaces(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// blockText is the text of the first match of a selector with the whitespace collapsed, empty if nothing
+// matches.
+func blockText(sel *goquery.Selection, selector string) string {
+	return strings.Join(strings.Fields(sel.Find(selector).First().Text()), " ")
+}
+
+// extractAlmanaxPage reads the dofus offering quest from an almanax page in the given language. Each field
+// is read from its block of the quest, so text elsewhere on the page can not match. A field whose block is
+// missing is read from the whole quest block, a document without one, like a kept month view cell, from
+// its whole text.
+func extractAlmanaxPage(doc *goquery.Document, lang string) almanaxPage {
+	var page almanaxPage
+	if _, ok := almanaxPagePatterns[lang]; !ok {
+		return page
+	}
+
+	block := doc.Find(questBlockSelector).First()
+	if block.Length() == 0 {
+		extractQuest(doc.Text(), lang, &page)
+		return page
+	}
+
+	page.Receiver = extractReceiver(blockText(block, questTitleSelector), lang)
+	if page.Receiver == "" {
+		page.Receiver = extractReceiver(block.Text(), lang)
+	}
+	page.Quantity, page.Item = extractOffering(blockText(block, questOfferingSelector), lang)
+	if page.Item == "" {
+		page.Quantity, page.Item = extractOffering(block.Text(), lang)
+	}
+
+	quest := block.Find(questBonusSelector).First()
+	if matches := kamasRegex.FindStringSubmatch(quest.Text()); len(matches) > 1 {
+		page.Kamas = parseNumber(matches[1])
+	}
+
+	// the bonus is the text of the block without the quest part
+	bonus := quest.Clone()
+	bonus.Find(".more-infos, .more-infos-content").Remove()
+	page.Bonus = strings.Join(strings.Fields(bonus.Text()), " ")
+
+	return page
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/dofusdude/alm-dates/almanax"
+	mapping "github.com/dofusdude/dodumap"
+)
+
+// searchIndex maps lowercased words of item names, receivers and bonus texts in all languages
+// to the receivers containing them.
+type searchIndex map[string][]int
+
+func searchTokens(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func buildSearchIndex(ds *almanax.Dataset) searchIndex {
+	index := make(searchIndex)
+	for i, alm := range ds.Receivers {
+		texts := []string{alm.Name}
+		for _, lang := range mapping.LanguagesUnity {
+			texts = append(texts, alm.Offering.ItemName[lang], alm.Bonus.Description[lang], alm.Bonus.Type[lang])
+		}
+
+		seen := make(map[string]bool)
+		for _, text := range texts {
+			for _, token := range searchTokens(text) {
+				if seen[token] {
+					continue
+				}
+				seen[token] = true
+				index[token] = append(index[token], i)
+			}
+		}
+	}
+	return index
+}
+
+// search returns the receivers where every query word is a prefix of an indexed word.
+func (index searchIndex) search(query string) []int {
+	var result map[int]bool
+	for _, token := range searchTokens(query) {
+		matches := make(map[int]bool)
+		for word, receivers := range index {
+			if !strings.HasPrefix(word, token) {
+				continue
+			}
+			for _, i := range receivers {
+				if result == nil || result[i] {
+					matches[i] = true
+				}
+			}
+		}
+		result = matches
+	}
+
+	var receivers []int
+	for i := range result {
+		receivers = append(receivers, i)
+	}
+	sort.Ints(receivers)
+	return receivers
+}
+
+type searchResult struct {
+	Date      string `json:"date"`
+	Receiver  string `json:"receiver"`
+	Item      string `json:"item"`
+	Quantity  int    `json:"quantity"`
+	BonusType string `json:"bonus_type"`
+	Bonus     string `json:"bonus"`
+}
+
+func (s *almanaxStore) search(query string, lang string) []searchResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	results := []searchResult{}
+	for _, i := range s.index.search(query) {
+		alm := s.data.Receivers[i]
+		for _, date := range alm.Days {
+			if !isDate(date) {
+				continue
+			}
+			results = append(results, searchResult{
+				Date:      date,
+				Receiver:  alm.Name,
+				Item:      alm.Offering.ItemName[lang],
+				Quantity:  alm.Offering.Quantity,
+				BonusType: alm.Bonus.Type[lang],
+				Bonus:     alm.Bonus.Description[lang],
+			})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Date < results[j].Date
+	})
+	return results
+}
+
+func (s *server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	if len(searchTokens(query)) == 0 {
+		http.Error(w, "missing search query q", http.StatusBadRequest)
+		return
+	}
+
+	results := s.store.search(query, normalizeLang(r.URL.Query().Get("lang")))
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(results)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"github.com/dofusdude/alm-dates/almanax"
+)
+
+// secondaryDay is the offering of a date as a community source like dofusdb answers it, either on its own or
+// as the first entry of a paginated list. The item id is the ankama one, names are not compared.
+type secondaryDay struct {
+	Tribute struct {
+		Item struct {
+			Id   int               `json:"id"`
+			Name map[string]string `json:"name"`
+		} `json:"item"`
+		Quantity int `json:"quantity"`
+	} `json:"tribute"`
+}
+
+// secondaryDisagreement is a mapped date whose offering the secondary source names differently.
+type secondaryDisagreement struct {
+	Date          string `json:"date"`
+	Receiver      string `json:"receiver"`
+	ItemId        int    `json:"item_id"`
+	SecondaryItem int    `json:"secondary_item_id"`
+	SecondaryName string `json:"secondary_item_name,omitempty"`
+}
+
+// errSecondaryMissing is a date the secondary source has no offering for.
+var errSecondaryMissing = errors.New("secondary source has no offering for the date")
+
+func secondaryUrl(template string, date string) string {
+	return strings.ReplaceAll(template, "{date}", date)
+}
+
+func fetchSecondary(ctx context.Context, cfg *Config, date string) (secondaryDay, error) {
+	var day secondaryDay
+	ctx, cancel := context.WithTimeout(ctx, cfg.NotifyTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, secondaryUrl(cfg.SecondarySource, date), nil)
+	if err != nil {
+		return day, err
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return day, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return day, errSecondaryMissing
+	}
+	err = statusRetryable(res)
+	if err != nil {
+		return day, err
+	}
+
+	var answer struct {
+		secondaryDay
+		Data []secondaryDay `json:"data"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&answer)
+	if err != nil {
+		return day, err
+	}
+	day = answer.secondaryDay
+	if answer.Data != nil {
+		if len(answer.Data) == 0 {
+			return day, errSecondaryMissing
+		}
+		day = answer.Data[0]
+	}
+	if day.Tribute.Item.Id == 0 {
+		return day, errSecondaryMissing
+	}
+	return day, nil
+}
+
+// crossValidate asks the secondary source for a random secondary_percent of the dates and returns those it
+// gives another offering than the mapped receiver. Dates it can not answer are skipped, the mapping is
+// only compared, never changed.
+func crossValidate(ds *almanax.Dataset, dates []string, cfg *Config) []secondaryDisagreement {
+	days := almanax.NewIndex(ds)
+	count := min(int(math.Ceil(float64(len(dates))*cfg.SecondaryPercent/100)), len(dates))
+	log.Info("cross validating with secondary source", "dates", count)
+
+	var disagreements []secondaryDisagreement
+	var answered int
+	for _, i := range rand.Perm(len(dates))[:count] {
+		date := dates[i]
+		mapped, ok := days.Day(date)
+		if !ok {
+			continue
+		}
+
+		// not retried, a source that is down should not hold the job up for the retry policy of every date
+		day, err := fetchSecondary(context.Background(), cfg, date)
+		if err != nil {
+			log.Debug("secondary source has no answer for date", "date", date, "error", err)
+			continue
+		}
+		answered++
+		if day.Tribute.Item.Id != mapped.Offering.ItemId {
+			disagreements = append(disagreements, secondaryDisagreement{Date: date, Receiver: mapped.Name, ItemId: mapped.Offering.ItemId, SecondaryItem: day.Tribute.Item.Id, SecondaryName: day.Tribute.Item.Name["en"]})
+		}
+	}
+	if count > 0 && answered == 0 {
+		log.Warn("secondary source answered none of the sampled dates, the mapping is not cross validated", "source", cfg.SecondarySource)
+	}
+	return disagreements
+}
+
+// secondaryReport collects the disagreements with the secondary source of a job for its run record.
+type secondaryReport struct {
+	mu            sync.Mutex
+	disagreements []secondaryDisagreement
+}
+
+func (r *secondaryReport) add(disagreements []secondaryDisagreement) {
+	for _, d := range disagreements {
+		log.Warn("secondary source disagrees with the mapping", "date", d.Date, "receiver", d.Receiver, "item", d.ItemId, "secondary_item", d.SecondaryItem, "secondary_name", d.SecondaryName)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.disagreements = append(r.disagreements, disagreements...)
+}
+
+// take returns the collected disagreements sorted by date and starts over.
+func (r *secondaryReport) take() []secondaryDisagreement {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	disagreements := r.disagreements
+	r.disagreements = nil
+	sort.Slice(disagreements, func(a, b int) bool {
+		return disagreements[a].Date < disagreements[b].Date
+	})
+	return disagreements
+}
+
+// crossValidate compares the dates a job mapped with the secondary source if one is configured. It only
+// reports, a source that is wrong or down does not hold the publish back.
+func (p *pipeline) crossValidate(ds *almanax.Dataset, dates []string) {
+	if p.cfg.SecondarySource == "" || p.cfg.SecondaryPercent <= 0 {
+		return
+	}
+	defer p.timePhase(phaseSecondary)()
+	p.scraper.secondary.add(crossValidate(ds, dates, &p.cfg))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+type selfcheckResult struct {
+	Lang    string
+	Url     string
+	Page    almanaxPage
+	Missing []string
+	Err     error
+}
+
+func (r selfcheckResult) ok() bool {
+	return r.Err == nil && len(r.Missing) == 0
+}
+
+// selfcheck scrapes the almanax page of a date in every language and reports which fields the extraction
+// found, so layout changes on krosmoz show up before a full mapping run.
+func selfcheck(date string, languages []string, timeout time.Duration) []selfcheckResult {
+	var results []selfcheckResult
+	for _, lang := range languages {
+		result := selfcheckResult{Lang: lang, Url: almanaxPageUrl(lang, date)}
+
+		doc, status, err := fetchAlmanaxDocument(lang, date, timeout)
+		if err == nil && status != 200 {
+			err = fmt.Errorf("status code error: %d", status)
+		}
+		if err != nil {
+			result.Err = err
+		} else {
+			result.Page = extractAlmanaxPage(doc, lang)
+			result.Missing = result.Page.missingFields()
+			for _, block := range missingBlocks(doc) {
+				result.Missing = append(result.Missing, block+" block")
+			}
+		}
+
+		results = append(results, result)
+	}
+	return results
+}
+
+func writeSelfcheck(w io.Writer, date string, results []selfcheckResult) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "date: %s\n\n", date)
+	fmt.Fprintln(tw, "LANG\tRECEIVER\tBONUS\tITEM\tKAMAS\tRESULT")
+	for _, result := range results {
+		if result.Err != nil {
+			fmt.Fprintf(tw, "%s\t-\t-\t-\t-\terror: %s\n", result.Lang, result.Err)
+			continue
+		}
+
+		status := "ok"
+		if !result.ok() {
+			status = "missing " + strings.Join(result.Missing, ", ")
+		}
+		item := ""
+		if result.Page.Item != "" {
+			item = fmt.Sprintf("%dx %s", result.Page.Quantity, result.Page.Item)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\n", result.Lang, result.Page.Receiver, truncate(result.Page.Bonus, 40), item, result.Page.Kamas, status)
+	}
+	return tw.Flush()
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// selfcheckCommand exits with 1 if any field is missing in any language, so it can run from cron.
+func selfcheckCommand(args []string) {
+	flags := flag.NewFlagSet("selfcheck", flag.ExitOnError)
+	date := flags.String("date", "", "date to check, defaults to today")
+	cfg, _, err := loadConfig(flags, args)
+	if err != nil {
+		log.Fatal("error loading config", "error", err)
+	}
+
+	for _, problem := range cfg.validate() {
+		if problem.key == "languages" {
+			log.Fatal("invalid config", "key", problem.key, "problem", problem.message)
+		}
+	}
+
+	if *date == "" {
+		*date = time.Now().In(cfg.location()).Format("2006-01-02")
+	}
+	if !isDate(*date) {
+		log.Fatal("invalid date, expected YYYY-MM-DD", "date", *date)
+	}
+
+	results := selfcheck(*date, cfg.Languages, cfg.ScrapeTimeout)
+	err = writeSelfcheck(os.Stdout, *date, results)
+	if err != nil {
+		log.Fatal("error writing results", "error", err)
+	}
+
+	for _, result := range results {
+		if !result.ok() {
+			os.Exit(1)
+		}
+	}
+}
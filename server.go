@@ -0,0 +1,686 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/dofusdude/alm-dates/almanax"
+	mapping "github.com/dofusdude/dodumap"
+)
+
+// almanaxStore holds the currently served almanax data.
+type almanaxStore struct {
+	mu      sync.RWMutex
+	version string
+	// generatedAt is when the served release asset was uploaded.
+	generatedAt time.Time
+	data        *almanax.Dataset
+	days        *almanax.Index
+	index       searchIndex
+
+	eventSource string
+	events      []calendarEvent
+
+	// hash identifies the served dataset and eventsHash the event calendar, together they tag the responses
+	hash       string
+	eventsHash string
+
+	// snapshot keeps the last served release, it is served when github is down at startup
+	snapshot string
+	// checked is when the latest release was last looked up successfully, stale is set while that fails
+	checked time.Time
+	stale   bool
+}
+
+// storeSnapshot is the last served release as kept on disk.
+type storeSnapshot struct {
+	Version     string          `json:"version"`
+	GeneratedAt time.Time       `json:"generated_at"`
+	Checked     time.Time       `json:"checked"`
+	Asset       json.RawMessage `json:"asset"`
+}
+
+// saveSnapshot keeps a loaded release asset for the next start.
+func (s *almanaxStore) saveSnapshot(version string, generatedAt time.Time, data []byte) error {
+	if s.snapshot == "" {
+		return nil
+	}
+	snapshot, err := json.Marshal(storeSnapshot{Version: version, GeneratedAt: generatedAt, Checked: time.Now().UTC(), Asset: data})
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(s.snapshot), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.snapshot, snapshot, 0644)
+}
+
+// loadSnapshot serves the kept release, stale until a refresh succeeds.
+func (s *almanaxStore) loadSnapshot() error {
+	data, err := os.ReadFile(s.snapshot)
+	if err != nil {
+		return err
+	}
+	var snapshot storeSnapshot
+	err = json.Unmarshal(data, &snapshot)
+	if err != nil {
+		return err
+	}
+	ds, err := almanax.Decode(snapshot.Asset)
+	if err != nil {
+		return err
+	}
+	s.set(snapshot.Version, snapshot.GeneratedAt, ds)
+	s.mu.Lock()
+	s.checked = snapshot.Checked
+	s.stale = true
+	s.mu.Unlock()
+	return nil
+}
+
+// staleness reports whether the served data could not be checked against github lately, and when it was
+// checked last.
+func (s *almanaxStore) staleness() (bool, time.Time) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.stale, s.checked
+}
+
+func (s *almanaxStore) checkedNow(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stale = err != nil
+	if err == nil {
+		s.checked = time.Now().UTC()
+	}
+}
+
+func (s *almanaxStore) set(version string, generatedAt time.Time, ds *almanax.Dataset) {
+	days := almanax.NewIndex(ds)
+	index := buildSearchIndex(ds)
+	encoded, err := ds.Encode()
+	if err != nil {
+		log.Warn("error encoding the served data, responses are not tagged", "version", version, "error", err)
+	}
+	var hash string
+	if err == nil {
+		hash = contentHash(encoded)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version = version
+	s.generatedAt = generatedAt
+	s.data = ds
+	s.days = days
+	s.index = index
+	s.hash = hash
+}
+
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// etag tags a response built from the served data, for the variant of it the parts name. It is empty while
+// the data has no hash.
+func (s *almanaxStore) etag(parts ...string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.hash == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(strings.Join(append([]string{s.hash, s.eventsHash}, parts...), "\x00")))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+func (s *almanaxStore) getVersion() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.version
+}
+
+// getDate returns the receiver of a date, for past dates also a receiver that left the game data.
+func (s *almanaxStore) getDate(date string) (*almanax.Receiver, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if receiver, ok := s.days.Day(date); ok {
+		return receiver, ok
+	}
+	return s.data.Tombstone(date)
+}
+
+// releaseMetadata reads the metadata asset published next to a dodumap list. Releases from before it have
+// none, they are served with the defaults.
+func releaseMetadata(repo dataRepo, version string) almanax.Metadata {
+	data, _, err := downloadReleaseAsset(repo, version, MappedAlmanaxMetadataFileName)
+	if err != nil {
+		if !errors.Is(err, errAssetNotFound) {
+			log.Warn("error loading the metadata of the release", "version", version, "error", err)
+		}
+		return almanax.Metadata{}
+	}
+	metadata, err := almanax.DecodeMetadata(data)
+	if err != nil {
+		log.Warn("error reading the metadata of the release", "version", version, "error", err)
+	}
+	return metadata
+}
+
+// metadata is the license and attribution of the served release, the defaults if it has none.
+func (s *almanaxStore) metadata() almanax.Metadata {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.data != nil && !s.data.Metadata.IsZero() {
+		return s.data.Metadata
+	}
+	cfg := defaultConfig()
+	return cfg.metadata()
+}
+
+func (s *almanaxStore) getEvents(date string, server string) []calendarEvent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return eventsOn(s.events, date, server)
+}
+
+// refresh reloads the event calendar and loads the latest release if it differs from the served version.
+// While it fails the served data is marked stale.
+func (s *almanaxStore) refresh() error {
+	err := s.loadLatest()
+	s.checkedNow(err)
+	return err
+}
+
+func (s *almanaxStore) loadLatest() error {
+	if s.eventSource != "" {
+		events, err := loadEventCalendar(s.eventSource)
+		if err != nil {
+			log.Error("error loading event calendar", "source", s.eventSource, "error", err)
+		} else {
+			// marshaling a list of strings and dates does not fail
+			encoded, _ := json.Marshal(events)
+			s.mu.Lock()
+			s.events = events
+			s.eventsHash = contentHash(encoded)
+			s.mu.Unlock()
+		}
+	}
+
+	version, err := getLatestVersion(defaultDataRepo)
+	if err != nil {
+		return err
+	}
+
+	if version == s.getVersion() {
+		return nil
+	}
+
+	data, generatedAt, err := downloadAlmanaxAsset(defaultDataRepo, version)
+	if err != nil {
+		return err
+	}
+	ds, err := almanax.Decode(data)
+	if err != nil {
+		return err
+	}
+	if !ds.EmbedsMetadata() {
+		ds.Metadata = releaseMetadata(defaultDataRepo, version)
+	}
+
+	s.set(version, generatedAt, ds)
+	log.Info("serving almanax data", "version", version)
+	err = s.saveSnapshot(version, generatedAt, data)
+	if err != nil {
+		log.Warn("error keeping the served release", "path", s.snapshot, "error", err)
+	}
+	return nil
+}
+
+func (s *almanaxStore) refreshLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := s.refresh()
+			if err != nil {
+				log.Error("error refreshing almanax data", "error", err)
+			}
+		}
+	}
+}
+
+type server struct {
+	store     *almanaxStore
+	publicUrl string
+}
+
+type datePage struct {
+	Lang        string
+	Date        string
+	Title       string
+	Description string
+	Receiver    string
+	Item        string
+	Quantity    int
+	BonusType   string
+	Bonus       string
+	RewardKamas int
+	// the receiver metadata of the seed, for the json form of the page
+	ItemId          int
+	ItemCategoryId  int
+	ExperienceRatio float64
+	OptimalLevel    int
+	Duration        float64
+	Events          []calendarEvent
+	Metadata        almanax.Metadata
+	ImageUrl        string
+	PageUrl         string
+	OEmbedUrl       string
+}
+
+var datePageTemplate = template.Must(template.New("date").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}"><head><meta charset="utf-8"><title>{{.Title}}</title>
+<meta property="og:type" content="website">
+<meta property="og:site_name" content="Almanax">
+<meta property="og:title" content="{{.Title}}">
+<meta property="og:description" content="{{.Description}}">
+<meta property="og:url" content="{{.PageUrl}}">
+{{if .ImageUrl}}<meta property="og:image" content="{{.ImageUrl}}">
+{{end}}<meta name="twitter:card" content="summary">
+<link rel="alternate" type="application/json+oembed" href="{{.OEmbedUrl}}" title="{{.Title}}">
+<style>` + siteStyle + `</style></head>
+<body><h1>{{.Title}}</h1>
+{{if .ImageUrl}}<img src="{{.ImageUrl}}" alt="{{.Item}}" width="100">
+{{end}}<p>{{.Receiver}}: {{.Quantity}}x {{.Item}}</p>
+<p><b>{{.BonusType}}</b>: {{.Bonus}}</p>
+<p>{{.RewardKamas}} Kamas</p>
+{{if .Events}}<ul>{{range .Events}}<li>{{.Name}}{{if .Servers}} ({{range $i, $s := .Servers}}{{if $i}}, {{end}}{{$s}}{{end}}){{end}}</li>{{end}}</ul>
+{{end}}<footer><small>{{.Metadata.Attribution}}<br>{{.Metadata.License}}<br>{{range $i, $s := .Metadata.Sources}}{{if $i}} · {{end}}<a href="{{$s}}">{{$s}}</a>{{end}}</small></footer>
+</body></html>
+`))
+
+// normalizeLang falls back to english for unknown languages.
+func normalizeLang(lang string) string {
+	for _, l := range mapping.LanguagesUnity {
+		if l == lang {
+			return lang
+		}
+	}
+	return "en"
+}
+
+func (s *server) datePage(date string, lang string, gameServer string) (datePage, bool) {
+	alm, ok := s.store.getDate(date)
+	if !ok {
+		return datePage{}, false
+	}
+
+	pageUrl := fmt.Sprintf("%s/almanax/%s?lang=%s", s.publicUrl, date, lang)
+	page := datePage{
+		Lang:            lang,
+		Date:            date,
+		Title:           fmt.Sprintf("Almanax %s - %s", date, alm.Bonus.Type[lang]),
+		Description:     fmt.Sprintf("%s: %dx %s. %s", alm.Name, alm.Offering.Quantity, alm.Offering.ItemName[lang], alm.Bonus.Description[lang]),
+		Receiver:        alm.Name,
+		Item:            alm.Offering.ItemName[lang],
+		Quantity:        alm.Offering.Quantity,
+		BonusType:       alm.Bonus.Type[lang],
+		Bonus:           alm.Bonus.Description[lang],
+		RewardKamas:     alm.RewardKamas,
+		ItemId:          alm.Offering.ItemId,
+		ItemCategoryId:  alm.Offering.ItemCategoryId,
+		ExperienceRatio: alm.ExperienceRatio,
+		OptimalLevel:    alm.OptimalLevel,
+		Duration:        alm.Duration,
+		Events:          s.store.getEvents(date, gameServer),
+		Metadata:        s.store.metadata(),
+		PageUrl:         pageUrl,
+		OEmbedUrl:       fmt.Sprintf("%s/oembed?format=json&url=%s", s.publicUrl, url.QueryEscape(pageUrl)),
+	}
+
+	item, err := getDoduapiItem(lang, alm.Offering.ItemId, alm.Offering.ItemCategoryId)
+	if err != nil {
+		log.Warn("could not resolve item image", "itemId", alm.Offering.ItemId, "error", err)
+	} else {
+		page.ImageUrl = item.ImageUrls.SD
+	}
+
+	return page, true
+}
+
+func (s *server) handleDate(w http.ResponseWriter, r *http.Request) {
+	date := r.PathValue("date")
+	// the mux has no patterns for part of a segment, so the month chunks share the route
+	if month, ok := strings.CutSuffix(date, ".json"); ok {
+		s.handleMonth(w, r, month)
+		return
+	}
+	if !isDate(date) {
+		http.Error(w, "invalid date, expected yyyy-mm-dd", http.StatusBadRequest)
+		return
+	}
+
+	// only complete pages are tagged, so a matching tag is answered before the item is looked up on doduapi
+	etag := s.store.etag(r.URL.RequestURI())
+	if _, mapped := s.store.getDate(date); mapped && etag != "" && etagMatches(r, etag) {
+		s.setCacheControl(w, isPastDate(date))
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	page, ok := s.datePage(date, normalizeLang(r.URL.Query().Get("lang")), r.URL.Query().Get("server"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	// a page without the item image is completed once doduapi answers again
+	s.setCacheControl(w, isPastDate(date) && page.ImageUrl != "")
+	if etag != "" && page.ImageUrl != "" {
+		w.Header().Set("ETag", etag)
+	}
+	switch r.URL.Query().Get("format") {
+	case "", "html":
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(newDateResponse(page))
+		return
+	default:
+		http.Error(w, "format must be html or json", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := datePageTemplate.Execute(w, page)
+	if err != nil {
+		log.Error("error rendering date page", "date", date, "error", err)
+	}
+}
+
+// month encodes the days of a month like the release asset, false if none of its days are mapped.
+func (s *almanaxStore) month(month string) ([]byte, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	chunk := s.data.Month(month)
+	if len(chunk.Receivers) == 0 && len(chunk.Tombstones) == 0 {
+		return nil, false, nil
+	}
+	data, err := chunk.Encode()
+	return data, true, err
+}
+
+// handleMonth serves the days of a month in the layout of the release asset, for clients that only need
+// the current month.
+func (s *server) handleMonth(w http.ResponseWriter, r *http.Request, month string) {
+	if !isMonth(month) {
+		http.Error(w, "invalid month, expected yyyy-mm.json", http.StatusBadRequest)
+		return
+	}
+
+	data, ok, err := s.store.month(month)
+	if err != nil {
+		log.Error("error encoding month", "month", month, "error", err)
+		http.Error(w, "could not encode month", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.setCacheControl(w, isPastDate(month+"-31"))
+	if s.notModified(w, r, month) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+const (
+	// pastMaxAge is the cache lifetime of past dates, which never change.
+	pastMaxAge = 365 * 24 * time.Hour
+	// futureMaxAge is the cache lifetime of today and later dates, a new release can still change them.
+	futureMaxAge = 5 * time.Minute
+)
+
+// isPastDate reports whether a date is over in every time zone, the day before yesterday in UTC or earlier.
+func isPastDate(date string) bool {
+	return date < time.Now().UTC().AddDate(0, 0, -1).Format("2006-01-02")
+}
+
+// setCacheControl lets CDNs keep past dates forever and everything else only briefly. Stale data is never
+// immutable, the release it comes from may have been replaced.
+func (s *server) setCacheControl(w http.ResponseWriter, past bool) {
+	if stale, _ := s.store.staleness(); past && !stale {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d, immutable", int(pastMaxAge.Seconds())))
+		return
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(futureMaxAge.Seconds())))
+}
+
+// notModified tags the response and answers 304 if the client has the same variant already.
+func (s *server) notModified(w http.ResponseWriter, r *http.Request, parts ...string) bool {
+	etag := s.store.etag(parts...)
+	if etag == "" {
+		return false
+	}
+	w.Header().Set("ETag", etag)
+	if etagMatches(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// etagMatches reports whether the If-None-Match of a request names the etag.
+func etagMatches(r *http.Request, etag string) bool {
+	for _, match := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		match = strings.TrimPrefix(strings.TrimSpace(match), "W/")
+		if match == etag || match == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// dateResponse is the json form of a date page, for bots that show the day without a second lookup.
+type dateResponse struct {
+	Date     string `json:"date"`
+	Lang     string `json:"lang"`
+	Receiver string `json:"receiver"`
+	Offering struct {
+		ItemId         int    `json:"item_id"`
+		ItemCategoryId int    `json:"item_category_id"`
+		ItemName       string `json:"item_name"`
+		Quantity       int    `json:"quantity"`
+		// ImageUrl is the image of the offered item doduapi has, empty while doduapi does not answer
+		ImageUrl string `json:"image_url,omitempty"`
+	} `json:"offering"`
+	Bonus struct {
+		Type        string `json:"type"`
+		Description string `json:"description"`
+	} `json:"bonus"`
+	RewardKamas     int             `json:"reward_kamas"`
+	ExperienceRatio float64         `json:"experience_ratio"`
+	OptimalLevel    int             `json:"optimal_level"`
+	Duration        float64         `json:"duration"`
+	Events          []calendarEvent `json:"events,omitempty"`
+	PageUrl         string          `json:"page_url"`
+}
+
+func newDateResponse(page datePage) dateResponse {
+	res := dateResponse{Date: page.Date, Lang: page.Lang, Receiver: page.Receiver, PageUrl: page.PageUrl, Events: page.Events}
+	res.Offering.ItemId = page.ItemId
+	res.Offering.ItemCategoryId = page.ItemCategoryId
+	res.Offering.ItemName = page.Item
+	res.Offering.Quantity = page.Quantity
+	res.Offering.ImageUrl = page.ImageUrl
+	res.Bonus.Type = page.BonusType
+	res.Bonus.Description = page.Bonus
+	res.RewardKamas = page.RewardKamas
+	res.ExperienceRatio = page.ExperienceRatio
+	res.OptimalLevel = page.OptimalLevel
+	res.Duration = page.Duration
+	return res
+}
+
+type oEmbedResponse struct {
+	Version         string `json:"version"`
+	Type            string `json:"type"`
+	Title           string `json:"title"`
+	ProviderName    string `json:"provider_name"`
+	ProviderUrl     string `json:"provider_url"`
+	ThumbnailUrl    string `json:"thumbnail_url,omitempty"`
+	ThumbnailWidth  int    `json:"thumbnail_width,omitempty"`
+	ThumbnailHeight int    `json:"thumbnail_height,omitempty"`
+}
+
+func (s *server) handleOEmbed(w http.ResponseWriter, r *http.Request) {
+	if format := r.URL.Query().Get("format"); format != "" && format != "json" {
+		http.Error(w, "only json format is supported", http.StatusNotImplemented)
+		return
+	}
+
+	target, err := url.Parse(r.URL.Query().Get("url"))
+	if err != nil {
+		http.Error(w, "invalid url", http.StatusBadRequest)
+		return
+	}
+
+	date, found := strings.CutPrefix(target.Path, "/almanax/")
+	if !found || !isDate(date) {
+		http.NotFound(w, r)
+		return
+	}
+
+	page, ok := s.datePage(date, normalizeLang(target.Query().Get("lang")), target.Query().Get("server"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	res := oEmbedResponse{
+		Version:      "1.0",
+		Type:         "link",
+		Title:        page.Title,
+		ProviderName: "Almanax",
+		ProviderUrl:  s.publicUrl,
+	}
+	if page.ImageUrl != "" {
+		res.ThumbnailUrl = page.ImageUrl
+		res.ThumbnailWidth = 200
+		res.ThumbnailHeight = 200
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// withAttribution adds the license and attribution of the served data to every response, so they travel
+// with the data wherever it is redistributed. Responses from data that could not be checked against github
+// lately say so.
+func (s *server) withAttribution(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if stale, checked := s.store.staleness(); stale {
+			w.Header().Set("X-Data-Stale", "true")
+			w.Header().Set("Warning", `110 - "Response is Stale"`)
+			if !checked.IsZero() {
+				w.Header().Set("X-Data-Checked", checked.Format(time.RFC3339))
+			}
+		}
+		metadata := s.store.metadata()
+		if metadata.License != "" {
+			w.Header().Set("X-License", metadata.License)
+		}
+		if metadata.Attribution != "" {
+			w.Header().Set("X-Attribution", metadata.Attribution)
+		}
+		for _, source := range metadata.Sources {
+			w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"via\"", source))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /almanax/{date}", s.handleDate)
+	mux.HandleFunc("GET /almanax/search", s.handleSearch)
+	mux.HandleFunc("GET /almanax/offerings", s.handleOfferings)
+	mux.HandleFunc("GET /almanax/stats", s.handleStats)
+	mux.HandleFunc("GET /almanax/events", s.handleEvents)
+	mux.HandleFunc("GET /oembed", s.handleOEmbed)
+	mux.HandleFunc("GET /freshness", s.handleFreshness)
+	return mux
+}
+
+// defaultSnapshotPath is in the user cache directory, empty if there is none.
+func defaultSnapshotPath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "alm-dates", "serve-snapshot.json")
+}
+
+func serveCommand(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", ":8080", "listen address")
+	publicUrl := flags.String("public-url", "http://localhost:8080", "public base url used in shared links")
+	refreshStr := flags.String("refresh", "5m", "interval to check for a new release")
+	eventSource := flags.String("events", "", "event calendar (ics or json) file or url to merge with the almanax")
+	snapshot := flags.String("snapshot", defaultSnapshotPath(), "file the served release is kept in, served while github is down at startup, empty disables it")
+	_ = flags.Parse(args)
+
+	refresh, err := ParseDuration(*refreshStr)
+	if err != nil {
+		log.Fatal("error parsing refresh interval", "error", err)
+	}
+
+	store := &almanaxStore{eventSource: *eventSource, snapshot: *snapshot}
+	err = store.refresh()
+	if err != nil {
+		if *snapshot == "" {
+			log.Fatal("error loading almanax data", "error", err)
+		}
+		snapshotErr := store.loadSnapshot()
+		if snapshotErr != nil {
+			log.Fatal("error loading almanax data and no kept release to serve", "error", err, "snapshot", snapshotErr)
+		}
+		log.Warn("github unavailable, serving the kept release until a refresh succeeds", "version", store.getVersion(), "error", err)
+	}
+
+	go store.refreshLoop(context.Background(), refresh)
+
+	srv := &server{
+		store:     store,
+		publicUrl: strings.TrimSuffix(*publicUrl, "/"),
+	}
+
+	log.Info("listening", "addr", *addr)
+	err = http.ListenAndServe(*addr, srv.withAttribution(srv.routes()))
+	if err != nil {
+		log.Fatal("server stopped", "error", err)
+	}
+}
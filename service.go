@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/charmbracelet/log"
+)
+
+const (
+	serviceName        = "alm-dates"
+	serviceDescription = "Maps the Dofus almanax calendar to new game versions."
+)
+
+func serviceCommand(args []string) {
+	if len(args) == 0 {
+		log.Fatal("missing service subcommand", "available", "install, uninstall")
+	}
+
+	switch args[0] {
+	case "install":
+		serviceInstallCommand(args[1:])
+	case "uninstall":
+		err := uninstallService()
+		if err != nil {
+			log.Fatal("error uninstalling service", "error", err)
+		}
+		log.Info("service uninstalled", "name", serviceName)
+	default:
+		log.Fatal("unknown service subcommand", "command", args[0])
+	}
+}
+
+// serviceInstallCommand registers the daemon with the service manager. The arguments are the daemon flags,
+// the workdir is pinned because service managers start processes in an unrelated directory.
+func serviceInstallCommand(args []string) {
+	cfg, _, err := loadConfig(flag.NewFlagSet("service install", flag.ExitOnError), args)
+	if err != nil {
+		log.Fatal("error loading config", "error", err)
+	}
+
+	for _, problem := range cfg.validate() {
+		if !problem.warning {
+			log.Fatal("invalid config", "key", problem.key, "problem", problem.message)
+		}
+	}
+
+	workdir, err := filepath.Abs(cfg.Workdir)
+	if err != nil {
+		log.Fatal("error parsing working directory", "error", err)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatal("error finding executable", "error", err)
+	}
+
+	daemonArgs := append(slices.Clone(args), "--workdir", workdir)
+	err = installService(exe, daemonArgs)
+	if err != nil {
+		log.Fatal("error installing service", "error", err)
+	}
+
+	log.Info("service installed", "name", serviceName, "workdir", workdir)
+}
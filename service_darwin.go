@@ -0,0 +1,97 @@
+//go:build darwin
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"text/template"
+)
+
+const (
+	launchdLabel     = "de.dofusdu.alm-dates"
+	launchdPlistPath = "/Library/LaunchDaemons/" + launchdLabel + ".plist"
+	launchdLogPath   = "/var/log/alm-dates.log"
+)
+
+var launchdPlistTemplate = template.Must(template.New("plist").Funcs(template.FuncMap{
+	"escape": template.HTMLEscapeString,
+}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{ .Label }}</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>{{ escape .Exe }}</string>
+{{- range .Args }}
+		<string>{{ escape . }}</string>
+{{- end }}
+	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+	<key>StandardOutPath</key>
+	<string>{{ .LogPath }}</string>
+	<key>StandardErrorPath</key>
+	<string>{{ .LogPath }}</string>
+</dict>
+</plist>
+`))
+
+// launchd stops daemons with SIGTERM, so it runs like any other process.
+func isService() bool {
+	return false
+}
+
+func runService(args []string) {
+	runDaemon(args)
+}
+
+func installService(exe string, args []string) error {
+	if _, err := os.Stat(launchdPlistPath); err == nil {
+		return fmt.Errorf("%s already exists", launchdPlistPath)
+	}
+
+	var plist bytes.Buffer
+	err := launchdPlistTemplate.Execute(&plist, struct {
+		Label   string
+		Exe     string
+		Args    []string
+		LogPath string
+	}{launchdLabel, exe, args, launchdLogPath})
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(launchdPlistPath, plist.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+
+	return launchctl("load", "-w", launchdPlistPath)
+}
+
+func uninstallService() error {
+	if _, err := os.Stat(launchdPlistPath); err != nil {
+		return fmt.Errorf("service %s is not installed", serviceName)
+	}
+
+	err := launchctl("unload", "-w", launchdPlistPath)
+	if err != nil {
+		return err
+	}
+	return os.Remove(launchdPlistPath)
+}
+
+func launchctl(args ...string) error {
+	out, err := exec.Command("launchctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("launchctl %s: %w: %s", args[0], err, bytes.TrimSpace(out))
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+//go:build !windows && !darwin
+
+package main
+
+import "fmt"
+
+func isService() bool {
+	return false
+}
+
+func runService(args []string) {
+	runDaemon(args)
+}
+
+func installService(exe string, args []string) error {
+	return fmt.Errorf("service install is only supported on windows and macos, use systemd or a container here")
+}
+
+func uninstallService() error {
+	return fmt.Errorf("service uninstall is only supported on windows and macos")
+}
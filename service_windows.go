@@ -0,0 +1,113 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/charmbracelet/log"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+func isService() bool {
+	service, err := svc.IsWindowsService()
+	return err == nil && service
+}
+
+type windowsService struct {
+	args []string
+}
+
+// Execute runs the daemon until the service control manager stops it.
+func (s *windowsService) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	go runDaemon(s.args)
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for req := range requests {
+		switch req.Cmd {
+		case svc.Interrogate:
+			status <- req.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			status <- svc.Status{State: svc.StopPending}
+
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+			select {
+			case <-shutdown(ctx):
+			case <-ctx.Done():
+				log.Error("shutdown grace period exceeded, exiting")
+			}
+			cancel()
+			return false, 0
+		}
+	}
+	return false, 0
+}
+
+// runService runs as a windows service. Services have no console, so logs go next to the executable.
+func runService(args []string) {
+	exe, err := os.Executable()
+	if err == nil {
+		logFile, err := os.OpenFile(filepath.Join(filepath.Dir(exe), serviceName+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err == nil {
+			defer logFile.Close()
+			log.SetOutput(logFile)
+		}
+	}
+
+	err = svc.Run(serviceName, &windowsService{args: args})
+	if err != nil {
+		log.Fatal("error running service", "error", err)
+	}
+}
+
+func installService(exe string, args []string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", serviceName)
+	}
+
+	s, err = m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: serviceName,
+		Description: serviceDescription,
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	return s.Start()
+}
+
+func uninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", serviceName)
+	}
+	defer s.Close()
+
+	_, err = s.Control(svc.Stop)
+	if err != nil {
+		log.Warn("could not stop service", "error", err)
+	}
+
+	return s.Delete()
+}
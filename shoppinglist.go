@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/charmbracelet/log"
+)
+
+// itemLink resolves the doduapi page of an offering item, empty if doduapi does not know the item.
+func itemLink(lang string, total offeringTotal) string {
+	_, err := getDoduapiItem(lang, total.ItemId, total.ItemCategoryId)
+	if err != nil {
+		log.Warn("could not resolve item on doduapi", "itemId", total.ItemId, "error", err)
+		return ""
+	}
+
+	link, _ := doduapiItemUrl(lang, total.ItemId, total.ItemCategoryId)
+	return link
+}
+
+func markdownEscape(s string) string {
+	replacer := strings.NewReplacer("|", `\|`, "[", `\[`, "]", `\]`)
+	return replacer.Replace(s)
+}
+
+// writeShoppingList writes the aggregated offerings as "table", "markdown" or "csv".
+func writeShoppingList(w io.Writer, format string, totals []offeringTotal, lang string, fromDate string, toDate string) error {
+	switch format {
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "QUANTITY\tITEM\tDAYS")
+		for _, total := range totals {
+			fmt.Fprintf(tw, "%d\t%s\t%d\n", total.Quantity, total.Item, len(total.Dates))
+		}
+		return tw.Flush()
+	case "markdown":
+		fmt.Fprintf(w, "## Almanax %s - %s\n\n", fromDate, toDate)
+		fmt.Fprintln(w, "| Quantity | Item | Days |")
+		fmt.Fprintln(w, "|---:|---|---:|")
+		for _, total := range totals {
+			item := markdownEscape(total.Item)
+			if link := itemLink(lang, total); link != "" {
+				item = fmt.Sprintf("[%s](%s)", item, link)
+			}
+			fmt.Fprintf(w, "| %d | %s | %d |\n", total.Quantity, item, len(total.Dates))
+		}
+		return nil
+	case "csv":
+		cw := csv.NewWriter(w)
+		err := cw.Write([]string{"quantity", "item", "item_id", "days", "link"})
+		if err != nil {
+			return err
+		}
+		for _, total := range totals {
+			err = cw.Write([]string{
+				strconv.Itoa(total.Quantity),
+				total.Item,
+				strconv.Itoa(total.ItemId),
+				strconv.Itoa(len(total.Dates)),
+				itemLink(lang, total),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unknown format %s", format)
+	}
+}
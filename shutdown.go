@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+var (
+	shutdownHooksMu sync.Mutex
+	shutdownHooks   []func(ctx context.Context)
+
+	// shutdownGrace bounds how long a graceful shutdown may take.
+	shutdownGrace = 25 * time.Second
+)
+
+// onShutdown registers a hook that runs on SIGTERM or SIGINT, for example to flush pending notifications.
+// Hooks should return when ctx is done.
+func onShutdown(hook func(ctx context.Context)) {
+	shutdownHooksMu.Lock()
+	defer shutdownHooksMu.Unlock()
+	shutdownHooks = append(shutdownHooks, hook)
+}
+
+func runShutdownHooks(ctx context.Context) {
+	shutdownHooksMu.Lock()
+	hooks := shutdownHooks
+	shutdownHooksMu.Unlock()
+
+	for _, hook := range hooks {
+		hook(ctx)
+	}
+}
+
+// shutdown drains the daemon and runs the shutdown hooks. The returned channel is closed when both are done.
+func shutdown(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		health.drain()
+		runShutdownHooks(ctx)
+		close(done)
+	}()
+	return done
+}
+
+// handleSignals shuts down gracefully on SIGTERM or SIGINT. If that takes longer than the grace period
+// or a second signal arrives, the process exits anyway.
+func handleSignals() {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+
+	sig := <-sigs
+	log.Info("shutting down", "signal", sig, "grace", FormatDuration(shutdownGrace))
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+	defer cancel()
+
+	select {
+	case <-shutdown(ctx):
+		log.Info("shutdown complete")
+		_ = os.Stderr.Sync()
+		os.Exit(0)
+	case <-ctx.Done():
+		log.Error("shutdown grace period exceeded, exiting")
+	case sig = <-sigs:
+		log.Warn("received second signal, exiting", "signal", sig)
+	}
+	_ = os.Stderr.Sync()
+	os.Exit(1)
+}
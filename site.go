@@ -0,0 +1,240 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/dofusdude/alm-dates/almanax"
+	mapping "github.com/dofusdude/dodumap"
+)
+
+var monthNames = map[string][12]string{
+	"en": {"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"},
+	"fr": {"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+	"de": {"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"},
+	"es": {"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+	"pt": {"janeiro", "fevereiro", "março", "abril", "maio", "junho", "julho", "agosto", "setembro", "outubro", "novembro", "dezembro"},
+}
+
+// weekdays start on monday
+var weekdayNames = map[string][7]string{
+	"en": {"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"},
+	"fr": {"lun.", "mar.", "mer.", "jeu.", "ven.", "sam.", "dim."},
+	"de": {"Mo", "Di", "Mi", "Do", "Fr", "Sa", "So"},
+	"es": {"lun", "mar", "mié", "jue", "vie", "sáb", "dom"},
+	"pt": {"seg", "ter", "qua", "qui", "sex", "sáb", "dom"},
+}
+
+type siteDay struct {
+	Day       int
+	Date      string
+	Receiver  string
+	Item      string
+	Quantity  int
+	BonusType string
+	Bonus     string
+}
+
+type siteMonth struct {
+	Key   string // 2006-01
+	Title string
+}
+
+type siteMonthPage struct {
+	Lang     string
+	Title    string
+	Weekdays [7]string
+	Weeks    [][7]*siteDay
+	Prev     string
+	Next     string
+}
+
+type siteIndexPage struct {
+	Lang   string
+	Months []siteMonth
+}
+
+const siteStyle = `body{font-family:sans-serif;margin:2em auto;max-width:70em;color:#222}
+table{border-collapse:collapse;width:100%;table-layout:fixed}
+th,td{border:1px solid #ccc;vertical-align:top;padding:.4em;font-size:.85em}
+td.empty{background:#f4f4f4}
+.day{font-weight:bold}.bonus{color:#555}
+nav a{margin-right:1em}`
+
+var siteRootTemplate = template.Must(template.New("root").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Almanax</title><style>` + siteStyle + `</style></head>
+<body><h1>Almanax</h1><ul>
+{{range .}}<li><a href="{{.}}/index.html">{{.}}</a></li>
+{{end}}</ul></body></html>
+`))
+
+var siteIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}"><head><meta charset="utf-8"><title>Almanax</title><style>` + siteStyle + `</style></head>
+<body><nav><a href="../index.html">&larr;</a></nav><h1>Almanax</h1><ul>
+{{range .Months}}<li><a href="{{.Key}}.html">{{.Title}}</a></li>
+{{end}}</ul></body></html>
+`))
+
+var siteMonthTemplate = template.Must(template.New("month").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}"><head><meta charset="utf-8"><title>Almanax - {{.Title}}</title><style>` + siteStyle + `</style></head>
+<body><nav><a href="index.html">&uarr;</a>{{if .Prev}}<a href="{{.Prev}}.html">&larr;</a>{{end}}{{if .Next}}<a href="{{.Next}}.html">&rarr;</a>{{end}}</nav>
+<h1>{{.Title}}</h1>
+<table><tr>{{range .Weekdays}}<th>{{.}}</th>{{end}}</tr>
+{{range .Weeks}}<tr>{{range .}}{{if .}}<td><div class="day">{{.Day}}</div>{{if .Receiver}}<div>{{.Receiver}}</div><div>{{.Quantity}}x {{.Item}}</div><div class="bonus" title="{{.Bonus}}">{{.BonusType}}</div>{{end}}</td>{{else}}<td class="empty"></td>{{end}}{{end}}</tr>
+{{end}}</table></body></html>
+`))
+
+func writeTemplateFile(path string, tmpl *template.Template, data any) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return tmpl.Execute(file, data)
+}
+
+func renderMonth(lang string, month time.Time, days *almanax.Index) siteMonthPage {
+	page := siteMonthPage{
+		Lang:     lang,
+		Title:    fmt.Sprintf("%s %d", monthNames[lang][month.Month()-1], month.Year()),
+		Weekdays: weekdayNames[lang],
+	}
+
+	var week [7]*siteDay
+	for day := month; day.Month() == month.Month(); day = day.AddDate(0, 0, 1) {
+		weekday := (int(day.Weekday()) + 6) % 7
+		if weekday == 0 && day.Day() != 1 {
+			page.Weeks = append(page.Weeks, week)
+			week = [7]*siteDay{}
+		}
+
+		date := day.Format("2006-01-02")
+		entry := &siteDay{Day: day.Day(), Date: date}
+		if alm, ok := days.Day(date); ok {
+			entry.Receiver = alm.Name
+			entry.Item = alm.Offering.ItemName[lang]
+			entry.Quantity = alm.Offering.Quantity
+			entry.BonusType = alm.Bonus.Type[lang]
+			entry.Bonus = alm.Bonus.Description[lang]
+		}
+		week[weekday] = entry
+	}
+	page.Weeks = append(page.Weeks, week)
+
+	return page
+}
+
+// exportSite renders a static html calendar per language and month into outDir, and the days of every
+// month as json in almanax/<month>.json.
+func exportSite(ds *almanax.Dataset, outDir string) error {
+	days := almanax.NewIndex(ds)
+	if days.Len() == 0 {
+		return fmt.Errorf("no mapped dates in data")
+	}
+
+	// days are sorted, so are the months
+	var monthKeys []string
+	for _, day := range days.Days() {
+		if len(monthKeys) == 0 || monthKeys[len(monthKeys)-1] != day.Date[:7] {
+			monthKeys = append(monthKeys, day.Date[:7])
+		}
+	}
+
+	err := os.MkdirAll(outDir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	var langs []string
+	for _, lang := range mapping.LanguagesUnity {
+		if _, ok := monthNames[lang]; ok {
+			langs = append(langs, lang)
+		}
+	}
+
+	for _, lang := range langs {
+		langDir := filepath.Join(outDir, lang)
+		err = os.MkdirAll(langDir, os.ModePerm)
+		if err != nil {
+			return err
+		}
+
+		index := siteIndexPage{Lang: lang}
+		for i, key := range monthKeys {
+			month, err := time.Parse("2006-01", key)
+			if err != nil {
+				return err
+			}
+
+			page := renderMonth(lang, month, days)
+			if i > 0 {
+				page.Prev = monthKeys[i-1]
+			}
+			if i < len(monthKeys)-1 {
+				page.Next = monthKeys[i+1]
+			}
+
+			err = writeTemplateFile(filepath.Join(langDir, key+".html"), siteMonthTemplate, page)
+			if err != nil {
+				return err
+			}
+			index.Months = append(index.Months, siteMonth{Key: key, Title: page.Title})
+		}
+
+		err = writeTemplateFile(filepath.Join(langDir, "index.html"), siteIndexTemplate, index)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the same month chunks as serve mode, for clients that only need the current month
+	chunkDir := filepath.Join(outDir, "almanax")
+	err = os.MkdirAll(chunkDir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	for _, key := range monthKeys {
+		data, err := ds.Month(key).Encode()
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(filepath.Join(chunkDir, key+".json"), data, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writeTemplateFile(filepath.Join(outDir, "index.html"), siteRootTemplate, langs)
+	if err != nil {
+		return err
+	}
+
+	// github pages should serve the files as they are
+	return os.WriteFile(filepath.Join(outDir, ".nojekyll"), nil, 0644)
+}
+
+func exportSiteCommand(args []string) {
+	flags := flag.NewFlagSet("export-site", flag.ExitOnError)
+	outDir := flags.String("out", "dist", "output directory")
+	version := flags.String("version", "", "data repo version to export, defaults to the latest release")
+	file := flags.String("file", "", "read the mapped almanax from a local file instead of the release")
+	_ = flags.Parse(args)
+
+	ds, err := loadAlmanaxSource(*file, *version)
+	if err != nil {
+		log.Fatal("error loading almanax data", "error", err)
+	}
+
+	err = exportSite(ds, *outDir)
+	if err != nil {
+		log.Fatal("error exporting site", "error", err)
+	}
+
+	log.Info("site exported", "out", *outDir)
+}
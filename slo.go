@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dofusdude/alm-dates/almanax"
+)
+
+// runTimeToServe is the run history kind of the time from detecting a version until doduapi serves its
+// mapping. It is not a job, it starts with the map-version job and finishes once the data is live.
+const runTimeToServe jobKind = "time-to-serve"
+
+// doduapiServes reports whether doduapi serves the offering of receiver on the date already.
+func doduapiServes(ctx context.Context, cfg *Config, date string, receiver *almanax.Receiver) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.NotifyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/en/almanax/%s", cfg.doduapiUrl(), date), nil)
+	if err != nil {
+		return false, err
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("doduapi answered %d", res.StatusCode)
+	}
+
+	var day struct {
+		Tribute struct {
+			Item struct {
+				AnkamaId int `json:"ankama_id"`
+			} `json:"item"`
+			Quantity int `json:"quantity"`
+		} `json:"tribute"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&day)
+	if err != nil {
+		return false, err
+	}
+	return day.Tribute.Item.AnkamaId == receiver.Offering.ItemId && day.Tribute.Quantity == receiver.Offering.Quantity, nil
+}
+
+// trackTimeToServe waits until doduapi serves the last mapped date of a published version and records the
+// time since the version was detected. An alert is raised once serve_slo is exceeded, after four times
+// serve_slo the tracking gives up.
+func (p *pipeline) trackTimeToServe(ctx context.Context, version string, detected time.Time, ds *almanax.Dataset) {
+	days := ds.Days()
+	if len(days) == 0 {
+		return
+	}
+	last := days[len(days)-1]
+
+	slo := p.cfg.ServeSlo
+	late := false
+	for {
+		served, err := doduapiServes(ctx, &p.cfg, last.Date, last.Receiver)
+		if err != nil {
+			p.log.Debug("could not check whether doduapi serves the new data", "version", version, "error", err)
+		}
+		elapsed := time.Since(detected)
+		if served {
+			metrics.observe(metricTimeToServe, elapsed.Seconds(), p.cfg.Game, p.name)
+			run := runRecord{Kind: runTimeToServe, Version: version, Started: detected.UTC(), Finished: time.Now().UTC()}
+			if late {
+				run.Error = fmt.Sprintf("exceeded serve_slo of %s", FormatDuration(slo))
+			}
+			if err := appendRun(p.workdir, run); err != nil {
+				p.log.Warn("error recording run", "error", err)
+			}
+			p.log.Info("new data is live on doduapi", "version", version, "time_to_serve", FormatDuration(elapsed.Round(time.Second)))
+			return
+		}
+
+		if !late && elapsed > slo {
+			late = true
+			p.log.Warn("new data is not live on doduapi within serve_slo", "version", version, "slo", FormatDuration(slo))
+			p.alerts.add(fmt.Sprintf("%s is not live on doduapi %s after it was detected", version, FormatDuration(slo)), nil)
+		}
+		if elapsed > 4*slo {
+			p.log.Error("gave up waiting for doduapi to serve the new data", "version", version, "waited", FormatDuration(elapsed.Round(time.Second)))
+			if err := appendRun(p.workdir, runRecord{Kind: runTimeToServe, Version: version, Started: detected.UTC(), Finished: time.Now().UTC(), Error: "not live on doduapi"}); err != nil {
+				p.log.Warn("error recording run", "error", err)
+			}
+			return
+		}
+
+		if !sleepCtx(ctx, p.cfg.ServeCheckInterval) {
+			return
+		}
+	}
+}
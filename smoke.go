@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/dofusdude/alm-dates/almanax"
+)
+
+type smokeCheck struct {
+	Name   string
+	Ok     bool
+	Detail string
+}
+
+// doduapiAlmanaxDay is the part of the doduapi almanax response the smoke test compares. doduapi has no
+// receiver names, the offering identifies the receiver instead.
+type doduapiAlmanaxDay struct {
+	Date    string `json:"date"`
+	Tribute struct {
+		Item struct {
+			AnkamaId int    `json:"ankama_id"`
+			Name     string `json:"name"`
+		} `json:"item"`
+		Quantity int `json:"quantity"`
+	} `json:"tribute"`
+}
+
+func getDoduapiAlmanax(cfg *Config, lang string, date string) (doduapiAlmanaxDay, error) {
+	var day doduapiAlmanaxDay
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.NotifyTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/%s/almanax/%s", cfg.doduapiUrl(), lang, date), nil)
+	if err != nil {
+		return day, err
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return day, err
+	}
+	defer res.Body.Close()
+
+	err = statusRetryable(res)
+	if err != nil {
+		return day, err
+	}
+	err = json.NewDecoder(res.Body).Decode(&day)
+	return day, err
+}
+
+// smoke checks the published asset end to end: it loads, covers today and the coming days, agrees with
+// krosmoz today and doduapi serves the same offering.
+func smoke(cfg *Config, date string, lang string) []smokeCheck {
+	var checks []smokeCheck
+	fail := func(name string, format string, args ...any) {
+		checks = append(checks, smokeCheck{Name: name, Detail: fmt.Sprintf(format, args...)})
+	}
+	pass := func(name string, format string, args ...any) {
+		checks = append(checks, smokeCheck{Name: name, Ok: true, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	repo := cfg.dataRepo()
+	version, err := getLatestVersion(repo)
+	if err != nil {
+		fail("asset", "latest release of %s: %s", repo, err)
+		return checks
+	}
+	ds, uploadedAt, err := loadDatasetRelease(repo, version)
+	if err != nil {
+		fail("asset", "%s of %s: %s", MappedAlmanaxFileName, version, err)
+		return checks
+	}
+	pass("asset", "%s, schema %d, %d receivers, uploaded %s", version, ds.Schema, len(ds.Receivers), uploadedAt.Format(time.RFC3339))
+
+	days := almanax.NewIndex(ds)
+	from, to := days.Coverage()
+	switch {
+	case days.Len() == 0:
+		fail("coverage", "no mapped dates")
+	case date < from || date > to:
+		fail("coverage", "%s is outside the mapped %s to %s", date, from, to)
+	default:
+		missing := 0
+		dates, _ := createDateRange(from, to)
+		for _, d := range dates {
+			if _, ok := days.Day(d); !ok {
+				missing++
+			}
+		}
+		today, _ := time.Parse("2006-01-02", date)
+		detail := fmt.Sprintf("%s to %s, %d days left", from, to, daysRemaining(today, to))
+		if missing > 0 {
+			fail("coverage", "%s, %d dates missing", detail, missing)
+		} else if cfg.ExtendBelow > 0 && time.Duration(daysRemaining(today, to))*24*time.Hour < cfg.ExtendBelow {
+			fail("coverage", "%s, below extend_below %s", detail, FormatDuration(cfg.ExtendBelow))
+		} else {
+			pass("coverage", "%s", detail)
+		}
+	}
+
+	mapped, ok := days.Day(date)
+	if !ok {
+		fail("krosmoz", "%s is not mapped", date)
+		fail("doduapi", "%s is not mapped", date)
+		return checks
+	}
+
+	doc, status, err := fetchAlmanaxDocument(lang, date, cfg.ScrapeTimeout)
+	switch {
+	case err != nil:
+		fail("krosmoz", "%s", err)
+	case status != 200:
+		fail("krosmoz", "status %d for %s", status, almanaxPageUrl(lang, date))
+	default:
+		page := extractAlmanaxPage(doc, lang)
+		aliases := parseReceiverAliases(cfg.ReceiverAliases)
+		i := matchAlmanaxPage(ds, lang, page, aliases)
+		if i == -1 || &ds.Receivers[i] != mapped {
+			fail("krosmoz", "mapped %q, krosmoz shows %q", mapped.Name, page.Receiver)
+		} else {
+			pass("krosmoz", "%s", mapped.Name)
+		}
+	}
+
+	served, err := getDoduapiAlmanax(cfg, lang, date)
+	switch {
+	case err != nil:
+		fail("doduapi", "%s", err)
+	case served.Tribute.Item.AnkamaId != mapped.Offering.ItemId || served.Tribute.Quantity != mapped.Offering.Quantity:
+		fail("doduapi", "mapped %dx %s, doduapi serves %dx %s", mapped.Offering.Quantity, mapped.Offering.ItemName.Get(lang), served.Tribute.Quantity, served.Tribute.Item.Name)
+	default:
+		pass("doduapi", "%dx %s", served.Tribute.Quantity, served.Tribute.Item.Name)
+	}
+	return checks
+}
+
+func writeSmoke(w io.Writer, date string, checks []smokeCheck) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "date: %s\n\n", date)
+	fmt.Fprintln(tw, "CHECK\tRESULT\tDETAIL")
+	for _, check := range checks {
+		result := "ok"
+		if !check.Ok {
+			result = "FAIL"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", check.Name, result, check.Detail)
+	}
+	return tw.Flush()
+}
+
+// smokeCommand exits with 1 if any check fails.
+func smokeCommand(args []string) {
+	flags := flag.NewFlagSet("smoke", flag.ExitOnError)
+	date := flags.String("date", "", "date to check, defaults to today")
+	lang := flags.String("lang", "en", "krosmoz page and doduapi language")
+	cfg, _, err := loadConfig(flags, args)
+	if err != nil {
+		log.Fatal("error loading config", "error", err)
+	}
+	retryPolicy = cfg.retryPolicy()
+	downloadTimeout = cfg.DownloadTimeout
+	httpClient = newHttpClient(cfg)
+
+	if *date == "" {
+		*date = time.Now().In(cfg.location()).Format("2006-01-02")
+	}
+	if !isDate(*date) {
+		log.Fatal("invalid date, expected YYYY-MM-DD", "date", *date)
+	}
+	if _, ok := almanaxPagePatterns[*lang]; !ok {
+		log.Fatal("unsupported language", "lang", *lang)
+	}
+
+	checks := smoke(&cfg, *date, *lang)
+	err = writeSmoke(os.Stdout, *date, checks)
+	if err != nil {
+		log.Fatal("error writing results", "error", err)
+	}
+
+	for _, check := range checks {
+		if !check.Ok {
+			os.Exit(1)
+		}
+	}
+}
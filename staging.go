@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// stageDataset posts the asset to the doduapi staging endpoint, which parses and validates it. The
+// production publish only happens if staging accepts it.
+func stageDataset(data []byte, version string, cfg *Config) error {
+	if cfg.StagingUrl == "" {
+		return nil
+	}
+
+	ctx := context.Background()
+	retry := cfg.retryPolicy()
+	err := retry.do(ctx, "stage dataset", func() error {
+		ctx, cancel := context.WithTimeout(ctx, cfg.UploadTimeout)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, "POST", cfg.StagingUrl, bytes.NewReader(data))
+		if err != nil {
+			return permanent(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Almanax-Version", version)
+		if cfg.StagingToken != "" {
+			req.Header.Set("Authorization", "Bearer "+cfg.StagingToken)
+		}
+
+		res, err := httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+
+		return statusRetryable(res)
+	})
+	if err != nil {
+		return fmt.Errorf("staging rejected the dataset: %w", err)
+	}
+	return nil
+}
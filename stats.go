@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/dofusdude/alm-dates/almanax"
+)
+
+type bonusTypeCount struct {
+	Type string `json:"type"`
+	Days int    `json:"days"`
+}
+
+type itemDemand struct {
+	ItemId         int    `json:"item_id"`
+	ItemCategoryId int    `json:"item_category_id"`
+	Item           string `json:"item"`
+	Quantity       int    `json:"quantity"`
+	Days           int    `json:"days"`
+}
+
+type monthTotals struct {
+	Month    string `json:"month"`
+	Days     int    `json:"days"`
+	Kamas    int    `json:"kamas"`
+	Quantity int    `json:"quantity"`
+}
+
+// almanaxStats are the overview numbers of the mapped days in a range.
+type almanaxStats struct {
+	Version    string           `json:"version"`
+	From       string           `json:"from"`
+	To         string           `json:"to"`
+	Days       int              `json:"days"`
+	BonusTypes []bonusTypeCount `json:"bonus_types"`
+	Items      []itemDemand     `json:"items"`
+	Months     []monthTotals    `json:"months"`
+}
+
+// aggregateStats counts the bonus types, sums up the offering items and the kamas and items per month of
+// the mapped days in the range. Bonus types and items are sorted by frequency.
+func aggregateStats(days *almanax.Index, fromDate string, toDate string, lang string) almanaxStats {
+	stats := almanaxStats{From: fromDate, To: toDate, BonusTypes: []bonusTypeCount{}, Months: []monthTotals{}}
+
+	bonusTypes := make(map[string]int)
+	for _, day := range days.Range(fromDate, toDate) {
+		alm := day.Receiver
+		stats.Days++
+		bonusTypes[alm.Bonus.Type.Get(lang)]++
+
+		month := day.Date[:7]
+		if len(stats.Months) == 0 || stats.Months[len(stats.Months)-1].Month != month {
+			stats.Months = append(stats.Months, monthTotals{Month: month})
+		}
+		totals := &stats.Months[len(stats.Months)-1]
+		totals.Days++
+		totals.Kamas += alm.RewardKamas
+		totals.Quantity += alm.Offering.Quantity
+	}
+
+	for bonusType, count := range bonusTypes {
+		stats.BonusTypes = append(stats.BonusTypes, bonusTypeCount{Type: bonusType, Days: count})
+	}
+	sort.Slice(stats.BonusTypes, func(i, j int) bool {
+		if stats.BonusTypes[i].Days != stats.BonusTypes[j].Days {
+			return stats.BonusTypes[i].Days > stats.BonusTypes[j].Days
+		}
+		return stats.BonusTypes[i].Type < stats.BonusTypes[j].Type
+	})
+
+	stats.Items = []itemDemand{}
+	for _, total := range aggregateOfferings(days, fromDate, toDate, lang) {
+		stats.Items = append(stats.Items, itemDemand{
+			ItemId:         total.ItemId,
+			ItemCategoryId: total.ItemCategoryId,
+			Item:           total.Item,
+			Quantity:       total.Quantity,
+			Days:           len(total.Dates),
+		})
+	}
+	return stats
+}
+
+// stats aggregates the range, an empty bound is the first or last mapped date.
+func (s *almanaxStore) stats(from string, to string, lang string) (almanaxStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	first, last := s.days.Coverage()
+	if from == "" {
+		from = first
+	}
+	if to == "" {
+		to = last
+	}
+	if first != "" {
+		var err error
+		from, to, err = parseDateRangeQuery(from, to)
+		if err != nil {
+			return almanaxStats{}, err
+		}
+	}
+
+	stats := aggregateStats(s.days, from, to, lang)
+	stats.Version = s.version
+	return stats, nil
+}
+
+func (s *server) handleStats(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	stats, err := s.store.stats(query.Get("from"), query.Get("to"), normalizeLang(query.Get("lang")))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/dofusdude/alm-dates/almanax"
+)
+
+// The mapping strategies, selectable with the strategies config in the order they are tried.
+const (
+	strategyIncremental     = "incremental"
+	strategyReplayFromCache = "replay-from-cache"
+	strategyCycleInference  = "cycle-inference"
+	strategyFullScrape      = "full-scrape"
+)
+
+var mappingStrategies = []string{strategyIncremental, strategyReplayFromCache, strategyCycleInference, strategyFullScrape}
+
+// mappingStrategy maps the dates it can and leaves the others to the next strategy.
+type mappingStrategy interface {
+	name() string
+	// mapDates adds the dates it can map to their receivers and sources and returns the dates left.
+	mapDates(ds *almanax.Dataset, dates []string, sources provenance) ([]string, error)
+}
+
+// incrementalStrategy keeps the dates the dataset already has, without it they are mapped again.
+type incrementalStrategy struct{}
+
+func (incrementalStrategy) name() string {
+	return strategyIncremental
+}
+
+func (incrementalStrategy) mapDates(ds *almanax.Dataset, dates []string, _ provenance) ([]string, error) {
+	return missingDates(ds, dates), nil
+}
+
+// replayStrategy maps dates from the kept krosmoz pages of earlier runs, whatever their age, without
+// requesting krosmoz.
+type replayStrategy struct {
+	pages     string
+	languages []string
+	aliases   map[string]string
+}
+
+func (replayStrategy) name() string {
+	return strategyReplayFromCache
+}
+
+func (r replayStrategy) mapDates(ds *almanax.Dataset, dates []string, sources provenance) ([]string, error) {
+	var left []string
+	for _, date := range dates {
+		mapped := false
+		for _, lang := range r.languages {
+			info, err := os.Stat(pagePath(r.pages, lang, date))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			html, err := loadPage(r.pages, lang, date)
+			if err != nil {
+				return nil, err
+			}
+			page, err := parseAlmanaxPage(html, lang, date)
+			if err != nil {
+				log.Warn("kept page does not parse, leaving the date to the next strategy", "date", date, "lang", lang, "error", err)
+				continue
+			}
+			i := matchAlmanaxPage(ds, lang, page, r.aliases)
+			if i == -1 {
+				continue
+			}
+			ds.Receivers[i].Days = append(ds.Receivers[i].Days, date)
+			sources[date] = provenanceEntry{Url: almanaxPageUrl(lang, date), Lang: lang, FetchedAt: info.ModTime().UTC(), Strategy: strategyReplayFromCache, Quantity: page.Quantity, Kamas: page.Kamas}
+			mapped = true
+			break
+		}
+		if !mapped {
+			left = append(left, date)
+		}
+	}
+	ds.SortDays()
+	return left, nil
+}
+
+// cycleMinDays is how many known dates cycle inference needs, a full year of the cycle.
+const cycleMinDays = 365
+
+// cycleStrategy infers a date from the receiver of the same calendar date a year before, in the dataset or
+// the reference dataset, usually the previous release. Inferred dates count as known for the year after,
+// so a window of several years is extrapolated from one. It is fast and hardly requests krosmoz, but only
+// right as long as the cycle did not change, verify scrapes a sample of the inferred dates to check that.
+type cycleStrategy struct {
+	reference func() (*almanax.Dataset, error)
+	// verify returns how many of the inferred dates it could check against krosmoz and the mismatches, and
+	// an error if it could not check enough of them to trust the cycle
+	verify func(ds *almanax.Dataset, dates []string) (int, []validationMismatch, error)
+}
+
+func (cycleStrategy) name() string {
+	return strategyCycleInference
+}
+
+func (c cycleStrategy) mapDates(ds *almanax.Dataset, dates []string, sources provenance) ([]string, error) {
+	reference, err := c.reference()
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]string)
+	for _, dataset := range []*almanax.Dataset{reference, ds} {
+		if dataset == nil {
+			continue
+		}
+		for _, day := range dataset.Days() {
+			known[day.Date] = day.Receiver.Name
+		}
+		for _, tombstone := range dataset.Tombstones {
+			for _, date := range tombstone.Days {
+				known[date] = tombstone.Name
+			}
+		}
+	}
+
+	if len(known) < cycleMinDays {
+		log.Info("not inferring dates, less than a year of the cycle is known", "known", len(known))
+		return dates, nil
+	}
+
+	receivers := make(map[string]int, len(ds.Receivers))
+	for i, receiver := range ds.Receivers {
+		receivers[receiver.Name] = i
+	}
+
+	// a date two years out is inferred from the one inferred a year before it
+	dates = slices.Clone(dates)
+	slices.Sort(dates)
+	var left, inferred []string
+	for _, date := range dates {
+		day, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			return nil, err
+		}
+		// february 29 has no date a year before
+		if day.Month() == time.February && day.Day() == 29 {
+			left = append(left, date)
+			continue
+		}
+		yearBefore := day.AddDate(-1, 0, 0).Format("2006-01-02")
+		i, ok := receivers[known[yearBefore]]
+		if !ok {
+			left = append(left, date)
+			continue
+		}
+		ds.Receivers[i].Days = append(ds.Receivers[i].Days, date)
+		sources[date] = provenanceEntry{FetchedAt: time.Now().UTC(), Strategy: strategyCycleInference, InferredFrom: yearBefore}
+		known[date] = ds.Receivers[i].Name
+		inferred = append(inferred, date)
+	}
+	ds.SortDays()
+
+	if c.verify != nil && len(inferred) > 0 {
+		checked, mismatches, err := c.verify(ds, inferred)
+		for _, mismatch := range mismatches {
+			log.Warn("inferred date disagrees with krosmoz", "date", mismatch.Date, "inferred", mismatch.Mapped, "scraped", mismatch.Scraped)
+		}
+		if err != nil {
+			log.Warn("cycle could not be verified, leaving the inferred dates to the next strategy", "inferred", len(inferred), "checked", checked, "error", err)
+		}
+		if len(mismatches) > 0 || err != nil {
+			// one wrong date means the cycle shifted somewhere, none of the inferred dates can be trusted
+			if len(mismatches) > 0 {
+				log.Warn("cycle does not hold, leaving the inferred dates to the next strategy", "inferred", len(inferred), "checked", checked, "mismatches", len(mismatches))
+			}
+			clearDates(ds, inferred)
+			for _, date := range inferred {
+				delete(sources, date)
+			}
+			left = append(left, inferred...)
+			slices.Sort(left)
+			return left, nil
+		}
+		log.Info("cycle holds", "inferred", len(inferred), "checked", checked)
+	}
+	return left, nil
+}
+
+// verifyCycle scrapes a random cycle_verify_percent of the inferred dates, at least one, and compares their
+// receivers. Krosmoz only serves dates up to some point after today, so the sample is taken from the dates
+// up to today first and a date krosmoz does not serve is replaced by another one, up to twice the sample in
+// requests. It fails if fewer than the sample could be checked.
+func (p *pipeline) verifyCycle(ds *almanax.Dataset, dates []string) (int, []validationMismatch, error) {
+	days := almanax.NewIndex(ds)
+	count := max(1, min(int(math.Ceil(float64(len(dates))*p.cfg.CycleVerifyPercent/100)), len(dates)))
+	p.log.Info("verifying inferred dates", "dates", count, "of", len(dates))
+
+	today := time.Now().In(p.cfg.location()).Format("2006-01-02")
+	var candidates, ahead []string
+	for _, i := range rand.Perm(len(dates)) {
+		if dates[i] > today {
+			ahead = append(ahead, dates[i])
+		} else {
+			candidates = append(candidates, dates[i])
+		}
+	}
+	candidates = append(candidates, ahead...)
+
+	var checked int
+	var mismatches []validationMismatch
+	for n, date := range candidates {
+		if checked == count || n == 2*count {
+			break
+		}
+		page, lang, _, err := p.scraper.scrape(date)
+		if err != nil {
+			p.log.Debug("could not verify inferred date", "date", date, "error", err)
+			continue
+		}
+		checked++
+		j := matchAlmanaxPage(ds, lang, page, p.aliases)
+		mapped, ok := days.Day(date)
+		if j != -1 && ok && &ds.Receivers[j] == mapped {
+			continue
+		}
+		mismatch := validationMismatch{Date: date, Scraped: page.Receiver}
+		if ok {
+			mismatch.Mapped = mapped.Name
+		}
+		if j != -1 {
+			mismatch.Scraped = ds.Receivers[j].Name
+		}
+		mismatches = append(mismatches, mismatch)
+	}
+	if checked < count {
+		return checked, mismatches, fmt.Errorf("krosmoz served %d of the %d inferred dates to check", checked, count)
+	}
+	return checked, mismatches, nil
+}
+
+// scrapeStrategy requests the dates from krosmoz with the scrape workers, continuing from the checkpoint of
+// the version. Only dates krosmoz did not generate yet are left.
+type scrapeStrategy struct {
+	p       *pipeline
+	version string
+}
+
+func (scrapeStrategy) name() string {
+	return strategyFullScrape
+}
+
+func (s scrapeStrategy) mapDates(ds *almanax.Dataset, dates []string, sources provenance) ([]string, error) {
+	cp, err := openCheckpoint(s.p.workdir, s.version)
+	if err != nil {
+		return nil, err
+	}
+	defer cp.close()
+
+	s.p.progress.track(cp, len(dates))
+	scraped, err := mapDates(ds, dates, s.p.scraper, s.p.aliases, cp)
+	for date, entry := range scraped {
+		entry.Strategy = strategyFullScrape
+		sources[date] = entry
+	}
+	if err != nil {
+		return nil, err
+	}
+	return missingDates(ds, dates), nil
+}
+
+// strategies returns the configured strategies in order for mapping a version.
+func (p *pipeline) strategies(version string) []mappingStrategy {
+	var strategies []mappingStrategy
+	for _, name := range p.cfg.Strategies {
+		switch name {
+		case strategyIncremental:
+			strategies = append(strategies, incrementalStrategy{})
+		case strategyReplayFromCache:
+			strategies = append(strategies, replayStrategy{pages: pagesDir(p.workdir), languages: p.cfg.ScrapeLanguages, aliases: p.aliases})
+		case strategyCycleInference:
+			cycle := cycleStrategy{reference: func() (*almanax.Dataset, error) {
+				return p.referenceDataset(version)
+			}}
+			if p.cfg.CycleVerifyPercent > 0 {
+				cycle.verify = p.verifyCycle
+			}
+			strategies = append(strategies, cycle)
+		case strategyFullScrape:
+			strategies = append(strategies, scrapeStrategy{p: p, version: version})
+		}
+	}
+	return strategies
+}
+
+// incremental reports whether dates the dataset already has are kept instead of mapped again.
+func (p *pipeline) incremental() bool {
+	return slices.Contains(p.cfg.Strategies, strategyIncremental)
+}
+
+// referenceDataset is the release before a version, nil if there is none.
+func (p *pipeline) referenceDataset(version string) (*almanax.Dataset, error) {
+	data, _, err := previousAsset(p.repo, version)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	return almanax.Decode(data)
+}
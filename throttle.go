@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"golang.org/x/exp/rand"
+)
+
+// throttledReader limits how fast a request body is read, which is how fast it is sent.
+type throttledReader struct {
+	r io.ReadCloser
+	// rate is in bytes per second
+	rate  int
+	start time.Time
+	sent  int
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+
+	// small reads keep the uplink free for other traffic in between
+	if chunk := max(t.rate/10, 1); len(p) > chunk {
+		p = p[:chunk]
+	}
+
+	n, err := t.r.Read(p)
+	t.sent += n
+
+	due := t.start.Add(time.Duration(float64(t.sent) / float64(t.rate) * float64(time.Second)))
+	if wait := time.Until(due); wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
+
+func (t *throttledReader) Close() error {
+	return t.r.Close()
+}
+
+// throttledTransport sends request bodies at most at rate bytes per second.
+type throttledTransport struct {
+	rate int
+	next http.RoundTripper
+}
+
+func (t *throttledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return t.next.RoundTrip(req)
+	}
+
+	throttled := req.Clone(req.Context())
+	throttled.Body = &throttledReader{r: req.Body, rate: t.rate}
+	return t.next.RoundTrip(throttled)
+}
+
+// uploadHttpClient returns the http client for the release asset upload, throttled if upload_rate is set.
+func uploadHttpClient(cfg *Config) *http.Client {
+	if cfg.UploadRate <= 0 {
+		return httpClient
+	}
+	return &http.Client{Transport: &throttledTransport{rate: cfg.UploadRate * 1024, next: httpClient.Transport}}
+}
+
+// uploadTimeout is the timeout of a release asset upload of size bytes: upload_timeout on top of the time
+// upload_rate needs for them, so a throttled large asset does not time out on every attempt.
+func uploadTimeout(cfg *Config, size int) time.Duration {
+	if cfg.UploadRate <= 0 {
+		return cfg.UploadTimeout
+	}
+	return cfg.UploadTimeout + time.Duration(size)*time.Second/time.Duration(cfg.UploadRate*1024)
+}
+
+// tokenBucket allows rate requests per second on average and bursts of up to burst requests.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// wait takes a token, waiting until there is one. Waiting callers are served in the order they came.
+func (b *tokenBucket) wait(ctx context.Context) error {
+	b.mu.Lock()
+	now := time.Now()
+	if !b.last.IsZero() {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	// the token is taken right away, a negative balance is the wait of the callers before
+	b.tokens--
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// krosmozLimiter is shared by all requests to krosmoz, across pipelines. It is set from the config on
+// startup like retryPolicy.
+var krosmozLimiter = newTokenBucket(1, 1)
+
+// krosmozThrottle backs off all requests to krosmoz after it answered 429 or 503, for as long as its
+// Retry-After says or progressively longer with the retry policy. Once the waits add up to more than max
+// without a request getting through, requests fail with a throttledError until the next one is allowed.
+type krosmozThrottle struct {
+	mu     sync.Mutex
+	policy RetryPolicy
+	max    time.Duration
+	// strikes are the throttled answers since the last one that was not
+	strikes int
+	// status is the last throttled answer
+	status int
+	waited time.Duration
+	until  time.Time
+}
+
+func newKrosmozThrottle(policy RetryPolicy, max time.Duration) *krosmozThrottle {
+	return &krosmozThrottle{policy: policy, max: max}
+}
+
+// throttledError is a request that was not sent, because krosmoz throttled the ones before for too long.
+type throttledError struct {
+	Status int
+	Waited time.Duration
+}
+
+func (e throttledError) Error() string {
+	return fmt.Sprintf("krosmoz throttled requests (status %d) for %s", e.Status, FormatDuration(e.Waited.Round(time.Second)))
+}
+
+// krosmozThrottled is shared by all requests to krosmoz like krosmozLimiter and set from the config on startup.
+var krosmozThrottled = newKrosmozThrottle(retryPolicy, 15*time.Minute)
+
+// wait blocks until krosmoz accepts requests again or fails when the backoff went on for too long. The
+// backoff starts over after failing, so the next run tries again.
+func (t *krosmozThrottle) wait(ctx context.Context) error {
+	t.mu.Lock()
+	delay := time.Until(t.until)
+	if delay > 0 && t.max > 0 && t.waited+delay > t.max {
+		err := throttledError{Status: t.status, Waited: t.waited}
+		t.strikes, t.waited, t.until = 0, 0, time.Time{}
+		t.mu.Unlock()
+		return err
+	}
+	t.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// report records an answer of krosmoz. A 429 or 503 moves the next request back by its Retry-After or the
+// backoff of the retry policy, whichever is longer.
+func (t *krosmozThrottle) report(status int, retryAfter string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if status != http.StatusTooManyRequests && status != http.StatusServiceUnavailable {
+		t.strikes, t.waited = 0, 0
+		return
+	}
+
+	t.strikes++
+	t.status = status
+	delay := t.policy.delay(t.strikes)
+	if after := parseRetryAfter(retryAfter); after > delay {
+		delay = after
+	}
+	from := time.Now()
+	if t.until.After(from) {
+		from = t.until
+	}
+	if until := time.Now().Add(delay); until.After(from) {
+		t.waited += until.Sub(from)
+		t.until = until
+	}
+	log.Warn("krosmoz throttles requests, backing off", "status", status, "retry_after", retryAfter, "for", FormatDuration(delay.Round(time.Second)))
+}
+
+// parseRetryAfter reads a Retry-After header in seconds or as http date, 0 if there is none.
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0)
+	}
+	return 0
+}
+
+// requestBudget allows at most limit requests in any minute, 0 allows any number.
+type requestBudget struct {
+	mu    sync.Mutex
+	limit int
+	// sent are the times of the last limit requests, oldest first
+	sent []time.Time
+}
+
+func newRequestBudget(limit int) *requestBudget {
+	return &requestBudget{limit: limit}
+}
+
+// wait takes a request of the budget, waiting until the oldest of the last limit requests is a minute ago.
+func (b *requestBudget) wait(ctx context.Context) error {
+	if b.limit <= 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	now := time.Now()
+	at := now
+	if len(b.sent) >= b.limit {
+		if free := b.sent[len(b.sent)-b.limit].Add(time.Minute); free.After(now) {
+			at = free
+		}
+		b.sent = b.sent[len(b.sent)-b.limit+1:]
+	}
+	// the slot is taken right away like with the token bucket, so callers are served in order
+	b.sent = append(b.sent, at)
+	b.mu.Unlock()
+
+	if delay := at.Sub(now); delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// krosmozBudget is the requests per minute budget of krosmoz, shared like krosmozLimiter and set from the
+// config on startup.
+var krosmozBudget = newRequestBudget(0)
+
+// krosmozJitter is the range of the random politeness delay before each krosmoz request, set from the
+// config on startup.
+var krosmozJitter = [2]time.Duration{}
+
+// waitKrosmozTurn waits until a krosmoz request may be sent: the rate limiter, the minute budget and then
+// a random delay within the jitter range, so requests do not come in a regular beat.
+func waitKrosmozTurn(ctx context.Context) error {
+	err := krosmozLimiter.wait(ctx)
+	if err != nil {
+		return err
+	}
+	err = krosmozBudget.wait(ctx)
+	if err != nil {
+		return err
+	}
+
+	jitter := krosmozJitter[0]
+	if spread := krosmozJitter[1] - krosmozJitter[0]; spread > 0 {
+		jitter += time.Duration(rand.Int63n(int64(spread)))
+	}
+	if jitter <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(jitter):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v67/github"
+)
+
+// tokenHealth is what github tells about the token of a pipeline.
+type tokenHealth struct {
+	// Expires is zero for tokens without expiration
+	Expires time.Time
+	// Scopes are only reported for classic tokens, nil for fine-grained ones
+	Scopes []string
+	// CanPush is whether the token may write the data repo, which publishing releases needs
+	CanPush bool
+}
+
+// tokenExpirationLayouts are the formats of the github-authentication-token-expiration header.
+var tokenExpirationLayouts = []string{"2006-01-02 15:04:05 MST", "2006-01-02 15:04:05 -0700"}
+
+// checkGithubToken reads the expiry, scopes and repo permissions of a token with one request for the repo.
+func checkGithubToken(ctx context.Context, token string, repo dataRepo) (tokenHealth, error) {
+	client := github.NewClient(httpClient).WithAuthToken(token)
+	rep, res, err := client.Repositories.Get(ctx, repo.owner, repo.name)
+	if err != nil {
+		var resErr *github.ErrorResponse
+		if errors.As(err, &resErr) && resErr.Response != nil && resErr.Response.StatusCode == http.StatusUnauthorized {
+			return tokenHealth{}, errors.New("github rejects the token, it expired or was revoked")
+		}
+		return tokenHealth{}, err
+	}
+
+	var health tokenHealth
+	if expiration := res.Header.Get("Github-Authentication-Token-Expiration"); expiration != "" {
+		for _, layout := range tokenExpirationLayouts {
+			if t, err := time.Parse(layout, expiration); err == nil {
+				health.Expires = t
+				break
+			}
+		}
+	}
+	if scopes, ok := res.Header["X-Oauth-Scopes"]; ok {
+		health.Scopes = []string{}
+		for _, scope := range strings.Split(strings.Join(scopes, ","), ",") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				health.Scopes = append(health.Scopes, scope)
+			}
+		}
+	}
+	health.CanPush = rep.GetPermissions()["push"]
+	return health, nil
+}
+
+// problems lists what keeps the token from publishing, and as warning its expiry within warnBefore.
+func (h tokenHealth) problems(warnBefore time.Duration) []configProblem {
+	var problems []configProblem
+	if !h.Expires.IsZero() {
+		left := time.Until(h.Expires)
+		if left <= 0 {
+			problems = append(problems, configProblem{key: "gh_auth_key", message: "github token expired " + h.Expires.Format(time.DateOnly)})
+		} else if left <= warnBefore {
+			problems = append(problems, configProblem{key: "gh_auth_key", message: fmt.Sprintf("github token expires %s, in %s", h.Expires.Format(time.DateOnly), FormatDuration(left.Round(time.Hour))), warning: true})
+		}
+	}
+	if h.Scopes != nil && !slices.Contains(h.Scopes, "repo") && !slices.Contains(h.Scopes, "public_repo") {
+		problems = append(problems, configProblem{key: "gh_auth_key", message: "github token lacks the repo or public_repo scope, it has " + strings.Join(h.Scopes, ", ")})
+	}
+	if !h.CanPush {
+		problems = append(problems, configProblem{key: "gh_auth_key", message: "github token can not write the data repo"})
+	}
+	return problems
+}
+
+// watchToken checks the github tokens every token_check_interval and raises an alert for every problem,
+// so publishing does not stop unnoticed when a token lapses.
+func (p *pipeline) watchToken(ctx context.Context) {
+	for {
+		p.checkToken(ctx, p.cfg.GhAuthKey, "")
+		if p.cfg.GhAuthKeySecondary != "" {
+			p.checkToken(ctx, p.cfg.GhAuthKeySecondary, "secondary ")
+		}
+
+		if !sleepCtx(ctx, p.cfg.TokenCheckInterval) {
+			return
+		}
+	}
+}
+
+// checkToken checks one token, the prefix tells the alerts of the secondary token apart.
+func (p *pipeline) checkToken(ctx context.Context, token string, prefix string) {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.NotifyTimeout)
+	defer cancel()
+	health, err := checkGithubToken(ctx, token, p.repo)
+	if err != nil {
+		p.log.Error("error checking "+prefix+"github token", "error", err)
+		p.alerts.add(prefix+"github token check failed", err)
+		return
+	}
+	if prefix == "" && !health.Expires.IsZero() {
+		metrics.set(metricTokenExpiry, float64(health.Expires.Unix()), p.cfg.Game, p.name)
+	}
+	for _, problem := range health.problems(p.cfg.TokenExpiryWarning) {
+		p.log.Warn(prefix+problem.message, "repo", p.repo)
+		p.alerts.add(prefix+problem.message, nil)
+	}
+}
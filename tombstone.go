@@ -0,0 +1,57 @@
+package main
+
+import (
+	"github.com/charmbracelet/log"
+	"github.com/dofusdude/alm-dates/almanax"
+)
+
+// carryTombstones keeps the days before the date of the receivers that the previous dataset has and the
+// new one does not, and the tombstones of the previous dataset. A tombstoned receiver that is back in the
+// game data gets its days back. It returns the number of receivers that left.
+func carryTombstones(ds *almanax.Dataset, previous *almanax.Dataset, before string) int {
+	live := make(map[string]int, len(ds.Receivers))
+	for i, receiver := range ds.Receivers {
+		live[receiver.Name] = i
+	}
+	tombstoned := make(map[string]bool, len(ds.Tombstones))
+	for _, tombstone := range ds.Tombstones {
+		tombstoned[tombstone.Name] = true
+	}
+
+	for _, tombstone := range previous.Tombstones {
+		if i, ok := live[tombstone.Name]; ok {
+			log.Info("removed receiver is back, restoring its days", "receiver", tombstone.Name, "days", len(tombstone.Days))
+			ds.Receivers[i].Days = append(ds.Receivers[i].Days, tombstone.Days...)
+			continue
+		}
+		if !tombstoned[tombstone.Name] {
+			ds.Tombstones = append(ds.Tombstones, tombstone)
+			tombstoned[tombstone.Name] = true
+		}
+	}
+
+	removed := 0
+	for _, receiver := range previous.Receivers {
+		if _, ok := live[receiver.Name]; ok || tombstoned[receiver.Name] {
+			continue
+		}
+		// the later days were mapped for a game version that no longer has the receiver
+		var days []string
+		for _, date := range receiver.Days {
+			if date != "" && date < before {
+				days = append(days, date)
+			}
+		}
+		log.Warn("receiver left the game data, keeping its days as a tombstone", "receiver", receiver.Name, "days", len(days))
+		removed++
+		if len(days) == 0 {
+			continue
+		}
+		receiver.Days = days
+		ds.Tombstones = append(ds.Tombstones, receiver)
+		tombstoned[receiver.Name] = true
+	}
+
+	ds.SortDays()
+	return removed
+}
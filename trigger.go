@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// triggerCommand queues a job in a running daemon by writing it into the trigger inbox of the workdir.
+func triggerCommand(args []string) {
+	if len(args) == 0 {
+		log.Fatal("missing job kind", "available", "map-version, backfill, extend-horizon, validate, cleanup-releases")
+	}
+
+	kind := jobKind(args[0])
+	if _, ok := jobPriorities[kind]; !ok {
+		log.Fatal("unknown job kind", "kind", kind)
+	}
+
+	flags := flag.NewFlagSet("trigger", flag.ExitOnError)
+	version := flags.String("version", "", "data release version, defaults to the last seen version")
+	from := flags.String("from", "", "first date of a backfill")
+	to := flags.String("to", "", "last date of a backfill")
+	tenant := flags.String("tenant", "", "tenant to queue the job for")
+	force := flags.Bool("force", false, "map-version maps every date of the window again, even if the release has it")
+	cfg, _, err := loadConfig(flags, args[1:])
+	if err != nil {
+		log.Fatal("error loading config", "error", err)
+	}
+
+	if kind == jobBackfill && (!isDate(*from) || !isDate(*to)) {
+		log.Fatal("backfill needs --from and --to as YYYY-MM-DD")
+	}
+
+	workdir := cfg.Workdir
+	if len(cfg.Tenants) > 0 {
+		tenants, err := loadTenants(&cfg)
+		if err != nil {
+			log.Fatal("error loading tenants", "error", err)
+		}
+		workdir = ""
+		for _, t := range tenants {
+			if t.name == *tenant {
+				workdir = t.cfg.Workdir
+			}
+		}
+		if workdir == "" {
+			log.Fatal("unknown tenant, use --tenant", "tenant", *tenant)
+		}
+	}
+
+	workdir, err = parseWd(workdir)
+	if err != nil {
+		log.Fatal("error parsing working directory", "error", err)
+	}
+
+	data, err := json.Marshal(job{Kind: kind, Version: *version, From: *from, To: *to, Queued: time.Now(), Force: *force})
+	if err != nil {
+		log.Fatal("error encoding job", "error", err)
+	}
+
+	dir := triggerInboxDir(workdir)
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		log.Fatal("error creating trigger inbox", "error", err)
+	}
+
+	// the daemon only reads .json files, so it never sees a partially written job
+	path := filepath.Join(dir, fmt.Sprintf("%d.json", time.Now().UnixNano()))
+	err = os.WriteFile(path+".tmp", data, 0644)
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		log.Fatal("error writing trigger", "error", err)
+	}
+
+	log.Info("job handed to the daemon", "kind", kind, "workdir", workdir)
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// triggerEvent asks a pipeline to queue a job.
+type triggerEvent struct {
+	Source string
+	Job    job
+}
+
+// TriggerSource emits trigger events until the context is done. New kinds of triggers only need a source,
+// the pipeline queues every event the same way.
+type TriggerSource interface {
+	Name() string
+	Run(ctx context.Context, events chan<- triggerEvent) error
+}
+
+// triggerSources returns the sources enabled in the config.
+func (p *pipeline) triggerSources() ([]TriggerSource, error) {
+	sources := []TriggerSource{
+		&releaseWatcher{workdir: p.workdir, repo: p.repo, interval: p.cfg.PollingInterval},
+		&inboxTrigger{dir: triggerInboxDir(p.workdir), interval: 5 * time.Second},
+	}
+
+	if p.cfg.ExtendBelow > 0 {
+		sources = append(sources, &horizonTrigger{interval: p.cfg.PollingInterval})
+	}
+	if p.cfg.DoduapiPoll {
+		sources = append(sources, &doduapiPoller{url: p.cfg.doduapiUrl() + "/meta/version", interval: p.cfg.PollingInterval, retry: p.cfg.retryPolicy()})
+	}
+	if p.cfg.WebhookAddr != "" {
+		sources = append(sources, &webhookListener{addr: p.cfg.WebhookAddr, secret: p.cfg.WebhookSecret, tenant: p.name})
+	}
+	for _, entry := range p.cfg.cronEntries() {
+		trigger, err := parseCronTrigger(entry, p.cfg.location())
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, trigger)
+	}
+	return sources, nil
+}
+
+// releaseWatcher emits a map-version job whenever the latest release of the data repo changes.
+type releaseWatcher struct {
+	workdir  string
+	repo     dataRepo
+	interval time.Duration
+}
+
+func (w *releaseWatcher) Name() string {
+	return "release watcher"
+}
+
+func (w *releaseWatcher) Run(ctx context.Context, events chan<- triggerEvent) error {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if !health.canStartUpdate() {
+				continue
+			}
+
+			// github being down is no reason to stop, the next tick asks again
+			currentVersion, err := getLatestVersion(w.repo)
+			if err != nil {
+				log.Error("error getting latest gh release", "error", err)
+				continue
+			}
+
+			localVersion, err := loadLocalVersion(w.workdir)
+			if err != nil {
+				return fmt.Errorf("error loading local version: %w", err)
+			}
+
+			if currentVersion != localVersion {
+				log.Info("update detected", "version", currentVersion)
+				events <- triggerEvent{Source: w.Name(), Job: job{Kind: jobMapVersion, Version: currentVersion}}
+
+				err = saveLocalVersion(currentVersion, w.workdir)
+				if err != nil {
+					return fmt.Errorf("error saving local version: %w", err)
+				}
+			}
+		}
+	}
+}
+
+// horizonTrigger emits a horizon extension every interval, the job checks whether the horizon is low.
+type horizonTrigger struct {
+	interval time.Duration
+}
+
+func (h *horizonTrigger) Name() string {
+	return "horizon check"
+}
+
+func (h *horizonTrigger) Run(ctx context.Context, events chan<- triggerEvent) error {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if health.canStartUpdate() {
+				events <- triggerEvent{Source: h.Name(), Job: job{Kind: jobExtendHorizon}}
+			}
+		}
+	}
+}
+
+// doduapiPoller emits a map-version job when doduapi reports a new game version, which can be earlier
+// than the release poll notices it.
+type doduapiPoller struct {
+	url      string
+	interval time.Duration
+	retry    RetryPolicy
+	last     string
+}
+
+func (d *doduapiPoller) Name() string {
+	return "doduapi poller"
+}
+
+func (d *doduapiPoller) Run(ctx context.Context, events chan<- triggerEvent) error {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			var version string
+			err := d.retry.do(ctx, "poll doduapi", func() error {
+				var err error
+				version, err = d.fetchVersion(ctx)
+				return err
+			})
+			if err != nil {
+				log.Warn("error polling doduapi", "url", d.url, "error", err)
+				continue
+			}
+
+			if d.last != "" && version != d.last && health.canStartUpdate() {
+				events <- triggerEvent{Source: d.Name(), Job: job{Kind: jobMapVersion, Version: version}}
+			}
+			d.last = version
+		}
+	}
+}
+
+func (d *doduapiPoller) fetchVersion(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.url, nil)
+	if err != nil {
+		return "", permanent(err)
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	err = statusRetryable(res)
+	if err != nil {
+		return "", err
+	}
+
+	var meta struct {
+		Version string `json:"version"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&meta)
+	if err != nil {
+		return "", err
+	}
+	if meta.Version == "" {
+		return "", fmt.Errorf("no version in response")
+	}
+	return meta.Version, nil
+}
+
+// webhookMaxBody is the largest job a webhook request may send.
+const webhookMaxBody = 64 << 10
+
+// webhookListener accepts jobs as json on POST /trigger, authorized with the secret as bearer token. The
+// tenants that listen on the same address share one server, a request picks its tenant with ?tenant=,
+// which may be left out while only one tenant listens.
+type webhookListener struct {
+	addr   string
+	secret string
+	tenant string
+}
+
+func (wh *webhookListener) Name() string {
+	return "webhook"
+}
+
+// webhookTenant is a tenant the shared webhook server of an address queues jobs for.
+type webhookTenant struct {
+	secret string
+	events chan<- triggerEvent
+}
+
+// webhookServer is the server of one listen address.
+type webhookServer struct {
+	mu      sync.Mutex
+	tenants map[string]webhookTenant
+}
+
+var (
+	webhookServersMu sync.Mutex
+	webhookServers   = make(map[string]*webhookServer)
+)
+
+func (s *webhookServer) lookup(name string) (webhookTenant, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if name == "" && len(s.tenants) == 1 {
+		for _, tenant := range s.tenants {
+			return tenant, true
+		}
+	}
+	tenant, ok := s.tenants[name]
+	return tenant, ok
+}
+
+func (s *webhookServer) handleTrigger(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := s.lookup(r.URL.Query().Get("tenant"))
+	if !ok {
+		http.Error(w, "unknown tenant, use ?tenant=", http.StatusNotFound)
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if tenant.secret == "" || subtle.ConstantTimeCompare([]byte(token), []byte(tenant.secret)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var j job
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, webhookMaxBody)).Decode(&j)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = j.validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tenant.events <- triggerEvent{Source: "webhook", Job: j}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Run registers the tenant with the server of its address. The first tenant of an address runs the server,
+// the others only wait for ctx.
+func (wh *webhookListener) Run(ctx context.Context, events chan<- triggerEvent) error {
+	webhookServersMu.Lock()
+	server, running := webhookServers[wh.addr]
+	if !running {
+		server = &webhookServer{tenants: make(map[string]webhookTenant)}
+		webhookServers[wh.addr] = server
+	}
+	webhookServersMu.Unlock()
+
+	server.mu.Lock()
+	server.tenants[wh.tenant] = webhookTenant{secret: wh.secret, events: events}
+	server.mu.Unlock()
+	defer func() {
+		server.mu.Lock()
+		delete(server.tenants, wh.tenant)
+		server.mu.Unlock()
+	}()
+
+	if running {
+		<-ctx.Done()
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /trigger", server.handleTrigger)
+	srv := &http.Server{Addr: wh.addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	log.Info("webhook listening", "addr", wh.addr)
+	err := srv.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// cronTrigger emits a job on a cron schedule, configured like "0 4 * * 1 validate".
+type cronTrigger struct {
+	spec     string
+	schedule cronSchedule
+	kind     jobKind
+	location *time.Location
+}
+
+func parseCronTrigger(entry string, location *time.Location) (*cronTrigger, error) {
+	fields := strings.Fields(entry)
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("cron entry %q: expected 5 schedule fields and a job kind", entry)
+	}
+
+	schedule, err := parseCron(strings.Join(fields[:5], " "))
+	if err != nil {
+		return nil, fmt.Errorf("cron entry %q: %w", entry, err)
+	}
+
+	kind := jobKind(fields[5])
+	if _, ok := jobPriorities[kind]; !ok || kind == jobBackfill {
+		return nil, fmt.Errorf("cron entry %q: unsupported job kind %s", entry, kind)
+	}
+
+	return &cronTrigger{spec: entry, schedule: schedule, kind: kind, location: location}, nil
+}
+
+func (c *cronTrigger) Name() string {
+	return "cron " + c.spec
+}
+
+func (c *cronTrigger) Run(ctx context.Context, events chan<- triggerEvent) error {
+	for {
+		next := c.schedule.next(time.Now().In(c.location))
+		if next.IsZero() {
+			return fmt.Errorf("schedule never matches")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Until(next)):
+			events <- triggerEvent{Source: c.Name(), Job: job{Kind: c.kind}}
+		}
+	}
+}
+
+func triggerInboxDir(workdir string) string {
+	return filepath.Join(stateDir(workdir), "triggers")
+}
+
+// inboxTrigger picks up jobs that the trigger command wrote as files into the workdir.
+type inboxTrigger struct {
+	dir      string
+	interval time.Duration
+}
+
+func (i *inboxTrigger) Name() string {
+	return "manual"
+}
+
+func (i *inboxTrigger) Run(ctx context.Context, events chan<- triggerEvent) error {
+	ticker := time.NewTicker(i.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		files, err := filepath.Glob(filepath.Join(i.dir, "*.json"))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+
+			var j job
+			if err := json.Unmarshal(data, &j); err != nil {
+				log.Error("invalid trigger file", "file", file, "error", err)
+			} else if err := j.validate(); err != nil {
+				log.Error("invalid job in trigger file", "file", file, "error", err)
+			} else {
+				events <- triggerEvent{Source: i.Name(), Job: j}
+			}
+
+			err = os.Remove(file)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// userAgentPool hands out the user agents of krosmoz requests in turn, UserAgent if it is empty.
+type userAgentPool struct {
+	agents []string
+	turn   atomic.Uint64
+}
+
+// scrapeUserAgents is shared by all krosmoz requests, it is set from the config on startup.
+var scrapeUserAgents = &userAgentPool{}
+
+// newUserAgentPool takes the configured user agents and those of the file, one per line. Empty lines and
+// lines starting with # are skipped.
+func newUserAgentPool(agents []string, file string) (*userAgentPool, error) {
+	pool := &userAgentPool{}
+	for _, agent := range agents {
+		if agent = strings.TrimSpace(agent); agent != "" {
+			pool.agents = append(pool.agents, agent)
+		}
+	}
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				pool.agents = append(pool.agents, line)
+			}
+		}
+	}
+	return pool, nil
+}
+
+// next returns the user agent for the next request.
+func (p *userAgentPool) next() string {
+	if len(p.agents) == 0 {
+		return UserAgent
+	}
+	return p.agents[(p.turn.Add(1)-1)%uint64(len(p.agents))]
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"github.com/dofusdude/alm-dates/almanax"
+	"golang.org/x/exp/rand"
+)
+
+type validationMismatch struct {
+	Date    string `json:"date"`
+	Mapped  string `json:"mapped"`
+	Scraped string `json:"scraped"`
+}
+
+// validateMapping scrapes a random share of the mapped dates a second time and returns the dates where
+// the receiver differs, which catches wrong pages served during the first pass. Overridden dates are left
+// out, krosmoz shows them wrong.
+func validateMapping(ds *almanax.Dataset, scraper *scraper, percent float64, workers int, aliases map[string]string, overrides map[string]dateOverride) []validationMismatch {
+	sample := sampleDates(ds, "", "", percent, overrides)
+	log.Info("validating mapping", "dates", len(sample), "workers", workers)
+	_, mismatches := checkSample(ds, sample, scraper, workers, aliases, false)
+	return mismatches
+}
+
+// sampleDates returns a random percent of the mapped dates from from to to, which are open if empty, without
+// the overridden ones.
+func sampleDates(ds *almanax.Dataset, from string, to string, percent float64, overrides map[string]dateOverride) []string {
+	var dates []string
+	for _, day := range almanax.NewIndex(ds).Range(from, to) {
+		if _, ok := overrides[day.Date]; !ok {
+			dates = append(dates, day.Date)
+		}
+	}
+
+	count := min(int(math.Ceil(float64(len(dates))*percent/100)), len(dates))
+	sample := make([]string, 0, count)
+	for _, i := range rand.Perm(len(dates))[:count] {
+		sample = append(sample, dates[i])
+	}
+	return sample
+}
+
+// checkSample scrapes the dates again with workers concurrent requests and returns how many it could check
+// and the dates whose receiver differs from the mapped one. Without cached krosmoz is always requested, the
+// cache would return the pages of the first pass.
+func checkSample(ds *almanax.Dataset, dates []string, scraper *scraper, workers int, aliases map[string]string, cached bool) (int, []validationMismatch) {
+	days := almanax.NewIndex(ds)
+	sample := make(chan string)
+	go func() {
+		for _, date := range dates {
+			sample <- date
+		}
+		close(sample)
+	}()
+
+	var checked int
+	var mu sync.Mutex
+	var mismatches []validationMismatch
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for date := range sample {
+				page, lang, _, err := scraper.scrapePage(date, cached)
+				if err != nil {
+					log.Warn("could not validate date", "date", date, "error", err)
+					continue
+				}
+				i := matchAlmanaxPage(ds, lang, page, aliases)
+				mapped, ok := days.Day(date)
+				mu.Lock()
+				checked++
+				mu.Unlock()
+				if i == -1 || !ok || &ds.Receivers[i] != mapped {
+					mismatch := validationMismatch{Date: date, Scraped: page.Receiver}
+					if ok {
+						mismatch.Mapped = mapped.Name
+					}
+					if i != -1 {
+						mismatch.Scraped = ds.Receivers[i].Name
+					}
+					mu.Lock()
+					mismatches = append(mismatches, mismatch)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(mismatches, func(i, j int) bool {
+		return mismatches[i].Date < mismatches[j].Date
+	})
+	return checked, mismatches
+}
+
+// coverageError lists the dates of a window that are not mapped, or mapped to more than one receiver.
+type coverageError struct {
+	Gaps []string
+	// Duplicates are the receivers of every date mapped more than once
+	Duplicates map[string][]string
+}
+
+func (e coverageError) Error() string {
+	var parts []string
+	if len(e.Gaps) > 0 {
+		parts = append(parts, fmt.Sprintf("%d dates unmapped: %s", len(e.Gaps), strings.Join(e.Gaps, ", ")))
+	}
+	if len(e.Duplicates) > 0 {
+		var duplicates []string
+		for _, date := range slices.Sorted(maps.Keys(e.Duplicates)) {
+			duplicates = append(duplicates, fmt.Sprintf("%s (%s)", date, strings.Join(e.Duplicates[date], " / ")))
+		}
+		parts = append(parts, fmt.Sprintf("%d dates mapped more than once: %s", len(duplicates), strings.Join(duplicates, ", ")))
+	}
+	return "coverage check failed, " + strings.Join(parts, "; ")
+}
+
+// checkCoverage makes sure every date of a window is mapped to exactly one receiver. Dates after the last
+// mapped one are the horizon, not gaps, a later extension maps them. exempt are the dates a run leaves
+// unmapped on purpose.
+func checkCoverage(ds *almanax.Dataset, dates []string, exempt map[string]bool) error {
+	window := make(map[string]bool, len(dates))
+	for _, date := range dates {
+		window[date] = true
+	}
+
+	receivers := make(map[string][]string)
+	last := ""
+	for _, day := range ds.Days() {
+		if !window[day.Date] {
+			continue
+		}
+		receivers[day.Date] = append(receivers[day.Date], day.Receiver.Name)
+		last = max(last, day.Date)
+	}
+
+	var problem coverageError
+	for _, date := range dates {
+		names := receivers[date]
+		switch {
+		case len(names) > 1:
+			if problem.Duplicates == nil {
+				problem.Duplicates = make(map[string][]string)
+			}
+			problem.Duplicates[date] = names
+		case len(names) == 0 && date < last && !exempt[date]:
+			problem.Gaps = append(problem.Gaps, date)
+		}
+	}
+	if len(problem.Gaps) == 0 && len(problem.Duplicates) == 0 {
+		return nil
+	}
+	return problem
+}
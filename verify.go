@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/dofusdude/alm-dates/almanax"
+)
+
+// verifyReport is the result of checking a sample of a published asset against krosmoz.
+type verifyReport struct {
+	Version string `json:"version"`
+	From    string `json:"from,omitempty"`
+	To      string `json:"to,omitempty"`
+	Sampled int    `json:"sampled"`
+	// Checked are the sampled dates krosmoz answered for, the others are not judged
+	Checked    int                  `json:"checked"`
+	Mismatches []validationMismatch `json:"mismatches"`
+	Passed     bool                 `json:"passed"`
+	// Warning is set when krosmoz answered for fewer dates than sampled, the report judges only those
+	Warning string `json:"warning,omitempty"`
+}
+
+// verifyVersion maps a sample of the published dates of a version again and compares them with the asset.
+// It passes if every date krosmoz answered for resolves to the published receiver and at least one did.
+// Krosmoz only serves dates up to a few days ahead, so the sample ends today unless to is earlier.
+func (p *pipeline) verifyVersion(version string, from string, to string, percent float64, cached bool) (verifyReport, error) {
+	report := verifyReport{Version: version, From: from, To: to, Mismatches: []validationMismatch{}}
+	data, _, err := downloadAlmanaxAsset(p.repo, version)
+	if err != nil {
+		return report, err
+	}
+	ds, err := almanax.Decode(data)
+	if err != nil {
+		return report, fmt.Errorf("%s of %s does not decode: %w", MappedAlmanaxFileName, version, err)
+	}
+	overrides, err := loadOverrides(p.workdir)
+	if err != nil {
+		return report, err
+	}
+
+	scrapeable := to
+	if today := time.Now().In(p.cfg.location()).Format("2006-01-02"); scrapeable == "" || scrapeable > today {
+		scrapeable = today
+	}
+	sample := sampleDates(ds, from, scrapeable, percent, overrides)
+	report.Sampled = len(sample)
+	p.log.Info("verifying published almanax", "version", version, "dates", len(sample), "to", scrapeable, "cached", cached)
+	checked, mismatches := checkSample(ds, sample, p.scraper, p.cfg.ValidateWorkers, p.aliases, cached)
+	report.Checked = checked
+	if mismatches != nil {
+		report.Mismatches = mismatches
+	}
+	if checked < len(sample) {
+		report.Warning = fmt.Sprintf("krosmoz answered for %d of %d sampled dates, less than --percent was checked", checked, len(sample))
+		p.log.Warn("not every sampled date was checked", "checked", checked, "sampled", len(sample))
+	}
+	report.Passed = checked > 0 && len(mismatches) == 0
+	return report, nil
+}
+
+func writeVerify(w io.Writer, report verifyReport) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	result := "PASS"
+	if !report.Passed {
+		result = "FAIL"
+	}
+	fmt.Fprintf(tw, "version: %s\n", report.Version)
+	fmt.Fprintf(tw, "checked: %d of %d sampled dates\n", report.Checked, report.Sampled)
+	if report.Warning != "" {
+		fmt.Fprintf(tw, "warning: %s\n", report.Warning)
+	}
+	fmt.Fprintf(tw, "result: %s\n", result)
+	if len(report.Mismatches) > 0 {
+		fmt.Fprintln(tw, "\nDATE\tPUBLISHED\tSCRAPED")
+		for _, mismatch := range report.Mismatches {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", mismatch.Date, mismatch.Mapped, mismatch.Scraped)
+		}
+	}
+	return tw.Flush()
+}
+
+// verifyCommand audits a published version without changing it and exits with 1 if the report fails.
+func verifyCommand(args []string) {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	version := flags.String("version", "", "published data release version to verify, defaults to the last seen version")
+	from := flags.String("from", "", "first date to sample, defaults to the first published date")
+	to := flags.String("to", "", "last date to sample, defaults to today, krosmoz does not serve later dates")
+	percent := flags.Float64("percent", 10, "percentage of the published dates to map again")
+	cached := flags.Bool("cached", false, "read pages from the workdir cache within page_cache_ttl instead of always requesting krosmoz")
+	asJson := flags.Bool("json", false, "write the report as json")
+	tenant := flags.String("tenant", "", "tenant to verify the version of")
+	cfg, _, err := loadConfig(flags, args)
+	if err != nil {
+		log.Fatal("error loading config", "error", err)
+	}
+
+	if (*from != "" && !isDate(*from)) || (*to != "" && !isDate(*to)) {
+		log.Fatal("--from and --to must be YYYY-MM-DD")
+	}
+	if *percent <= 0 || *percent > 100 {
+		log.Fatal("--percent must be between 0 and 100")
+	}
+
+	p := commandPipeline(&cfg, *tenant)
+	if *version == "" {
+		*version, err = loadLocalVersion(p.workdir)
+		if err != nil || *version == "" {
+			log.Fatal("no version given and none seen yet", "error", err)
+		}
+	}
+
+	err = p.duringMaintenance()
+	if err != nil {
+		log.Fatal("not verifying, try again later", "error", err)
+	}
+
+	start := time.Now()
+	report, err := p.verifyVersion(*version, *from, *to, *percent, *cached)
+	if err != nil {
+		log.Fatal("error verifying version", "version", *version, "error", err)
+	}
+	p.log.Info("verified", "version", *version, "checked", report.Checked, "mismatches", len(report.Mismatches), "duration", FormatDuration(time.Since(start).Round(time.Second)))
+
+	if *asJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+	} else {
+		err = writeVerify(os.Stdout, report)
+	}
+	if err != nil {
+		log.Fatal("error writing report", "error", err)
+	}
+	if !report.Passed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/log"
+)
+
+// WorkdirLayoutVersion is the workdir layout this binary reads and writes.
+//
+//	layout_version  the layout marker
+//	state/          persistent state like the last seen version and the overrides
+//	cache/          data that can be deleted at any time
+const WorkdirLayoutVersion = 2
+
+const layoutFileName = "layout_version"
+
+// workdirMigrations[i] migrates a workdir from layout i to layout i+1.
+var workdirMigrations = []func(workdir string) error{
+	migrateStateDir,
+	migrateOverrides,
+}
+
+func stateDir(workdir string) string {
+	return filepath.Join(workdir, "state")
+}
+
+func cacheDir(workdir string) string {
+	return filepath.Join(workdir, "cache")
+}
+
+// readLayoutVersion returns 0 for workdirs from before the layout marker existed.
+func readLayoutVersion(workdir string) (int, error) {
+	data, err := os.ReadFile(filepath.Join(workdir, layoutFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	version, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", layoutFileName, err)
+	}
+	return version, nil
+}
+
+func writeLayoutVersion(workdir string, version int) error {
+	return os.WriteFile(filepath.Join(workdir, layoutFileName), []byte(strconv.Itoa(version)), 0644)
+}
+
+// migrateWorkdir brings the workdir to the current layout. The marker is written after each step,
+// so an interrupted migration continues where it stopped.
+func migrateWorkdir(workdir string) error {
+	version, err := readLayoutVersion(workdir)
+	if err != nil {
+		return err
+	}
+
+	if version > WorkdirLayoutVersion {
+		return fmt.Errorf("workdir layout %d is newer than the supported layout %d, refusing to touch it", version, WorkdirLayoutVersion)
+	}
+
+	for ; version < WorkdirLayoutVersion; version++ {
+		log.Info("migrating workdir", "from", version, "to", version+1, "workdir", workdir)
+		err = workdirMigrations[version](workdir)
+		if err != nil {
+			return fmt.Errorf("workdir migration %d -> %d: %w", version, version+1, err)
+		}
+
+		err = writeLayoutVersion(workdir, version+1)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateStateDir moves the version file from the workdir root into state/.
+func migrateStateDir(workdir string) error {
+	err := os.MkdirAll(stateDir(workdir), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(filepath.Join(workdir, "version"), filepath.Join(stateDir(workdir), "version"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// migrateOverrides moves the overrides file from the workdir root into state/, where the backup finds it.
+func migrateOverrides(workdir string) error {
+	err := os.MkdirAll(stateDir(workdir), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(filepath.Join(workdir, overridesFileName), overridesPath(workdir))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
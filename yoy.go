@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/dofusdude/alm-dates/almanax"
+)
+
+// yearShiftWindow is how many days around a date the previous year is searched for the same receiver.
+const yearShiftWindow = 30
+
+// calendarDay is the receiver and bonus of a date in one language.
+type calendarDay struct {
+	Receiver string
+	Bonus    string
+}
+
+// yearChange is a date whose receiver or bonus differs from the same calendar date of a previous year.
+type yearChange struct {
+	Date             string `json:"date"`
+	Receiver         string `json:"receiver"`
+	PreviousReceiver string `json:"previous_receiver"`
+	Bonus            string `json:"bonus"`
+	PreviousBonus    string `json:"previous_bonus"`
+	// Shift is the offset in days to the nearest date of the previous year with the same receiver, nil if
+	// there is none within yearShiftWindow days.
+	Shift *int `json:"shift"`
+}
+
+// yearComparison compares the dates of a year with the same calendar dates of a previous year.
+type yearComparison struct {
+	Year         int          `json:"year"`
+	Against      int          `json:"against"`
+	Dates        int          `json:"dates"`
+	SameReceiver int          `json:"same_receiver"`
+	SameBonus    int          `json:"same_bonus"`
+	Changes      []yearChange `json:"changes"`
+}
+
+// calendarDays merges the history with the dataset by date, the dataset wins where both have a date.
+func calendarDays(history []historyDay, ds *almanax.Dataset, lang string) map[string]calendarDay {
+	days := make(map[string]calendarDay)
+	for _, day := range history {
+		days[day.Date] = calendarDay{Receiver: day.Receiver, Bonus: almanax.Text(day.Bonus).Get(lang)}
+	}
+	if ds != nil {
+		for _, day := range ds.Days() {
+			days[day.Date] = calendarDay{Receiver: day.Receiver.Name, Bonus: day.Receiver.Bonus.Description.Get(lang)}
+		}
+	}
+	return days
+}
+
+// compareYears compares the year with every earlier year that has any of its calendar dates, the closest
+// year first. February 29 has no counterpart and is left out.
+func compareYears(days map[string]calendarDay, year int) []yearComparison {
+	var years []int
+	seen := make(map[int]bool)
+	for date := range days {
+		y, _ := strconv.Atoi(date[:4])
+		if y < year && !seen[y] {
+			seen[y] = true
+			years = append(years, y)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(years)))
+
+	first := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	var comparisons []yearComparison
+	for _, against := range years {
+		comparison := yearComparison{Year: year, Against: against, Changes: []yearChange{}}
+		for date := first; date.Year() == year; date = date.AddDate(0, 0, 1) {
+			if date.Month() == time.February && date.Day() == 29 {
+				continue
+			}
+			day, ok := days[date.Format("2006-01-02")]
+			if !ok {
+				continue
+			}
+			previousDate := time.Date(against, date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+			previous, ok := days[previousDate.Format("2006-01-02")]
+			if !ok {
+				continue
+			}
+
+			comparison.Dates++
+			if day.Receiver == previous.Receiver {
+				comparison.SameReceiver++
+			}
+			if day.Bonus == previous.Bonus {
+				comparison.SameBonus++
+			}
+			if day.Receiver == previous.Receiver && day.Bonus == previous.Bonus {
+				continue
+			}
+			comparison.Changes = append(comparison.Changes, yearChange{
+				Date:             date.Format("2006-01-02"),
+				Receiver:         day.Receiver,
+				PreviousReceiver: previous.Receiver,
+				Bonus:            day.Bonus,
+				PreviousBonus:    previous.Bonus,
+				Shift:            receiverShift(days, day.Receiver, previousDate),
+			})
+		}
+		if comparison.Dates > 0 {
+			comparisons = append(comparisons, comparison)
+		}
+	}
+	return comparisons
+}
+
+// receiverShift finds the nearest date around previousDate with the receiver, preferring the earlier one.
+func receiverShift(days map[string]calendarDay, receiver string, previousDate time.Time) *int {
+	for offset := 0; offset <= yearShiftWindow; offset++ {
+		for _, shift := range []int{-offset, offset} {
+			if day, ok := days[previousDate.AddDate(0, 0, shift).Format("2006-01-02")]; ok && day.Receiver == receiver {
+				return &shift
+			}
+		}
+	}
+	return nil
+}
+
+func formatShift(shift *int) string {
+	if shift == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%+d", *shift)
+}
+
+func percentOf(n int, total int) float64 {
+	return float64(n) * 100 / float64(total)
+}
+
+// writeYearReport writes the comparisons as "table", "markdown" or "json". Only the changes against the
+// closest year are listed, the other years are summarized.
+func writeYearReport(w io.Writer, format string, year int, comparisons []yearComparison) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(comparisons)
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "YEAR\tAGAINST\tDATES\tSAME RECEIVER\tSAME BONUS")
+		for _, c := range comparisons {
+			fmt.Fprintf(tw, "%d\t%d\t%d\t%.1f%%\t%.1f%%\n", c.Year, c.Against, c.Dates, percentOf(c.SameReceiver, c.Dates), percentOf(c.SameBonus, c.Dates))
+		}
+		if len(comparisons) > 0 && len(comparisons[0].Changes) > 0 {
+			fmt.Fprintln(tw)
+			fmt.Fprintf(tw, "DATE\t%d\t%d\tSHIFT\tBONUS %d\n", comparisons[0].Year, comparisons[0].Against, comparisons[0].Year)
+			for _, change := range comparisons[0].Changes {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", change.Date, change.Receiver, change.PreviousReceiver, formatShift(change.Shift), change.Bonus)
+			}
+		}
+		return tw.Flush()
+	case "markdown":
+		fmt.Fprintf(w, "## Almanax %d compared to previous years\n\n", year)
+		fmt.Fprintln(w, "| Against | Dates | Same receiver | Same bonus |")
+		fmt.Fprintln(w, "|---:|---:|---:|---:|")
+		for _, c := range comparisons {
+			fmt.Fprintf(w, "| %d | %d | %.1f%% | %.1f%% |\n", c.Against, c.Dates, percentOf(c.SameReceiver, c.Dates), percentOf(c.SameBonus, c.Dates))
+		}
+		if len(comparisons) > 0 && len(comparisons[0].Changes) > 0 {
+			c := comparisons[0]
+			fmt.Fprintf(w, "\n### Changes against %d\n\n", c.Against)
+			fmt.Fprintf(w, "| Date | %d | %d | Shift | Bonus %d | Bonus %d |\n", c.Year, c.Against, c.Year, c.Against)
+			fmt.Fprintln(w, "|---|---|---|---:|---|---|")
+			for _, change := range c.Changes {
+				fmt.Fprintf(w, "| %s | %s | %s | %s | %s | %s |\n", change.Date, markdownEscape(change.Receiver), markdownEscape(change.PreviousReceiver),
+					formatShift(change.Shift), markdownEscape(change.Bonus), markdownEscape(change.PreviousBonus))
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown format %s", format)
+	}
+}
+
+// logYearOverYear summarizes how the new days compare to the year before, a sanity check of a mapping
+// run next to the history it is appended to.
+func logYearOverYear(history []historyDay, ds *almanax.Dataset) {
+	days := calendarDays(history, ds, "en")
+	years := make(map[int]bool)
+	for _, day := range ds.Days() {
+		year, _ := strconv.Atoi(day.Date[:4])
+		years[year] = true
+	}
+
+	for year := range years {
+		comparisons := compareYears(days, year)
+		if len(comparisons) == 0 {
+			continue
+		}
+		c := comparisons[0]
+		log.Info("year over year", "year", c.Year, "against", c.Against, "dates", c.Dates, "changed_receivers", c.Dates-c.SameReceiver, "changed_bonuses", c.Dates-c.SameBonus)
+	}
+}
+
+func yoyCommand(args []string) {
+	flags := flag.NewFlagSet("yoy", flag.ExitOnError)
+	year := flags.Int("year", time.Now().Year(), "year to compare with the previous years")
+	lang := flags.String("lang", "en", "language of the bonuses")
+	format := flags.String("format", "table", "output format: table, markdown or json")
+	version := flags.String("version", "", "data repo version, defaults to the latest release")
+	file := flags.String("file", "", "read the mapped almanax from a local file instead of the release")
+	historyFile := flags.String("history", "", "read the history from a local file instead of the release, needed with --file")
+	_ = flags.Parse(args)
+
+	if *file == "" && *version == "" {
+		var err error
+		*version, err = getLatestVersion(defaultDataRepo)
+		if err != nil {
+			log.Fatal("error getting latest version", "error", err)
+		}
+	}
+
+	ds, err := loadAlmanaxSource(*file, *version)
+	if err != nil {
+		log.Fatal("error loading almanax data", "error", err)
+	}
+
+	var history []historyDay
+	switch {
+	case *historyFile != "":
+		data, err := os.ReadFile(*historyFile)
+		if err != nil {
+			log.Fatal("error reading history", "error", err)
+		}
+		var asset historyAsset
+		err = json.Unmarshal(data, &asset)
+		if err != nil {
+			log.Fatal("error reading history", "file", *historyFile, "error", err)
+		}
+		history = asset.Days
+	case *file == "":
+		history, _, err = publishedHistory(defaultDataRepo, *version)
+		if err != nil {
+			log.Fatal("error loading history", "error", err)
+		}
+	}
+	if len(history) == 0 {
+		log.Warn("no history, only the dates of the mapped almanax are compared")
+	}
+
+	comparisons := compareYears(calendarDays(history, ds, normalizeLang(*lang)), *year)
+	if len(comparisons) == 0 {
+		log.Warn("no previous year has any of the dates", "year", *year)
+	}
+
+	err = writeYearReport(os.Stdout, *format, *year, comparisons)
+	if err != nil {
+		log.Fatal("error writing report", "error", err)
+	}
+}